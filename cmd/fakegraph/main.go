@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"github.com/isaee-xyz/whatomate/pkg/fakegraph"
+	"github.com/zerodha/logf"
+)
+
+var (
+	addr         = flag.String("addr", "127.0.0.1:9090", "Address to listen on")
+	webhookURL   = flag.String("webhook-url", "http://localhost:8080/api/webhook", "Where to deliver webhooks")
+	appSecret    = flag.String("app-secret", "", "App secret to sign webhooks with (whatsapp.app_secret)")
+	accessToken  = flag.String("access-token", "", "Accept only this access token (any token if empty)")
+	autoStatuses = flag.Bool("auto-statuses", true, "Deliver sent and delivered status webhooks for every sent message")
+)
+
+// fakegraph runs a fake Meta Graph API for local development and end-to-end testing.
+// Point whatsapp.base_url at it.
+func main() {
+	flag.Parse()
+
+	lo := logf.New(logf.Opts{
+		EnableColor:     true,
+		Level:           logf.DebugLevel,
+		TimestampFormat: "2006-01-02 15:04:05",
+		DefaultFields:   []any{"app", "fakegraph"},
+	})
+
+	fake := fakegraph.New(fakegraph.Options{
+		AccessToken:  *accessToken,
+		WebhookURL:   *webhookURL,
+		AppSecret:    *appSecret,
+		AutoStatuses: *autoStatuses,
+	})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lo.Debug("Request", "method", r.Method, "path", r.URL.Path)
+		fake.ServeHTTP(w, r)
+	})
+
+	lo.Info("Fake Graph API listening", "address", *addr, "webhook_url", *webhookURL)
+	if err := http.ListenAndServe(*addr, handler); err != nil {
+		lo.Fatal("Server failed", "error", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,6 +16,8 @@ import (
 	"github.com/isaee-xyz/whatomate/internal/handlers"
 	"github.com/isaee-xyz/whatomate/internal/middleware"
 	"github.com/isaee-xyz/whatomate/internal/queue"
+	"github.com/isaee-xyz/whatomate/internal/secrets"
+	"github.com/isaee-xyz/whatomate/internal/storage"
 	"github.com/isaee-xyz/whatomate/internal/websocket"
 	"github.com/isaee-xyz/whatomate/internal/worker"
 	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
@@ -24,9 +27,11 @@ import (
 )
 
 var (
-	configPath  = flag.String("config", "config.toml", "Path to config file")
-	migrate     = flag.Bool("migrate", false, "Run database migrations")
-	numWorkers  = flag.Int("workers", 1, "Number of workers to run (0 to disable embedded workers)")
+	configPath   = flag.String("config", "config.toml", "Path to config file")
+	migrate      = flag.Bool("migrate", false, "Run database migrations")
+	numWorkers   = flag.Int("workers", 1, "Number of workers to run (0 to disable embedded workers)")
+	migrateMedia = flag.Bool("migrate-media", false, "Copy media from storage.local_path into the configured storage backend and exit")
+	reencrypt    = flag.Bool("reencrypt-secrets", false, "Re-encrypt stored credentials with the current encryption key and exit")
 )
 
 func main() {
@@ -58,6 +63,25 @@ func main() {
 		})
 	}
 
+	// Initialize media storage. Signed URLs of local storage are served by this server.
+	store, err := storage.New(&cfg.Storage, cfg.JWT.Secret, strings.TrimSuffix(cfg.Server.BasePath, "/")+"/media/signed")
+	if err != nil {
+		lo.Fatal("Failed to initialize media storage", "error", err)
+	}
+
+	// Copy existing local media into the bucket if requested
+	if *migrateMedia {
+		runMediaMigration(cfg, store, lo)
+		return
+	}
+
+	// Encrypt credentials stored in the database; must be set up before the first query
+	keyring, err := secrets.NewKeyring(&cfg.Encryption)
+	if err != nil {
+		lo.Fatal("Failed to initialize encryption keys", "error", err)
+	}
+	secrets.Register(keyring)
+
 	// Connect to PostgreSQL
 	db, err := database.NewPostgres(&cfg.Database, cfg.App.Debug)
 	if err != nil {
@@ -72,6 +96,19 @@ func main() {
 		}
 	}
 
+	// Encrypt plaintext credentials left from before encryption (a no-op once done) or,
+	// when requested, move them all to the current key after a rotation
+	if *migrate || *reencrypt {
+		n, err := database.ReencryptSecrets(db, keyring)
+		if err != nil {
+			lo.Fatal("Failed to re-encrypt secrets", "error", err)
+		}
+		lo.Info("Stored credentials encrypted", "updated", n, "key", keyring.CurrentKeyID())
+		if *reencrypt {
+			return
+		}
+	}
+
 	// Connect to Redis
 	rdb, err := database.NewRedis(&cfg.Redis)
 	if err != nil {
@@ -83,11 +120,16 @@ func main() {
 	jobQueue := queue.NewRedisQueue(rdb, lo)
 	lo.Info("Job queue initialized")
 
+	// Initialize inbound webhook queue
+	webhookQueue := queue.NewWebhookQueue(rdb, lo, cfg.WhatsApp.WebhookPartitions)
+
 	// Initialize Fastglue
 	g := fastglue.NewGlue()
 
 	// Initialize WhatsApp client
 	waClient := whatsapp.New(lo)
+	waClient.BaseURL = cfg.WhatsApp.BaseURL
+	waClient.MaxRetries = cfg.WhatsApp.MaxRetries
 
 	// Initialize WebSocket hub
 	wsHub := websocket.NewHub(lo)
@@ -103,6 +145,10 @@ func main() {
 		WhatsApp: waClient,
 		WSHub:    wsHub,
 		Queue:    jobQueue,
+
+		WebhookQueue: webhookQueue,
+		Storage:      store,
+		Keyring:      keyring,
 	}
 
 	// Start campaign stats subscriber for real-time WebSocket updates from worker
@@ -120,10 +166,11 @@ func main() {
 
 	// Create server
 	server := &fasthttp.Server{
-		Handler:      g.Handler(),
-		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
-		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
-		Name:         "Whatomate",
+		Handler:            g.Handler(),
+		ReadTimeout:        time.Duration(cfg.Server.ReadTimeout) * time.Second,
+		WriteTimeout:       time.Duration(cfg.Server.WriteTimeout) * time.Second,
+		MaxRequestBodySize: cfg.Server.MaxBodySize * 1024 * 1024, // Recipient spreadsheets exceed fasthttp's 4MB default
+		Name:               "Whatomate",
 	}
 
 	// Start server in goroutine
@@ -141,6 +188,26 @@ func main() {
 	go slaProcessor.Start(slaCtx)
 	lo.Info("SLA processor started")
 
+	// Start campaign scheduler (starts scheduled campaigns when due; safe on multiple instances)
+	campaignScheduler := handlers.NewCampaignScheduler(app, 30*time.Second)
+	schedulerCtx, schedulerCancel := context.WithCancel(context.Background())
+	go campaignScheduler.Start(schedulerCtx)
+
+	// Start webhook processor (consumes inbound Meta webhooks from Redis streams)
+	webhookConsumer, err := queue.NewWebhookConsumer(rdb, lo, cfg.WhatsApp.WebhookPartitions, cfg.WhatsApp.WebhookMaxAttempts)
+	if err != nil {
+		lo.Fatal("Failed to create webhook consumer", "error", err)
+	}
+	webhookCtx, webhookCancel := context.WithCancel(context.Background())
+	webhookDone := make(chan struct{})
+	go func() {
+		defer close(webhookDone)
+		if err := webhookConsumer.Consume(webhookCtx, app.HandleWebhookEvent); err != nil && err != context.Canceled {
+			lo.Error("Webhook processor error", "error", err)
+		}
+	}()
+	lo.Info("Webhook processor started", "partitions", cfg.WhatsApp.WebhookPartitions)
+
 	// Start embedded workers
 	var workers []*worker.Worker
 	var workerCancel context.CancelFunc
@@ -186,6 +253,18 @@ func main() {
 	slaProcessor.Stop()
 	lo.Info("SLA processor stopped")
 
+	// Stop campaign scheduler
+	lo.Info("Stopping campaign scheduler...")
+	schedulerCancel()
+	campaignScheduler.Stop()
+	lo.Info("Campaign scheduler stopped")
+
+	// Stop webhook processor; unfinished events stay pending and are picked up on restart
+	lo.Info("Stopping webhook processor...")
+	webhookCancel()
+	<-webhookDone
+	lo.Info("Webhook processor stopped")
+
 	// Stop workers first
 	if workerCancel != nil {
 		lo.Info("Stopping workers...", "count", len(workers))
@@ -204,6 +283,29 @@ func main() {
 	lo.Info("Server stopped")
 }
 
+// runMediaMigration copies files under storage.local_path into the configured (S3) storage
+func runMediaMigration(cfg *config.Config, store storage.Store, lo logf.Logger) {
+	if _, ok := store.(*storage.Local); ok {
+		lo.Fatal("Media migration needs a non-local storage backend; set storage.type = \"s3\"")
+	}
+	src, err := storage.NewLocal(cfg.Storage.LocalPath, cfg.JWT.Secret, "")
+	if err != nil {
+		lo.Fatal("Failed to open local media storage", "error", err)
+	}
+
+	lo.Info("Migrating media", "from", cfg.Storage.LocalPath, "to", cfg.Storage.Type)
+	res, err := storage.MigrateLocal(context.Background(), src, store, func(key string, err error) {
+		lo.Error("Failed to migrate media file", "key", key, "error", err)
+	})
+	if err != nil {
+		lo.Fatal("Media migration failed", "error", err)
+	}
+	lo.Info("Media migration complete", "copied", res.Copied, "skipped", res.Skipped, "failed", res.Failed)
+	if res.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
 func setupRoutes(g *fastglue.Fastglue, app *handlers.App, lo logf.Logger, basePath string) {
 	// Health check
 	g.GET("/health", app.HealthCheck)
@@ -223,6 +325,9 @@ func setupRoutes(g *fastglue.Fastglue, app *handlers.App, lo logf.Logger, basePa
 	g.GET("/api/webhook", app.WebhookVerify)
 	g.POST("/api/webhook", app.WebhookHandler)
 
+	// WhatsApp Flows data endpoints (public - requests are encrypted and signed by Meta)
+	g.POST("/api/flow-endpoint/{id}", app.FlowDataExchange)
+
 	// WebSocket route (auth handled in handler via query param)
 	g.GET("/ws", app.WebSocketHandler)
 
@@ -240,6 +345,10 @@ func setupRoutes(g *fastglue.Fastglue, app *handlers.App, lo logf.Logger, basePa
 		if len(path) >= 13 && path[:13] == "/api/auth/sso" {
 			return r
 		}
+		// Skip auth for flow data endpoints (requests are encrypted with the account's key)
+		if len(path) >= 19 && path[:19] == "/api/flow-endpoint/" {
+			return r
+		}
 		// Apply auth for all other /api routes (supports both JWT and API key)
 		if len(path) > 4 && path[:4] == "/api" {
 			return middleware.AuthWithDB(app.Config.JWT.Secret, app.DB)(r)
@@ -263,10 +372,11 @@ func setupRoutes(g *fastglue.Fastglue, app *handlers.App, lo logf.Logger, basePa
 			return r // Auth middleware will handle unauthenticated requests
 		}
 
-		// Admin-only routes: user management, API keys, and SSO settings
+		// Admin-only routes: user management, API keys, SSO settings, and webhook dead letters
 		if (len(path) >= 10 && path[:10] == "/api/users") ||
 			(len(path) >= 13 && path[:13] == "/api/api-keys") ||
-			(len(path) >= 17 && path[:17] == "/api/settings/sso") {
+			(len(path) >= 17 && path[:17] == "/api/settings/sso") ||
+			(len(path) >= 26 && path[:26] == "/api/webhooks/dead-letters") {
 			if role != "admin" {
 				r.RequestCtx.SetStatusCode(403)
 				r.RequestCtx.SetBodyString(`{"status":"error","message":"Admin access required"}`)
@@ -296,6 +406,8 @@ func setupRoutes(g *fastglue.Fastglue, app *handlers.App, lo logf.Logger, basePa
 					"/api/templates",
 					"/api/flows",
 					"/api/campaigns",
+					"/api/segments",
+					"/api/opt-outs",
 					"/api/chatbot",
 					"/api/analytics",
 				}
@@ -353,6 +465,12 @@ func setupRoutes(g *fastglue.Fastglue, app *handlers.App, lo logf.Logger, basePa
 	g.DELETE("/api/contacts/{id}", app.DeleteContact)
 	g.PUT("/api/contacts/{id}/assign", app.AssignContact)
 
+	// Opt-outs (do-not-contact list)
+	g.GET("/api/opt-outs", app.ListOptOuts)
+	g.POST("/api/opt-outs", app.CreateOptOut)
+	g.GET("/api/opt-outs/events", app.ListOptOutEvents)
+	g.DELETE("/api/opt-outs/{id}", app.DeleteOptOut)
+
 	// Messages
 	g.GET("/api/contacts/{id}/messages", app.GetMessages)
 	g.POST("/api/contacts/{id}/messages", app.SendMessage)
@@ -364,15 +482,23 @@ func setupRoutes(g *fastglue.Fastglue, app *handlers.App, lo logf.Logger, basePa
 
 	// Media (serves media files for messages, auth-protected)
 	g.GET("/api/media/{message_id}", app.ServeMedia)
+	g.GET("/api/media/{message_id}/url", app.GetMediaURL)
+
+	// Signed media URLs of local storage (public, the signature authorizes the download)
+	g.GET("/media/signed/{key:*}", app.ServeSignedMedia)
 
 	// Templates
 	g.GET("/api/templates", app.ListTemplates)
 	g.POST("/api/templates", app.CreateTemplate)
+	g.GET("/api/templates/groups", app.ListTemplateGroups)
 	g.GET("/api/templates/{id}", app.GetTemplate)
 	g.PUT("/api/templates/{id}", app.UpdateTemplate)
 	g.DELETE("/api/templates/{id}", app.DeleteTemplate)
 	g.POST("/api/templates/sync", app.SyncTemplates)
+	g.POST("/api/templates/validate", app.ValidateTemplate)
 	g.POST("/api/templates/{id}/publish", app.SubmitTemplate)
+	g.GET("/api/templates/{id}/versions", app.ListTemplateVersions)
+	g.GET("/api/templates/{id}/versions/{version}", app.GetTemplateVersion)
 
 	// WhatsApp Flows
 	g.GET("/api/flows", app.ListFlows)
@@ -383,6 +509,7 @@ func setupRoutes(g *fastglue.Fastglue, app *handlers.App, lo logf.Logger, basePa
 	g.POST("/api/flows/{id}/save-to-meta", app.SaveFlowToMeta)
 	g.POST("/api/flows/{id}/publish", app.PublishFlow)
 	g.POST("/api/flows/{id}/deprecate", app.DeprecateFlow)
+	g.PUT("/api/flows/{id}/endpoint", app.ConfigureFlowEndpoint)
 	g.POST("/api/flows/sync", app.SyncFlows)
 
 	// Bulk Campaigns
@@ -394,14 +521,31 @@ func setupRoutes(g *fastglue.Fastglue, app *handlers.App, lo logf.Logger, basePa
 	g.POST("/api/campaigns/{id}/start", app.StartCampaign)
 	g.POST("/api/campaigns/{id}/pause", app.PauseCampaign)
 	g.POST("/api/campaigns/{id}/cancel", app.CancelCampaign)
+	g.POST("/api/campaigns/{id}/schedule", app.ScheduleCampaign)
+	g.DELETE("/api/campaigns/{id}/schedule", app.UnscheduleCampaign)
 	g.POST("/api/campaigns/{id}/retry-failed", app.RetryFailed)
+	g.POST("/api/campaigns/{id}/header-media", app.UploadCampaignHeaderMedia)
 	g.GET("/api/campaigns/{id}/progress", app.GetCampaign)
 	g.POST("/api/campaigns/{id}/recipients/import", app.ImportRecipients)
+	g.POST("/api/campaigns/{id}/recipients/upload/preview", app.PreviewRecipientImport)
+	g.POST("/api/campaigns/{id}/recipients/upload", app.UploadRecipients)
+	g.GET("/api/campaigns/{id}/recipients/import-reports/{report_id}", app.DownloadRecipientImportReport)
 	g.GET("/api/campaigns/{id}/recipients", app.GetCampaignRecipients)
 
+	// Audience Segments
+	g.GET("/api/segments", app.ListSegments)
+	g.POST("/api/segments", app.CreateSegment)
+	g.POST("/api/segments/preview", app.PreviewSegment)
+	g.GET("/api/segments/{id}", app.GetSegment)
+	g.PUT("/api/segments/{id}", app.UpdateSegment)
+	g.DELETE("/api/segments/{id}", app.DeleteSegment)
+	g.GET("/api/segments/{id}/preview", app.PreviewSegment)
+
 	// Chatbot Settings
 	g.GET("/api/chatbot/settings", app.GetChatbotSettings)
 	g.PUT("/api/chatbot/settings", app.UpdateChatbotSettings)
+	g.GET("/api/chatbot/export", app.ExportChatbotBundle)
+	g.POST("/api/chatbot/import", app.ImportChatbotBundle)
 
 	// Keyword Rules
 	g.GET("/api/chatbot/keywords", app.ListKeywordRules)
@@ -416,6 +560,14 @@ func setupRoutes(g *fastglue.Fastglue, app *handlers.App, lo logf.Logger, basePa
 	g.GET("/api/chatbot/flows/{id}", app.GetChatbotFlow)
 	g.PUT("/api/chatbot/flows/{id}", app.UpdateChatbotFlow)
 	g.DELETE("/api/chatbot/flows/{id}", app.DeleteChatbotFlow)
+	g.POST("/api/chatbot/flows/simulate", app.SimulateChatbotFlow)
+	g.GET("/api/chatbot/flows/{id}/versions", app.ListChatbotFlowVersions)
+	g.GET("/api/chatbot/flows/{id}/versions/{version}", app.GetChatbotFlowVersion)
+	g.GET("/api/chatbot/flows/{id}/diff", app.DiffChatbotFlowVersions)
+	g.POST("/api/chatbot/flows/{id}/publish", app.PublishChatbotFlow)
+	g.POST("/api/chatbot/flows/{id}/rollback", app.RollbackChatbotFlow)
+	g.PUT("/api/chatbot/flows/{id}/rollout", app.UpdateChatbotFlowRollout)
+	g.GET("/api/chatbot/flows/{id}/metrics", app.GetChatbotFlowMetrics)
 
 	// AI Contexts
 	g.GET("/api/chatbot/ai-contexts", app.ListAIContexts)
@@ -478,6 +630,11 @@ func setupRoutes(g *fastglue.Fastglue, app *handlers.App, lo logf.Logger, basePa
 	g.DELETE("/api/webhooks/:id", app.DeleteWebhook)
 	g.POST("/api/webhooks/:id/test", app.TestWebhook)
 
+	// Inbound webhook dead letters (admin only - enforced by middleware)
+	g.GET("/api/webhooks/dead-letters", app.ListWebhookDeadLetters)
+	g.POST("/api/webhooks/dead-letters/{id}/replay", app.ReplayWebhookDeadLetter)
+	g.DELETE("/api/webhooks/dead-letters/{id}", app.DeleteWebhookDeadLetter)
+
 	// Serve embedded frontend (SPA)
 	if frontend.IsEmbedded() {
 		lo.Info("Serving embedded frontend", "base_path", basePath)
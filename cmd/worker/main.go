@@ -9,6 +9,7 @@ import (
 
 	"github.com/isaee-xyz/whatomate/internal/config"
 	"github.com/isaee-xyz/whatomate/internal/database"
+	"github.com/isaee-xyz/whatomate/internal/secrets"
 	"github.com/isaee-xyz/whatomate/internal/worker"
 	"github.com/zerodha/logf"
 )
@@ -46,6 +47,13 @@ func main() {
 		})
 	}
 
+	// Encrypt credentials stored in the database; must be set up before the first query
+	keyring, err := secrets.NewKeyring(&cfg.Encryption)
+	if err != nil {
+		lo.Fatal("Failed to initialize encryption keys", "error", err)
+	}
+	secrets.Register(keyring)
+
 	// Connect to PostgreSQL
 	db, err := database.NewPostgres(&cfg.Database, cfg.App.Debug)
 	if err != nil {
@@ -152,7 +152,11 @@ export const templatesService = {
   create: (data: any) => api.post('/templates', data),
   update: (id: string, data: any) => api.put(`/templates/${id}`, data),
   delete: (id: string) => api.delete(`/templates/${id}`),
-  sync: () => api.post('/templates/sync')
+  sync: () => api.post('/templates/sync'),
+  validate: (data: any) => api.post('/templates/validate', data),
+  groups: (params?: { account?: string }) => api.get('/templates/groups', { params }),
+  versions: (id: string) => api.get(`/templates/${id}/versions`),
+  getVersion: (id: string, version: number) => api.get(`/templates/${id}/versions/${version}`)
 }
 
 export const flowsService = {
@@ -164,6 +168,8 @@ export const flowsService = {
   saveToMeta: (id: string) => api.post(`/flows/${id}/save-to-meta`),
   publish: (id: string) => api.post(`/flows/${id}/publish`),
   deprecate: (id: string) => api.post(`/flows/${id}/deprecate`),
+  configureEndpoint: (id: string, data: { mode: string; url?: string; secret?: string; responses?: Record<string, any> }) =>
+    api.put(`/flows/${id}/endpoint`, data),
   sync: (whatsappAccount: string) => api.post('/flows/sync', { whatsapp_account: whatsappAccount })
 }
 
@@ -176,18 +182,65 @@ export const campaignsService = {
   start: (id: string) => api.post(`/campaigns/${id}/start`),
   pause: (id: string) => api.post(`/campaigns/${id}/pause`),
   cancel: (id: string) => api.post(`/campaigns/${id}/cancel`),
+  schedule: (id: string, scheduledAt: string) => api.post(`/campaigns/${id}/schedule`, { scheduled_at: scheduledAt }),
+  unschedule: (id: string) => api.delete(`/campaigns/${id}/schedule`),
   retryFailed: (id: string) => api.post(`/campaigns/${id}/retry-failed`),
+  uploadHeaderMedia: (id: string, file: File) => {
+    const formData = new FormData()
+    formData.append('file', file)
+    return api.post(`/campaigns/${id}/header-media`, formData, {
+      headers: { 'Content-Type': 'multipart/form-data' }
+    })
+  },
   stats: (id: string) => api.get(`/campaigns/${id}/stats`),
   // Recipients
   getRecipients: (id: string) => api.get(`/campaigns/${id}/recipients`),
   addRecipients: (id: string, recipients: Array<{ phone_number: string; recipient_name?: string; template_params?: Record<string, any> }>) =>
-    api.post(`/campaigns/${id}/recipients/import`, { recipients })
+    api.post(`/campaigns/${id}/recipients/import`, { recipients }),
+  previewRecipientFile: (id: string, file: File) => {
+    const formData = new FormData()
+    formData.append('file', file)
+    return api.post(`/campaigns/${id}/recipients/upload/preview`, formData, {
+      headers: { 'Content-Type': 'multipart/form-data' }
+    })
+  },
+  uploadRecipientFile: (id: string, file: File, mapping: { phone: string; name: string; params: Record<string, string> }) => {
+    const formData = new FormData()
+    formData.append('file', file)
+    formData.append('mapping', JSON.stringify(mapping))
+    return api.post(`/campaigns/${id}/recipients/upload`, formData, {
+      headers: { 'Content-Type': 'multipart/form-data' }
+    })
+  },
+  downloadImportReport: (id: string, reportId: string) =>
+    api.get(`/campaigns/${id}/recipients/import-reports/${reportId}`, { responseType: 'blob' })
+}
+
+export const optOutsService = {
+  list: (params?: { search?: string; page?: number; limit?: number }) => api.get('/opt-outs', { params }),
+  create: (data: { phone_number: string; reason?: string }) => api.post('/opt-outs', data),
+  delete: (id: string, reason?: string) => api.delete(`/opt-outs/${id}`, { params: { reason } }),
+  events: (params?: { phone_number?: string; page?: number; limit?: number }) => api.get('/opt-outs/events', { params })
+}
+
+export const segmentsService = {
+  list: () => api.get('/segments'),
+  get: (id: string) => api.get(`/segments/${id}`),
+  create: (data: any) => api.post('/segments', data),
+  update: (id: string, data: any) => api.put(`/segments/${id}`, data),
+  delete: (id: string) => api.delete(`/segments/${id}`),
+  preview: (filter: any) => api.post('/segments/preview', { filter }),
+  previewSaved: (id: string) => api.get(`/segments/${id}/preview`)
 }
 
 export const chatbotService = {
   // Settings
   getSettings: () => api.get('/chatbot/settings'),
   updateSettings: (data: any) => api.put('/chatbot/settings', data),
+  exportBundle: (params?: { whatsapp_account?: string; format?: 'json' | 'yaml' }) =>
+    api.get('/chatbot/export', { params, responseType: 'blob' }),
+  importBundle: (bundle: string, params?: { whatsapp_account?: string; on_conflict?: 'skip' | 'replace'; dry_run?: boolean }) =>
+    api.post('/chatbot/import', bundle, { params, headers: { 'Content-Type': 'text/plain' } }),
 
   // Keywords
   listKeywords: () => api.get('/chatbot/keywords'),
@@ -202,6 +255,14 @@ export const chatbotService = {
   createFlow: (data: any) => api.post('/chatbot/flows', data),
   updateFlow: (id: string, data: any) => api.put(`/chatbot/flows/${id}`, data),
   deleteFlow: (id: string) => api.delete(`/chatbot/flows/${id}`),
+  simulateFlow: (data: any) => api.post('/chatbot/flows/simulate', data),
+  flowVersions: (id: string) => api.get(`/chatbot/flows/${id}/versions`),
+  getFlowVersion: (id: string, version: number | 'draft') => api.get(`/chatbot/flows/${id}/versions/${version}`),
+  diffFlowVersions: (id: string, from?: number | 'draft', to?: number | 'draft') => api.get(`/chatbot/flows/${id}/diff`, { params: { from, to } }),
+  publishFlow: (id: string, data?: { notes?: string; rollout_percent?: number }) => api.post(`/chatbot/flows/${id}/publish`, data || {}),
+  rollbackFlow: (id: string, version?: number) => api.post(`/chatbot/flows/${id}/rollback`, version ? { version } : {}),
+  updateFlowRollout: (id: string, version: number, percent: number) => api.put(`/chatbot/flows/${id}/rollout`, { version, percent }),
+  flowMetrics: (id: string) => api.get(`/chatbot/flows/${id}/metrics`),
 
   // AI Contexts
   listAIContexts: () => api.get('/chatbot/ai-contexts'),
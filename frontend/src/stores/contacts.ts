@@ -14,6 +14,7 @@ export interface Contact {
   last_message_at?: string
   unread_count: number
   assigned_user_id?: string
+  window_expires_at?: string | null
   created_at: string
   updated_at: string
 }
@@ -253,6 +254,8 @@ export const useContactsStore = defineStore('contacts', () => {
         contact.last_message_at = message.created_at
         if (message.direction === 'incoming') {
           contact.unread_count++
+          // An inbound message re-opens the 24-hour customer service window
+          contact.window_expires_at = new Date(new Date(message.created_at).getTime() + 24 * 60 * 60 * 1000).toISOString()
         }
       }
     }
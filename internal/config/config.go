@@ -19,6 +19,8 @@ type Config struct {
 	WhatsApp WhatsAppConfig `koanf:"whatsapp"`
 	AI       AIConfig       `koanf:"ai"`
 	Storage  StorageConfig  `koanf:"storage"`
+
+	Encryption EncryptionConfig `koanf:"encryption"`
 }
 
 type AppConfig struct {
@@ -32,7 +34,9 @@ type ServerConfig struct {
 	Port         int    `koanf:"port"`
 	ReadTimeout  int    `koanf:"read_timeout"`
 	WriteTimeout int    `koanf:"write_timeout"`
-	BasePath     string `koanf:"base_path"` // Base path for frontend (e.g., "/whatomate" for proxy pass)
+	BasePath     string `koanf:"base_path"`        // Base path for frontend (e.g., "/whatomate" for proxy pass)
+	MaxBodySize  int    `koanf:"max_body_size_mb"` // Maximum request body in MB (e.g. recipient spreadsheet uploads)
+	RootURL      string `koanf:"root_url"`         // Public URL of the server, registered with Meta for WhatsApp Flows endpoints
 }
 
 type DatabaseConfig struct {
@@ -61,8 +65,16 @@ type JWTConfig struct {
 }
 
 type WhatsAppConfig struct {
-	WebhookVerifyToken string `koanf:"webhook_verify_token"`
-	APIVersion         string `koanf:"api_version"`
+	WebhookVerifyToken        string `koanf:"webhook_verify_token"`
+	APIVersion                string `koanf:"api_version"`
+	AppSecret                 string `koanf:"app_secret"`                  // Global Meta App Secret used to verify webhook signatures
+	SkipSignatureVerification bool   `koanf:"skip_signature_verification"` // Accept unsigned webhooks (ignored in production)
+	WebhookPartitions         int    `koanf:"webhook_partitions"`          // Number of Redis stream partitions for inbound webhooks
+	WebhookMaxAttempts        int    `koanf:"webhook_max_attempts"`        // Processing attempts before an event is dead-lettered
+	CampaignConcurrency       int    `koanf:"campaign_concurrency"`        // Concurrent sends per campaign (the per-account rate limit still applies)
+	ThrottleRetries           int    `koanf:"throttle_retries"`            // Retries of a send after Meta reports throttling; -1 disables
+	MaxRetries                int    `koanf:"max_retries"`                 // Retries of a read after a transient Meta error; sends are never retried; -1 disables
+	BaseURL                   string `koanf:"base_url"`                    // Graph API base URL; point at a mock such as cmd/fakegraph for testing
 }
 
 type AIConfig struct {
@@ -72,12 +84,21 @@ type AIConfig struct {
 }
 
 type StorageConfig struct {
-	Type      string `koanf:"type"` // local, s3
-	LocalPath string `koanf:"local_path"`
-	S3Bucket  string `koanf:"s3_bucket"`
-	S3Region  string `koanf:"s3_region"`
-	S3Key     string `koanf:"s3_key"`
-	S3Secret  string `koanf:"s3_secret"`
+	Type                string `koanf:"type"` // local, s3
+	LocalPath           string `koanf:"local_path"`
+	S3Bucket            string `koanf:"s3_bucket"`
+	S3Region            string `koanf:"s3_region"`
+	S3Key               string `koanf:"s3_key"`
+	S3Secret            string `koanf:"s3_secret"`
+	S3Endpoint          string `koanf:"s3_endpoint"`             // S3-compatible endpoint such as MinIO; empty for AWS
+	S3UsePathStyle      bool   `koanf:"s3_use_path_style"`       // Address the bucket in the path (required by MinIO)
+	SignedURLExpiryMins int    `koanf:"signed_url_expiry_mins"` // Lifetime of media download URLs
+}
+
+// EncryptionConfig holds the master keys that encrypt credentials stored in the database
+type EncryptionConfig struct {
+	Keys    map[string]string `koanf:"keys"`    // Key ID -> base64-encoded 32-byte master key
+	Current string            `koanf:"current"` // ID of the key new values are encrypted with (optional with a single key)
 }
 
 // Load loads configuration from file and environment variables
@@ -129,6 +150,9 @@ func setDefaults(cfg *Config) {
 	if cfg.Server.WriteTimeout == 0 {
 		cfg.Server.WriteTimeout = 30
 	}
+	if cfg.Server.MaxBodySize == 0 {
+		cfg.Server.MaxBodySize = 64
+	}
 	if cfg.Database.Port == 0 {
 		cfg.Database.Port = 5432
 	}
@@ -153,13 +177,34 @@ func setDefaults(cfg *Config) {
 	if cfg.JWT.RefreshExpiryDays == 0 {
 		cfg.JWT.RefreshExpiryDays = 7
 	}
+	if cfg.WhatsApp.BaseURL == "" {
+		cfg.WhatsApp.BaseURL = "https://graph.facebook.com"
+	}
 	if cfg.WhatsApp.APIVersion == "" {
 		cfg.WhatsApp.APIVersion = "v18.0"
 	}
+	if cfg.WhatsApp.WebhookPartitions == 0 {
+		cfg.WhatsApp.WebhookPartitions = 8
+	}
+	if cfg.WhatsApp.WebhookMaxAttempts == 0 {
+		cfg.WhatsApp.WebhookMaxAttempts = 5
+	}
+	if cfg.WhatsApp.CampaignConcurrency == 0 {
+		cfg.WhatsApp.CampaignConcurrency = 10
+	}
+	if cfg.WhatsApp.ThrottleRetries == 0 {
+		cfg.WhatsApp.ThrottleRetries = 5
+	}
+	if cfg.WhatsApp.MaxRetries == 0 {
+		cfg.WhatsApp.MaxRetries = 2
+	}
 	if cfg.Storage.Type == "" {
 		cfg.Storage.Type = "local"
 	}
 	if cfg.Storage.LocalPath == "" {
 		cfg.Storage.LocalPath = "./uploads"
 	}
+	if cfg.Storage.SignedURLExpiryMins == 0 {
+		cfg.Storage.SignedURLExpiryMins = 15
+	}
 }
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadRetryDefaults(t *testing.T) {
+	tests := []struct {
+		name           string
+		toml           string
+		throttle, read int
+	}{
+		{"unset", "", 5, 2},
+		{"zero uses the default", "throttle_retries = 0\nmax_retries = 0\n", 5, 2},
+		{"configured", "throttle_retries = 3\nmax_retries = 4\n", 3, 4},
+		{"disabled", "throttle_retries = -1\nmax_retries = -1\n", -1, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(path, []byte("[whatsapp]\n"+tt.toml), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.WhatsApp.ThrottleRetries != tt.throttle {
+				t.Errorf("ThrottleRetries = %d, want %d", cfg.WhatsApp.ThrottleRetries, tt.throttle)
+			}
+			if cfg.WhatsApp.MaxRetries != tt.read {
+				t.Errorf("MaxRetries = %d, want %d", cfg.WhatsApp.MaxRetries, tt.read)
+			}
+		})
+	}
+}
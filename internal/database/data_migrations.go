@@ -0,0 +1,114 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// dataMigration records a one-off data migration that has been applied
+type dataMigration struct {
+	Name      string    `gorm:"primaryKey;size:100"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (dataMigration) TableName() string {
+	return "data_migrations"
+}
+
+// DataMigration rewrites existing rows once, e.g. after a change to how a column is stored.
+// Run returns warnings about rows it could not migrate.
+type DataMigration struct {
+	Name string
+	Run  func(tx *gorm.DB) ([]string, error)
+}
+
+// GetDataMigrations returns the one-off data migrations in the order they are applied
+func GetDataMigrations() []DataMigration {
+	return []DataMigration{
+		{"normalize_contact_phone_numbers", normalizeContactPhoneNumbers},
+		{"backfill_contact_service_windows", backfillContactServiceWindows},
+	}
+}
+
+// runDataMigration applies a data migration unless it has been applied before. The
+// migration and its record are written in one transaction so it runs exactly once.
+func runDataMigration(db *gorm.DB, m DataMigration) ([]string, error) {
+	var warnings []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&dataMigration{}).Where("name = ?", m.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+
+		var err error
+		if warnings, err = m.Run(tx); err != nil {
+			return err
+		}
+		return tx.Create(&dataMigration{Name: m.Name, AppliedAt: time.Now()}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to run data migration %s: %w", m.Name, err)
+	}
+	return warnings, nil
+}
+
+// normalizeContactPhoneNumbers strips contacts' phone numbers to the canonical digits-only
+// form. A contact whose normalized number belongs to another contact of the organization is
+// left unchanged and reported, so duplicates can be merged by hand.
+func normalizeContactPhoneNumbers(tx *gorm.DB) ([]string, error) {
+	var contacts []struct {
+		ID             uuid.UUID
+		OrganizationID uuid.UUID
+		PhoneNumber    string
+	}
+	if err := tx.Table("contacts").Select("id, organization_id, phone_number").Where("phone_number ~ '[^0-9]'").Order("created_at").Scan(&contacts).Error; err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	for _, c := range contacts {
+		normalized := digitsOnly(c.PhoneNumber)
+
+		var existing []uuid.UUID
+		if err := tx.Table("contacts").Where("organization_id = ? AND phone_number = ?", c.OrganizationID, normalized).Limit(1).Pluck("id", &existing).Error; err != nil {
+			return nil, err
+		}
+		if len(existing) > 0 {
+			warnings = append(warnings, fmt.Sprintf("contact %s (%s) was not normalized: contact %s already has the number %s", c.ID, c.PhoneNumber, existing[0], normalized))
+			continue
+		}
+
+		if err := tx.Table("contacts").Where("id = ?", c.ID).Update("phone_number", normalized).Error; err != nil {
+			return nil, err
+		}
+	}
+	return warnings, nil
+}
+
+// backfillContactServiceWindows opens the customer service windows of contacts that wrote
+// in the last 24 hours, from before inbound messages were tracked per account
+func backfillContactServiceWindows(tx *gorm.DB) ([]string, error) {
+	err := tx.Exec(`INSERT INTO contact_service_windows (organization_id, contact_id, whats_app_account, last_inbound_at, created_at, updated_at)
+		SELECT organization_id, contact_id, whats_app_account, MAX(created_at), NOW(), NOW() FROM messages
+		WHERE direction = 'incoming' AND deleted_at IS NULL AND created_at > NOW() - INTERVAL '24 hours'
+		GROUP BY organization_id, contact_id, whats_app_account
+		ON CONFLICT (contact_id, whats_app_account) DO NOTHING`).Error
+	return nil, err
+}
+
+// digitsOnly removes everything but the digits from s
+func digitsOnly(s string) string {
+	digits := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			digits = append(digits, s[i])
+		}
+	}
+	return string(digits)
+}
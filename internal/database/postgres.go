@@ -8,6 +8,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/isaee-xyz/whatomate/internal/config"
 	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/internal/secrets"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -66,11 +67,16 @@ func GetMigrationModels() []MigrationModel {
 		{"Webhook", &models.Webhook{}},
 		{"WhatsAppAccount", &models.WhatsAppAccount{}},
 		{"Contact", &models.Contact{}},
+		{"ContactServiceWindow", &models.ContactServiceWindow{}},
+		{"ContactOptOut", &models.ContactOptOut{}},
+		{"ContactOptOutEvent", &models.ContactOptOutEvent{}},
 		{"Message", &models.Message{}},
 		{"Template", &models.Template{}},
+		{"TemplateVersion", &models.TemplateVersion{}},
 		{"WhatsAppFlow", &models.WhatsAppFlow{}},
 
 		// Bulk & Notifications
+		{"Segment", &models.Segment{}},
 		{"BulkMessageCampaign", &models.BulkMessageCampaign{}},
 		{"BulkMessageRecipient", &models.BulkMessageRecipient{}},
 		{"NotificationRule", &models.NotificationRule{}},
@@ -80,6 +86,8 @@ func GetMigrationModels() []MigrationModel {
 		{"KeywordRule", &models.KeywordRule{}},
 		{"ChatbotFlow", &models.ChatbotFlow{}},
 		{"ChatbotFlowStep", &models.ChatbotFlowStep{}},
+		{"ChatbotFlowVersion", &models.ChatbotFlowVersion{}},
+		{"ChatbotFlowRun", &models.ChatbotFlowRun{}},
 		{"ChatbotSession", &models.ChatbotSession{}},
 		{"ChatbotSessionMessage", &models.ChatbotSessionMessage{}},
 		{"AIContext", &models.AIContext{}},
@@ -110,10 +118,11 @@ func RunMigrationWithProgress(db *gorm.DB) error {
 	silentDB := db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
 
 	migrationModels := GetMigrationModels()
+	dataMigrations := GetDataMigrations()
 	indexes := getIndexes()
 
-	// Total steps: models + indexes + default admin check
-	totalSteps := len(migrationModels) + len(indexes) + 1
+	// Total steps: models + data migrations + indexes + default admin check
+	totalSteps := len(migrationModels) + len(dataMigrations) + len(indexes) + 1
 	currentStep := 0
 	barWidth := 40
 
@@ -139,6 +148,23 @@ func RunMigrationWithProgress(db *gorm.DB) error {
 		currentStep++
 	}
 
+	// Apply one-off data migrations that have not run yet
+	if err := silentDB.AutoMigrate(&dataMigration{}); err != nil {
+		fmt.Printf("\n  \033[31m✗ Migration failed: DataMigration\033[0m\n\n")
+		return fmt.Errorf("failed to migrate DataMigration: %w", err)
+	}
+	var warnings []string
+	for _, m := range dataMigrations {
+		printProgress(currentStep, totalSteps)
+		w, err := runDataMigration(silentDB, m)
+		if err != nil {
+			fmt.Printf("\n  \033[31m✗ Data migration failed: %s\033[0m\n\n", m.Name)
+			return err
+		}
+		warnings = append(warnings, w...)
+		currentStep++
+	}
+
 	// Create indexes
 	for _, idx := range indexes {
 		printProgress(currentStep, totalSteps)
@@ -159,10 +185,26 @@ func RunMigrationWithProgress(db *gorm.DB) error {
 
 	printProgress(currentStep, totalSteps)
 	fmt.Printf("\n  \033[32m✓ Migration completed\033[0m\n\n")
+	for _, w := range warnings {
+		fmt.Printf("  \033[33m! %s\033[0m\n", w)
+	}
+	if len(warnings) > 0 {
+		fmt.Println()
+	}
 
 	return nil
 }
 
+// ReencryptSecrets encrypts credential columns that are still plaintext or encrypted with
+// an old key with the current key. Returns the number of values rewritten.
+func ReencryptSecrets(db *gorm.DB, keyring *secrets.Keyring) (int, error) {
+	var all []interface{}
+	for _, m := range GetMigrationModels() {
+		all = append(all, m.Model)
+	}
+	return secrets.Reencrypt(db, keyring, all...)
+}
+
 // repeatChar repeats a character n times
 func repeatChar(char string, n int) string {
 	result := ""
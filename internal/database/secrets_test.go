@@ -0,0 +1,112 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/config"
+	"github.com/isaee-xyz/whatomate/internal/secrets"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// TestEncryptedColumns saves every model with encrypted fields and checks that the values
+// reach the database encrypted, both on create and on update. It builds the statements in
+// dry-run mode, so no database is needed.
+func TestEncryptedColumns(t *testing.T) {
+	k, err := secrets.NewKeyring(&config.EncryptionConfig{Keys: map[string]string{
+		"test": base64.StdEncoding.EncodeToString(make([]byte, 32)),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets.Register(k)
+
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1 port=1 user=x dbname=x sslmode=disable"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := 0
+	for _, m := range GetMigrationModels() {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m.Model); err != nil {
+			t.Fatalf("%s: %v", m.Name, err)
+		}
+
+		var fields []*schema.Field
+		for _, field := range stmt.Schema.Fields {
+			if field.TagSettings["SERIALIZER"] == secrets.SerializerName {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		t.Run(m.Name, func(t *testing.T) {
+			model := reflect.New(reflect.TypeOf(m.Model).Elem())
+			if id := model.Elem().FieldByName("ID"); id.IsValid() && id.Type() == reflect.TypeOf(uuid.UUID{}) {
+				id.Set(reflect.ValueOf(uuid.New()))
+			}
+
+			var columns []string
+			for _, field := range fields {
+				found++
+				columns = append(columns, field.DBName)
+				plaintext := "plaintext-" + field.DBName
+				if err := field.Set(stmt.Context, model.Elem(), plaintext); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			create := db.Session(&gorm.Session{}).Create(model.Interface()).Statement
+			update := db.Session(&gorm.Session{}).Model(model.Interface()).Select(columns).Updates(model.Interface()).Statement
+			for name, s := range map[string]*gorm.Statement{"create": create, "update": update} {
+				for _, field := range fields {
+					assertEncrypted(t, k, name, s.Vars, "plaintext-"+field.DBName)
+				}
+			}
+		})
+	}
+
+	// The credentials of accounts, SSO providers, chatbot AI and flow endpoints
+	if found < 6 {
+		t.Errorf("found %d encrypted fields, want at least 6", found)
+	}
+}
+
+// assertEncrypted checks that plaintext is among vars only as a value encrypted with k
+func assertEncrypted(t *testing.T, k *secrets.Keyring, name string, vars []interface{}, plaintext string) {
+	t.Helper()
+	for _, v := range vars {
+		if valuer, ok := v.(driver.Valuer); ok {
+			var err error
+			if v, err = valuer.Value(); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		value, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if value == plaintext {
+			t.Errorf("%s: %s is stored in plaintext", name, plaintext)
+			return
+		}
+		if secrets.IsEncrypted(value) {
+			if decrypted, err := k.Decrypt(value); err == nil && decrypted == plaintext {
+				return
+			}
+		}
+	}
+	t.Errorf("%s: %s is not stored", name, plaintext)
+}
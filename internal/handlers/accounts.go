@@ -3,13 +3,13 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 
 	"github.com/google/uuid"
 	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/internal/ratelimit"
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
 	"github.com/valyala/fasthttp"
 	"github.com/zerodha/fastglue"
 )
@@ -18,6 +18,7 @@ import (
 type AccountRequest struct {
 	Name               string `json:"name" validate:"required"`
 	AppID              string `json:"app_id"`
+	AppSecret          string `json:"app_secret"`
 	PhoneID            string `json:"phone_id" validate:"required"`
 	BusinessID         string `json:"business_id" validate:"required"`
 	AccessToken        string `json:"access_token" validate:"required"`
@@ -26,6 +27,8 @@ type AccountRequest struct {
 	IsDefaultIncoming  bool   `json:"is_default_incoming"`
 	IsDefaultOutgoing  bool   `json:"is_default_outgoing"`
 	AutoReadReceipt    bool   `json:"auto_read_receipt"`
+	ThroughputTier     string `json:"throughput_tier"`
+	MessagesPerSecond  *int   `json:"messages_per_second"`
 }
 
 // AccountResponse represents the response for an account (without sensitive data)
@@ -41,7 +44,10 @@ type AccountResponse struct {
 	IsDefaultOutgoing  bool      `json:"is_default_outgoing"`
 	AutoReadReceipt    bool      `json:"auto_read_receipt"`
 	Status             string    `json:"status"`
+	ThroughputTier     string    `json:"throughput_tier"`
+	MessagesPerSecond  int       `json:"messages_per_second"`
 	HasAccessToken     bool      `json:"has_access_token"`
+	HasAppSecret       bool      `json:"has_app_secret"`
 	PhoneNumber        string    `json:"phone_number,omitempty"`
 	DisplayName        string    `json:"display_name,omitempty"`
 	CreatedAt          string    `json:"created_at"`
@@ -101,19 +107,34 @@ func (a *App) CreateAccount(r *fastglue.Request) error {
 		apiVersion = "v21.0"
 	}
 
+	throughputTier := req.ThroughputTier
+	if throughputTier == "" {
+		throughputTier = ratelimit.TierStandard
+	}
+	if err := validateThroughput(throughputTier, req.MessagesPerSecond); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+	messagesPerSecond := 0
+	if req.MessagesPerSecond != nil {
+		messagesPerSecond = *req.MessagesPerSecond
+	}
+
 	account := models.WhatsAppAccount{
 		OrganizationID:     orgID,
 		Name:               req.Name,
 		AppID:              req.AppID,
+		AppSecret:          req.AppSecret,
 		PhoneID:            req.PhoneID,
 		BusinessID:         req.BusinessID,
-		AccessToken:        req.AccessToken, // TODO: encrypt before storing
+		AccessToken:        req.AccessToken, // Encrypted by the column serializer
 		WebhookVerifyToken: webhookVerifyToken,
 		APIVersion:         apiVersion,
 		IsDefaultIncoming:  req.IsDefaultIncoming,
 		IsDefaultOutgoing:  req.IsDefaultOutgoing,
 		AutoReadReceipt:    req.AutoReadReceipt,
 		Status:             "active",
+		ThroughputTier:     throughputTier,
+		MessagesPerSecond:  messagesPerSecond,
 	}
 
 	// If this is set as default, unset other defaults
@@ -190,6 +211,9 @@ func (a *App) UpdateAccount(r *fastglue.Request) error {
 	if req.AppID != "" {
 		account.AppID = req.AppID
 	}
+	if req.AppSecret != "" {
+		account.AppSecret = req.AppSecret
+	}
 	if req.PhoneID != "" {
 		account.PhoneID = req.PhoneID
 	}
@@ -197,7 +221,7 @@ func (a *App) UpdateAccount(r *fastglue.Request) error {
 		account.BusinessID = req.BusinessID
 	}
 	if req.AccessToken != "" {
-		account.AccessToken = req.AccessToken // TODO: encrypt
+		account.AccessToken = req.AccessToken
 	}
 	if req.WebhookVerifyToken != "" {
 		account.WebhookVerifyToken = req.WebhookVerifyToken
@@ -207,6 +231,20 @@ func (a *App) UpdateAccount(r *fastglue.Request) error {
 	}
 	account.AutoReadReceipt = req.AutoReadReceipt
 
+	if req.ThroughputTier != "" || req.MessagesPerSecond != nil {
+		tier := req.ThroughputTier
+		if tier == "" {
+			tier = account.ThroughputTier
+		}
+		if err := validateThroughput(tier, req.MessagesPerSecond); err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+		}
+		account.ThroughputTier = tier
+		if req.MessagesPerSecond != nil {
+			account.MessagesPerSecond = *req.MessagesPerSecond
+		}
+	}
+
 	// Handle default flags
 	if req.IsDefaultIncoming && !account.IsDefaultIncoming {
 		a.DB.Model(&models.WhatsAppAccount{}).
@@ -281,46 +319,62 @@ func (a *App) TestAccountConnection(r *fastglue.Request) error {
 	}
 
 	// Test the connection by fetching phone number details from Meta API
-	url := fmt.Sprintf("https://graph.facebook.com/%s/%s?fields=display_phone_number,verified_name,quality_rating,messaging_limit_tier",
-		account.APIVersion, account.PhoneID)
-
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Authorization", "Bearer "+account.AccessToken)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	info, err := a.WhatsApp.GetPhoneNumber(r.RequestCtx, &whatsapp.Account{
+		PhoneID:     account.PhoneID,
+		APIVersion:  account.APIVersion,
+		AccessToken: account.AccessToken,
+	})
 	if err != nil {
-		return r.SendEnvelope(map[string]interface{}{
-			"success": false,
-			"error":   "Failed to connect to WhatsApp API: " + err.Error(),
-		})
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-
-	if resp.StatusCode != 200 {
-		var errorResp map[string]interface{}
-		json.Unmarshal(body, &errorResp)
-		return r.SendEnvelope(map[string]interface{}{
-			"success": false,
-			"error":   "API error",
-			"details": errorResp,
-		})
-	}
-
-	var result map[string]interface{}
-	json.Unmarshal(body, &result)
+		// Only credential problems change the account status; a transient failure says
+		// nothing about whether the account works
+		class := whatsapp.ClassOf(err)
+		if class == whatsapp.ErrorClassAuth {
+			a.setAccountStatus(&account, "error")
+		}
+		result := map[string]interface{}{
+			"success":     false,
+			"error":       err.Error(),
+			"error_class": class,
+			"status":      account.Status,
+		}
+		var apiErr *whatsapp.APIError
+		if errors.As(err, &apiErr) {
+			result["details"] = map[string]interface{}{
+				"code":         apiErr.Code,
+				"subcode":      apiErr.Subcode,
+				"type":         apiErr.Type,
+				"message":      apiErr.Message,
+				"user_message": apiErr.UserMessage,
+				"fbtrace_id":   apiErr.FBTraceID,
+			}
+		}
+		return r.SendEnvelope(result)
+	}
+
+	a.setAccountStatus(&account, "active")
 
 	return r.SendEnvelope(map[string]interface{}{
 		"success":              true,
-		"display_phone_number": result["display_phone_number"],
-		"verified_name":        result["verified_name"],
-		"quality_rating":       result["quality_rating"],
-		"messaging_limit_tier": result["messaging_limit_tier"],
+		"status":               account.Status,
+		"display_phone_number": info.DisplayPhoneNumber,
+		"verified_name":        info.VerifiedName,
+		"quality_rating":       info.QualityRating,
+		"messaging_limit_tier": info.MessagingLimitTier,
 	})
 }
 
+// setAccountStatus persists a changed account status and drops the cached account
+func (a *App) setAccountStatus(account *models.WhatsAppAccount, status string) {
+	if account.Status == status {
+		return
+	}
+	if err := a.DB.Model(account).Update("status", status).Error; err != nil {
+		a.Log.Error("Failed to update account status", "error", err, "account", account.Name)
+		return
+	}
+	a.InvalidateWhatsAppAccountCache(account.PhoneID)
+}
+
 // Helper functions
 
 func accountToResponse(acc models.WhatsAppAccount) AccountResponse {
@@ -336,12 +390,28 @@ func accountToResponse(acc models.WhatsAppAccount) AccountResponse {
 		IsDefaultOutgoing:  acc.IsDefaultOutgoing,
 		AutoReadReceipt:    acc.AutoReadReceipt,
 		Status:             acc.Status,
+		ThroughputTier:     acc.ThroughputTier,
+		MessagesPerSecond:  ratelimit.MessagesPerSecond(acc.ThroughputTier, acc.MessagesPerSecond),
 		HasAccessToken:     acc.AccessToken != "",
+		HasAppSecret:       acc.AppSecret != "",
 		CreatedAt:          acc.CreatedAt.Format("2006-01-02T15:04:05Z"),
 		UpdatedAt:          acc.UpdatedAt.Format("2006-01-02T15:04:05Z"),
 	}
 }
 
+// validateThroughput checks the throughput tier and the optional messages-per-second override.
+// The override may lower the rate but cannot exceed what the tier allows.
+func validateThroughput(tier string, messagesPerSecond *int) error {
+	tierRate, ok := ratelimit.TierRates[tier]
+	if !ok {
+		return fmt.Errorf("invalid throughput_tier %q (expected standard or high)", tier)
+	}
+	if messagesPerSecond != nil && (*messagesPerSecond < 0 || *messagesPerSecond > tierRate) {
+		return fmt.Errorf("messages_per_second must be between 0 and %d for the %s tier", tierRate, tier)
+	}
+	return nil
+}
+
 func generateVerifyToken() string {
 	bytes := make([]byte, 32)
 	rand.Read(bytes)
@@ -8,6 +8,8 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/isaee-xyz/whatomate/internal/config"
 	"github.com/isaee-xyz/whatomate/internal/queue"
+	"github.com/isaee-xyz/whatomate/internal/secrets"
+	"github.com/isaee-xyz/whatomate/internal/storage"
 	"github.com/isaee-xyz/whatomate/internal/websocket"
 	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
 	"github.com/zerodha/fastglue"
@@ -24,7 +26,13 @@ type App struct {
 	WhatsApp          *whatsapp.Client
 	WSHub             *websocket.Hub
 	Queue             queue.Queue
+	WebhookQueue      *queue.WebhookQueue
+	Storage           storage.Store
+	Keyring           *secrets.Keyring // Encrypts credentials kept in Redis caches
 	CampaignSubCancel context.CancelFunc
+
+	// simulation is set on the copy of the app that runs a chatbot flow simulation
+	simulation *flowSimulation
 }
 
 // getOrgIDFromContext extracts organization ID from request context (set by auth middleware)
@@ -94,12 +102,13 @@ func (a *App) StartCampaignStatsSubscriber() error {
 		a.WSHub.BroadcastToOrg(update.OrganizationID, websocket.WSMessage{
 			Type: websocket.TypeCampaignStatsUpdate,
 			Payload: map[string]interface{}{
-				"campaign_id":     update.CampaignID,
-				"status":          update.Status,
-				"sent_count":      update.SentCount,
-				"delivered_count": update.DeliveredCount,
-				"read_count":      update.ReadCount,
-				"failed_count":    update.FailedCount,
+				"campaign_id":         update.CampaignID,
+				"status":              update.Status,
+				"sent_count":          update.SentCount,
+				"delivered_count":     update.DeliveredCount,
+				"read_count":          update.ReadCount,
+				"failed_count":        update.FailedCount,
+				"messages_per_second": update.MessagesPerSecond,
 			},
 		})
 	})
@@ -8,6 +8,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/internal/secrets"
 	"gorm.io/gorm"
 )
 
@@ -15,6 +16,7 @@ const (
 	// Cache TTLs - 6 hours since these rarely change (invalidated on update anyway)
 	settingsCacheTTL        = 6 * time.Hour
 	flowsCacheTTL           = 6 * time.Hour
+	flowVersionsCacheTTL    = 24 * time.Hour // Versions never change
 	keywordRulesCacheTTL    = 6 * time.Hour
 	whatsappAccountCacheTTL = 6 * time.Hour
 	webhooksCacheTTL        = 6 * time.Hour
@@ -24,6 +26,7 @@ const (
 	// Cache key prefixes
 	settingsCachePrefix        = "chatbot:settings:"
 	flowsCachePrefix           = "chatbot:flows:"
+	flowVersionsCachePrefix    = "chatbot:flow_versions:"
 	keywordRulesCachePrefix    = "chatbot:keywords:"
 	whatsappAccountCachePrefix = "whatsapp:account:"
 	webhooksCachePrefix        = "webhooks:"
@@ -64,7 +67,8 @@ func (a *App) getChatbotSettingsCached(orgID uuid.UUID, whatsAppAccount string)
 	return &settings, nil
 }
 
-// getChatbotFlowsCached retrieves all enabled flows with steps from cache or database
+// getChatbotFlowsCached retrieves all enabled flows with steps from cache or database.
+// Published flows come as their published version.
 func (a *App) getChatbotFlowsCached(orgID uuid.UUID) ([]models.ChatbotFlow, error) {
 	ctx := context.Background()
 	cacheKey := fmt.Sprintf("%s%s", flowsCachePrefix, orgID.String())
@@ -87,6 +91,16 @@ func (a *App) getChatbotFlowsCached(orgID uuid.UUID) ([]models.ChatbotFlow, erro
 		Find(&flows).Error; err != nil {
 		return nil, err
 	}
+	for i := range flows {
+		if flows[i].PublishedVersion == 0 {
+			continue
+		}
+		var version models.ChatbotFlowVersion
+		if err := a.DB.Where("flow_id = ? AND version = ?", flows[i].ID, flows[i].PublishedVersion).First(&version).Error; err != nil {
+			return nil, err
+		}
+		flows[i] = chatbotFlowFromVersion(&flows[i], &version)
+	}
 
 	// Cache the result
 	if data, err := json.Marshal(flows); err == nil {
@@ -98,6 +112,12 @@ func (a *App) getChatbotFlowsCached(orgID uuid.UUID) ([]models.ChatbotFlow, erro
 
 // getChatbotFlowByIDCached retrieves a specific flow by ID from the cached flows list
 func (a *App) getChatbotFlowByIDCached(orgID uuid.UUID, flowID uuid.UUID) (*models.ChatbotFlow, error) {
+	if a.simulation != nil {
+		if flow, ok := a.simulation.flows[flowID]; ok {
+			return flow, nil
+		}
+	}
+
 	flows, err := a.getChatbotFlowsCached(orgID)
 	if err != nil {
 		return nil, err
@@ -112,6 +132,44 @@ func (a *App) getChatbotFlowByIDCached(orgID uuid.UUID, flowID uuid.UUID) (*mode
 	return nil, gorm.ErrRecordNotFound
 }
 
+// getChatbotFlowVersionCached retrieves an enabled flow as published in a version, for the
+// sessions that run it. Version 0 is the flow as it was before it was first published.
+func (a *App) getChatbotFlowVersionCached(orgID uuid.UUID, flowID uuid.UUID, version int) (*models.ChatbotFlow, error) {
+	flow, err := a.getChatbotFlowByIDCached(orgID, flowID)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		if flow.Version == 0 {
+			return flow, nil
+		}
+		// The first version is recorded from the flow as it ran before
+		version = 1
+	}
+	if version == flow.Version {
+		return flow, nil
+	}
+
+	ctx := context.Background()
+	cacheKey := fmt.Sprintf("%s%s:%d", flowVersionsCachePrefix, flowID.String(), version)
+
+	// Try cache first
+	var flowVersion models.ChatbotFlowVersion
+	cached, err := a.Redis.Get(ctx, cacheKey).Result()
+	if err != nil || cached == "" || json.Unmarshal([]byte(cached), &flowVersion) != nil {
+		// Cache miss - fetch from database
+		if err := a.DB.Where("flow_id = ? AND version = ?", flowID, version).First(&flowVersion).Error; err != nil {
+			return nil, err
+		}
+		if data, err := json.Marshal(flowVersion); err == nil {
+			a.Redis.Set(ctx, cacheKey, data, flowVersionsCacheTTL)
+		}
+	}
+
+	versioned := chatbotFlowFromVersion(flow, &flowVersion)
+	return &versioned, nil
+}
+
 // getKeywordRulesCached retrieves keyword rules from cache or database
 func (a *App) getKeywordRulesCached(orgID uuid.UUID, whatsAppAccount string) ([]models.KeywordRule, error) {
 	ctx := context.Background()
@@ -183,7 +241,8 @@ func (a *App) deleteKeysByPattern(ctx context.Context, pattern string) {
 	}
 }
 
-// whatsAppAccountCache is used for caching since AccessToken has json:"-" tag
+// whatsAppAccountCache is used for caching since AccessToken has json:"-" tag.
+// The token is kept encrypted with the app's keyring so Redis never holds it in plaintext.
 type whatsAppAccountCache struct {
 	models.WhatsAppAccount
 	AccessToken string `json:"access_token"`
@@ -198,9 +257,11 @@ func (a *App) getWhatsAppAccountCached(phoneID string) (*models.WhatsAppAccount,
 	cached, err := a.Redis.Get(ctx, cacheKey).Result()
 	if err == nil && cached != "" {
 		var cacheData whatsAppAccountCache
-		if err := json.Unmarshal([]byte(cached), &cacheData); err == nil {
-			cacheData.WhatsAppAccount.AccessToken = cacheData.AccessToken
-			return &cacheData.WhatsAppAccount, nil
+		if err := json.Unmarshal([]byte(cached), &cacheData); err == nil && secrets.IsEncrypted(cacheData.AccessToken) {
+			if token, err := a.Keyring.Decrypt(cacheData.AccessToken); err == nil {
+				cacheData.WhatsAppAccount.AccessToken = token
+				return &cacheData.WhatsAppAccount, nil
+			}
 		}
 	}
 
@@ -210,10 +271,15 @@ func (a *App) getWhatsAppAccountCached(phoneID string) (*models.WhatsAppAccount,
 		return nil, err
 	}
 
-	// Cache the result (include AccessToken explicitly)
+	// Cache the result (include the encrypted AccessToken explicitly)
+	token, err := a.Keyring.Encrypt(account.AccessToken)
+	if err != nil {
+		a.Log.Error("Failed to encrypt access token for cache", "error", err, "phone_id", phoneID)
+		return &account, nil
+	}
 	cacheData := whatsAppAccountCache{
 		WhatsAppAccount: account,
-		AccessToken:     account.AccessToken,
+		AccessToken:     token,
 	}
 	if data, err := json.Marshal(cacheData); err == nil {
 		a.Redis.Set(ctx, cacheKey, data, whatsappAccountCacheTTL)
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/internal/templates"
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+	"gorm.io/gorm"
+)
+
+// headerMediaMaxAge is how long an uploaded header media ID is used. Meta deletes
+// uploaded media after 30 days; the margin leaves time for the campaign to run.
+const headerMediaMaxAge = 29 * 24 * time.Hour
+
+// campaignHeaderMediaJSONB checks header media given for a campaign against its template
+// and returns it as stored on the campaign
+func campaignHeaderMediaJSONB(h *whatsapp.TemplateHeaderParam, template *models.Template) (models.JSONB, error) {
+	media := models.JSONB{}
+	if h == nil || (h.MediaID == "" && h.Link == "") {
+		return media, nil
+	}
+
+	headerType := strings.ToLower(template.HeaderType)
+	switch headerType {
+	case "image", "video", "document":
+	default:
+		return nil, errors.New("header_media is only used for templates with an image, video or document header")
+	}
+	if h.Type != "" && !strings.EqualFold(h.Type, headerType) {
+		return nil, fmt.Errorf("template header expects %s, got %s", headerType, h.Type)
+	}
+
+	media["type"] = headerType
+	if h.MediaID != "" {
+		media["media_id"] = h.MediaID
+	} else {
+		media["link"] = h.Link
+	}
+	if h.Filename != "" {
+		media["filename"] = h.Filename
+	}
+	return media, nil
+}
+
+// validateCampaignParams checks, before a campaign starts, that its template is approved,
+// its header media is usable and every pending recipient has the template's parameters
+func (a *App) validateCampaignParams(campaign *models.BulkMessageCampaign) error {
+	var template models.Template
+	if err := a.DB.Where("id = ?", campaign.TemplateID).First(&template).Error; err != nil {
+		return errors.New("template not found")
+	}
+	if !strings.EqualFold(template.Status, "APPROVED") {
+		return fmt.Errorf("template %s is not approved", template.Name)
+	}
+
+	header := templates.CampaignHeaderParam(campaign)
+	if header != nil && header.MediaID != "" {
+		// Uploaded media belongs to the account it was uploaded with and expires
+		if account, _ := campaign.HeaderMedia["whatsapp_account"].(string); account != "" && account != campaign.WhatsAppAccount {
+			return errors.New("header media was uploaded for another WhatsApp account; upload it again")
+		}
+		if uploaded, _ := campaign.HeaderMedia["uploaded_at"].(string); uploaded != "" {
+			if t, err := time.Parse(time.RFC3339, uploaded); err == nil && time.Since(t) > headerMediaMaxAge {
+				return errors.New("header media has expired on WhatsApp; upload it again")
+			}
+		}
+	}
+
+	layout := templates.Layout(&template)
+	var invalid int
+	var example string
+	var batch []models.BulkMessageRecipient
+	err := a.DB.Select("id", "phone_number", "template_params").
+		Where("campaign_id = ? AND status = ?", campaign.ID, "pending").
+		FindInBatches(&batch, recipientImportBatchSize, func(tx *gorm.DB, batchNum int) error {
+			for i := range batch {
+				if _, err := layout.ParamsFromValues(batch[i].TemplateParams, header); err != nil {
+					if invalid == 0 {
+						example = fmt.Sprintf("%s: %v", batch[i].PhoneNumber, err)
+					}
+					invalid++
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		a.Log.Error("Failed to check campaign recipients", "error", err, "campaign_id", campaign.ID)
+		return errors.New("failed to check campaign recipients")
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d recipient(s) cannot be sent the template (%s)", invalid, example)
+	}
+	return nil
+}
+
+// UploadCampaignHeaderMedia uploads the header image, video or document of a campaign's
+// template to WhatsApp once; every recipient is then sent the same media ID
+func (a *App) UploadCampaignHeaderMedia(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	id, err := uuid.Parse(r.RequestCtx.UserValue("id").(string))
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid campaign ID", nil, "")
+	}
+
+	var campaign models.BulkMessageCampaign
+	if err := a.DB.Where("id = ? AND organization_id = ?", id, orgID).Preload("Template").First(&campaign).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Campaign not found", nil, "")
+	}
+	if campaign.Status != "draft" && campaign.Status != "scheduled" && campaign.Status != "paused" {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Can only change header media of draft, scheduled or paused campaigns", nil, "")
+	}
+	if campaign.Template == nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Template not found", nil, "")
+	}
+
+	headerType := strings.ToLower(campaign.Template.HeaderType)
+	if headerType != "image" && headerType != "video" && headerType != "document" {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Template has no image, video or document header", nil, "")
+	}
+
+	form, err := r.RequestCtx.MultipartForm()
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid multipart form", nil, "")
+	}
+	files := form.File["file"]
+	if len(files) == 0 {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "file is required", nil, "")
+	}
+	fileHeader := files[0]
+
+	mimeType := fileHeader.Header.Get("Content-Type")
+	if headerType != "document" && !strings.HasPrefix(mimeType, headerType+"/") {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, fmt.Sprintf("Template header expects a %s file", headerType), nil, "")
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Failed to read file", nil, "")
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to read file data", nil, "")
+	}
+
+	var account models.WhatsAppAccount
+	if err := a.DB.Where("name = ? AND organization_id = ?", campaign.WhatsAppAccount, orgID).First(&account).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "WhatsApp account not found", nil, "")
+	}
+	waAccount := &whatsapp.Account{
+		PhoneID:     account.PhoneID,
+		BusinessID:  account.BusinessID,
+		APIVersion:  account.APIVersion,
+		AccessToken: account.AccessToken,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	mediaID, err := a.WhatsApp.UploadMedia(ctx, waAccount, data, mimeType, fileHeader.Filename)
+	if err != nil {
+		a.Log.Error("Failed to upload campaign header media", "error", err, "campaign_id", id)
+		return r.SendErrorEnvelope(fasthttp.StatusBadGateway, "Failed to upload media to WhatsApp", nil, "")
+	}
+
+	headerMedia := models.JSONB{
+		"type":             headerType,
+		"media_id":         mediaID,
+		"filename":         fileHeader.Filename,
+		"mime_type":        mimeType,
+		"whatsapp_account": account.Name,
+		"uploaded_at":      time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := a.DB.Model(&campaign).Update("header_media", headerMedia).Error; err != nil {
+		a.Log.Error("Failed to save campaign header media", "error", err, "campaign_id", id)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to save header media", nil, "")
+	}
+
+	a.Log.Info("Campaign header media uploaded", "campaign_id", id, "media_id", mediaID)
+	return r.SendEnvelope(map[string]interface{}{
+		"header_media": headerMedia,
+	})
+}
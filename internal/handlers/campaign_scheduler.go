@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/internal/queue"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// scheduledAtLayouts are the accepted formats for a campaign's scheduled_at.
+// Values without an offset (e.g. from a datetime-local input) are read in the organization's timezone.
+var scheduledAtLayouts = []string{
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+}
+
+var errInvalidScheduledAt = errors.New("invalid scheduled_at: expected RFC 3339 or YYYY-MM-DDTHH:MM")
+
+// getOrganizationLocation returns the organization's configured timezone, defaulting to UTC
+func (a *App) getOrganizationLocation(orgID uuid.UUID) *time.Location {
+	var org models.Organization
+	if err := a.DB.Select("settings").Where("id = ?", orgID).First(&org).Error; err != nil {
+		return time.UTC
+	}
+	if tz, ok := org.Settings["timezone"].(string); ok && tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			return loc
+		}
+		a.Log.Warn("Invalid organization timezone, using UTC", "organization_id", orgID, "timezone", tz)
+	}
+	return time.UTC
+}
+
+// parseScheduledAt parses a scheduled_at value. An empty value means "not scheduled" and returns nil.
+func parseScheduledAt(value string, loc *time.Location) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	for _, layout := range scheduledAtLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, errInvalidScheduledAt
+}
+
+// validateScheduledAt parses scheduled_at in the organization's timezone and checks it is in the future
+func (a *App) validateScheduledAt(orgID uuid.UUID, value string) (*time.Time, error) {
+	scheduledAt, err := parseScheduledAt(value, a.getOrganizationLocation(orgID))
+	if err != nil {
+		return nil, err
+	}
+	if scheduledAt != nil && !scheduledAt.After(time.Now()) {
+		return nil, errors.New("scheduled_at must be in the future")
+	}
+	return scheduledAt, nil
+}
+
+// CampaignScheduler starts scheduled campaigns when their scheduled time arrives.
+// Due campaigns are claimed with a single conditional UPDATE, so several server
+// instances can run the scheduler without starting a campaign twice.
+type CampaignScheduler struct {
+	app       *App
+	interval  time.Duration
+	publisher *queue.Publisher
+	stopCh    chan struct{}
+}
+
+// NewCampaignScheduler creates a new campaign scheduler
+func NewCampaignScheduler(app *App, interval time.Duration) *CampaignScheduler {
+	return &CampaignScheduler{
+		app:       app,
+		interval:  interval,
+		publisher: queue.NewPublisher(app.Redis, app.Log),
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// Start begins the scheduling loop
+func (s *CampaignScheduler) Start(ctx context.Context) {
+	s.app.Log.Info("Campaign scheduler started", "interval", s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	// Pick up anything that became due while no instance was running
+	s.startDueCampaigns(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.app.Log.Info("Campaign scheduler stopped by context")
+			return
+		case <-s.stopCh:
+			s.app.Log.Info("Campaign scheduler stopped")
+			return
+		case <-ticker.C:
+			s.startDueCampaigns(ctx)
+		}
+	}
+}
+
+// Stop stops the campaign scheduler
+func (s *CampaignScheduler) Stop() {
+	close(s.stopCh)
+}
+
+// startDueCampaigns claims and enqueues all scheduled campaigns whose time has come
+func (s *CampaignScheduler) startDueCampaigns(ctx context.Context) {
+	now := time.Now()
+
+	// Claim due campaigns atomically; a row updated by another instance no longer matches status = 'scheduled'
+	var due []models.BulkMessageCampaign
+	err := s.app.DB.Model(&due).
+		Clauses(clause.Returning{}).
+		Where("status = ? AND scheduled_at <= ?", "scheduled", now).
+		Updates(map[string]interface{}{
+			"status":     "queued",
+			"started_at": now,
+		}).Error
+	if err != nil {
+		s.app.Log.Error("Failed to claim scheduled campaigns", "error", err)
+		return
+	}
+
+	for i := range due {
+		s.startCampaign(ctx, &due[i])
+	}
+}
+
+// startCampaign enqueues a claimed campaign for processing by the workers
+func (s *CampaignScheduler) startCampaign(ctx context.Context, campaign *models.BulkMessageCampaign) {
+	if campaign.SegmentID != nil {
+		if _, _, err := s.app.resolveSegmentRecipients(campaign); err != nil {
+			s.app.Log.Error("Failed to resolve segment recipients", "error", err, "campaign_id", campaign.ID)
+			s.app.DB.Model(campaign).Update("status", "failed")
+			s.publishStatus(ctx, campaign, "failed")
+			return
+		}
+	}
+
+	var recipientCount int64
+	s.app.DB.Model(&models.BulkMessageRecipient{}).Where("campaign_id = ?", campaign.ID).Count(&recipientCount)
+	if recipientCount == 0 {
+		s.app.Log.Warn("Scheduled campaign has no recipients", "campaign_id", campaign.ID)
+		s.app.DB.Model(campaign).Update("status", "failed")
+		s.publishStatus(ctx, campaign, "failed")
+		return
+	}
+
+	if err := s.app.validateCampaignParams(campaign); err != nil {
+		s.app.Log.Error("Scheduled campaign cannot be sent", "error", err, "campaign_id", campaign.ID)
+		s.app.DB.Model(campaign).Update("status", "failed")
+		s.publishStatus(ctx, campaign, "failed")
+		return
+	}
+	s.app.DB.Model(campaign).Update("template_version", gorm.Expr("(SELECT version FROM templates WHERE id = ?)", campaign.TemplateID))
+
+	if s.app.Queue != nil {
+		if err := s.app.Queue.EnqueueCampaign(ctx, campaign.ID); err != nil {
+			// Release the claim so the next tick (on any instance) retries
+			s.app.Log.Error("Failed to enqueue scheduled campaign", "error", err, "campaign_id", campaign.ID)
+			s.app.DB.Model(&models.BulkMessageCampaign{}).
+				Where("id = ? AND status = ?", campaign.ID, "queued").
+				Updates(map[string]interface{}{"status": "scheduled", "started_at": nil})
+			return
+		}
+	} else {
+		s.app.Log.Warn("Queue not configured, processing scheduled campaign in goroutine")
+		go s.app.processCampaign(campaign.ID)
+	}
+
+	s.app.Log.Info("Scheduled campaign started", "campaign_id", campaign.ID, "scheduled_at", campaign.ScheduledAt)
+	s.publishStatus(ctx, campaign, "queued")
+}
+
+// publishStatus notifies connected clients (on every instance) of the campaign's new status
+func (s *CampaignScheduler) publishStatus(ctx context.Context, campaign *models.BulkMessageCampaign, status string) {
+	_ = s.publisher.PublishCampaignStats(ctx, &queue.CampaignStatsUpdate{
+		CampaignID:     campaign.ID.String(),
+		OrganizationID: campaign.OrganizationID,
+		Status:         status,
+		SentCount:      campaign.SentCount,
+		DeliveredCount: campaign.DeliveredCount,
+		ReadCount:      campaign.ReadCount,
+		FailedCount:    campaign.FailedCount,
+	})
+}
@@ -2,12 +2,14 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/internal/templates"
 	"github.com/isaee-xyz/whatomate/internal/websocket"
 	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
 	"github.com/valyala/fasthttp"
@@ -17,10 +19,40 @@ import (
 
 // CampaignRequest represents campaign create/update request
 type CampaignRequest struct {
-	Name            string     `json:"name" validate:"required"`
-	WhatsAppAccount string     `json:"whatsapp_account" validate:"required"`
-	TemplateID      string     `json:"template_id" validate:"required"`
-	ScheduledAt     *time.Time `json:"scheduled_at"`
+	Name            string `json:"name" validate:"required"`
+	WhatsAppAccount string `json:"whatsapp_account" validate:"required"`
+	TemplateID      string `json:"template_id" validate:"required"`
+	// ScheduledAt is RFC 3339, or local time in the organization's timezone. On update, null
+	// or "" clears the schedule and an omitted field leaves it alone.
+	ScheduledAt OptionalString `json:"scheduled_at"`
+	SegmentID   string         `json:"segment_id"` // Recipients are resolved from the segment when the campaign starts
+	// ParamFields maps template variables to contact fields for segment recipients, e.g. {"1": "profile_name"}
+	ParamFields map[string]string `json:"param_fields"`
+	// HeaderMedia is the media sent in the header of templates with an IMAGE, VIDEO or DOCUMENT
+	// header (a public link or an uploaded media ID). Recipients may override it with a "header" param.
+	HeaderMedia *whatsapp.TemplateHeaderParam `json:"header_media"`
+}
+
+// OptionalString is a JSON string that records whether it was in the request, so that
+// an update can tell an omitted field from one set to null
+type OptionalString struct {
+	Set   bool
+	Value string
+}
+
+// UnmarshalJSON marks the field as set; null sets it to ""
+func (o *OptionalString) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Value = ""
+		return nil
+	}
+	return json.Unmarshal(data, &o.Value)
+}
+
+// ScheduleCampaignRequest represents a campaign schedule/reschedule request
+type ScheduleCampaignRequest struct {
+	ScheduledAt string `json:"scheduled_at" validate:"required"`
 }
 
 // CampaignResponse represents campaign in API responses
@@ -41,9 +73,19 @@ type CampaignResponse struct {
 	CompletedAt     *time.Time `json:"completed_at,omitempty"`
 	CreatedAt       time.Time  `json:"created_at"`
 	UpdatedAt       time.Time  `json:"updated_at"`
+
+	SegmentID   *uuid.UUID   `json:"segment_id,omitempty"`
+	ParamFields models.JSONB `json:"param_fields,omitempty"`
+	HeaderMedia models.JSONB `json:"header_media,omitempty"`
+
+	// Template version when the campaign was last started; each recipient records the
+	// version it was actually sent
+	TemplateVersion int `json:"template_version,omitempty"`
 }
 
-// RecipientRequest represents recipient import request
+// RecipientRequest represents recipient import request. TemplateParams holds body variables
+// by number or name ({"1": "John"} or {"first_name": "John"}), plus "header" for a header
+// variable or media link and "button_<index>" for button parameters.
 type RecipientRequest struct {
 	PhoneNumber    string                 `json:"phone_number" validate:"required"`
 	RecipientName  string                 `json:"recipient_name"`
@@ -107,6 +149,9 @@ func (a *App) ListCampaigns(r *fastglue.Request) error {
 			ReadCount:       c.ReadCount,
 			FailedCount:     c.FailedCount,
 			ScheduledAt:     c.ScheduledAt,
+			SegmentID:       c.SegmentID,
+			ParamFields:     c.ParamFields,
+			TemplateVersion: c.TemplateVersion,
 			StartedAt:       c.StartedAt,
 			CompletedAt:     c.CompletedAt,
 			CreatedAt:       c.CreatedAt,
@@ -157,13 +202,36 @@ func (a *App) CreateCampaign(r *fastglue.Request) error {
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "WhatsApp account not found", nil, "")
 	}
 
+	scheduledAt, err := a.validateScheduledAt(orgID, req.ScheduledAt.Value)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+
+	segmentID, paramFields, err := a.validateCampaignSegment(orgID, req.SegmentID, req.ParamFields, &template)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+
+	headerMedia, err := campaignHeaderMediaJSONB(req.HeaderMedia, &template)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+
+	status := "draft"
+	if scheduledAt != nil {
+		status = "scheduled"
+	}
+
 	campaign := models.BulkMessageCampaign{
 		OrganizationID:  orgID,
 		WhatsAppAccount: req.WhatsAppAccount,
 		Name:            req.Name,
 		TemplateID:      templateID,
-		Status:          "draft",
-		ScheduledAt:     req.ScheduledAt,
+		Status:          status,
+		ScheduledAt:     scheduledAt,
+		SegmentID:       segmentID,
+		ParamFields:     paramFields,
+		HeaderMedia:     headerMedia,
 		CreatedBy:       userID,
 	}
 
@@ -186,6 +254,9 @@ func (a *App) CreateCampaign(r *fastglue.Request) error {
 		DeliveredCount:  campaign.DeliveredCount,
 		FailedCount:     campaign.FailedCount,
 		ScheduledAt:     campaign.ScheduledAt,
+		SegmentID:       campaign.SegmentID,
+		ParamFields:     campaign.ParamFields,
+		HeaderMedia:     campaign.HeaderMedia,
 		CreatedAt:       campaign.CreatedAt,
 		UpdatedAt:       campaign.UpdatedAt,
 	})
@@ -222,6 +293,10 @@ func (a *App) GetCampaign(r *fastglue.Request) error {
 		DeliveredCount:  campaign.DeliveredCount,
 		FailedCount:     campaign.FailedCount,
 		ScheduledAt:     campaign.ScheduledAt,
+		SegmentID:       campaign.SegmentID,
+		ParamFields:     campaign.ParamFields,
+		HeaderMedia:     campaign.HeaderMedia,
+		TemplateVersion: campaign.TemplateVersion,
 		StartedAt:       campaign.StartedAt,
 		CompletedAt:     campaign.CompletedAt,
 		CreatedAt:       campaign.CreatedAt,
@@ -252,9 +327,9 @@ func (a *App) UpdateCampaign(r *fastglue.Request) error {
 		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Campaign not found", nil, "")
 	}
 
-	// Only allow updates to draft campaigns
-	if campaign.Status != "draft" {
-		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Can only update draft campaigns", nil, "")
+	// Only allow updates to campaigns that have not started
+	if campaign.Status != "draft" && campaign.Status != "scheduled" {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Can only update draft or scheduled campaigns", nil, "")
 	}
 
 	var req CampaignRequest
@@ -262,28 +337,70 @@ func (a *App) UpdateCampaign(r *fastglue.Request) error {
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
 	}
 
-	// Update fields
 	updates := map[string]interface{}{
-		"name":         req.Name,
-		"scheduled_at": req.ScheduledAt,
+		"name": req.Name,
+	}
+
+	// Setting or clearing scheduled_at moves the campaign between draft and scheduled;
+	// requests without it leave the schedule alone
+	if req.ScheduledAt.Set {
+		scheduledAt, err := a.validateScheduledAt(orgID, req.ScheduledAt.Value)
+		if err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+		}
+		updates["scheduled_at"] = scheduledAt
+		updates["status"] = "draft"
+		if scheduledAt != nil {
+			updates["status"] = "scheduled"
+		}
 	}
 
+	templateID := campaign.TemplateID
 	if req.TemplateID != "" {
-		templateID, err := uuid.Parse(req.TemplateID)
+		templateID, err = uuid.Parse(req.TemplateID)
 		if err != nil {
 			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid template ID", nil, "")
 		}
 		updates["template_id"] = templateID
 	}
 
+	var template models.Template
+	if err := a.DB.Where("id = ? AND organization_id = ?", templateID, orgID).First(&template).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Template not found", nil, "")
+	}
+
+	segmentID, paramFields, err := a.validateCampaignSegment(orgID, req.SegmentID, req.ParamFields, &template)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+	updates["segment_id"] = segmentID
+	updates["param_fields"] = paramFields
+
+	// Header media is kept unless replaced
+	if req.HeaderMedia != nil {
+		headerMedia, err := campaignHeaderMediaJSONB(req.HeaderMedia, &template)
+		if err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+		}
+		updates["header_media"] = headerMedia
+	}
+
 	if req.WhatsAppAccount != "" {
 		updates["whats_app_account"] = req.WhatsAppAccount
 	}
 
-	if err := a.DB.Model(&campaign).Updates(updates).Error; err != nil {
-		a.Log.Error("Failed to update campaign", "error", err)
+	// Conditional update so a campaign the scheduler has claimed since it was read is not
+	// moved back to draft or scheduled
+	result := a.DB.Model(&models.BulkMessageCampaign{}).
+		Where("id = ? AND status IN ?", id, []string{"draft", "scheduled"}).
+		Updates(updates)
+	if result.Error != nil {
+		a.Log.Error("Failed to update campaign", "error", result.Error)
 		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to update campaign", nil, "")
 	}
+	if result.RowsAffected == 0 {
+		return r.SendErrorEnvelope(fasthttp.StatusConflict, "Campaign has started and can no longer be updated", nil, "")
+	}
 
 	// Reload campaign
 	a.DB.Where("id = ?", id).Preload("Template").First(&campaign)
@@ -299,6 +416,10 @@ func (a *App) UpdateCampaign(r *fastglue.Request) error {
 		DeliveredCount:  campaign.DeliveredCount,
 		FailedCount:     campaign.FailedCount,
 		ScheduledAt:     campaign.ScheduledAt,
+		SegmentID:       campaign.SegmentID,
+		ParamFields:     campaign.ParamFields,
+		HeaderMedia:     campaign.HeaderMedia,
+		TemplateVersion: campaign.TemplateVersion,
 		CreatedAt:       campaign.CreatedAt,
 		UpdatedAt:       campaign.UpdatedAt,
 	}
@@ -374,6 +495,14 @@ func (a *App) StartCampaign(r *fastglue.Request) error {
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Campaign cannot be started in current state", nil, "")
 	}
 
+	// Resolve segment recipients from the contacts matching the segment now
+	if campaign.SegmentID != nil && campaign.Status != "paused" {
+		if _, _, err := a.resolveSegmentRecipients(&campaign); err != nil {
+			a.Log.Error("Failed to resolve segment recipients", "error", err, "campaign_id", id)
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Failed to resolve segment recipients: "+err.Error(), nil, "")
+		}
+	}
+
 	// Check if there are recipients
 	var recipientCount int64
 	a.DB.Model(&models.BulkMessageRecipient{}).Where("campaign_id = ?", id).Count(&recipientCount)
@@ -381,11 +510,17 @@ func (a *App) StartCampaign(r *fastglue.Request) error {
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Campaign has no recipients", nil, "")
 	}
 
+	// Every pending recipient must have the parameters the template needs
+	if err := a.validateCampaignParams(&campaign); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+
 	// Update status
 	now := time.Now()
 	updates := map[string]interface{}{
-		"status":     "queued",
-		"started_at": now,
+		"status":           "queued",
+		"started_at":       now,
+		"template_version": gorm.Expr("(SELECT version FROM templates WHERE id = ?)", campaign.TemplateID),
 	}
 
 	if err := a.DB.Model(&campaign).Updates(updates).Error; err != nil {
@@ -483,6 +618,99 @@ func (a *App) CancelCampaign(r *fastglue.Request) error {
 	})
 }
 
+// ScheduleCampaign schedules (or reschedules) a draft or scheduled campaign
+func (a *App) ScheduleCampaign(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	campaignID := r.RequestCtx.UserValue("id").(string)
+	id, err := uuid.Parse(campaignID)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid campaign ID", nil, "")
+	}
+
+	var req ScheduleCampaignRequest
+	if err := r.Decode(&req, "json"); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
+	}
+
+	scheduledAt, err := a.validateScheduledAt(orgID, req.ScheduledAt)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+	if scheduledAt == nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "scheduled_at is required", nil, "")
+	}
+
+	// Conditional update so a campaign the scheduler has just claimed is not moved back
+	result := a.DB.Model(&models.BulkMessageCampaign{}).
+		Where("id = ? AND organization_id = ? AND status IN ?", id, orgID, []string{"draft", "scheduled"}).
+		Updates(map[string]interface{}{
+			"status":       "scheduled",
+			"scheduled_at": scheduledAt,
+		})
+	if result.Error != nil {
+		a.Log.Error("Failed to schedule campaign", "error", result.Error)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to schedule campaign", nil, "")
+	}
+	if result.RowsAffected == 0 {
+		var campaign models.BulkMessageCampaign
+		if err := a.DB.Where("id = ? AND organization_id = ?", id, orgID).First(&campaign).Error; err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Campaign not found", nil, "")
+		}
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Can only schedule draft or scheduled campaigns", nil, "")
+	}
+
+	a.Log.Info("Campaign scheduled", "campaign_id", id, "scheduled_at", scheduledAt)
+
+	return r.SendEnvelope(map[string]interface{}{
+		"message":      "Campaign scheduled",
+		"status":       "scheduled",
+		"scheduled_at": scheduledAt,
+	})
+}
+
+// UnscheduleCampaign removes a campaign's schedule and returns it to draft
+func (a *App) UnscheduleCampaign(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	campaignID := r.RequestCtx.UserValue("id").(string)
+	id, err := uuid.Parse(campaignID)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid campaign ID", nil, "")
+	}
+
+	result := a.DB.Model(&models.BulkMessageCampaign{}).
+		Where("id = ? AND organization_id = ? AND status = ?", id, orgID, "scheduled").
+		Updates(map[string]interface{}{
+			"status":       "draft",
+			"scheduled_at": nil,
+		})
+	if result.Error != nil {
+		a.Log.Error("Failed to unschedule campaign", "error", result.Error)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to unschedule campaign", nil, "")
+	}
+	if result.RowsAffected == 0 {
+		var campaign models.BulkMessageCampaign
+		if err := a.DB.Where("id = ? AND organization_id = ?", id, orgID).First(&campaign).Error; err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Campaign not found", nil, "")
+		}
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Campaign is not scheduled", nil, "")
+	}
+
+	a.Log.Info("Campaign unscheduled", "campaign_id", id)
+
+	return r.SendEnvelope(map[string]interface{}{
+		"message": "Campaign unscheduled",
+		"status":  "draft",
+	})
+}
+
 // RetryFailed retries sending to all failed recipients
 func (a *App) RetryFailed(r *fastglue.Request) error {
 	orgID, err := a.getOrgIDFromContext(r)
@@ -506,28 +734,45 @@ func (a *App) RetryFailed(r *fastglue.Request) error {
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Can only retry failed messages on completed, paused, or failed campaigns", nil, "")
 	}
 
-	// Count failed recipients
-	var failedCount int64
-	a.DB.Model(&models.BulkMessageRecipient{}).Where("campaign_id = ? AND status = ?", id, "failed").Count(&failedCount)
+	// Sending again cannot help while Meta rejects the account's credentials
+	var account models.WhatsAppAccount
+	if err := a.DB.Where("name = ? AND organization_id = ?", campaign.WhatsAppAccount, orgID).First(&account).Error; err == nil && account.Status == "error" {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "The campaign's WhatsApp account has invalid credentials; update them and test the connection first", nil, "")
+	}
+
+	// Recipients that failed permanently (invalid number, rejected template, ...) would fail
+	// again, so only the others are retried
+	var failedCount, permanentCount int64
+	a.DB.Model(&models.BulkMessageRecipient{}).
+		Where("campaign_id = ? AND status = ? AND COALESCE(error_class, '') <> ?", id, "failed", string(whatsapp.ErrorClassPermanent)).
+		Count(&failedCount)
+	a.DB.Model(&models.BulkMessageRecipient{}).
+		Where("campaign_id = ? AND status = ? AND error_class = ?", id, "failed", string(whatsapp.ErrorClassPermanent)).
+		Count(&permanentCount)
 
 	if failedCount == 0 {
+		if permanentCount > 0 {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "All failed messages failed permanently and cannot be retried", nil, "")
+		}
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "No failed messages to retry", nil, "")
 	}
 
-	// Reset failed recipients to pending
+	// Reset retryable failed recipients to pending
 	if err := a.DB.Model(&models.BulkMessageRecipient{}).
-		Where("campaign_id = ? AND status = ?", id, "failed").
+		Where("campaign_id = ? AND status = ? AND COALESCE(error_class, '') <> ?", id, "failed", string(whatsapp.ErrorClassPermanent)).
 		Updates(map[string]interface{}{
 			"status":        "pending",
 			"error_message": "",
+			"error_code":    0,
+			"error_class":   "",
 		}).Error; err != nil {
 		a.Log.Error("Failed to reset failed recipients", "error", err)
 		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to reset failed recipients", nil, "")
 	}
 
-	// Reset failed messages in messages table to pending
+	// Reset the matching failed messages in messages table to pending
 	if err := a.DB.Model(&models.Message{}).
-		Where("metadata->>'campaign_id' = ? AND status = ?", id.String(), "failed").
+		Where("metadata->>'campaign_id' = ? AND status = ? AND COALESCE(metadata->>'error_class', '') <> ?", id.String(), "failed", string(whatsapp.ErrorClassPermanent)).
 		Updates(map[string]interface{}{
 			"status":        "pending",
 			"error_message": "",
@@ -544,7 +789,7 @@ func (a *App) RetryFailed(r *fastglue.Request) error {
 		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to update campaign", nil, "")
 	}
 
-	a.Log.Info("Retrying failed messages", "campaign_id", id, "failed_count", failedCount)
+	a.Log.Info("Retrying failed messages", "campaign_id", id, "failed_count", failedCount, "permanent_count", permanentCount)
 
 	// Enqueue campaign for processing
 	if a.Queue != nil {
@@ -557,9 +802,10 @@ func (a *App) RetryFailed(r *fastglue.Request) error {
 	}
 
 	return r.SendEnvelope(map[string]interface{}{
-		"message":     "Retrying failed messages",
-		"retry_count": failedCount,
-		"status":      "queued",
+		"message":         "Retrying failed messages",
+		"retry_count":     failedCount,
+		"permanent_count": permanentCount,
+		"status":          "queued",
 	})
 }
 
@@ -581,8 +827,8 @@ func (a *App) ImportRecipients(r *fastglue.Request) error {
 		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Campaign not found", nil, "")
 	}
 
-	if campaign.Status != "draft" {
-		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Can only add recipients to draft campaigns", nil, "")
+	if campaign.Status != "draft" && campaign.Status != "scheduled" {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Can only add recipients to draft or scheduled campaigns", nil, "")
 	}
 
 	var req struct {
@@ -592,12 +838,16 @@ func (a *App) ImportRecipients(r *fastglue.Request) error {
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
 	}
 
-	// Create recipients
+	// Create recipients with phone numbers in canonical form
 	recipients := make([]models.BulkMessageRecipient, len(req.Recipients))
 	for i, rec := range req.Recipients {
+		phoneNumber, err := whatsapp.NormalizePhoneNumber(rec.PhoneNumber)
+		if err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, fmt.Sprintf("Invalid phone number at row %d: %s", i+1, rec.PhoneNumber), nil, "")
+		}
 		recipients[i] = models.BulkMessageRecipient{
 			CampaignID:     id,
-			PhoneNumber:    rec.PhoneNumber,
+			PhoneNumber:    phoneNumber,
 			RecipientName:  rec.RecipientName,
 			TemplateParams: models.JSONB(rec.TemplateParams),
 			Status:         "pending",
@@ -703,6 +953,15 @@ func (a *App) processCampaign(campaignID uuid.UUID) {
 	sentCount := 0
 	failedCount := 0
 
+	// Recipients are sent the template in their contact's language where one is approved
+	header := templates.CampaignHeaderParam(&campaign)
+	var variants []models.Template
+	var fallback []string
+	if campaign.Template != nil {
+		variants = templates.Variants(a.DB, campaign.Template, campaign.WhatsAppAccount)
+		fallback = templates.LanguageFallback(a.DB, campaign.OrganizationID)
+	}
+
 	for _, recipient := range recipients {
 		// Check if campaign is still active (not paused/cancelled)
 		var currentCampaign models.BulkMessageCampaign
@@ -713,9 +972,9 @@ func (a *App) processCampaign(campaignID uuid.UUID) {
 		}
 
 		// Get or create contact for this recipient
-		contact, _ := a.getOrCreateContact(campaign.OrganizationID, recipient.PhoneNumber, recipient.RecipientName)
-		if contact == nil {
-			a.Log.Error("Failed to get or create contact", "phone", recipient.PhoneNumber)
+		contact, _, err := a.getOrCreateContact(campaign.OrganizationID, recipient.PhoneNumber, recipient.RecipientName)
+		if err != nil {
+			a.Log.Error("Failed to get or create contact", "error", err, "phone", recipient.PhoneNumber)
 			a.DB.Model(&recipient).Updates(map[string]interface{}{
 				"status":        "failed",
 				"error_message": "Failed to create contact",
@@ -725,7 +984,30 @@ func (a *App) processCampaign(campaignID uuid.UUID) {
 		}
 
 		// Send template message
-		waMessageID, err := a.sendTemplateMessage(&account, campaign.Template, &recipient)
+		template := campaign.Template
+		if template != nil {
+			template = templates.ForRecipient(variants, template, header, &recipient, contact.Locale, fallback)
+		}
+		waMessageID, err := a.sendTemplateMessage(&account, template, header, &recipient)
+		if errors.Is(err, errContactOptedOut) {
+			a.Log.Info("Skipping opted-out recipient", "recipient", recipient.PhoneNumber)
+			a.DB.Model(&recipient).Updates(map[string]interface{}{
+				"status":        "opted_out",
+				"error_message": err.Error(),
+			})
+			continue
+		}
+		if err != nil && whatsapp.IsAuthError(err) {
+			// Every further send would fail the same way; pause with the recipient still pending
+			a.Log.Error("Meta rejected the account's credentials, pausing campaign", "error", err, "account", account.Name, "campaign_id", campaignID)
+			a.setAccountStatus(&account, "error")
+			a.DB.Model(&campaign).Updates(map[string]interface{}{
+				"status":       "paused",
+				"sent_count":   sentCount,
+				"failed_count": failedCount,
+			})
+			return
+		}
 
 		// Create Message record with campaign_id in metadata
 		message := models.Message{
@@ -741,21 +1023,15 @@ func (a *App) processCampaign(campaignID uuid.UUID) {
 				"recipient_name": recipient.RecipientName,
 			},
 		}
-		if campaign.Template != nil {
-			message.TemplateName = campaign.Template.Name
+		if err != nil {
+			message.Metadata["error_class"] = string(whatsapp.ClassOf(err))
+		}
+		if template != nil {
+			message.TemplateName = template.Name
+			message.Metadata["template_language"] = template.Language
+			message.Metadata["template_version"] = template.Version
 			// Store template body with substituted values for display in chat
-			content := campaign.Template.BodyContent
-			// Replace placeholders {{1}}, {{2}}, etc. with actual values
-			if recipient.TemplateParams != nil {
-				for i := 1; i <= 10; i++ {
-					key := fmt.Sprintf("%d", i)
-					if val, ok := recipient.TemplateParams[key]; ok {
-						placeholder := fmt.Sprintf("{{%d}}", i)
-						content = strings.ReplaceAll(content, placeholder, fmt.Sprintf("%v", val))
-					}
-				}
-			}
-			message.Content = content
+			message.Content = whatsapp.RenderTemplateText(template.BodyContent, recipient.TemplateParams)
 		}
 
 		if err != nil {
@@ -779,8 +1055,14 @@ func (a *App) processCampaign(campaignID uuid.UUID) {
 			"status":               message.Status,
 			"whats_app_message_id": waMessageID,
 		}
+		if template != nil {
+			recipientUpdate["template_language"] = template.Language
+			recipientUpdate["template_version"] = template.Version
+		}
 		if message.Status == "failed" {
 			recipientUpdate["error_message"] = message.ErrorMessage
+			recipientUpdate["error_code"] = whatsapp.ErrorCode(err)
+			recipientUpdate["error_class"] = string(whatsapp.ClassOf(err))
 		}
 		a.DB.Model(&recipient).Updates(recipientUpdate)
 
@@ -911,8 +1193,18 @@ func (a *App) recalculateCampaignStats(campaignID uuid.UUID) {
 	}
 }
 
-// sendTemplateMessage sends a template message via WhatsApp Cloud API
-func (a *App) sendTemplateMessage(account *models.WhatsAppAccount, template *models.Template, recipient *models.BulkMessageRecipient) (string, error) {
+// sendTemplateMessage sends a template message via WhatsApp Cloud API. header is the
+// campaign's header media, used unless the recipient has its own.
+func (a *App) sendTemplateMessage(account *models.WhatsAppAccount, template *models.Template, header *whatsapp.TemplateHeaderParam, recipient *models.BulkMessageRecipient) (string, error) {
+	if a.isOptedOut(account.OrganizationID, recipient.PhoneNumber) {
+		return "", errContactOptedOut
+	}
+
+	params, err := templates.Layout(template).ParamsFromValues(recipient.TemplateParams, header)
+	if err != nil {
+		return "", err
+	}
+
 	waAccount := &whatsapp.Account{
 		PhoneID:     account.PhoneID,
 		BusinessID:  account.BusinessID,
@@ -920,29 +1212,6 @@ func (a *App) sendTemplateMessage(account *models.WhatsAppAccount, template *mod
 		AccessToken: account.AccessToken,
 	}
 
-	// Build template components with parameters
-	var components []map[string]interface{}
-
-	// Add body parameters if template has variables
-	if recipient.TemplateParams != nil && len(recipient.TemplateParams) > 0 {
-		bodyParams := []map[string]interface{}{}
-		for i := 1; i <= 10; i++ {
-			key := fmt.Sprintf("%d", i)
-			if val, ok := recipient.TemplateParams[key]; ok {
-				bodyParams = append(bodyParams, map[string]interface{}{
-					"type": "text",
-					"text": val,
-				})
-			}
-		}
-		if len(bodyParams) > 0 {
-			components = append(components, map[string]interface{}{
-				"type":       "body",
-				"parameters": bodyParams,
-			})
-		}
-	}
-
 	ctx := context.Background()
-	return a.WhatsApp.SendTemplateMessageWithComponents(ctx, waAccount, recipient.PhoneNumber, template.Name, template.Language, components)
+	return a.WhatsApp.SendTemplateMessageWithComponents(ctx, waAccount, recipient.PhoneNumber, template.Name, template.Language, whatsapp.BuildTemplateComponents(params))
 }
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCampaignRequestScheduledAt(t *testing.T) {
+	tests := []struct {
+		body  string
+		want  OptionalString
+		valid bool
+	}{
+		{`{"name":"x"}`, OptionalString{}, true},
+		{`{"scheduled_at":null}`, OptionalString{Set: true}, true},
+		{`{"scheduled_at":""}`, OptionalString{Set: true}, true},
+		{`{"scheduled_at":"2030-01-02T09:00:00Z"}`, OptionalString{Set: true, Value: "2030-01-02T09:00:00Z"}, true},
+		{`{"scheduled_at":1893574800}`, OptionalString{}, false},
+	}
+
+	for _, tt := range tests {
+		var req CampaignRequest
+		err := json.Unmarshal([]byte(tt.body), &req)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: error = %v, want valid %v", tt.body, err, tt.valid)
+			continue
+		}
+		if tt.valid && req.ScheduledAt != tt.want {
+			t.Errorf("%s: scheduled_at = %+v, want %+v", tt.body, req.ScheduledAt, tt.want)
+		}
+	}
+}
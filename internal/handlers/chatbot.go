@@ -2,6 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -75,13 +78,17 @@ type KeywordRuleResponse struct {
 
 // ChatbotFlowResponse represents a chatbot flow for API response
 type ChatbotFlowResponse struct {
-	ID              string   `json:"id"`
-	Name            string   `json:"name"`
-	Description     string   `json:"description"`
-	TriggerKeywords []string `json:"trigger_keywords"`
-	Enabled         bool     `json:"enabled"`
-	StepsCount      int      `json:"steps_count"`
-	CreatedAt       string   `json:"created_at"`
+	ID               string   `json:"id"`
+	Name             string   `json:"name"`
+	Description      string   `json:"description"`
+	TriggerKeywords  []string `json:"trigger_keywords"`
+	Enabled          bool     `json:"enabled"`
+	StepsCount       int      `json:"steps_count"`
+	PublishedVersion int      `json:"published_version"`
+	HasDraft         bool     `json:"has_draft"`
+	RolloutVersion   int      `json:"rollout_version,omitempty"`
+	RolloutPercent   int      `json:"rollout_percent,omitempty"`
+	CreatedAt        string   `json:"created_at"`
 }
 
 // AIContextResponse represents an AI context for API response
@@ -620,13 +627,17 @@ func (a *App) ListChatbotFlows(r *fastglue.Request) error {
 	response := make([]ChatbotFlowResponse, len(flows))
 	for i, flow := range flows {
 		response[i] = ChatbotFlowResponse{
-			ID:              flow.ID.String(),
-			Name:            flow.Name,
-			Description:     flow.Description,
-			TriggerKeywords: flow.TriggerKeywords,
-			Enabled:         flow.IsEnabled,
-			StepsCount:      len(flow.Steps),
-			CreatedAt:       flow.CreatedAt.Format(time.RFC3339),
+			ID:               flow.ID.String(),
+			Name:             flow.Name,
+			Description:      flow.Description,
+			TriggerKeywords:  flow.TriggerKeywords,
+			Enabled:          flow.IsEnabled,
+			StepsCount:       len(flow.Steps),
+			PublishedVersion: flow.PublishedVersion,
+			HasDraft:         flow.HasDraft,
+			RolloutVersion:   flow.RolloutVersion,
+			RolloutPercent:   flow.RolloutPercent,
+			CreatedAt:        flow.CreatedAt.Format(time.RFC3339),
 		}
 	}
 
@@ -646,15 +657,99 @@ type FlowStepRequest struct {
 	ApiConfig       map[string]interface{}   `json:"api_config"`
 	Buttons         []map[string]interface{} `json:"buttons"`
 	TransferConfig  map[string]interface{}   `json:"transfer_config"`
+	MessageConfig   map[string]interface{}   `json:"message_config"`
+	ControlConfig   map[string]interface{}   `json:"control_config"`
 	ValidationRegex string                   `json:"validation_regex"`
 	ValidationError string                   `json:"validation_error"`
 	StoreAs         string                   `json:"store_as"`
 	NextStep        string                   `json:"next_step"`
+	ConditionalNext map[string]interface{}   `json:"conditional_next"`
 	SkipCondition   string                   `json:"skip_condition"`
 	RetryOnInvalid  bool                     `json:"retry_on_invalid"`
 	MaxRetries      int                      `json:"max_retries"`
 }
 
+// validateFlowSteps checks the message config of steps that send location, contacts,
+// list and the other typed messages, so that mistakes surface on save rather than mid-flow
+func validateFlowSteps(steps []FlowStepRequest) error {
+	for _, step := range steps {
+		// buttons steps keep using the step's buttons field
+		if step.MessageType == "buttons" || !specMessageTypes[step.MessageType] {
+			continue
+		}
+		spec, err := stepMessageSpec(step.MessageConfig)
+		if err == nil {
+			_, err = spec.build(step.MessageType, step.Message)
+		}
+		if err != nil {
+			return fmt.Errorf("step %q: %v", step.StepName, err)
+		}
+	}
+	return nil
+}
+
+// flowStepsFromRequest builds the steps of a flow from a create/update request, numbered
+// in request order
+func flowStepsFromRequest(reqSteps []FlowStepRequest) []models.ChatbotFlowStep {
+	steps := make([]models.ChatbotFlowStep, 0, len(reqSteps))
+	for i, stepReq := range reqSteps {
+		// Convert buttons to JSONBArray
+		var buttons models.JSONBArray
+		for _, btn := range stepReq.Buttons {
+			buttons = append(buttons, btn)
+		}
+
+		step := models.ChatbotFlowStep{
+			BaseModel:       models.BaseModel{ID: uuid.New()},
+			StepName:        stepReq.StepName,
+			StepOrder:       i + 1,
+			Message:         stepReq.Message,
+			MessageType:     stepReq.MessageType,
+			InputType:       stepReq.InputType,
+			InputConfig:     models.JSONB(stepReq.InputConfig),
+			ApiConfig:       models.JSONB(stepReq.ApiConfig),
+			Buttons:         buttons,
+			TransferConfig:  models.JSONB(stepReq.TransferConfig),
+			MessageConfig:   models.JSONB(stepReq.MessageConfig),
+			ControlConfig:   models.JSONB(stepReq.ControlConfig),
+			ValidationRegex: stepReq.ValidationRegex,
+			ValidationError: stepReq.ValidationError,
+			StoreAs:         stepReq.StoreAs,
+			NextStep:        stepReq.NextStep,
+			ConditionalNext: models.JSONB(stepReq.ConditionalNext),
+			SkipCondition:   stepReq.SkipCondition,
+			RetryOnInvalid:  stepReq.RetryOnInvalid,
+			MaxRetries:      stepReq.MaxRetries,
+		}
+		if step.MessageType == "" {
+			step.MessageType = "text"
+		}
+		if step.MaxRetries == 0 {
+			step.MaxRetries = 3
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// flowInitialTemplateID parses the initial template of a flow; an empty value means none.
+// The template is sent in the contact's language when the flow starts.
+func (a *App) flowInitialTemplateID(orgID uuid.UUID, value string) (*uuid.UUID, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil, errors.New("invalid initial_template_id")
+	}
+	var count int64
+	a.DB.Model(&models.Template{}).Where("id = ? AND organization_id = ?", id, orgID).Count(&count)
+	if count == 0 {
+		return nil, errors.New("initial template not found")
+	}
+	return &id, nil
+}
+
 // CreateChatbotFlow creates a new chatbot flow
 func (a *App) CreateChatbotFlow(r *fastglue.Request) error {
 	orgID, err := a.getOrgIDFromContext(r)
@@ -667,6 +762,7 @@ func (a *App) CreateChatbotFlow(r *fastglue.Request) error {
 		Description       string                 `json:"description"`
 		TriggerKeywords   []string               `json:"trigger_keywords"`
 		InitialMessage    string                 `json:"initial_message"`
+		InitialTemplateID string                 `json:"initial_template_id"`
 		CompletionMessage string                 `json:"completion_message"`
 		OnCompleteAction  string                 `json:"on_complete_action"`
 		CompletionConfig  map[string]interface{} `json:"completion_config"`
@@ -681,6 +777,17 @@ func (a *App) CreateChatbotFlow(r *fastglue.Request) error {
 	if req.Name == "" {
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Name is required", nil, "")
 	}
+	if err := validateFlowSteps(req.Steps); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+	steps := flowStepsFromRequest(req.Steps)
+	if problems := validateFlowGraph(uuid.Nil, steps, a.flowStepsFunc(orgID)); len(problems) > 0 {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid flow: "+strings.Join(problems, "; "), map[string]interface{}{"problems": problems}, "")
+	}
+	initialTemplateID, err := a.flowInitialTemplateID(orgID, req.InitialTemplateID)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
 
 	// Use transaction for flow + steps
 	tx := a.DB.Begin()
@@ -693,6 +800,7 @@ func (a *App) CreateChatbotFlow(r *fastglue.Request) error {
 		Description:       req.Description,
 		TriggerKeywords:   req.TriggerKeywords,
 		InitialMessage:    req.InitialMessage,
+		InitialTemplateID: initialTemplateID,
 		CompletionMessage: req.CompletionMessage,
 		OnCompleteAction:  req.OnCompleteAction,
 		CompletionConfig:  models.JSONB(req.CompletionConfig),
@@ -705,53 +813,34 @@ func (a *App) CreateChatbotFlow(r *fastglue.Request) error {
 	}
 
 	// Create steps
-	for i, stepReq := range req.Steps {
-		// Convert buttons to JSONBArray
-		var buttons models.JSONBArray
-		for _, btn := range stepReq.Buttons {
-			buttons = append(buttons, btn)
-		}
-
-		step := models.ChatbotFlowStep{
-			BaseModel:       models.BaseModel{ID: uuid.New()},
-			FlowID:          flowID,
-			StepName:        stepReq.StepName,
-			StepOrder:       i + 1,
-			Message:         stepReq.Message,
-			MessageType:     stepReq.MessageType,
-			InputType:       stepReq.InputType,
-			InputConfig:     models.JSONB(stepReq.InputConfig),
-			ApiConfig:       models.JSONB(stepReq.ApiConfig),
-			Buttons:         buttons,
-			TransferConfig:  models.JSONB(stepReq.TransferConfig),
-			ValidationRegex: stepReq.ValidationRegex,
-			ValidationError: stepReq.ValidationError,
-			StoreAs:         stepReq.StoreAs,
-			NextStep:        stepReq.NextStep,
-			SkipCondition:   stepReq.SkipCondition,
-			RetryOnInvalid:  stepReq.RetryOnInvalid,
-			MaxRetries:      stepReq.MaxRetries,
-		}
-		if step.MessageType == "" {
-			step.MessageType = "text"
-		}
-		if step.MaxRetries == 0 {
-			step.MaxRetries = 3
-		}
-		if err := tx.Create(&step).Error; err != nil {
+	for i := range steps {
+		steps[i].FlowID = flowID
+		if err := tx.Create(&steps[i]).Error; err != nil {
 			tx.Rollback()
 			return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to create flow step", nil, "")
 		}
 	}
 
+	// New flows go live as their first version
+	userID, _ := r.RequestCtx.UserValue("user_id").(uuid.UUID)
+	version, err := recordChatbotFlowVersion(tx, &flow, steps, "", &userID)
+	if err == nil {
+		err = tx.Model(&flow).UpdateColumn("published_version", version.Version).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to publish flow", nil, "")
+	}
+
 	tx.Commit()
 
 	// Invalidate cache
 	a.InvalidateChatbotFlowsCache(orgID)
 
 	return r.SendEnvelope(map[string]interface{}{
-		"id":      flow.ID.String(),
-		"message": "Flow created successfully",
+		"id":                flow.ID.String(),
+		"published_version": version.Version,
+		"message":           "Flow created successfully",
 	})
 }
 
@@ -797,12 +886,18 @@ func (a *App) UpdateChatbotFlow(r *fastglue.Request) error {
 	if err := a.DB.Where("id = ? AND organization_id = ?", id, orgID).First(&flow).Error; err != nil {
 		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Flow not found", nil, "")
 	}
+	// Loaded apart from the flow so that saving it leaves them alone
+	var currentSteps []models.ChatbotFlowStep
+	if err := a.DB.Where("flow_id = ?", id).Order("step_order ASC").Find(&currentSteps).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to load flow steps", nil, "")
+	}
 
 	var req struct {
 		Name              *string                `json:"name"`
 		Description       *string                `json:"description"`
 		TriggerKeywords   []string               `json:"trigger_keywords"`
 		InitialMessage    *string                `json:"initial_message"`
+		InitialTemplateID *string                `json:"initial_template_id"` // Empty to clear
 		CompletionMessage *string                `json:"completion_message"`
 		OnCompleteAction  *string                `json:"on_complete_action"`
 		CompletionConfig  map[string]interface{} `json:"completion_config"`
@@ -813,9 +908,43 @@ func (a *App) UpdateChatbotFlow(r *fastglue.Request) error {
 	if err := json.Unmarshal(r.RequestCtx.PostBody(), &req); err != nil {
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
 	}
+	if err := validateFlowSteps(req.Steps); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+	steps := flowStepsFromRequest(req.Steps)
+	if len(steps) > 0 {
+		if problems := validateFlowGraph(id, steps, a.flowStepsFunc(orgID)); len(problems) > 0 {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid flow: "+strings.Join(problems, "; "), map[string]interface{}{"problems": problems}, "")
+		}
+	}
+	if req.InitialTemplateID != nil {
+		initialTemplateID, err := a.flowInitialTemplateID(orgID, *req.InitialTemplateID)
+		if err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+		}
+		flow.InitialTemplateID = initialTemplateID
+	}
+
+	// Everything but the description and enabled state is versioned: edits go to the
+	// draft, and live traffic keeps running the published version
+	draftEdited := req.Name != nil || len(req.TriggerKeywords) > 0 || req.InitialMessage != nil ||
+		req.InitialTemplateID != nil || req.CompletionMessage != nil || req.OnCompleteAction != nil ||
+		req.CompletionConfig != nil || len(req.Steps) > 0
 
 	tx := a.DB.Begin()
 
+	// Flows that predate versioning first get the definition they run recorded and
+	// published, so that this edit does not reach the sessions running it
+	if draftEdited && flow.PublishedVersion == 0 {
+		userID, _ := r.RequestCtx.UserValue("user_id").(uuid.UUID)
+		version, err := recordChatbotFlowVersion(tx, &flow, currentSteps, "", &userID)
+		if err != nil {
+			tx.Rollback()
+			return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to update flow", nil, "")
+		}
+		flow.PublishedVersion = version.Version
+	}
+
 	if req.Name != nil {
 		flow.Name = *req.Name
 	}
@@ -855,44 +984,21 @@ func (a *App) UpdateChatbotFlow(r *fastglue.Request) error {
 		}
 
 		// Create new steps
-		for i, stepReq := range req.Steps {
-			// Convert buttons to JSONBArray
-			var buttons models.JSONBArray
-			for _, btn := range stepReq.Buttons {
-				buttons = append(buttons, btn)
-			}
-
-			step := models.ChatbotFlowStep{
-				BaseModel:       models.BaseModel{ID: uuid.New()},
-				FlowID:          id,
-				StepName:        stepReq.StepName,
-				StepOrder:       i + 1,
-				Message:         stepReq.Message,
-				MessageType:     stepReq.MessageType,
-				InputType:       stepReq.InputType,
-				InputConfig:     models.JSONB(stepReq.InputConfig),
-				ApiConfig:       models.JSONB(stepReq.ApiConfig),
-				Buttons:         buttons,
-				TransferConfig:  models.JSONB(stepReq.TransferConfig),
-				ValidationRegex: stepReq.ValidationRegex,
-				ValidationError: stepReq.ValidationError,
-				StoreAs:         stepReq.StoreAs,
-				NextStep:        stepReq.NextStep,
-				SkipCondition:   stepReq.SkipCondition,
-				RetryOnInvalid:  stepReq.RetryOnInvalid,
-				MaxRetries:      stepReq.MaxRetries,
-			}
-			if step.MessageType == "" {
-				step.MessageType = "text"
-			}
-			if step.MaxRetries == 0 {
-				step.MaxRetries = 3
-			}
-			if err := tx.Create(&step).Error; err != nil {
+		for i := range steps {
+			steps[i].FlowID = id
+			if err := tx.Create(&steps[i]).Error; err != nil {
 				tx.Rollback()
 				return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to create flow step", nil, "")
 			}
 		}
+		currentSteps = steps
+	}
+
+	if draftEdited {
+		if err := updateFlowHasDraft(tx, &flow, currentSteps); err != nil {
+			tx.Rollback()
+			return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to update flow", nil, "")
+		}
 	}
 
 	tx.Commit()
@@ -901,7 +1007,9 @@ func (a *App) UpdateChatbotFlow(r *fastglue.Request) error {
 	a.InvalidateChatbotFlowsCache(orgID)
 
 	return r.SendEnvelope(map[string]interface{}{
-		"message": "Flow updated successfully",
+		"message":           "Flow updated successfully",
+		"published_version": flow.PublishedVersion,
+		"has_draft":         flow.HasDraft,
 	})
 }
 
@@ -918,6 +1026,19 @@ func (a *App) DeleteChatbotFlow(r *fastglue.Request) error {
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid flow ID", nil, "")
 	}
 
+	// Flows that other flows call or jump to can't be deleted
+	var callers []string
+	a.DB.Model(&models.ChatbotFlowStep{}).
+		Joins("JOIN chatbot_flows ON chatbot_flows.id = chatbot_flow_steps.flow_id AND chatbot_flows.deleted_at IS NULL").
+		Where("chatbot_flows.organization_id = ? AND chatbot_flows.id <> ?", orgID, id).
+		Where("chatbot_flow_steps.message_type IN ? AND chatbot_flow_steps.control_config->>'flow_id' = ?",
+			[]string{StepTypeCallFlow, StepTypeJumpFlow}, id.String()).
+		Distinct().Pluck("chatbot_flows.name", &callers)
+	if len(callers) > 0 {
+		return r.SendErrorEnvelope(fasthttp.StatusConflict,
+			"Flow is used by other flows: "+strings.Join(callers, ", "), nil, "")
+	}
+
 	// Delete flow and steps in transaction
 	tx := a.DB.Begin()
 
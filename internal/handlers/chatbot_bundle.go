@@ -0,0 +1,1048 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// ChatbotBundleVersion is the version of the bundle format written by exports. Imports
+// accept bundles up to this version.
+const ChatbotBundleVersion = 1
+
+// What to do with a bundle record named like a record of the organization
+const (
+	BundleConflictSkip    = "skip"
+	BundleConflictReplace = "replace"
+)
+
+// What an import does with a record of the bundle
+const (
+	BundleActionCreate      = "create"
+	BundleActionReplace     = "replace"
+	BundleActionSkip        = "skip"
+	BundleActionUseExisting = "use_existing"
+)
+
+// errBundleNotApplied rolls back the transaction of an import that is a dry run or has errors
+var errBundleNotApplied = errors.New("bundle not applied")
+
+// bundleOmittedFields are the record fields left out of bundles: IDs, timestamps and
+// relations only mean something in the organization they come from
+var bundleOmittedFields = []string{"id", "organization_id", "created_at", "updated_at", "deleted_at", "organization"}
+
+// bundleFlowOmittedFields are the flow fields left out besides bundleOmittedFields: the
+// versioning state, and fields replaced by portable references
+var bundleFlowOmittedFields = []string{"published_version", "has_draft", "rollout_version", "rollout_percent", "version", "initial_template_id", "initial_template", "steps"}
+
+// ChatbotBundle is a portable copy of an organization's chatbot configuration. Records have
+// the fields of their models without IDs, and refer to teams, flows, WhatsApp Flows, users
+// and templates by name (e-mail for users) rather than by ID.
+type ChatbotBundle struct {
+	Version         int                     `json:"version"`
+	ExportedAt      time.Time               `json:"exported_at"`
+	WhatsAppAccount string                  `json:"whatsapp_account,omitempty"` // Account the export was limited to
+	Settings        models.JSONB            `json:"settings,omitempty"`
+	KeywordRules    []models.JSONB          `json:"keyword_rules"`
+	Flows           []models.JSONB          `json:"flows"` // As published, with their steps
+	AIContexts      []models.JSONB          `json:"ai_contexts"`
+	Templates       []ChatbotBundleTemplate `json:"templates"` // Templates the flows send, in all their languages
+}
+
+// ChatbotBundleTemplate is a message template in a bundle
+type ChatbotBundleTemplate struct {
+	Name          string        `json:"name"`
+	DisplayName   string        `json:"display_name"`
+	Language      string        `json:"language"`
+	Category      string        `json:"category"`
+	HeaderType    string        `json:"header_type"`
+	HeaderContent string        `json:"header_content"`
+	BodyContent   string        `json:"body_content"`
+	FooterContent string        `json:"footer_content"`
+	Buttons       []interface{} `json:"buttons"`
+	SampleValues  []interface{} `json:"sample_values"`
+}
+
+// ChatbotImportItem is what an import does, or would do, with a record of the bundle
+type ChatbotImportItem struct {
+	Type     string `json:"type"` // settings, keyword_rule, flow, ai_context, template
+	Name     string `json:"name"`
+	Action   string `json:"action"`             // create, replace, skip, use_existing
+	ID       string `json:"id,omitempty"`       // Record of the organization the bundle record is imported as
+	Conflict string `json:"conflict,omitempty"` // How the record clashes with one of the organization
+}
+
+// ChatbotImportResult is the report of an import
+type ChatbotImportResult struct {
+	DryRun    bool                `json:"dry_run"`
+	Applied   bool                `json:"applied"`
+	Items     []ChatbotImportItem `json:"items"`
+	Conflicts int                 `json:"conflicts"`
+	Errors    []string            `json:"errors"` // References that can't be resolved; nothing is imported while there are any
+	Warnings  []string            `json:"warnings"`
+}
+
+// ExportChatbotBundle exports the chatbot configuration as a bundle. ?whatsapp_account=
+// limits it to the records of one account and the organization-wide ones, and
+// ?format=yaml returns it as YAML rather than JSON.
+func (a *App) ExportChatbotBundle(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	format := string(r.RequestCtx.QueryArgs().Peek("format"))
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "yaml" {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "format must be json or yaml", nil, "")
+	}
+	accountName := string(r.RequestCtx.QueryArgs().Peek("whatsapp_account"))
+	if accountName != "" {
+		var count int64
+		a.DB.Model(&models.WhatsAppAccount{}).Where("name = ? AND organization_id = ?", accountName, orgID).Count(&count)
+		if count == 0 {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "WhatsApp account not found", nil, "")
+		}
+	}
+
+	bundle, err := a.exportChatbotBundle(orgID, accountName)
+	if err != nil {
+		a.Log.Error("Failed to export chatbot bundle", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to export chatbot configuration", nil, "")
+	}
+
+	var data []byte
+	contentType := "application/json"
+	if format == "yaml" {
+		data, err = chatbotBundleYAML(bundle)
+		contentType = "application/yaml"
+	} else {
+		data, err = json.MarshalIndent(bundle, "", "  ")
+	}
+	if err != nil {
+		a.Log.Error("Failed to encode chatbot bundle", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to export chatbot configuration", nil, "")
+	}
+
+	r.RequestCtx.Response.Header.Set("Content-Type", contentType)
+	r.RequestCtx.Response.Header.Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="chatbot-%s.%s"`, bundle.ExportedAt.Format("20060102-150405"), format))
+	r.RequestCtx.SetBody(data)
+	return nil
+}
+
+// ImportChatbotBundle imports a bundle (JSON or YAML) into the organization. It is a dry
+// run unless ?dry_run=false: the import runs in a transaction that is rolled back, and the
+// report says what would be created, replaced or skipped. Records named like existing ones
+// are skipped, or replaced with ?on_conflict=replace. Account-specific records and templates
+// go to the account in ?whatsapp_account=.
+func (a *App) ImportChatbotBundle(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	args := r.RequestCtx.QueryArgs()
+	dryRun := string(args.Peek("dry_run")) != "false"
+	onConflict := string(args.Peek("on_conflict"))
+	if onConflict == "" {
+		onConflict = BundleConflictSkip
+	}
+	if onConflict != BundleConflictSkip && onConflict != BundleConflictReplace {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "on_conflict must be skip or replace", nil, "")
+	}
+	accountName := string(args.Peek("whatsapp_account"))
+	if accountName != "" {
+		var count int64
+		a.DB.Model(&models.WhatsAppAccount{}).Where("name = ? AND organization_id = ?", accountName, orgID).Count(&count)
+		if count == 0 {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "WhatsApp account not found", nil, "")
+		}
+	}
+
+	bundle, err := parseChatbotBundle(r.RequestCtx.PostBody())
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid bundle: "+err.Error(), nil, "")
+	}
+
+	userID, _ := r.RequestCtx.UserValue("user_id").(uuid.UUID)
+	result, err := a.importChatbotBundle(orgID, bundle, accountName, onConflict, dryRun, &userID)
+	if err != nil {
+		a.Log.Error("Failed to import chatbot bundle", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to import chatbot configuration", nil, "")
+	}
+
+	if result.Applied {
+		a.InvalidateChatbotSettingsCache(orgID)
+		a.InvalidateSLASettingsCache()
+		a.InvalidateKeywordRulesCache(orgID)
+		a.InvalidateChatbotFlowsCache(orgID)
+		a.InvalidateAIContextsCache(orgID)
+	}
+
+	return r.SendEnvelope(result)
+}
+
+// parseChatbotBundle decodes a bundle given as JSON or YAML
+func parseChatbotBundle(data []byte) (*ChatbotBundle, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("empty body")
+	}
+	if data[0] != '{' {
+		var raw interface{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+		var err error
+		if data, err = json.Marshal(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	var bundle ChatbotBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, err
+	}
+	if bundle.Version < 1 || bundle.Version > ChatbotBundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", bundle.Version)
+	}
+	return &bundle, nil
+}
+
+// chatbotBundleYAML encodes a bundle as YAML, with fields in the same order as in JSON
+func chatbotBundleYAML(bundle *ChatbotBundle) ([]byte, error) {
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	yamlBlockStyle(&node)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// yamlBlockStyle switches a node decoded from JSON to block style, with multi-line
+// strings as literal blocks
+func yamlBlockStyle(node *yaml.Node) {
+	node.Style = 0
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" && strings.Contains(node.Value, "\n") {
+		node.Style = yaml.LiteralStyle
+	}
+	for _, child := range node.Content {
+		yamlBlockStyle(child)
+	}
+}
+
+// bundleRecord returns the fields of a model as stored in a bundle
+func bundleRecord(v interface{}, omitted ...string) models.JSONB {
+	record := models.JSONB{}
+	if data, err := json.Marshal(v); err == nil {
+		_ = json.Unmarshal(data, &record)
+	}
+	for _, name := range bundleOmittedFields {
+		delete(record, name)
+	}
+	for _, name := range omitted {
+		delete(record, name)
+	}
+	return record
+}
+
+// decodeBundleRecord fills a model from the fields of a bundle record
+func decodeBundleRecord(record models.JSONB, v interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// exportChatbotBundle builds the bundle of an organization's chatbot configuration,
+// limited to an account's records and the organization-wide ones if accountName is set
+func (a *App) exportChatbotBundle(orgID uuid.UUID, accountName string) (*ChatbotBundle, error) {
+	bundle := &ChatbotBundle{
+		Version:         ChatbotBundleVersion,
+		ExportedAt:      time.Now().UTC(),
+		WhatsAppAccount: accountName,
+		KeywordRules:    []models.JSONB{},
+		Flows:           []models.JSONB{},
+		AIContexts:      []models.JSONB{},
+		Templates:       []ChatbotBundleTemplate{},
+	}
+	scope := func(db *gorm.DB) *gorm.DB {
+		db = db.Where("organization_id = ?", orgID)
+		if accountName != "" {
+			db = db.Where("whats_app_account IN ?", []string{"", accountName})
+		}
+		return db
+	}
+
+	// Account-specific settings take precedence, as when the chatbot runs
+	var settings []models.ChatbotSettings
+	if err := a.DB.Scopes(scope).Order("CASE WHEN whats_app_account = '' THEN 1 ELSE 0 END").Limit(1).Find(&settings).Error; err != nil {
+		return nil, err
+	}
+	if len(settings) > 0 {
+		bundle.Settings = bundleRecord(&settings[0], "sla_escalation_notify_ids")
+		emails := []string{}
+		for _, id := range settings[0].SLAEscalationNotifyIDs {
+			var user models.User
+			if err := a.DB.Where("id = ? AND organization_id = ?", id, orgID).First(&user).Error; err == nil {
+				emails = append(emails, user.Email)
+			}
+		}
+		bundle.Settings["sla_escalation_notify"] = emails
+	}
+
+	var rules []models.KeywordRule
+	if err := a.DB.Scopes(scope).Order("priority DESC, name").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		bundle.KeywordRules = append(bundle.KeywordRules, bundleRecord(&rules[i]))
+	}
+
+	var contexts []models.AIContext
+	if err := a.DB.Scopes(scope).Order("priority DESC, name").Find(&contexts).Error; err != nil {
+		return nil, err
+	}
+	for i := range contexts {
+		bundle.AIContexts = append(bundle.AIContexts, bundleRecord(&contexts[i]))
+	}
+
+	var flows []models.ChatbotFlow
+	if err := a.DB.Scopes(scope).Order("name").
+		Preload("Steps", func(db *gorm.DB) *gorm.DB {
+			return db.Order("step_order ASC")
+		}).
+		Find(&flows).Error; err != nil {
+		return nil, err
+	}
+	exp := &bundleExport{app: a, orgID: orgID, bundle: bundle, templates: map[uuid.UUID]bool{}}
+	for i := range flows {
+		record, err := exp.flow(&flows[i])
+		if err != nil {
+			return nil, err
+		}
+		bundle.Flows = append(bundle.Flows, record)
+	}
+	return bundle, nil
+}
+
+// bundleExport resolves the IDs exported records refer to into names
+type bundleExport struct {
+	app       *App
+	orgID     uuid.UUID
+	bundle    *ChatbotBundle
+	templates map[uuid.UUID]bool // Templates already in the bundle
+}
+
+// flow returns the bundle record of a flow as published (its draft if never published)
+func (x *bundleExport) flow(flow *models.ChatbotFlow) (models.JSONB, error) {
+	if flow.PublishedVersion > 0 {
+		var version models.ChatbotFlowVersion
+		if err := x.app.DB.Where("flow_id = ? AND version = ?", flow.ID, flow.PublishedVersion).First(&version).Error; err != nil {
+			return nil, err
+		}
+		published := chatbotFlowFromVersion(flow, &version)
+		flow = &published
+	}
+
+	record := bundleRecord(flow, bundleFlowOmittedFields...)
+	if flow.InitialTemplateID != nil {
+		template, err := x.template(*flow.InitialTemplateID)
+		if err != nil {
+			return nil, err
+		}
+		if template != nil {
+			record["initial_template"] = map[string]interface{}{"name": template.Name, "language": template.Language}
+		}
+	}
+
+	steps := []interface{}{}
+	for i := range flow.Steps {
+		step := versionStep(&flow.Steps[i])
+		delete(step, "template_id")
+		x.portableStep(step)
+		steps = append(steps, step)
+	}
+	record["steps"] = steps
+	return record, nil
+}
+
+// template adds a template to the bundle with its other languages on the same account.
+// Returns nil if the template no longer exists.
+func (x *bundleExport) template(id uuid.UUID) (*models.Template, error) {
+	var template models.Template
+	if err := x.app.DB.Where("id = ? AND organization_id = ?", id, x.orgID).First(&template).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if x.templates[template.ID] {
+		return &template, nil
+	}
+
+	var variants []models.Template
+	if err := x.app.DB.Where("organization_id = ? AND whats_app_account = ? AND name = ?", x.orgID, template.WhatsAppAccount, template.Name).
+		Order("language").Find(&variants).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range variants {
+		if x.templates[t.ID] {
+			continue
+		}
+		x.templates[t.ID] = true
+		x.bundle.Templates = append(x.bundle.Templates, ChatbotBundleTemplate{
+			Name:          t.Name,
+			DisplayName:   t.DisplayName,
+			Language:      t.Language,
+			Category:      t.Category,
+			HeaderType:    t.HeaderType,
+			HeaderContent: t.HeaderContent,
+			BodyContent:   t.BodyContent,
+			FooterContent: t.FooterContent,
+			Buttons:       convertFromJSONBArray(t.Buttons),
+			SampleValues:  convertFromJSONBArray(t.SampleValues),
+		})
+	}
+	return &template, nil
+}
+
+// portableStep replaces the team, flow and WhatsApp Flow IDs of a step by their names.
+// References to records that no longer exist are exported with an empty name.
+func (x *bundleExport) portableStep(step map[string]interface{}) {
+	if config, ok := step["transfer_config"].(map[string]interface{}); ok {
+		if id, _ := config["team_id"].(string); id != "" && id != "_general" {
+			var team models.Team
+			x.app.DB.Select("name").Where("id = ? AND organization_id = ?", id, x.orgID).First(&team)
+			delete(config, "team_id")
+			config["team"] = team.Name
+		}
+	}
+	if config, ok := step["control_config"].(map[string]interface{}); ok {
+		if id, _ := config["flow_id"].(string); id != "" {
+			var flow models.ChatbotFlow
+			x.app.DB.Select("name").Where("id = ? AND organization_id = ?", id, x.orgID).First(&flow)
+			delete(config, "flow_id")
+			config["flow"] = flow.Name
+		}
+	}
+	if config, ok := step["input_config"].(map[string]interface{}); ok {
+		if id, _ := config["whatsapp_flow_id"].(string); id != "" {
+			name := ""
+			if waFlow, err := x.app.findWhatsAppFlow(x.orgID, id); err == nil {
+				name = waFlow.Name
+			}
+			delete(config, "whatsapp_flow_id")
+			config["whatsapp_flow"] = name
+		}
+	}
+}
+
+// importChatbotBundle imports a bundle in a transaction, which is rolled back for a dry
+// run or when references can't be resolved
+func (a *App) importChatbotBundle(orgID uuid.UUID, bundle *ChatbotBundle, accountName, onConflict string, dryRun bool, userID *uuid.UUID) (*ChatbotImportResult, error) {
+	result := &ChatbotImportResult{
+		DryRun:   dryRun,
+		Items:    []ChatbotImportItem{},
+		Errors:   []string{},
+		Warnings: []string{},
+	}
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		imp := &bundleImport{
+			tx:         tx,
+			orgID:      orgID,
+			account:    accountName,
+			onConflict: onConflict,
+			userID:     userID,
+			result:     result,
+			templates:  map[string]uuid.UUID{},
+			flows:      map[string]uuid.UUID{},
+		}
+		if err := imp.run(bundle); err != nil {
+			return err
+		}
+		if dryRun || len(result.Errors) > 0 {
+			return errBundleNotApplied
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBundleNotApplied) {
+		return nil, err
+	}
+	result.Applied = err == nil
+
+	for i := range result.Items {
+		item := &result.Items[i]
+		if item.Conflict != "" {
+			result.Conflicts++
+		}
+		// IDs of records created by a dry run don't exist
+		if !result.Applied && item.Action == BundleActionCreate {
+			item.ID = ""
+		}
+	}
+	return result, nil
+}
+
+// bundleImport imports the records of a bundle and maps the names they refer to onto
+// the organization's records
+type bundleImport struct {
+	tx         *gorm.DB
+	orgID      uuid.UUID
+	account    string // Account that account-specific records and templates go to
+	onConflict string
+	userID     *uuid.UUID
+	result     *ChatbotImportResult
+	templates  map[string]uuid.UUID // "name:language" -> imported or existing template
+	flows      map[string]uuid.UUID // Name -> flow the bundle's flow is imported as
+}
+
+func (imp *bundleImport) fail(format string, args ...interface{}) {
+	imp.result.Errors = append(imp.result.Errors, fmt.Sprintf(format, args...))
+}
+
+func (imp *bundleImport) warn(format string, args ...interface{}) {
+	imp.result.Warnings = append(imp.result.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (imp *bundleImport) add(item ChatbotImportItem) {
+	imp.result.Items = append(imp.result.Items, item)
+}
+
+// conflict returns the item of a record named like an existing one, whose action follows
+// the conflict strategy
+func (imp *bundleImport) conflict(recordType, name string, existingID uuid.UUID) ChatbotImportItem {
+	item := ChatbotImportItem{
+		Type:     recordType,
+		Name:     name,
+		Action:   BundleActionSkip,
+		ID:       existingID.String(),
+		Conflict: "The organization has one with this name",
+	}
+	if imp.onConflict == BundleConflictReplace {
+		item.Action = BundleActionReplace
+	}
+	return item
+}
+
+// recordAccount returns the account a bundle record goes to: the import's account for
+// account-specific records, none for organization-wide ones
+func (imp *bundleImport) recordAccount(name string) string {
+	if name == "" {
+		return ""
+	}
+	return imp.account
+}
+
+func (imp *bundleImport) run(bundle *ChatbotBundle) error {
+	if imp.account == "" && bundleNeedsAccount(bundle) {
+		imp.fail("whatsapp_account is required: the bundle has templates or account-specific records")
+		return nil
+	}
+
+	for i := range bundle.Templates {
+		if err := imp.template(&bundle.Templates[i]); err != nil {
+			return err
+		}
+	}
+	if bundle.Settings != nil {
+		if err := imp.settings(bundle.Settings); err != nil {
+			return err
+		}
+	}
+	for _, record := range bundle.KeywordRules {
+		if err := imp.keywordRule(record); err != nil {
+			return err
+		}
+	}
+	for _, record := range bundle.AIContexts {
+		if err := imp.aiContext(record); err != nil {
+			return err
+		}
+	}
+	return imp.importFlows(bundle.Flows)
+}
+
+// bundleNeedsAccount reports whether a bundle has records that belong to an account
+func bundleNeedsAccount(bundle *ChatbotBundle) bool {
+	if len(bundle.Templates) > 0 {
+		return true
+	}
+	records := append([]models.JSONB{bundle.Settings}, bundle.KeywordRules...)
+	records = append(records, bundle.Flows...)
+	records = append(records, bundle.AIContexts...)
+	for _, record := range records {
+		if account, _ := record["whatsapp_account"].(string); account != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// template imports a template as a local draft on the import's account, unless the
+// account has one with the same name and language. Those are kept as they are.
+func (imp *bundleImport) template(bt *ChatbotBundleTemplate) error {
+	name := normalizeTemplateName(bt.Name)
+	if name == "" || bt.Language == "" || bt.BodyContent == "" {
+		imp.fail("template %q: name, language and body_content are required", bt.Name)
+		return nil
+	}
+	label := name + " (" + bt.Language + ")"
+	template := models.Template{
+		BaseModel:       models.BaseModel{ID: uuid.New()},
+		OrganizationID:  imp.orgID,
+		WhatsAppAccount: imp.account,
+		Name:            name,
+		DisplayName:     bt.DisplayName,
+		Language:        bt.Language,
+		Category:        strings.ToUpper(bt.Category),
+		Status:          "DRAFT", // Local draft until submitted to Meta
+		HeaderType:      strings.ToUpper(bt.HeaderType),
+		HeaderContent:   bt.HeaderContent,
+		BodyContent:     bt.BodyContent,
+		FooterContent:   bt.FooterContent,
+		Buttons:         convertToJSONBArray(bt.Buttons),
+		SampleValues:    convertToJSONBArray(bt.SampleValues),
+	}
+
+	var existing models.Template
+	err := imp.tx.Where("organization_id = ? AND whats_app_account = ? AND name = ? AND language = ?",
+		imp.orgID, imp.account, name, bt.Language).First(&existing).Error
+	if err == nil {
+		imp.templates[name+":"+bt.Language] = existing.ID
+		item := ChatbotImportItem{Type: "template", Name: label, Action: BundleActionUseExisting, ID: existing.ID.String()}
+		if templateContentDiffers(&existing, &template) {
+			item.Conflict = "The account's template has different content and is kept as it is"
+		}
+		imp.add(item)
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if err := imp.tx.Create(&template).Error; err != nil {
+		return err
+	}
+	if err := recordTemplateVersion(imp.tx, nil, &template, TemplateVersionImported, "", imp.userID); err != nil {
+		return err
+	}
+	imp.templates[name+":"+bt.Language] = template.ID
+	imp.add(ChatbotImportItem{Type: "template", Name: label, Action: BundleActionCreate, ID: template.ID.String()})
+	return nil
+}
+
+// templateContentDiffers reports whether two templates send different messages
+func templateContentDiffers(a, b *models.Template) bool {
+	from := templateVersionSnapshot(a)
+	to := templateVersionSnapshot(b)
+	for _, field := range []string{"category", "header_type", "header_content", "body_content", "footer_content", "buttons"} {
+		if _, ok := templateVersionChanges(&from, &to)[field]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// settings imports the chatbot settings, mapping the e-mails of users notified of SLA
+// escalations to their IDs. The AI API key is not part of bundles and is left as it is.
+func (imp *bundleImport) settings(record models.JSONB) error {
+	var settings models.ChatbotSettings
+	if err := decodeBundleRecord(record, &settings); err != nil {
+		imp.fail("settings: %v", err)
+		return nil
+	}
+	settings.OrganizationID = imp.orgID
+	settings.WhatsAppAccount = imp.recordAccount(settings.WhatsAppAccount)
+	settings.SLAEscalationNotifyIDs = models.StringArray{}
+	emails, _ := record["sla_escalation_notify"].([]interface{})
+	for _, e := range emails {
+		email, _ := e.(string)
+		var user models.User
+		if err := imp.tx.Where("organization_id = ? AND email = ?", imp.orgID, email).First(&user).Error; err != nil {
+			imp.warn("settings: user %q to notify of SLA escalations not found, left out", email)
+			continue
+		}
+		settings.SLAEscalationNotifyIDs = append(settings.SLAEscalationNotifyIDs, user.ID.String())
+	}
+
+	name := "Organization defaults"
+	if settings.WhatsAppAccount != "" {
+		name = settings.WhatsAppAccount
+	}
+	var existing models.ChatbotSettings
+	err := imp.tx.Where("organization_id = ? AND whats_app_account = ?", imp.orgID, settings.WhatsAppAccount).First(&existing).Error
+	if err == nil {
+		item := imp.conflict("settings", name, existing.ID)
+		item.Conflict = "Chatbot settings already exist"
+		imp.add(item)
+		if item.Action == BundleActionSkip {
+			return nil
+		}
+		settings.BaseModel = existing.BaseModel
+		settings.AIAPIKey = existing.AIAPIKey
+		return imp.tx.Save(&settings).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	settings.ID = uuid.New()
+	if err := imp.tx.Create(&settings).Error; err != nil {
+		return err
+	}
+	imp.add(ChatbotImportItem{Type: "settings", Name: name, Action: BundleActionCreate, ID: settings.ID.String()})
+	// Create leaves out false values of fields that default to true
+	return imp.tx.Save(&settings).Error
+}
+
+// keywordRule imports a keyword rule
+func (imp *bundleImport) keywordRule(record models.JSONB) error {
+	var rule models.KeywordRule
+	if err := decodeBundleRecord(record, &rule); err != nil || rule.Name == "" || len(rule.Keywords) == 0 {
+		imp.fail("keyword rule %v: name and keywords are required", record["name"])
+		return nil
+	}
+	rule.OrganizationID = imp.orgID
+	rule.WhatsAppAccount = imp.recordAccount(rule.WhatsAppAccount)
+
+	var existing models.KeywordRule
+	err := imp.tx.Where("organization_id = ? AND whats_app_account = ? AND name = ?", imp.orgID, rule.WhatsAppAccount, rule.Name).First(&existing).Error
+	if err == nil {
+		item := imp.conflict("keyword_rule", rule.Name, existing.ID)
+		imp.add(item)
+		if item.Action == BundleActionSkip {
+			return nil
+		}
+		rule.BaseModel = existing.BaseModel
+		return imp.tx.Save(&rule).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	rule.ID = uuid.New()
+	if err := imp.tx.Create(&rule).Error; err != nil {
+		return err
+	}
+	if !rule.IsEnabled {
+		if err := imp.tx.Model(&rule).Update("is_enabled", false).Error; err != nil {
+			return err
+		}
+	}
+	imp.add(ChatbotImportItem{Type: "keyword_rule", Name: rule.Name, Action: BundleActionCreate, ID: rule.ID.String()})
+	return nil
+}
+
+// aiContext imports an AI context
+func (imp *bundleImport) aiContext(record models.JSONB) error {
+	var ctx models.AIContext
+	if err := decodeBundleRecord(record, &ctx); err != nil || ctx.Name == "" {
+		imp.fail("AI context %v: name is required", record["name"])
+		return nil
+	}
+	ctx.OrganizationID = imp.orgID
+	ctx.WhatsAppAccount = imp.recordAccount(ctx.WhatsAppAccount)
+	if ctx.ContextType == "" {
+		ctx.ContextType = "static"
+	}
+
+	var existing models.AIContext
+	err := imp.tx.Where("organization_id = ? AND whats_app_account = ? AND name = ?", imp.orgID, ctx.WhatsAppAccount, ctx.Name).First(&existing).Error
+	if err == nil {
+		item := imp.conflict("ai_context", ctx.Name, existing.ID)
+		imp.add(item)
+		if item.Action == BundleActionSkip {
+			return nil
+		}
+		ctx.BaseModel = existing.BaseModel
+		return imp.tx.Save(&ctx).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	ctx.ID = uuid.New()
+	if err := imp.tx.Create(&ctx).Error; err != nil {
+		return err
+	}
+	if !ctx.IsEnabled {
+		if err := imp.tx.Model(&ctx).Update("is_enabled", false).Error; err != nil {
+			return err
+		}
+	}
+	imp.add(ChatbotImportItem{Type: "ai_context", Name: ctx.Name, Action: BundleActionCreate, ID: ctx.ID.String()})
+	return nil
+}
+
+// importedFlow is a flow of the bundle on its way into the organization
+type importedFlow struct {
+	record   models.JSONB
+	flow     models.ChatbotFlow
+	existing *models.ChatbotFlow // Flow it replaces
+}
+
+// importFlows imports flows in two passes, so that flows of the bundle can call each
+// other: the flows are created first, then their steps, whose flow references then
+// resolve. Each imported flow is published as a new version.
+func (imp *bundleImport) importFlows(records []models.JSONB) error {
+	var flows []*importedFlow
+	for _, record := range records {
+		f := &importedFlow{record: record}
+		fields := models.JSONB{}
+		for key, value := range record {
+			if key != "steps" {
+				fields[key] = value
+			}
+		}
+		if err := decodeBundleRecord(fields, &f.flow); err != nil || f.flow.Name == "" {
+			imp.fail("flow %v: name is required", record["name"])
+			continue
+		}
+		f.flow.OrganizationID = imp.orgID
+		f.flow.WhatsAppAccount = imp.recordAccount(f.flow.WhatsAppAccount)
+		f.flow.InitialTemplateID = nil // Set from the initial_template reference
+		f.flow.PublishedVersion, f.flow.RolloutVersion, f.flow.RolloutPercent = 0, 0, 0
+		if _, dup := imp.flows[f.flow.Name]; dup {
+			imp.fail("flow %q: the bundle has more than one flow with this name", f.flow.Name)
+			continue
+		}
+
+		var existing models.ChatbotFlow
+		err := imp.tx.Where("organization_id = ? AND whats_app_account = ? AND name = ?", imp.orgID, f.flow.WhatsAppAccount, f.flow.Name).First(&existing).Error
+		if err == nil {
+			item := imp.conflict("flow", f.flow.Name, existing.ID)
+			imp.add(item)
+			imp.flows[f.flow.Name] = existing.ID
+			if item.Action == BundleActionSkip {
+				continue
+			}
+			f.existing = &existing
+			f.flow.BaseModel = existing.BaseModel
+			flows = append(flows, f)
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		f.flow.ID = uuid.New()
+		if err := imp.tx.Create(&f.flow).Error; err != nil {
+			return err
+		}
+		imp.flows[f.flow.Name] = f.flow.ID
+		imp.add(ChatbotImportItem{Type: "flow", Name: f.flow.Name, Action: BundleActionCreate, ID: f.flow.ID.String()})
+		flows = append(flows, f)
+	}
+
+	for _, f := range flows {
+		if err := imp.flowContent(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flowContent saves the definition and steps of an imported flow and publishes them
+func (imp *bundleImport) flowContent(f *importedFlow) error {
+	flow := &f.flow
+	problems := 0
+	countProblem := func(format string, args ...interface{}) {
+		imp.fail("flow %q: "+format, append([]interface{}{flow.Name}, args...)...)
+		problems++
+	}
+
+	if ref, ok := f.record["initial_template"].(map[string]interface{}); ok {
+		name, _ := ref["name"].(string)
+		language, _ := ref["language"].(string)
+		if id, ok := imp.templateID(name, language); ok {
+			flow.InitialTemplateID = &id
+		} else {
+			countProblem("initial template %q (%s) not found", name, language)
+		}
+	}
+
+	records, _ := f.record["steps"].([]interface{})
+	steps := make([]models.ChatbotFlowStep, 0, len(records))
+	for i, s := range records {
+		stepRecord, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if err := imp.resolveStepRefs(stepRecord); err != nil {
+			countProblem("step %v: %v", stepRecord["step_name"], err)
+			continue
+		}
+		var step models.ChatbotFlowStep
+		if err := decodeBundleRecord(stepRecord, &step); err != nil {
+			countProblem("step %v: %v", stepRecord["step_name"], err)
+			continue
+		}
+		step.ID = uuid.New()
+		step.FlowID = flow.ID
+		step.StepOrder = i + 1
+		if step.MessageType == "" {
+			step.MessageType = "text"
+		}
+		steps = append(steps, step)
+	}
+	for _, problem := range validateFlowGraph(flow.ID, steps, flowStepsLookup(imp.tx, imp.orgID)) {
+		countProblem("%s", problem)
+	}
+	if problems > 0 {
+		return nil
+	}
+
+	if f.existing != nil {
+		// Flows that predate versioning first get the definition they run recorded, so
+		// that it can be rolled back to
+		if f.existing.PublishedVersion == 0 {
+			var currentSteps []models.ChatbotFlowStep
+			if err := imp.tx.Where("flow_id = ?", flow.ID).Order("step_order ASC").Find(&currentSteps).Error; err != nil {
+				return err
+			}
+			if _, err := recordChatbotFlowVersion(imp.tx, f.existing, currentSteps, "", imp.userID); err != nil {
+				return err
+			}
+		}
+		flow.PublishedVersion = f.existing.PublishedVersion
+		flow.RolloutVersion = f.existing.RolloutVersion
+		flow.RolloutPercent = f.existing.RolloutPercent
+		if err := imp.tx.Save(flow).Error; err != nil {
+			return err
+		}
+		if err := imp.tx.Where("flow_id = ?", flow.ID).Delete(&models.ChatbotFlowStep{}).Error; err != nil {
+			return err
+		}
+	} else {
+		// Create leaves out false values of fields that default to true
+		if err := imp.tx.Model(flow).Updates(map[string]interface{}{
+			"is_enabled":          flow.IsEnabled,
+			"initial_template_id": flow.InitialTemplateID,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	for i := range steps {
+		if err := imp.tx.Create(&steps[i]).Error; err != nil {
+			return err
+		}
+	}
+
+	flow.Steps = steps
+	version, err := recordChatbotFlowVersion(imp.tx, flow, steps, "Imported", imp.userID)
+	if err != nil {
+		return err
+	}
+	return setFlowVersions(imp.tx, flow, version.Version, 0)
+}
+
+// resolveStepRefs replaces the team, flow and WhatsApp Flow names of a bundle step by the
+// IDs of the organization's records
+func (imp *bundleImport) resolveStepRefs(step map[string]interface{}) error {
+	if config, ok := step["transfer_config"].(map[string]interface{}); ok {
+		if name, ok := config["team"].(string); ok {
+			delete(config, "team")
+			if name != "" {
+				var team models.Team
+				if err := imp.tx.Where("organization_id = ? AND name = ?", imp.orgID, name).First(&team).Error; err != nil {
+					return fmt.Errorf("team %q not found", name)
+				}
+				config["team_id"] = team.ID.String()
+			}
+		}
+	}
+	if config, ok := step["control_config"].(map[string]interface{}); ok {
+		if name, ok := config["flow"].(string); ok {
+			delete(config, "flow")
+			id, found := imp.flows[name]
+			if !found && name != "" {
+				var flow models.ChatbotFlow
+				if err := imp.tx.Where("organization_id = ? AND name = ?", imp.orgID, name).First(&flow).Error; err == nil {
+					id, found = flow.ID, true
+				}
+			}
+			if !found {
+				return fmt.Errorf("flow %q not found", name)
+			}
+			config["flow_id"] = id.String()
+		}
+	}
+	if config, ok := step["input_config"].(map[string]interface{}); ok {
+		if name, ok := config["whatsapp_flow"].(string); ok {
+			delete(config, "whatsapp_flow")
+			var waFlows []models.WhatsAppFlow
+			imp.tx.Where("organization_id = ? AND name = ?", imp.orgID, name).Find(&waFlows)
+			if len(waFlows) == 0 {
+				return fmt.Errorf("WhatsApp Flow %q not found", name)
+			}
+			// WhatsApp Flows of the import's account take precedence over namesakes on other accounts
+			waFlow := waFlows[0]
+			for _, candidate := range waFlows {
+				if candidate.WhatsAppAccount == imp.account {
+					waFlow = candidate
+					break
+				}
+			}
+			config["whatsapp_flow_id"] = waFlow.ID.String()
+		}
+	}
+	return nil
+}
+
+// templateID returns the template a flow of the bundle sends: the one imported or found
+// on the import's account, else one with the name in another language or on another
+// account. Flows send templates in the contact's language whichever one they point to.
+func (imp *bundleImport) templateID(name, language string) (uuid.UUID, bool) {
+	name = normalizeTemplateName(name)
+	if id, ok := imp.templates[name+":"+language]; ok {
+		return id, true
+	}
+	var templates []models.Template
+	imp.tx.Where("organization_id = ? AND name = ?", imp.orgID, name).Find(&templates)
+	best, bestScore := -1, -1
+	for i, t := range templates {
+		score := 0
+		if t.WhatsAppAccount == imp.account {
+			score += 2
+		}
+		if t.Language == language {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return uuid.Nil, false
+	}
+	return templates[best].ID, true
+}
@@ -0,0 +1,676 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"gorm.io/gorm"
+)
+
+// Control steps route the conversation without sending a message or waiting for input
+const (
+	StepTypeCondition = "condition" // First branch whose condition holds, else the next step
+	StepTypeGoto      = "goto"      // Jump to a step, at most max_iterations times, then to exit_step
+	StepTypeCallFlow  = "call_flow" // Run another flow, then continue with the next step
+	StepTypeJumpFlow  = "jump_flow" // Continue in another flow, without returning
+)
+
+const (
+	// maxGotoIterations caps the max_iterations of goto steps
+	maxGotoIterations = 100
+	// maxFlowCallDepth caps how deeply call_flow steps can nest
+	maxFlowCallDepth = 5
+	// maxStepVisits is how often a step may run between two user inputs before the flow
+	// is considered stuck in a loop
+	maxStepVisits = maxGotoIterations + 1
+)
+
+// isControlStep reports whether a step only routes the conversation
+func isControlStep(step *models.ChatbotFlowStep) bool {
+	switch step.MessageType {
+	case StepTypeCondition, StepTypeGoto, StepTypeCallFlow, StepTypeJumpFlow:
+		return true
+	}
+	return false
+}
+
+// conditionBranch is a branch of a condition step
+type conditionBranch struct {
+	Condition string
+	Next      string
+}
+
+// conditionBranches returns the branches of a condition step, in order
+func conditionBranches(step *models.ChatbotFlowStep) []conditionBranch {
+	list, _ := step.ControlConfig["branches"].([]interface{})
+	branches := make([]conditionBranch, 0, len(list))
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		condition, _ := m["condition"].(string)
+		next, _ := m["next"].(string)
+		branches = append(branches, conditionBranch{Condition: strings.TrimSpace(condition), Next: next})
+	}
+	return branches
+}
+
+// gotoConfig returns the target, iteration limit and exit step of a goto step
+func gotoConfig(step *models.ChatbotFlowStep) (target string, maxIterations int, exitStep string) {
+	target, _ = step.ControlConfig["target"].(string)
+	exitStep, _ = step.ControlConfig["exit_step"].(string)
+	if n, ok := step.ControlConfig["max_iterations"].(float64); ok {
+		maxIterations = int(n)
+	}
+	return target, maxIterations, exitStep
+}
+
+// controlFlowID returns the flow a call_flow or jump_flow step goes to
+func controlFlowID(step *models.ChatbotFlowStep) (uuid.UUID, error) {
+	value, _ := step.ControlConfig["flow_id"].(string)
+	if value == "" {
+		return uuid.Nil, errors.New("flow_id is required")
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, errors.New("invalid flow_id")
+	}
+	return id, nil
+}
+
+// defaultNextStep returns the step that follows steps[i]: its next_step, or the next step
+// in order. Empty means the flow completes.
+func defaultNextStep(steps []models.ChatbotFlowStep, i int) string {
+	if steps[i].NextStep != "" {
+		return steps[i].NextStep
+	}
+	if i+1 < len(steps) {
+		return steps[i+1].StepName
+	}
+	return ""
+}
+
+// stepEdges returns the steps steps[i] can lead to within its flow, and whether it can
+// also leave the flow (complete it, transfer or jump to another flow)
+func stepEdges(steps []models.ChatbotFlowStep, i int) (next []string, exits bool) {
+	step := &steps[i]
+	add := func(name string) {
+		if name == "" {
+			exits = true
+			return
+		}
+		next = append(next, name)
+	}
+
+	switch step.MessageType {
+	case "transfer", StepTypeJumpFlow:
+		return nil, true
+	case StepTypeCondition:
+		for _, branch := range conditionBranches(step) {
+			add(branch.Next)
+		}
+		add(defaultNextStep(steps, i))
+	case StepTypeGoto:
+		target, _, exitStep := gotoConfig(step)
+		next = append(next, target)
+		add(exitStep)
+	default:
+		add(defaultNextStep(steps, i))
+		for _, v := range step.ConditionalNext {
+			if name, ok := v.(string); ok {
+				add(name)
+			}
+		}
+	}
+	return next, exits
+}
+
+// waitsForInput reports whether the flow stops at a step until the user replies
+func waitsForInput(step *models.ChatbotFlowStep) bool {
+	if isControlStep(step) || step.MessageType == "transfer" {
+		return false
+	}
+	return step.InputType != "none" || expectsFlowResponse(step)
+}
+
+// flowStepsFunc looks up the steps of another flow of the organization, reporting
+// whether the flow exists
+type flowStepsFunc func(uuid.UUID) ([]models.ChatbotFlowStep, bool)
+
+// validateFlowGraph checks that a flow's steps form a sound graph: unique names, control
+// steps that are fully configured, references to steps and flows that exist, every step
+// reachable from the first, no loop that can never be left or that runs without waiting
+// for input or being bounded by a goto, and no flows calling or jumping to each other in a
+// circle without waiting for input. flowSteps looks up the flows called or jumped to;
+// flowID is the flow being saved (uuid.Nil for a new flow).
+func validateFlowGraph(flowID uuid.UUID, steps []models.ChatbotFlowStep, flowSteps flowStepsFunc) []string {
+	var problems []string
+	index := make(map[string]int, len(steps))
+	for i, step := range steps {
+		if step.StepName == "" {
+			problems = append(problems, fmt.Sprintf("step %d has no name", i+1))
+			continue
+		}
+		if _, dup := index[step.StepName]; dup {
+			problems = append(problems, fmt.Sprintf("step name %q is used more than once", step.StepName))
+			continue
+		}
+		index[step.StepName] = i
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+
+	for i := range steps {
+		step := &steps[i]
+		switch step.MessageType {
+		case StepTypeCondition:
+			branches := conditionBranches(step)
+			if len(branches) == 0 {
+				problems = append(problems, fmt.Sprintf("step %q: condition needs at least one branch", step.StepName))
+			}
+			for n, branch := range branches {
+				if branch.Condition == "" || branch.Next == "" {
+					problems = append(problems, fmt.Sprintf("step %q: branch %d needs a condition and a next step", step.StepName, n+1))
+				}
+			}
+		case StepTypeGoto:
+			target, maxIterations, _ := gotoConfig(step)
+			if target == "" {
+				problems = append(problems, fmt.Sprintf("step %q: goto needs a target", step.StepName))
+			}
+			if maxIterations < 1 || maxIterations > maxGotoIterations {
+				problems = append(problems, fmt.Sprintf("step %q: max_iterations must be between 1 and %d", step.StepName, maxGotoIterations))
+			}
+		case StepTypeCallFlow, StepTypeJumpFlow:
+			id, err := controlFlowID(step)
+			switch {
+			case err != nil:
+				problems = append(problems, fmt.Sprintf("step %q: %v", step.StepName, err))
+			case id == flowID && step.MessageType == StepTypeCallFlow:
+				problems = append(problems, fmt.Sprintf("step %q: a flow cannot call itself", step.StepName))
+			case id != flowID && !flowStepsExist(flowSteps, id):
+				problems = append(problems, fmt.Sprintf("step %q: flow %s not found", step.StepName, id))
+			}
+		}
+
+		next, _ := stepEdges(steps, i)
+		for _, name := range next {
+			if name == "" {
+				continue
+			}
+			if _, ok := index[name]; !ok {
+				problems = append(problems, fmt.Sprintf("step %q: leads to unknown step %q", step.StepName, name))
+			}
+		}
+	}
+	if len(problems) > 0 || len(steps) == 0 {
+		return problems
+	}
+
+	// Adjacency by step index
+	edges := make([][]int, len(steps))
+	exits := make([]bool, len(steps))
+	for i := range steps {
+		next, canExit := stepEdges(steps, i)
+		exits[i] = canExit
+		for _, name := range next {
+			edges[i] = append(edges[i], index[name])
+		}
+	}
+
+	// Every step must be reachable from the first
+	reached := make([]bool, len(steps))
+	queue := []int{0}
+	reached[0] = true
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		for _, j := range edges[i] {
+			if !reached[j] {
+				reached[j] = true
+				queue = append(queue, j)
+			}
+		}
+	}
+	for i, ok := range reached {
+		if !ok {
+			problems = append(problems, fmt.Sprintf("step %q is unreachable", steps[i].StepName))
+		}
+	}
+
+	// Every loop needs a way out, and must wait for input or be bounded by a goto
+	for _, component := range stronglyConnected(edges) {
+		if len(component) == 1 && !hasEdge(edges, component[0], component[0]) {
+			continue
+		}
+		inLoop := make(map[int]bool, len(component))
+		for _, i := range component {
+			inLoop[i] = true
+		}
+		names := make([]string, 0, len(component))
+		canLeave, waits, bounded := false, false, false
+		for _, i := range component {
+			names = append(names, strconv.Quote(steps[i].StepName))
+			if exits[i] {
+				canLeave = true
+			}
+			for _, j := range edges[i] {
+				if !inLoop[j] {
+					canLeave = true
+				}
+			}
+			if waitsForInput(&steps[i]) {
+				waits = true
+			}
+			if steps[i].MessageType == StepTypeGoto {
+				bounded = true
+			}
+		}
+		sort.Strings(names)
+		loop := "loop through steps " + strings.Join(names, ", ")
+		if !canLeave {
+			problems = append(problems, loop+" has no way out")
+		} else if !waits && !bounded {
+			problems = append(problems, loop+" never waits for input; bound it with a goto step")
+		}
+	}
+
+	if cycle := flowEntryCycle(flowID, steps, flowSteps); len(cycle) == 2 {
+		problems = append(problems, "the flow jumps to itself without waiting for input")
+	} else if cycle != nil {
+		names := make([]string, len(cycle))
+		for i, id := range cycle {
+			names[i] = id.String()
+		}
+		problems = append(problems, "flows "+strings.Join(names, " -> ")+" call or jump to each other without waiting for input")
+	}
+	return problems
+}
+
+func flowStepsExist(flowSteps flowStepsFunc, id uuid.UUID) bool {
+	_, ok := flowSteps(id)
+	return ok
+}
+
+// flowEntries returns the flows that call_flow and jump_flow steps reachable from the
+// first step without waiting for input lead to. The steps after a call_flow are not
+// followed, as the called flow may wait for input before it returns.
+func flowEntries(steps []models.ChatbotFlowStep) []uuid.UUID {
+	if len(steps) == 0 {
+		return nil
+	}
+	index := make(map[string]int, len(steps))
+	for i, step := range steps {
+		index[step.StepName] = i
+	}
+
+	var entries []uuid.UUID
+	seen := make(map[uuid.UUID]bool)
+	reached := make([]bool, len(steps))
+	queue := []int{0}
+	reached[0] = true
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		step := &steps[i]
+		if waitsForInput(step) {
+			continue
+		}
+		if step.MessageType == StepTypeCallFlow || step.MessageType == StepTypeJumpFlow {
+			if id, err := controlFlowID(step); err == nil && !seen[id] {
+				seen[id] = true
+				entries = append(entries, id)
+			}
+			continue
+		}
+		next, _ := stepEdges(steps, i)
+		for _, name := range next {
+			if j, ok := index[name]; ok && !reached[j] {
+				reached[j] = true
+				queue = append(queue, j)
+			}
+		}
+	}
+	return entries
+}
+
+// flowEntryCycle returns a circle of flows, from flowID back to it, each of which calls or
+// jumps to the next without waiting for input, or nil if there is none. Flows are always
+// entered at their first step, so every such circle through the flow being saved passes
+// its start; circles among other flows were rejected when those were saved.
+func flowEntryCycle(flowID uuid.UUID, steps []models.ChatbotFlowStep, flowSteps flowStepsFunc) []uuid.UUID {
+	if flowID == uuid.Nil {
+		// A new flow cannot be called or jumped to yet
+		return nil
+	}
+
+	entries := map[uuid.UUID][]uuid.UUID{flowID: flowEntries(steps)}
+	entriesOf := func(id uuid.UUID) []uuid.UUID {
+		if e, ok := entries[id]; ok {
+			return e
+		}
+		s, _ := flowSteps(id)
+		entries[id] = flowEntries(s)
+		return entries[id]
+	}
+
+	// Breadth-first from the flow, remembering how each flow was first entered
+	parent := map[uuid.UUID]uuid.UUID{}
+	queue := []uuid.UUID{flowID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, next := range entriesOf(id) {
+			if next == flowID {
+				cycle := []uuid.UUID{flowID}
+				for at := id; at != flowID; at = parent[at] {
+					cycle = append(cycle, at)
+				}
+				cycle = append(cycle, flowID)
+				// Reverse the path walked back through parents, keeping flowID at both ends
+				for i, j := 1, len(cycle)-2; i < j; i, j = i+1, j-1 {
+					cycle[i], cycle[j] = cycle[j], cycle[i]
+				}
+				return cycle
+			}
+			if _, ok := parent[next]; !ok {
+				parent[next] = id
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil
+}
+
+// stronglyConnected returns the strongly connected components of a graph (Tarjan)
+func stronglyConnected(edges [][]int) [][]int {
+	n := len(edges)
+	indexOf := make([]int, n)
+	lowLink := make([]int, n)
+	onStack := make([]bool, n)
+	for i := range indexOf {
+		indexOf[i] = -1
+	}
+	var stack []int
+	var components [][]int
+	counter := 0
+
+	var visit func(v int)
+	visit = func(v int) {
+		indexOf[v] = counter
+		lowLink[v] = counter
+		counter++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range edges[v] {
+			if indexOf[w] == -1 {
+				visit(w)
+				lowLink[v] = min(lowLink[v], lowLink[w])
+			} else if onStack[w] {
+				lowLink[v] = min(lowLink[v], indexOf[w])
+			}
+		}
+		if lowLink[v] == indexOf[v] {
+			var component []int
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				component = append(component, w)
+				if w == v {
+					break
+				}
+			}
+			components = append(components, component)
+		}
+	}
+	for v := 0; v < n; v++ {
+		if indexOf[v] == -1 {
+			visit(v)
+		}
+	}
+	return components
+}
+
+func hasEdge(edges [][]int, from, to int) bool {
+	for _, j := range edges[from] {
+		if j == to {
+			return true
+		}
+	}
+	return false
+}
+
+// flowStepsFunc returns a lookup of the organization's chatbot flows for validateFlowGraph
+func (a *App) flowStepsFunc(orgID uuid.UUID) flowStepsFunc {
+	return flowStepsLookup(a.DB, orgID)
+}
+
+// flowStepsLookup looks up the current steps of the organization's chatbot flows in db
+func flowStepsLookup(db *gorm.DB, orgID uuid.UUID) flowStepsFunc {
+	return func(id uuid.UUID) ([]models.ChatbotFlowStep, bool) {
+		var count int64
+		db.Model(&models.ChatbotFlow{}).Where("id = ? AND organization_id = ?", id, orgID).Count(&count)
+		if count == 0 {
+			return nil, false
+		}
+		var steps []models.ChatbotFlowStep
+		db.Where("flow_id = ?", id).Order("step_order ASC").Find(&steps)
+		return steps, true
+	}
+}
+
+// runControlStep routes the conversation from a control step to the step, flow or
+// completion it leads to
+func (a *App) runControlStep(account *models.WhatsAppAccount, session *models.ChatbotSession, contact *models.Contact, step *models.ChatbotFlowStep, flow *models.ChatbotFlow, visits map[string]int) {
+	sessionData := session.SessionData
+	if sessionData == nil {
+		sessionData = models.JSONB{}
+	}
+	stepIndex := 0
+	for i := range flow.Steps {
+		if flow.Steps[i].StepName == step.StepName {
+			stepIndex = i
+			break
+		}
+	}
+
+	switch step.MessageType {
+	case StepTypeCondition:
+		next := defaultNextStep(flow.Steps, stepIndex)
+		for _, branch := range conditionBranches(step) {
+			if branch.Condition != "" && evaluateExpression(branch.Condition, sessionData) {
+				next = branch.Next
+				break
+			}
+		}
+		a.Log.Info("Condition evaluated", "step", step.StepName, "next_step", next)
+		a.advanceToStep(account, session, contact, flow, next, visits)
+
+	case StepTypeGoto:
+		target, maxIterations, exitStep := gotoConfig(step)
+		key := flow.ID.String() + ":" + step.StepName
+		counts := session.LoopCounts
+		if counts == nil {
+			counts = models.JSONB{}
+		}
+		taken, _ := counts[key].(float64)
+		next := target
+		if int(taken) >= maxIterations {
+			// Loop exhausted: leave it, and start over if the flow comes back to it later
+			delete(counts, key)
+			next = exitStep
+			a.Log.Info("Loop exhausted", "step", step.StepName, "iterations", int(taken), "exit_step", exitStep)
+		} else {
+			counts[key] = taken + 1
+		}
+		session.LoopCounts = counts
+		a.DB.Model(session).Update("loop_counts", counts)
+		a.advanceToStep(account, session, contact, flow, next, visits)
+
+	case StepTypeCallFlow:
+		next := defaultNextStep(flow.Steps, stepIndex)
+		target, err := a.controlTargetFlow(account.OrganizationID, step)
+		if err == nil && len(session.CallStack) >= maxFlowCallDepth {
+			err = fmt.Errorf("flow calls are nested more than %d deep", maxFlowCallDepth)
+		}
+		if err != nil {
+			a.Log.Error("Failed to call flow, continuing", "error", err, "step", step.StepName)
+			a.advanceToStep(account, session, contact, flow, next, visits)
+			return
+		}
+		session.CallStack = append(session.CallStack, map[string]interface{}{
+			"flow_id":     flow.ID.String(),
+			"version":     flow.Version,
+			"return_step": next,
+		})
+		a.DB.Model(session).Update("call_stack", session.CallStack)
+		a.Log.Info("Calling flow", "step", step.StepName, "flow_id", target.ID, "return_step", next)
+		a.enterFlow(account, session, contact, target, visits)
+
+	case StepTypeJumpFlow:
+		target, err := a.controlTargetFlow(account.OrganizationID, step)
+		if err != nil {
+			a.Log.Error("Failed to jump to flow, completing", "error", err, "step", step.StepName)
+			a.completeFlow(account, session, contact, flow)
+			return
+		}
+		a.Log.Info("Jumping to flow", "step", step.StepName, "flow_id", target.ID)
+		a.enterFlow(account, session, contact, target, visits)
+	}
+}
+
+// controlTargetFlow loads the enabled flow a call_flow or jump_flow step goes to
+func (a *App) controlTargetFlow(orgID uuid.UUID, step *models.ChatbotFlowStep) (*models.ChatbotFlow, error) {
+	id, err := controlFlowID(step)
+	if err != nil {
+		return nil, err
+	}
+	target, err := a.getChatbotFlowByIDCached(orgID, id)
+	if err != nil {
+		return nil, fmt.Errorf("flow %s not found: %w", id, err)
+	}
+	if !target.IsEnabled {
+		return nil, fmt.Errorf("flow %s is disabled", id)
+	}
+	return a.rolloutFlow(orgID, target), nil
+}
+
+// enterFlow makes flow the session's current flow, keeping the session data, and sends its
+// initial message and first step
+func (a *App) enterFlow(account *models.WhatsAppAccount, session *models.ChatbotSession, contact *models.Contact, flow *models.ChatbotFlow, visits map[string]int) {
+	session.CurrentFlowID = &flow.ID
+	session.FlowVersion = flow.Version
+	session.CurrentStep = ""
+	session.StepRetries = 0
+	a.DB.Model(session).Updates(map[string]interface{}{
+		"current_flow_id": flow.ID,
+		"flow_version":    flow.Version,
+		"current_step":    "",
+		"step_retries":    0,
+	})
+
+	// Send initial template (in the contact's language) or message if configured
+	if flow.InitialTemplateID != nil {
+		if template := a.flowInitialTemplate(account, contact, flow); template != nil {
+			a.sendAndSaveTemplateMessage(account, contact, template)
+			a.logSessionMessage(session.ID, "outgoing", template.BodyContent, "flow_start")
+		} else if flow.InitialMessage != "" {
+			a.sendAndSaveTextMessage(account, contact, flow.InitialMessage)
+			a.logSessionMessage(session.ID, "outgoing", flow.InitialMessage, "flow_start")
+		}
+	} else if flow.InitialMessage != "" {
+		a.sendAndSaveTextMessage(account, contact, flow.InitialMessage)
+		a.logSessionMessage(session.ID, "outgoing", flow.InitialMessage, "flow_start")
+	}
+
+	// Send first step message (with skip check)
+	if len(flow.Steps) > 0 {
+		firstStep := &flow.Steps[0]
+		a.Log.Info("Sending first step", "step_name", firstStep.StepName, "message_type", firstStep.MessageType, "message", firstStep.Message)
+		session.CurrentStep = firstStep.StepName
+		a.DB.Model(session).Update("current_step", firstStep.StepName)
+
+		a.sendStepWithSkipCheck(account, session, contact, firstStep, flow, visits)
+	} else {
+		// No steps, complete the flow
+		a.completeFlow(account, session, contact, flow)
+	}
+}
+
+// advanceToStep moves the session to the named step of flow and runs it, or completes
+// the flow when the name is empty or unknown
+func (a *App) advanceToStep(account *models.WhatsAppAccount, session *models.ChatbotSession, contact *models.Contact, flow *models.ChatbotFlow, nextStepName string, visits map[string]int) {
+	if nextStepName == "" {
+		// No next step, complete flow
+		a.completeFlow(account, session, contact, flow)
+		return
+	}
+
+	var nextStep *models.ChatbotFlowStep
+	for i := range flow.Steps {
+		if flow.Steps[i].StepName == nextStepName {
+			nextStep = &flow.Steps[i]
+			break
+		}
+	}
+	if nextStep == nil {
+		a.Log.Warn("Next step not found, completing flow", "next_step", nextStepName)
+		a.completeFlow(account, session, contact, flow)
+		return
+	}
+
+	// Update session to next step
+	session.CurrentStep = nextStep.StepName
+	a.DB.Model(session).Update("current_step", nextStep.StepName)
+
+	// Recursively check next step (it may also need to skip or have no input)
+	a.sendStepWithSkipCheck(account, session, contact, nextStep, flow, visits)
+}
+
+// returnFromSubFlow resumes the flow that called the session's current flow, at the step
+// after the call. Returns false when the current flow was not called by another.
+func (a *App) returnFromSubFlow(account *models.WhatsAppAccount, session *models.ChatbotSession, contact *models.Contact) bool {
+	for len(session.CallStack) > 0 {
+		frame, _ := session.CallStack[len(session.CallStack)-1].(map[string]interface{})
+		session.CallStack = session.CallStack[:len(session.CallStack)-1]
+		a.DB.Model(session).Update("call_stack", session.CallStack)
+
+		flowIDStr, _ := frame["flow_id"].(string)
+		returnStep, _ := frame["return_step"].(string)
+		flowID, err := uuid.Parse(flowIDStr)
+		if err != nil {
+			continue
+		}
+		// Frames read back from the database hold numbers as float64
+		version, _ := frame["version"].(int)
+		if v, ok := frame["version"].(float64); ok {
+			version = int(v)
+		}
+		caller, err := a.getChatbotFlowVersionCached(account.OrganizationID, flowID, version)
+		if err != nil {
+			a.Log.Warn("Calling flow not found, returning further up", "flow_id", flowIDStr)
+			continue
+		}
+
+		a.Log.Info("Returning to calling flow", "flow_id", caller.ID, "version", caller.Version, "return_step", returnStep)
+		session.CurrentFlowID = &caller.ID
+		session.FlowVersion = caller.Version
+		session.StepRetries = 0
+		a.DB.Model(session).Updates(map[string]interface{}{
+			"current_flow_id": caller.ID,
+			"flow_version":    caller.Version,
+			"step_retries":    0,
+		})
+		a.advanceToStep(account, session, contact, caller, returnStep, nil)
+		return true
+	}
+	return false
+}
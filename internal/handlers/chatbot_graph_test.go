@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+)
+
+// Steps for graph tests. Text steps with input type "none" only send a message; ask
+// steps wait for a reply.
+func textStep(name, next string) models.ChatbotFlowStep {
+	return models.ChatbotFlowStep{StepName: name, MessageType: "text", InputType: "none", NextStep: next}
+}
+
+func askStep(name, next string) models.ChatbotFlowStep {
+	return models.ChatbotFlowStep{StepName: name, MessageType: "text", InputType: "text", NextStep: next}
+}
+
+func flowStep(name, stepType string, flowID uuid.UUID) models.ChatbotFlowStep {
+	return models.ChatbotFlowStep{StepName: name, MessageType: stepType, ControlConfig: models.JSONB{"flow_id": flowID.String()}}
+}
+
+func gotoStep(name, target string, maxIterations float64, exitStep string) models.ChatbotFlowStep {
+	return models.ChatbotFlowStep{StepName: name, MessageType: StepTypeGoto, ControlConfig: models.JSONB{
+		"target": target, "max_iterations": maxIterations, "exit_step": exitStep,
+	}}
+}
+
+func conditionStep(name, condition, branchNext, next string) models.ChatbotFlowStep {
+	return models.ChatbotFlowStep{StepName: name, MessageType: StepTypeCondition, NextStep: next, ControlConfig: models.JSONB{
+		"branches": []interface{}{map[string]interface{}{"condition": condition, "next": branchNext}},
+	}}
+}
+
+// testFlows is a flowStepsFunc over fixed flows
+func testFlows(flows map[uuid.UUID][]models.ChatbotFlowStep) flowStepsFunc {
+	return func(id uuid.UUID) ([]models.ChatbotFlowStep, bool) {
+		steps, ok := flows[id]
+		return steps, ok
+	}
+}
+
+func TestValidateFlowGraph(t *testing.T) {
+	self, other := uuid.New(), uuid.New()
+	flows := testFlows(map[uuid.UUID][]models.ChatbotFlowStep{other: {askStep("hello", "")}})
+
+	tests := []struct {
+		name  string
+		steps []models.ChatbotFlowStep
+		want  string // substring of the only problem; "" for a valid flow
+	}{
+		{"linear", []models.ChatbotFlowStep{askStep("name", ""), textStep("thanks", "")}, ""},
+		{"empty", nil, ""},
+		{"unnamed step", []models.ChatbotFlowStep{askStep("", "")}, "step 1 has no name"},
+		{"duplicate name", []models.ChatbotFlowStep{askStep("a", ""), askStep("a", "")}, `step name "a" is used more than once`},
+		{"unknown next step", []models.ChatbotFlowStep{askStep("a", "missing")}, `leads to unknown step "missing"`},
+		{"unreachable step", []models.ChatbotFlowStep{askStep("a", "c"), askStep("b", ""), askStep("c", "")}, `step "b" is unreachable`},
+		{
+			"condition without branches",
+			[]models.ChatbotFlowStep{{StepName: "a", MessageType: StepTypeCondition}},
+			"condition needs at least one branch",
+		},
+		{
+			"condition branch without next step",
+			[]models.ChatbotFlowStep{conditionStep("a", "x == 1", "", "")},
+			"branch 1 needs a condition and a next step",
+		},
+		{"goto without target", []models.ChatbotFlowStep{gotoStep("a", "", 3, "")}, "goto needs a target"},
+		{
+			"goto iterations out of range",
+			[]models.ChatbotFlowStep{askStep("a", ""), gotoStep("b", "a", maxGotoIterations+1, "")},
+			"max_iterations must be between 1 and 100",
+		},
+		{"call without flow", []models.ChatbotFlowStep{{StepName: "a", MessageType: StepTypeCallFlow}}, "flow_id is required"},
+		{
+			"call with invalid flow",
+			[]models.ChatbotFlowStep{{StepName: "a", MessageType: StepTypeCallFlow, ControlConfig: models.JSONB{"flow_id": "x"}}},
+			"invalid flow_id",
+		},
+		{"call to unknown flow", []models.ChatbotFlowStep{flowStep("a", StepTypeCallFlow, uuid.New())}, "not found"},
+		{"call to itself", []models.ChatbotFlowStep{flowStep("a", StepTypeCallFlow, self)}, "a flow cannot call itself"},
+		{"call to another flow", []models.ChatbotFlowStep{flowStep("a", StepTypeCallFlow, other), textStep("b", "")}, ""},
+		{"jump to itself after input", []models.ChatbotFlowStep{askStep("a", ""), flowStep("b", StepTypeJumpFlow, self)}, ""},
+		{"jump to itself without input", []models.ChatbotFlowStep{textStep("a", ""), flowStep("b", StepTypeJumpFlow, self)}, "the flow jumps to itself without waiting for input"},
+		{"loop waiting for input", []models.ChatbotFlowStep{askStep("a", ""), conditionStep("b", "done == 1", "c", "a"), textStep("c", "")}, ""},
+		{"loop bounded by goto", []models.ChatbotFlowStep{textStep("a", ""), gotoStep("b", "a", 3, "")}, ""},
+		{
+			"loop without input",
+			[]models.ChatbotFlowStep{textStep("a", ""), conditionStep("b", "done == 1", "c", "a"), textStep("c", "")},
+			`loop through steps "a", "b" never waits for input`,
+		},
+		{"loop without way out", []models.ChatbotFlowStep{askStep("a", "b"), askStep("b", "a")}, `loop through steps "a", "b" has no way out`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := validateFlowGraph(self, tt.steps, flows)
+			if tt.want == "" {
+				if len(problems) > 0 {
+					t.Errorf("problems = %q, want none", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0], tt.want) {
+				t.Errorf("problems = %q, want one containing %q", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFlowGraphFlowCycles(t *testing.T) {
+	f, g, h := uuid.New(), uuid.New(), uuid.New()
+
+	tests := []struct {
+		name  string
+		steps []models.ChatbotFlowStep // of flow f
+		other map[uuid.UUID][]models.ChatbotFlowStep
+		want  string // substring of the only problem; "" for a valid flow
+	}{
+		{
+			"jump back without input",
+			[]models.ChatbotFlowStep{textStep("hi", ""), flowStep("to_g", StepTypeJumpFlow, g)},
+			map[uuid.UUID][]models.ChatbotFlowStep{g: {flowStep("to_f", StepTypeJumpFlow, f)}},
+			"flows " + f.String() + " -> " + g.String() + " -> " + f.String() + " call or jump to each other",
+		},
+		{
+			"call back without input",
+			[]models.ChatbotFlowStep{flowStep("call_g", StepTypeCallFlow, g), askStep("after", "")},
+			map[uuid.UUID][]models.ChatbotFlowStep{g: {textStep("hi", ""), flowStep("call_f", StepTypeCallFlow, f)}},
+			"flows " + f.String() + " -> " + g.String() + " -> " + f.String(),
+		},
+		{
+			"through three flows",
+			[]models.ChatbotFlowStep{flowStep("to_g", StepTypeJumpFlow, g)},
+			map[uuid.UUID][]models.ChatbotFlowStep{
+				g: {textStep("hi", ""), flowStep("to_h", StepTypeJumpFlow, h)},
+				h: {flowStep("to_f", StepTypeJumpFlow, f)},
+			},
+			"flows " + f.String() + " -> " + g.String() + " -> " + h.String() + " -> " + f.String(),
+		},
+		{
+			"through a condition branch",
+			[]models.ChatbotFlowStep{conditionStep("route", "x == 1", "to_h", "to_g"), flowStep("to_g", StepTypeJumpFlow, g), flowStep("to_h", StepTypeJumpFlow, h)},
+			map[uuid.UUID][]models.ChatbotFlowStep{
+				g: {askStep("ask", ""), flowStep("to_f", StepTypeJumpFlow, f)},
+				h: {conditionStep("route", "y == 1", "to_f", "to_g"), flowStep("to_g", StepTypeJumpFlow, g), flowStep("to_f", StepTypeJumpFlow, f)},
+			},
+			"flows " + f.String() + " -> " + h.String() + " -> " + f.String(),
+		},
+		{
+			"other flow waits for input",
+			[]models.ChatbotFlowStep{flowStep("to_g", StepTypeJumpFlow, g)},
+			map[uuid.UUID][]models.ChatbotFlowStep{g: {askStep("ask", ""), flowStep("to_f", StepTypeJumpFlow, f)}},
+			"",
+		},
+		{
+			"flow waits for input before leaving",
+			[]models.ChatbotFlowStep{askStep("ask", ""), flowStep("to_g", StepTypeJumpFlow, g)},
+			map[uuid.UUID][]models.ChatbotFlowStep{g: {flowStep("to_f", StepTypeJumpFlow, f)}},
+			"",
+		},
+		{
+			// Only steps before the call run without input; the called flow may wait
+			"return from a call",
+			[]models.ChatbotFlowStep{flowStep("call_h", StepTypeCallFlow, h), flowStep("to_g", StepTypeJumpFlow, g)},
+			map[uuid.UUID][]models.ChatbotFlowStep{
+				g: {flowStep("to_f", StepTypeJumpFlow, f)},
+				h: {askStep("ask", "")},
+			},
+			"",
+		},
+		{
+			// A circle between other flows is theirs to fix, and must not hang validation
+			"circle not through this flow",
+			[]models.ChatbotFlowStep{flowStep("to_g", StepTypeJumpFlow, g)},
+			map[uuid.UUID][]models.ChatbotFlowStep{
+				g: {flowStep("to_h", StepTypeJumpFlow, h)},
+				h: {flowStep("to_g", StepTypeJumpFlow, g)},
+			},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flows := map[uuid.UUID][]models.ChatbotFlowStep{f: {askStep("old", "")}}
+			for id, steps := range tt.other {
+				flows[id] = steps
+			}
+			problems := validateFlowGraph(f, tt.steps, testFlows(flows))
+			if tt.want == "" {
+				if len(problems) > 0 {
+					t.Errorf("problems = %q, want none", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0], tt.want) {
+				t.Errorf("problems = %q, want one containing %q", problems, tt.want)
+			}
+		})
+	}
+
+	// A new flow cannot be entered by others yet
+	steps := []models.ChatbotFlowStep{flowStep("to_g", StepTypeJumpFlow, g)}
+	flows := testFlows(map[uuid.UUID][]models.ChatbotFlowStep{g: {flowStep("to_g", StepTypeJumpFlow, g)}})
+	if problems := validateFlowGraph(uuid.Nil, steps, flows); len(problems) > 0 {
+		t.Errorf("new flow problems = %q, want none", problems)
+	}
+}
+
+func TestStronglyConnected(t *testing.T) {
+	tests := []struct {
+		name  string
+		edges [][]int
+		want  [][]int
+	}{
+		{"empty", nil, nil},
+		{"single", [][]int{nil}, [][]int{{0}}},
+		{"self loop", [][]int{{0}}, [][]int{{0}}},
+		{"chain", [][]int{{1}, {2}, nil}, [][]int{{0}, {1}, {2}}},
+		{"cycle", [][]int{{1}, {2}, {0}}, [][]int{{0, 1, 2}}},
+		{"two cycles joined", [][]int{{1}, {0, 2}, {3}, {2}}, [][]int{{0, 1}, {2, 3}}},
+		{"cycle with tail", [][]int{{1}, {2}, {1, 3}, nil}, [][]int{{0}, {1, 2}, {3}}},
+		{"nested cycles", [][]int{{1}, {2, 0}, {0, 3}, {1}}, [][]int{{0, 1, 2, 3}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stronglyConnected(tt.edges)
+			for _, component := range got {
+				sort.Ints(component)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i][0] < got[j][0] })
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("components = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
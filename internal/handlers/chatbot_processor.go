@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,8 +14,10 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/internal/templates"
 	"github.com/isaee-xyz/whatomate/internal/websocket"
 	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
+	"gorm.io/gorm"
 )
 
 // IncomingTextMessage represents a text, interactive, or media message from the webhook
@@ -37,7 +40,17 @@ type IncomingTextMessage struct {
 			Title       string `json:"title"`
 			Description string `json:"description"`
 		} `json:"list_reply,omitempty"`
+		NFMReply *struct {
+			ResponseJSON string `json:"response_json"`
+			Body         string `json:"body"`
+			Name         string `json:"name"`
+		} `json:"nfm_reply,omitempty"`
 	} `json:"interactive,omitempty"`
+	// Button is a reply to a template's quick-reply button
+	Button *struct {
+		Text    string `json:"text"`
+		Payload string `json:"payload"`
+	} `json:"button,omitempty"`
 	Image *struct {
 		ID       string `json:"id"`
 		MimeType string `json:"mime_type"`
@@ -94,8 +107,10 @@ type IncomingTextMessage struct {
 	} `json:"contacts,omitempty"`
 }
 
-// processIncomingMessageFull processes incoming WhatsApp messages with chatbot logic
-func (a *App) processIncomingMessageFull(phoneNumberID string, msg IncomingTextMessage, profileName string) {
+// processIncomingMessageFull processes incoming WhatsApp messages with chatbot logic.
+// An error is returned when the message could not be stored so the webhook queue retries
+// it; once stored, a retry is skipped as a duplicate, so later failures are only logged.
+func (a *App) processIncomingMessageFull(phoneNumberID string, msg IncomingTextMessage, profileName string) error {
 	a.Log.Info("Processing incoming message",
 		"phone_number_id", phoneNumberID,
 		"from", msg.From,
@@ -106,18 +121,22 @@ func (a *App) processIncomingMessageFull(phoneNumberID string, msg IncomingTextM
 	// Find the WhatsApp account by phone_number_id (use cache)
 	account, err := a.getWhatsAppAccountCached(phoneNumberID)
 	if err != nil {
-		a.Log.Error("WhatsApp account not found", "phone_id", phoneNumberID, "error", err)
-		return
+		return fmt.Errorf("failed to find WhatsApp account %s: %w", phoneNumberID, err)
 	}
 
 	// Handle reaction messages specially - they update existing messages, not create new ones
 	if msg.Type == "reaction" && msg.Reaction != nil {
-		a.handleIncomingReaction(account, msg.From, msg.Reaction.MessageID, msg.Reaction.Emoji, profileName)
-		return
+		return a.handleIncomingReaction(account, msg.From, msg.Reaction.MessageID, msg.Reaction.Emoji, profileName)
 	}
 
 	// Get or create contact (always do this for all incoming messages)
-	contact, isNewContact := a.getOrCreateContact(account.OrganizationID, msg.From, profileName)
+	contact, isNewContact, err := a.getOrCreateContact(account.OrganizationID, msg.From, profileName)
+	if err != nil {
+		return err
+	}
+
+	// Every inbound message opens (or extends) the customer service window on this account
+	a.recordInboundMessage(account.OrganizationID, contact.ID, account.Name, parseWebhookTimestamp(msg.Timestamp))
 
 	// Dispatch webhook if new contact was created
 	if isNewContact {
@@ -134,9 +153,14 @@ func (a *App) processIncomingMessageFull(phoneNumberID string, msg IncomingTextM
 	messageType := msg.Type
 	buttonID := "" // Track button/list ID for conditional routing
 	var mediaInfo *MediaInfo
+	var submission *flowSubmission
 
 	if msg.Type == "text" && msg.Text != nil {
 		messageText = msg.Text.Body
+	} else if msg.Type == "button" && msg.Button != nil {
+		// Handle template quick-reply button
+		messageText = msg.Button.Text
+		buttonID = msg.Button.Payload
 	} else if msg.Type == "interactive" && msg.Interactive != nil {
 		// Handle button reply
 		if msg.Interactive.ButtonReply != nil {
@@ -150,6 +174,15 @@ func (a *App) processIncomingMessageFull(phoneNumberID string, msg IncomingTextM
 			buttonID = msg.Interactive.ListReply.ID
 			messageType = "button_reply"
 		}
+		// Handle WhatsApp Flow response
+		if msg.Interactive.NFMReply != nil {
+			messageText = msg.Interactive.NFMReply.Body
+			if messageText == "" {
+				messageText = "Sent"
+			}
+			messageType = "flow"
+			submission = a.resolveFlowSubmission(account, msg.Interactive.NFMReply.ResponseJSON)
+		}
 	} else if msg.Type == "image" && msg.Image != nil {
 		// Handle image message
 		messageText = msg.Image.Caption
@@ -280,30 +313,54 @@ func (a *App) processIncomingMessageFull(phoneNumberID string, msg IncomingTextM
 	if msg.Context != nil && msg.Context.ID != "" {
 		replyToWAMID = msg.Context.ID
 	}
-	a.saveIncomingMessage(account, contact, msg.ID, messageType, messageText, mediaInfo, replyToWAMID)
+	var flowResponse models.JSONB
+	if submission != nil {
+		flowResponse = submission.record()
+	}
+	saved := a.saveIncomingMessage(account, contact, msg.ID, messageType, messageText, mediaInfo, replyToWAMID, flowResponse)
+	if saved == nil {
+		return fmt.Errorf("failed to save incoming message %s", msg.ID)
+	}
+	if submission != nil {
+		a.dispatchFlowSubmitted(account, contact, saved, submission)
+	}
 
 	// Clear chatbot tracking since client has replied
 	a.ClearContactChatbotTracking(contact.ID)
 
+	// STOP / START keywords, typed or picked from a button or list, update the opt-out list
+	// and get no automated reply
+	for _, reply := range optOutKeywordCandidates(msg) {
+		if a.handleOptOutKeyword(account, contact, reply) {
+			return nil
+		}
+	}
+
 	// Check for active agent transfer - skip chatbot processing if transferred
 	if a.hasActiveAgentTransfer(account.OrganizationID, contact.ID) {
 		a.Log.Info("Contact has active agent transfer, skipping chatbot processing",
 			"contact_id", contact.ID,
 			"phone_number", contact.PhoneNumber)
-		return
+		return nil
 	}
 
 	// Check if chatbot is enabled for this account (use cache)
 	settings, err := a.getChatbotSettingsCached(account.OrganizationID, account.Name)
 	if err != nil {
 		a.Log.Error("Failed to load chatbot settings", "error", err, "account", account.Name, "org_id", account.OrganizationID)
-		return
+		return nil
 	}
 	if !settings.IsEnabled {
 		a.Log.Debug("Chatbot not enabled for this account, creating transfer for agent queue", "account", account.Name, "settings_id", settings.ID)
 		// Create transfer to agent queue when chatbot is disabled
 		a.createTransferToQueue(account, contact, "chatbot_disabled")
-		return
+		return nil
+	}
+
+	// Opted-out contacts still reach agents, but get no automated replies
+	if a.isOptedOut(account.OrganizationID, contact.PhoneNumber) {
+		a.Log.Info("Contact has opted out, skipping chatbot processing", "contact_id", contact.ID)
+		return nil
 	}
 	a.Log.Info("Chatbot settings loaded", "settings_id", settings.ID, "is_enabled", settings.IsEnabled, "ai_enabled", settings.AIEnabled, "ai_provider", settings.AIProvider, "default_response", settings.DefaultResponse)
 
@@ -316,7 +373,7 @@ func (a *App) processIncomingMessageFull(phoneNumberID string, msg IncomingTextM
 				if settings.OutOfHoursMessage != "" {
 					a.sendAndSaveTextMessage(account, contact, settings.OutOfHoursMessage)
 				}
-				return
+				return nil
 			}
 			// AllowAutomatedOutsideHours is true, continue processing flows/keywords/AI
 			a.Log.Info("Outside business hours but automated responses allowed, continuing")
@@ -326,7 +383,7 @@ func (a *App) processIncomingMessageFull(phoneNumberID string, msg IncomingTextM
 	// Only process text and interactive messages for chatbot
 	if messageText == "" {
 		a.Log.Debug("Skipping message with no text content for chatbot", "type", msg.Type)
-		return
+		return nil
 	}
 
 	a.Log.Info("Processing message", "text", messageText, "buttonID", buttonID, "from", msg.From)
@@ -348,7 +405,7 @@ func (a *App) processIncomingMessageFull(phoneNumberID string, msg IncomingTextM
 				if settings.OutOfHoursMessage != "" {
 					a.sendAndSaveTextMessage(account, contact, settings.OutOfHoursMessage)
 				}
-				return
+				return nil
 			}
 		}
 		// Within business hours - send transfer message and create transfer
@@ -356,19 +413,19 @@ func (a *App) processIncomingMessageFull(phoneNumberID string, msg IncomingTextM
 			a.sendAndSaveTextMessage(account, contact, keywordResponse.Body)
 		}
 		a.createTransferFromKeyword(account, contact)
-		return
+		return nil
 	}
 
 	// Check if user is in an active flow
 	if session.CurrentFlowID != nil {
-		a.processFlowResponse(account, session, contact, messageText, buttonID)
-		return
+		a.processFlowResponse(account, session, contact, messageText, buttonID, submission)
+		return nil
 	}
 
 	// Try to match flow trigger keywords first (before greeting to avoid duplicate messages)
 	if flow := a.matchFlowTrigger(account.OrganizationID, account.Name, messageText); flow != nil {
 		a.startFlow(account, session, contact, flow)
-		return
+		return nil
 	}
 
 	// Send greeting message for new sessions (only if no flow was triggered)
@@ -390,7 +447,7 @@ func (a *App) processIncomingMessageFull(phoneNumberID string, msg IncomingTextM
 			a.sendAndSaveTextMessage(account, contact, settings.DefaultResponse)
 		}
 		a.logSessionMessage(session.ID, "outgoing", settings.DefaultResponse, "greeting")
-		return // After greeting, don't process further for new sessions
+		return nil // After greeting, don't process further for new sessions
 	}
 
 	// Handle non-transfer keyword matches (transfer was already handled above)
@@ -405,7 +462,7 @@ func (a *App) processIncomingMessageFull(phoneNumberID string, msg IncomingTextM
 		}
 		// Log outgoing message
 		a.logSessionMessage(session.ID, "outgoing", keywordResponse.Body, "keyword_response")
-		return
+		return nil
 	}
 
 	// If no keyword matched, try AI response if enabled
@@ -419,7 +476,7 @@ func (a *App) processIncomingMessageFull(phoneNumberID string, msg IncomingTextM
 			a.Log.Info("AI response generated successfully", "response_length", len(aiResponse))
 			a.sendAndSaveTextMessage(account, contact, aiResponse)
 			a.logSessionMessage(session.ID, "outgoing", aiResponse, "ai_response")
-			return
+			return nil
 		} else {
 			a.Log.Warn("AI returned empty response")
 		}
@@ -450,6 +507,7 @@ func (a *App) processIncomingMessageFull(phoneNumberID string, msg IncomingTextM
 	} else if !isNewSession {
 		a.Log.Info("No fallback message configured for existing session")
 	}
+	return nil
 }
 
 // KeywordResponse holds the response content and optional buttons
@@ -619,6 +677,174 @@ func (a *App) sendAndSaveTextMessage(account *models.WhatsAppAccount, contact *m
 	return err
 }
 
+// flowInitialTemplate returns the flow's initial template in the language best suited to
+// the contact, among its approved languages on the account. Returns nil if none can be sent,
+// e.g. when it takes parameters, in which case the flow's initial message is used instead.
+func (a *App) flowInitialTemplate(account *models.WhatsAppAccount, contact *models.Contact, flow *models.ChatbotFlow) *models.Template {
+	var template models.Template
+	if err := a.DB.Where("id = ? AND organization_id = ?", *flow.InitialTemplateID, account.OrganizationID).First(&template).Error; err != nil {
+		a.Log.Error("Flow initial template not found", "error", err, "flow_id", flow.ID)
+		return nil
+	}
+
+	// Templates belong to the account they were created on; the flow may run on another
+	// account that has a template with the same name
+	fits := func(t *models.Template) bool {
+		_, err := templates.Layout(t).ParamsFromValues(nil, nil)
+		return err == nil
+	}
+	var variants []models.Template
+	for _, v := range templates.Variants(a.DB, &template, account.Name) {
+		if fits(&v) {
+			variants = append(variants, v)
+		}
+	}
+	def := &template
+	if template.WhatsAppAccount != account.Name || !strings.EqualFold(template.Status, "APPROVED") || !fits(&template) {
+		if len(variants) == 0 {
+			a.Log.Warn("Flow initial template cannot be sent on this account", "flow_id", flow.ID, "template", template.Name, "account", account.Name)
+			return nil
+		}
+		def = &variants[0]
+	}
+	return templates.Localized(variants, def, contact.Locale, templates.LanguageFallback(a.DB, account.OrganizationID), nil)
+}
+
+// sendAndSaveTemplateMessage sends a template without parameters and saves it to the database
+func (a *App) sendAndSaveTemplateMessage(account *models.WhatsAppAccount, contact *models.Contact, template *models.Template) error {
+	waAccount := &whatsapp.Account{
+		PhoneID:     account.PhoneID,
+		BusinessID:  account.BusinessID,
+		APIVersion:  account.APIVersion,
+		AccessToken: account.AccessToken,
+	}
+	ctx := context.Background()
+	wamid, err := a.WhatsApp.SendTemplateMessageWithComponents(ctx, waAccount, contact.PhoneNumber, template.Name, template.Language, nil)
+
+	msg := models.Message{
+		OrganizationID:  account.OrganizationID,
+		WhatsAppAccount: account.Name,
+		ContactID:       contact.ID,
+		Direction:       "outgoing",
+		MessageType:     "template",
+		Content:         template.BodyContent,
+		TemplateName:    template.Name,
+		Status:          "sent",
+		Metadata: models.JSONB{
+			"template_id":       template.ID.String(),
+			"template_language": template.Language,
+			"template_version":  template.Version,
+		},
+	}
+	if err != nil {
+		msg.Status = "failed"
+		msg.ErrorMessage = err.Error()
+	} else if wamid != "" {
+		msg.WhatsAppMessageID = wamid
+	}
+
+	if dbErr := a.DB.Create(&msg).Error; dbErr != nil {
+		a.Log.Error("Failed to save chatbot message", "error", dbErr)
+	}
+
+	// Track chatbot message for client inactivity SLA
+	if err == nil {
+		a.UpdateContactChatbotMessage(contact.ID)
+	}
+
+	// Broadcast via WebSocket
+	if a.WSHub != nil {
+		var assignedUserIDStr string
+		if contact.AssignedUserID != nil {
+			assignedUserIDStr = contact.AssignedUserID.String()
+		}
+		a.WSHub.BroadcastToOrg(account.OrganizationID, websocket.WSMessage{
+			Type: websocket.TypeNewMessage,
+			Payload: map[string]any{
+				"id":               msg.ID,
+				"contact_id":       contact.ID.String(),
+				"assigned_user_id": assignedUserIDStr,
+				"profile_name":     contact.ProfileName,
+				"direction":        msg.Direction,
+				"message_type":     msg.MessageType,
+				"content":          map[string]string{"body": msg.Content},
+				"status":           msg.Status,
+				"wamid":            msg.WhatsAppMessageID,
+				"created_at":       msg.CreatedAt,
+				"updated_at":       msg.UpdatedAt,
+			},
+		})
+	}
+
+	return err
+}
+
+// sendAndSaveMessage sends a typed message (location, list, ...) and saves it to the database
+func (a *App) sendAndSaveMessage(account *models.WhatsAppAccount, contact *models.Contact, outgoing whatsapp.OutgoingMessage) error {
+	waAccount := &whatsapp.Account{
+		PhoneID:     account.PhoneID,
+		BusinessID:  account.BusinessID,
+		APIVersion:  account.APIVersion,
+		AccessToken: account.AccessToken,
+	}
+	ctx := context.Background()
+	wamid, err := a.WhatsApp.Send(ctx, waAccount, contact.PhoneNumber, outgoing, "")
+
+	messageType, content, interactiveData := outgoingMessageRecord(outgoing)
+	msg := models.Message{
+		OrganizationID:  account.OrganizationID,
+		WhatsAppAccount: account.Name,
+		ContactID:       contact.ID,
+		Direction:       "outgoing",
+		MessageType:     messageType,
+		Content:         content,
+		InteractiveData: interactiveData,
+		Status:          "sent",
+	}
+	if err != nil {
+		msg.Status = "failed"
+		msg.ErrorMessage = err.Error()
+	} else if wamid != "" {
+		msg.WhatsAppMessageID = wamid
+	}
+
+	if dbErr := a.DB.Create(&msg).Error; dbErr != nil {
+		a.Log.Error("Failed to save chatbot message", "error", dbErr)
+	}
+
+	// Track chatbot message for client inactivity SLA
+	if err == nil {
+		a.UpdateContactChatbotMessage(contact.ID)
+	}
+
+	// Broadcast via WebSocket
+	if a.WSHub != nil {
+		var assignedUserIDStr string
+		if contact.AssignedUserID != nil {
+			assignedUserIDStr = contact.AssignedUserID.String()
+		}
+		a.WSHub.BroadcastToOrg(account.OrganizationID, websocket.WSMessage{
+			Type: websocket.TypeNewMessage,
+			Payload: map[string]any{
+				"id":               msg.ID,
+				"contact_id":       contact.ID.String(),
+				"assigned_user_id": assignedUserIDStr,
+				"profile_name":     contact.ProfileName,
+				"direction":        msg.Direction,
+				"message_type":     msg.MessageType,
+				"content":          map[string]string{"body": msg.Content},
+				"interactive_data": msg.InteractiveData,
+				"status":           msg.Status,
+				"wamid":            msg.WhatsAppMessageID,
+				"created_at":       msg.CreatedAt,
+				"updated_at":       msg.UpdatedAt,
+			},
+		})
+	}
+
+	return err
+}
+
 // sendAndSaveInteractiveButtons sends an interactive button message and saves it to the database
 func (a *App) sendAndSaveInteractiveButtons(account *models.WhatsAppAccount, contact *models.Contact, bodyText string, buttons []map[string]interface{}) error {
 	wamid, err := a.sendInteractiveButtons(account, contact.PhoneNumber, bodyText, buttons)
@@ -740,16 +966,36 @@ func (a *App) sendInteractiveButtons(account *models.WhatsAppAccount, to, bodyTe
 }
 
 // getOrCreateContact finds or creates a contact for the phone number
-// Returns the contact and a boolean indicating if the contact was newly created
-func (a *App) getOrCreateContact(orgID uuid.UUID, phoneNumber, profileName string) (*models.Contact, bool) {
+// Returns the contact and a boolean indicating if the contact was newly created (or restored after deletion)
+func (a *App) getOrCreateContact(orgID uuid.UUID, phoneNumber, profileName string) (*models.Contact, bool, error) {
+	// wa_id from Meta is already canonical; normalize defensively but keep it if it doesn't parse
+	if normalized, err := whatsapp.NormalizePhoneNumber(phoneNumber); err == nil {
+		phoneNumber = normalized
+	}
+
 	var contact models.Contact
-	result := a.DB.Where("organization_id = ? AND phone_number = ?", orgID, phoneNumber).First(&contact)
+	result := a.DB.Unscoped().Where("organization_id = ? AND phone_number = ?", orgID, phoneNumber).First(&contact)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, false, fmt.Errorf("failed to find contact: %w", result.Error)
+	}
 	if result.Error == nil {
+		updates := map[string]interface{}{}
 		// Update profile name if changed
 		if profileName != "" && contact.ProfileName != profileName {
-			a.DB.Model(&contact).Update("profile_name", profileName)
+			updates["profile_name"] = profileName
+			contact.ProfileName = profileName
+		}
+		restored := contact.DeletedAt.Valid
+		if restored {
+			updates["deleted_at"] = nil
+			contact.DeletedAt = gorm.DeletedAt{}
 		}
-		return &contact, false
+		if len(updates) > 0 {
+			if err := a.DB.Unscoped().Model(&contact).Updates(updates).Error; err != nil {
+				return nil, false, fmt.Errorf("failed to update contact: %w", err)
+			}
+		}
+		return &contact, restored, nil
 	}
 
 	// Create new contact
@@ -760,12 +1006,14 @@ func (a *App) getOrCreateContact(orgID uuid.UUID, phoneNumber, profileName strin
 		ProfileName:    profileName,
 	}
 	if err := a.DB.Create(&contact).Error; err != nil {
-		a.Log.Error("Failed to create contact", "error", err)
 		// Try to fetch again in case of race condition
-		a.DB.Where("organization_id = ? AND phone_number = ?", orgID, phoneNumber).First(&contact)
-		return &contact, false
+		var existing models.Contact
+		if a.DB.Where("organization_id = ? AND phone_number = ?", orgID, phoneNumber).First(&existing).Error != nil {
+			return nil, false, fmt.Errorf("failed to create contact: %w", err)
+		}
+		return &existing, false, nil
 	}
-	return &contact, true
+	return &contact, true, nil
 }
 
 // getOrCreateSession finds an active session or creates a new one
@@ -847,37 +1095,28 @@ func (a *App) startFlow(account *models.WhatsAppAccount, session *models.Chatbot
 		a.Log.Info("Flow step", "index", i, "step_name", step.StepName, "step_order", step.StepOrder, "message_type", step.MessageType)
 	}
 
+	// New runs start on the published version, or on the rollout version for its share
+	flow = a.rolloutFlow(session.OrganizationID, flow)
+	a.startFlowRun(session, flow)
+
 	// Update session with flow info
 	session.CurrentFlowID = &flow.ID
+	session.FlowVersion = flow.Version
 	session.CurrentStep = ""
 	session.StepRetries = 0
 	session.SessionData = models.JSONB{}
+	session.CallStack = models.JSONBArray{}
+	session.LoopCounts = models.JSONB{}
 	a.DB.Save(session)
 
-	// Send initial message if configured
-	if flow.InitialMessage != "" {
-		a.sendAndSaveTextMessage(account, contact, flow.InitialMessage)
-		a.logSessionMessage(session.ID, "outgoing", flow.InitialMessage, "flow_start")
-	}
-
-	// Send first step message (with skip check)
-	if len(flow.Steps) > 0 {
-		firstStep := &flow.Steps[0]
-		a.Log.Info("Sending first step", "step_name", firstStep.StepName, "message_type", firstStep.MessageType, "message", firstStep.Message)
-		session.CurrentStep = firstStep.StepName
-		a.DB.Model(session).Update("current_step", firstStep.StepName)
-
-		a.sendStepWithSkipCheck(account, session, contact, firstStep, flow, nil)
-	} else {
-		// No steps, complete the flow
-		a.completeFlow(account, session, contact, flow)
-	}
+	a.enterFlow(account, session, contact, flow, nil)
 }
 
-// processFlowResponse handles user response within a flow
-func (a *App) processFlowResponse(account *models.WhatsAppAccount, session *models.ChatbotSession, contact *models.Contact, userInput string, buttonID string) {
-	// Load the current flow from cache
-	flow, err := a.getChatbotFlowByIDCached(account.OrganizationID, *session.CurrentFlowID)
+// processFlowResponse handles user response within a flow. submission is set when the
+// user replied with a WhatsApp Flow response.
+func (a *App) processFlowResponse(account *models.WhatsAppAccount, session *models.ChatbotSession, contact *models.Contact, userInput string, buttonID string, submission *flowSubmission) {
+	// Load the version of the current flow the session runs, from cache
+	flow, err := a.getChatbotFlowVersionCached(account.OrganizationID, *session.CurrentFlowID, session.FlowVersion)
 	if err != nil {
 		a.Log.Error("Failed to load flow", "error", err)
 		a.exitFlow(session)
@@ -912,8 +1151,51 @@ func (a *App) processFlowResponse(account *models.WhatsAppAccount, session *mode
 		return
 	}
 
+	// Steps that open a WhatsApp Flow only accept that flow's response, once it matches
+	// the flow's screens. Its fields are stored and can be branched on.
+	isFlowStep := expectsFlowResponse(currentStep)
+	if isFlowStep {
+		if submission == nil || submission.Session == nil || submission.Session.ID != session.ID ||
+			submission.Step == nil || submission.Step.StepName != currentStep.StepName || submission.Err != nil {
+			session.StepRetries++
+			a.DB.Model(session).Update("step_retries", session.StepRetries)
+
+			maxRetries := currentStep.MaxRetries
+			if maxRetries == 0 {
+				maxRetries = 3 // Default max retries
+			}
+			if session.StepRetries >= maxRetries {
+				a.Log.Warn("Max flow retries exceeded, closing conversation", "step", currentStep.StepName)
+				a.sendAndSaveTextMessage(account, contact, "Sorry, we couldn't continue. Please try again later.")
+				a.exitFlow(session)
+				a.closeSession(session)
+				return
+			}
+
+			if submission != nil && submission.Err != nil {
+				a.Log.Info("Invalid flow response", "error", submission.Err, "step", currentStep.StepName)
+				errorMsg := currentStep.ValidationError
+				if errorMsg == "" {
+					errorMsg = "Some answers were missing or invalid. Please fill in the form again."
+				}
+				a.sendAndSaveTextMessage(account, contact, errorMsg)
+				a.logSessionMessage(session.ID, "outgoing", errorMsg, currentStep.StepName+"_retry")
+			}
+
+			// Resend the flow
+			a.sendStepMessage(account, session, contact, currentStep)
+			return
+		}
+
+		a.storeFlowResponse(session, currentStep, submission.Response)
+		if field, ok := currentStep.InputConfig["branch_on"].(string); ok && field != "" {
+			userInput, _ = sessionValueString(submission.Response[field])
+		}
+		buttonID = ""
+	}
+
 	// Validate input if required (skip validation for button/list responses)
-	if currentStep.ValidationRegex != "" && buttonID == "" {
+	if currentStep.ValidationRegex != "" && buttonID == "" && !isFlowStep {
 		re, err := regexp.Compile(currentStep.ValidationRegex)
 		if err == nil && !re.MatchString(userInput) {
 			// Invalid input
@@ -996,7 +1278,7 @@ func (a *App) processFlowResponse(account *models.WhatsAppAccount, session *mode
 	}
 
 	// Store the user's response (use buttonID if available, otherwise userInput)
-	if currentStep.StoreAs != "" {
+	if currentStep.StoreAs != "" && !isFlowStep {
 		sessionData := session.SessionData
 		if sessionData == nil {
 			sessionData = models.JSONB{}
@@ -1083,16 +1365,31 @@ func (a *App) completeFlow(account *models.WhatsAppAccount, session *models.Chat
 
 	// Execute on-complete action
 	if flow.OnCompleteAction == "webhook" && len(flow.CompletionConfig) > 0 {
-		go a.sendFlowCompletionWebhook(flow, session, contact)
+		if a.simulation != nil {
+			// Recorded before the simulation's turn ends
+			a.sendFlowCompletionWebhook(flow, session, contact)
+		} else {
+			go a.sendFlowCompletionWebhook(flow, session, contact)
+		}
+	}
+
+	// A called sub-flow hands the conversation back to its caller
+	if a.returnFromSubFlow(account, session, contact) {
+		return
 	}
 
 	// Update session
+	a.endFlowRun(session, FlowRunCompleted)
 	now := time.Now()
 	a.DB.Model(session).Updates(map[string]interface{}{
 		"current_flow_id": nil,
 		"current_step":    "",
 		"status":          "completed",
 		"completed_at":    now,
+		"call_stack":      models.JSONBArray{},
+		"loop_counts":     models.JSONB{},
+		"flow_version":    0,
+		"flow_run_id":     nil,
 	})
 
 	// Clear chatbot tracking so SLA doesn't fire after flow completion
@@ -1168,7 +1465,7 @@ func (a *App) sendFlowCompletionWebhook(flow *models.ChatbotFlow, session *model
 	}
 
 	// Make the request
-	client := &http.Client{Timeout: 30 * time.Second}
+	client := a.outboundHTTPClient(30 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
 		a.Log.Error("Webhook request failed", "error", err, "url", webhookURL)
@@ -1196,10 +1493,15 @@ func (a *App) sendFlowCompletionWebhook(flow *models.ChatbotFlow, session *model
 
 // exitFlow clears flow state from session without completion
 func (a *App) exitFlow(session *models.ChatbotSession) {
+	a.endFlowRun(session, FlowRunCancelled)
 	a.DB.Model(session).Updates(map[string]interface{}{
 		"current_flow_id": nil,
 		"current_step":    "",
 		"step_retries":    0,
+		"call_stack":      models.JSONBArray{},
+		"loop_counts":     models.JSONB{},
+		"flow_version":    0,
+		"flow_run_id":     nil,
 	})
 }
 
@@ -1222,7 +1524,7 @@ func (a *App) replaceVariables(message string, data models.JSONB) string {
 	result := message
 	for key, value := range data {
 		placeholder := "{{" + key + "}}"
-		if strVal, ok := value.(string); ok {
+		if strVal, ok := sessionValueString(value); ok {
 			result = strings.ReplaceAll(result, placeholder, strVal)
 		}
 	}
@@ -1230,14 +1532,17 @@ func (a *App) replaceVariables(message string, data models.JSONB) string {
 }
 
 // sendStepWithSkipCheck checks if a step should be skipped and sends the appropriate step message
-// It takes the full flow to find next steps when skipping
-func (a *App) sendStepWithSkipCheck(account *models.WhatsAppAccount, session *models.ChatbotSession, contact *models.Contact, step *models.ChatbotFlowStep, flow *models.ChatbotFlow, skippedSteps map[string]bool) {
+// It takes the full flow to find next steps when skipping. visits counts the steps run since
+// the last user input, to stop flows that loop without waiting for the user.
+func (a *App) sendStepWithSkipCheck(account *models.WhatsAppAccount, session *models.ChatbotSession, contact *models.Contact, step *models.ChatbotFlowStep, flow *models.ChatbotFlow, visits map[string]int) {
 	// Prevent infinite loops
-	if skippedSteps == nil {
-		skippedSteps = make(map[string]bool)
+	if visits == nil {
+		visits = make(map[string]int)
 	}
-	if skippedSteps[step.StepName] {
-		a.Log.Warn("Skip loop detected, completing flow", "step", step.StepName)
+	visitKey := flow.ID.String() + ":" + step.StepName
+	visits[visitKey]++
+	if visits[visitKey] > maxStepVisits {
+		a.Log.Warn("Step loop detected, completing flow", "step", step.StepName)
 		a.completeFlow(account, session, contact, flow)
 		return
 	}
@@ -1250,47 +1555,13 @@ func (a *App) sendStepWithSkipCheck(account *models.WhatsAppAccount, session *mo
 
 	if a.shouldSkipStep(step, sessionData) {
 		a.Log.Info("Skipping step", "step", step.StepName, "condition", step.SkipCondition)
-		skippedSteps[step.StepName] = true
-
-		// Find next step
-		nextStepName := step.NextStep
-		if nextStepName == "" {
-			// Find by step order
-			for i, s := range flow.Steps {
-				if s.StepName == step.StepName && i+1 < len(flow.Steps) {
-					nextStepName = flow.Steps[i+1].StepName
-					break
-				}
-			}
-		}
-
-		if nextStepName == "" {
-			// No next step, complete flow
-			a.completeFlow(account, session, contact, flow)
-			return
-		}
-
-		// Find and execute next step
-		var nextStep *models.ChatbotFlowStep
-		for i := range flow.Steps {
-			if flow.Steps[i].StepName == nextStepName {
-				nextStep = &flow.Steps[i]
-				break
-			}
-		}
-
-		if nextStep == nil {
-			a.Log.Warn("Next step not found after skip, completing flow", "next_step", nextStepName)
-			a.completeFlow(account, session, contact, flow)
-			return
-		}
-
-		// Update session to next step
-		session.CurrentStep = nextStep.StepName
-		a.DB.Model(session).Update("current_step", nextStep.StepName)
+		a.advanceToStep(account, session, contact, flow, a.nextStepInOrder(flow, step), visits)
+		return
+	}
 
-		// Recursively check next step (it may also need to be skipped)
-		a.sendStepWithSkipCheck(account, session, contact, nextStep, flow, skippedSteps)
+	// Condition, goto and flow call steps route without sending anything
+	if isControlStep(step) {
+		a.runControlStep(account, session, contact, step, flow, visits)
 		return
 	}
 
@@ -1298,48 +1569,20 @@ func (a *App) sendStepWithSkipCheck(account *models.WhatsAppAccount, session *mo
 	a.sendStepMessage(account, session, contact, step)
 
 	// If input type is "none", automatically advance to next step without waiting for user input
-	if step.InputType == "none" {
-
-		// Find next step
-		nextStepName := step.NextStep
-		if nextStepName == "" {
-			// Find by step order
-			for i, s := range flow.Steps {
-				if s.StepName == step.StepName && i+1 < len(flow.Steps) {
-					nextStepName = flow.Steps[i+1].StepName
-					break
-				}
-			}
-		}
-
-		if nextStepName == "" {
-			// No next step, complete flow
-			a.completeFlow(account, session, contact, flow)
-			return
-		}
-
-		// Find and execute next step
-		var nextStep *models.ChatbotFlowStep
-		for i := range flow.Steps {
-			if flow.Steps[i].StepName == nextStepName {
-				nextStep = &flow.Steps[i]
-				break
-			}
-		}
+	// (steps that open a WhatsApp Flow always wait for its response)
+	if step.InputType == "none" && !expectsFlowResponse(step) && step.MessageType != "transfer" {
+		a.advanceToStep(account, session, contact, flow, a.nextStepInOrder(flow, step), visits)
+	}
+}
 
-		if nextStep == nil {
-			a.Log.Warn("Next step not found after no-input step, completing flow", "next_step", nextStepName)
-			a.completeFlow(account, session, contact, flow)
-			return
+// nextStepInOrder returns the step that follows step: its next_step, or the next step in order
+func (a *App) nextStepInOrder(flow *models.ChatbotFlow, step *models.ChatbotFlowStep) string {
+	for i := range flow.Steps {
+		if flow.Steps[i].StepName == step.StepName {
+			return defaultNextStep(flow.Steps, i)
 		}
-
-		// Update session to next step
-		session.CurrentStep = nextStep.StepName
-		a.DB.Model(session).Update("current_step", nextStep.StepName)
-
-		// Recursively process next step (it may also need to skip or have no input)
-		a.sendStepWithSkipCheck(account, session, contact, nextStep, flow, skippedSteps)
 	}
+	return step.NextStep
 }
 
 // sendStepMessage sends the appropriate message based on step message_type
@@ -1390,6 +1633,17 @@ func (a *App) sendStepMessage(account *models.WhatsAppAccount, session *models.C
 		}
 		a.logSessionMessage(session.ID, "outgoing", message, step.StepName)
 
+	case "whatsapp_flow":
+		// Open the step's WhatsApp Flow; its response comes back with the step's flow token
+		message = a.replaceVariables(step.Message, session.SessionData)
+		if err := a.sendFlowStepMessage(account, session, contact, step, message); err != nil {
+			a.Log.Error("Failed to send WhatsApp Flow, sending its text instead", "error", err, "step", step.StepName)
+			if message != "" {
+				a.sendAndSaveTextMessage(account, contact, message)
+			}
+		}
+		a.logSessionMessage(session.ID, "outgoing", message, step.StepName)
+
 	case "transfer":
 		// Transfer to team/agent queue
 		message = a.replaceVariables(step.Message, session.SessionData)
@@ -1424,6 +1678,27 @@ func (a *App) sendStepMessage(account *models.WhatsAppAccount, session *models.C
 		a.exitFlow(session)
 		return
 
+	case "location", "contacts", "sticker", "list", "cta_url", "location_request", "product", "product_list":
+		// Typed message built from the step's message config, with variables replaced in
+		// the body and in every text of the config
+		message = a.replaceVariables(step.Message, session.SessionData)
+		replace := func(s string) string { return a.replaceVariables(s, session.SessionData) }
+		config, _ := replaceStringValues(map[string]interface{}(step.MessageConfig), replace).(map[string]interface{})
+		spec, err := stepMessageSpec(config)
+		var outgoing whatsapp.OutgoingMessage
+		if err == nil {
+			outgoing, err = spec.build(step.MessageType, message)
+		}
+		if err != nil {
+			a.Log.Error("Invalid step message, sending its text instead", "error", err, "step", step.StepName)
+			if message != "" {
+				a.sendAndSaveTextMessage(account, contact, message)
+			}
+		} else {
+			a.sendAndSaveMessage(account, contact, outgoing)
+		}
+		a.logSessionMessage(session.ID, "outgoing", message, step.StepName)
+
 	default:
 		// Default: use the step message with variable replacement
 		message = a.replaceVariables(step.Message, session.SessionData)
@@ -1487,7 +1762,7 @@ func (a *App) fetchApiResponse(apiConfig models.JSONB, sessionData models.JSONB)
 	}
 
 	// Make the request
-	client := &http.Client{Timeout: 30 * time.Second}
+	client := a.outboundHTTPClient(30 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("API request failed: %w", err)
@@ -1719,7 +1994,7 @@ func (a *App) fetchAPIContext(apiConfig models.JSONB, session *models.ChatbotSes
 	}
 
 	// Make the request
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := a.outboundHTTPClient(10 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("API request failed: %w", err)
@@ -1816,7 +2091,7 @@ func (a *App) generateOpenAIResponse(settings *models.ChatbotSettings, session *
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+settings.AIAPIKey)
 
-	client := &http.Client{Timeout: 60 * time.Second}
+	client := a.outboundHTTPClient(60 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("request failed: %w", err)
@@ -1920,7 +2195,7 @@ func (a *App) generateAnthropicResponse(settings *models.ChatbotSettings, sessio
 	req.Header.Set("x-api-key", settings.AIAPIKey)
 	req.Header.Set("anthropic-version", "2023-06-01")
 
-	client := &http.Client{Timeout: 60 * time.Second}
+	client := a.outboundHTTPClient(60 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("request failed: %w", err)
@@ -2033,7 +2308,7 @@ func (a *App) generateGoogleResponse(settings *models.ChatbotSettings, session *
 
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 60 * time.Second}
+	client := a.outboundHTTPClient(60 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("request failed: %w", err)
@@ -2095,8 +2370,9 @@ type Reaction struct {
 	FromUser  string `json:"from_user,omitempty"`  // User ID if from agent
 }
 
-// handleIncomingReaction handles incoming reaction messages from WhatsApp
-func (a *App) handleIncomingReaction(account *models.WhatsAppAccount, fromPhone, messageWAMID, emoji, profileName string) {
+// handleIncomingReaction handles incoming reaction messages from WhatsApp. Reactions to
+// unknown messages are ignored; an error is returned when the reaction could not be saved.
+func (a *App) handleIncomingReaction(account *models.WhatsAppAccount, fromPhone, messageWAMID, emoji, profileName string) error {
 	a.Log.Info("Handling incoming reaction",
 		"from", fromPhone,
 		"message_wamid", messageWAMID,
@@ -2117,20 +2393,23 @@ func (a *App) handleIncomingReaction(account *models.WhatsAppAccount, fromPhone,
 				suffix := messageWAMID[suffixStart:]
 				if err := a.DB.Where("whats_app_message_id LIKE ?", "%"+suffix).First(&message).Error; err != nil {
 					a.Log.Warn("Message not found for reaction", "wamid", messageWAMID, "suffix", suffix)
-					return
+					return nil
 				}
 			} else {
 				a.Log.Warn("Message not found for reaction - invalid WAMID format", "wamid", messageWAMID)
-				return
+				return nil
 			}
 		} else {
 			a.Log.Warn("Message not found for reaction - no FQIA pattern", "wamid", messageWAMID)
-			return
+			return nil
 		}
 	}
 
 	// Get or create contact
-	contact, _ := a.getOrCreateContact(account.OrganizationID, fromPhone, profileName)
+	contact, _, err := a.getOrCreateContact(account.OrganizationID, fromPhone, profileName)
+	if err != nil {
+		return err
+	}
 
 	// Parse existing reactions from Metadata
 	var metadata map[string]interface{}
@@ -2178,8 +2457,7 @@ func (a *App) handleIncomingReaction(account *models.WhatsAppAccount, fromPhone,
 
 	// Save to database
 	if err := a.DB.Model(&message).Update("metadata", metadata).Error; err != nil {
-		a.Log.Error("Failed to update message reactions", "error", err)
-		return
+		return fmt.Errorf("failed to update message reactions: %w", err)
 	}
 
 	a.Log.Info("Updated message reaction", "message_id", message.ID, "reactions_count", len(newReactions))
@@ -2195,6 +2473,7 @@ func (a *App) handleIncomingReaction(account *models.WhatsAppAccount, fromPhone,
 			},
 		})
 	}
+	return nil
 }
 
 // Helper function to safely get string from map
@@ -2215,7 +2494,7 @@ type MediaInfo struct {
 }
 
 // saveIncomingMessage saves an incoming message to the messages table
-func (a *App) saveIncomingMessage(account *models.WhatsAppAccount, contact *models.Contact, whatsappMsgID, msgType, content string, mediaInfo *MediaInfo, replyToWAMID string) {
+func (a *App) saveIncomingMessage(account *models.WhatsAppAccount, contact *models.Contact, whatsappMsgID, msgType, content string, mediaInfo *MediaInfo, replyToWAMID string, flowResponse models.JSONB) *models.Message {
 	now := time.Now()
 
 	message := models.Message{
@@ -2227,6 +2506,7 @@ func (a *App) saveIncomingMessage(account *models.WhatsAppAccount, contact *mode
 		Direction:         "incoming",
 		MessageType:       msgType,
 		Content:           content,
+		FlowResponse:      flowResponse,
 		Status:            "received",
 	}
 
@@ -2250,7 +2530,7 @@ func (a *App) saveIncomingMessage(account *models.WhatsAppAccount, contact *mode
 
 	if err := a.DB.Create(&message).Error; err != nil {
 		a.Log.Error("Failed to save incoming message", "error", err)
-		return
+		return nil
 	}
 
 	// Update contact's last message info
@@ -2325,6 +2605,8 @@ func (a *App) saveIncomingMessage(account *models.WhatsAppAccount, contact *mode
 		WhatsAppAccount: account.Name,
 		Direction:       "incoming",
 	})
+
+	return &message
 }
 
 // isWithinBusinessHours checks if current time is within configured business hours
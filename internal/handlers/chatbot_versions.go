@@ -0,0 +1,665 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+	"gorm.io/gorm"
+)
+
+// Statuses of flow runs
+const (
+	FlowRunActive    = "active"
+	FlowRunCompleted = "completed"
+	FlowRunCancelled = "cancelled"
+)
+
+// chatbotFlowVersionFields are the flow fields compared between versions to build a
+// version's changes; steps are compared separately
+var chatbotFlowVersionFields = []struct {
+	name  string
+	value func(v *models.ChatbotFlowVersion) interface{}
+}{
+	{"name", func(v *models.ChatbotFlowVersion) interface{} { return v.Name }},
+	{"trigger_keywords", func(v *models.ChatbotFlowVersion) interface{} { return v.TriggerKeywords }},
+	{"trigger_button_id", func(v *models.ChatbotFlowVersion) interface{} { return v.TriggerButtonID }},
+	{"initial_message", func(v *models.ChatbotFlowVersion) interface{} { return v.InitialMessage }},
+	{"initial_message_type", func(v *models.ChatbotFlowVersion) interface{} { return v.InitialMessageType }},
+	{"initial_template_id", func(v *models.ChatbotFlowVersion) interface{} { return v.InitialTemplateID }},
+	{"completion_message", func(v *models.ChatbotFlowVersion) interface{} { return v.CompletionMessage }},
+	{"on_complete_action", func(v *models.ChatbotFlowVersion) interface{} { return v.OnCompleteAction }},
+	{"completion_config", func(v *models.ChatbotFlowVersion) interface{} { return v.CompletionConfig }},
+	{"timeout_message", func(v *models.ChatbotFlowVersion) interface{} { return v.TimeoutMessage }},
+	{"cancel_keywords", func(v *models.ChatbotFlowVersion) interface{} { return v.CancelKeywords }},
+}
+
+// versionStepOmittedFields are the step fields not stored in versions
+var versionStepOmittedFields = []string{"id", "flow_id", "created_at", "updated_at", "deleted_at", "flow", "template"}
+
+// chatbotFlowVersionSnapshot returns the versioned fields of a flow and the given steps
+func chatbotFlowVersionSnapshot(flow *models.ChatbotFlow, steps []models.ChatbotFlowStep) models.ChatbotFlowVersion {
+	version := models.ChatbotFlowVersion{
+		OrganizationID:     flow.OrganizationID,
+		FlowID:             flow.ID,
+		Name:               flow.Name,
+		TriggerKeywords:    flow.TriggerKeywords,
+		TriggerButtonID:    flow.TriggerButtonID,
+		InitialMessage:     flow.InitialMessage,
+		InitialMessageType: flow.InitialMessageType,
+		InitialTemplateID:  flow.InitialTemplateID,
+		CompletionMessage:  flow.CompletionMessage,
+		OnCompleteAction:   flow.OnCompleteAction,
+		CompletionConfig:   flow.CompletionConfig,
+		TimeoutMessage:     flow.TimeoutMessage,
+		CancelKeywords:     flow.CancelKeywords,
+		Steps:              models.JSONBArray{},
+		Changes:            models.JSONB{},
+	}
+	for i := range steps {
+		version.Steps = append(version.Steps, versionStep(&steps[i]))
+	}
+	return version
+}
+
+// versionStep returns a step as stored in a version, without its IDs and timestamps
+func versionStep(step *models.ChatbotFlowStep) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if data, err := json.Marshal(step); err == nil {
+		_ = json.Unmarshal(data, &fields)
+	}
+	for _, name := range versionStepOmittedFields {
+		delete(fields, name)
+	}
+	return fields
+}
+
+// chatbotFlowFromVersion returns flow as published in a version. The enabled state,
+// versioning settings and description stay the flow's own.
+func chatbotFlowFromVersion(flow *models.ChatbotFlow, v *models.ChatbotFlowVersion) models.ChatbotFlow {
+	versioned := *flow
+	versioned.Name = v.Name
+	versioned.TriggerKeywords = v.TriggerKeywords
+	versioned.TriggerButtonID = v.TriggerButtonID
+	versioned.InitialMessage = v.InitialMessage
+	versioned.InitialMessageType = v.InitialMessageType
+	versioned.InitialTemplateID = v.InitialTemplateID
+	versioned.CompletionMessage = v.CompletionMessage
+	versioned.OnCompleteAction = v.OnCompleteAction
+	versioned.CompletionConfig = v.CompletionConfig
+	versioned.TimeoutMessage = v.TimeoutMessage
+	versioned.CancelKeywords = v.CancelKeywords
+	versioned.Version = v.Version
+
+	versioned.Steps = nil
+	if data, err := json.Marshal(v.Steps); err == nil {
+		_ = json.Unmarshal(data, &versioned.Steps)
+	}
+	for i := range versioned.Steps {
+		versioned.Steps[i].FlowID = flow.ID
+	}
+	return versioned
+}
+
+// chatbotFlowVersionChanges returns field -> {from, to} for the flow fields that differ
+// between versions, and under "steps" the steps added, removed and changed (step name ->
+// field -> {from, to})
+func chatbotFlowVersionChanges(from, to *models.ChatbotFlowVersion) models.JSONB {
+	changes := models.JSONB{}
+	for _, f := range chatbotFlowVersionFields {
+		if change := valueChange(f.value(from), f.value(to)); change != nil {
+			changes[f.name] = change
+		}
+	}
+
+	fromSteps := map[string]map[string]interface{}{}
+	for _, s := range from.Steps {
+		if step, ok := s.(map[string]interface{}); ok {
+			name, _ := step["step_name"].(string)
+			fromSteps[name] = step
+		}
+	}
+	added := []string{}
+	removed := []string{}
+	changed := map[string]interface{}{}
+	seen := map[string]bool{}
+	for _, s := range to.Steps {
+		step, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := step["step_name"].(string)
+		seen[name] = true
+		previous, ok := fromSteps[name]
+		if !ok {
+			added = append(added, name)
+			continue
+		}
+		if fields := stepChanges(previous, step); len(fields) > 0 {
+			changed[name] = fields
+		}
+	}
+	for _, s := range from.Steps {
+		if step, ok := s.(map[string]interface{}); ok {
+			if name, _ := step["step_name"].(string); !seen[name] {
+				removed = append(removed, name)
+			}
+		}
+	}
+	if len(added) > 0 || len(removed) > 0 || len(changed) > 0 {
+		changes["steps"] = map[string]interface{}{
+			"added":   added,
+			"removed": removed,
+			"changed": changed,
+		}
+	}
+	return changes
+}
+
+// stepChanges returns field -> {from, to} for the fields that differ between two versions of a step
+func stepChanges(from, to map[string]interface{}) map[string]interface{} {
+	names := make([]string, 0, len(from)+len(to))
+	for name := range from {
+		names = append(names, name)
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := map[string]interface{}{}
+	for _, name := range names {
+		if change := valueChange(from[name], to[name]); change != nil {
+			changes[name] = change
+		}
+	}
+	return changes
+}
+
+// valueChange returns {from, to} if the values differ once encoded as JSON, nil otherwise
+func valueChange(from, to interface{}) map[string]interface{} {
+	fromJSON, _ := json.Marshal(from)
+	toJSON, _ := json.Marshal(to)
+	if string(fromJSON) == string(toJSON) {
+		return nil
+	}
+	return map[string]interface{}{"from": from, "to": to}
+}
+
+// recordChatbotFlowVersion records flow with the given steps as its next version
+func recordChatbotFlowVersion(tx *gorm.DB, flow *models.ChatbotFlow, steps []models.ChatbotFlowStep, notes string, userID *uuid.UUID) (*models.ChatbotFlowVersion, error) {
+	var latest models.ChatbotFlowVersion
+	err := tx.Where("flow_id = ?", flow.ID).Order("version DESC").First(&latest).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	version := chatbotFlowVersionSnapshot(flow, steps)
+	version.Version = latest.Version + 1
+	version.Notes = notes
+	version.PublishedBy = userID
+	if latest.Version > 0 {
+		version.Changes = chatbotFlowVersionChanges(&latest, &version)
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// flowDraftChanges returns the changes of a flow's draft (its own fields and the given
+// steps) against its latest version; nil for flows never published
+func flowDraftChanges(db *gorm.DB, flow *models.ChatbotFlow, steps []models.ChatbotFlowStep) (models.JSONB, error) {
+	var latest models.ChatbotFlowVersion
+	err := db.Where("flow_id = ?", flow.ID).Order("version DESC").First(&latest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	draft := chatbotFlowVersionSnapshot(flow, steps)
+	return chatbotFlowVersionChanges(&latest, &draft), nil
+}
+
+// updateFlowHasDraft sets whether a flow's draft has changes not recorded in a version
+func updateFlowHasDraft(tx *gorm.DB, flow *models.ChatbotFlow, steps []models.ChatbotFlowStep) error {
+	changes, err := flowDraftChanges(tx, flow, steps)
+	if err != nil {
+		return err
+	}
+	flow.HasDraft = len(changes) > 0
+	return tx.Model(&models.ChatbotFlow{}).Where("id = ?", flow.ID).UpdateColumn("has_draft", flow.HasDraft).Error
+}
+
+// rolloutFlow picks the version of flow a new run starts on: its rollout version for
+// RolloutPercent of runs, the published version otherwise
+func (a *App) rolloutFlow(orgID uuid.UUID, flow *models.ChatbotFlow) *models.ChatbotFlow {
+	if flow.RolloutVersion == 0 || flow.RolloutPercent <= 0 || rand.Intn(100) >= flow.RolloutPercent {
+		return flow
+	}
+	candidate, err := a.getChatbotFlowVersionCached(orgID, flow.ID, flow.RolloutVersion)
+	if err != nil {
+		a.Log.Error("Failed to load rollout version, using published version", "error", err, "flow_id", flow.ID, "version", flow.RolloutVersion)
+		return flow
+	}
+	return candidate
+}
+
+// startFlowRun records the start of a session's run of a flow version
+func (a *App) startFlowRun(session *models.ChatbotSession, flow *models.ChatbotFlow) {
+	a.endFlowRun(session, FlowRunCancelled)
+	run := models.ChatbotFlowRun{
+		BaseModel:      models.BaseModel{ID: uuid.New()},
+		OrganizationID: session.OrganizationID,
+		FlowID:         flow.ID,
+		Version:        flow.Version,
+		SessionID:      session.ID,
+		ContactID:      session.ContactID,
+		Status:         FlowRunActive,
+	}
+	if err := a.DB.Create(&run).Error; err != nil {
+		a.Log.Error("Failed to record flow run", "error", err, "flow_id", flow.ID)
+		return
+	}
+	session.FlowRunID = &run.ID
+}
+
+// endFlowRun ends the session's active flow run with a status. The caller clears
+// flow_run_id on the session.
+func (a *App) endFlowRun(session *models.ChatbotSession, status string) {
+	if session.FlowRunID == nil {
+		return
+	}
+	a.DB.Model(&models.ChatbotFlowRun{}).
+		Where("id = ? AND status = ?", *session.FlowRunID, FlowRunActive).
+		Updates(map[string]interface{}{
+			"status":   status,
+			"ended_at": time.Now(),
+		})
+	session.FlowRunID = nil
+}
+
+// FlowVersionMetrics counts the runs of a flow version by outcome
+type FlowVersionMetrics struct {
+	Version        int     `json:"version"`
+	Started        int64   `json:"started"`
+	Completed      int64   `json:"completed"`
+	Cancelled      int64   `json:"cancelled"`
+	Active         int64   `json:"active"`          // Still running, or abandoned
+	CompletionRate float64 `json:"completion_rate"` // Completed runs, in percent of started
+}
+
+// versionedChatbotFlow loads the flow of a version request. Returns nil and the error
+// response if the flow is not found.
+func (a *App) versionedChatbotFlow(r *fastglue.Request) (*models.ChatbotFlow, error) {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return nil, r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	idStr, _ := r.RequestCtx.UserValue("id").(string)
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return nil, r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid flow ID", nil, "")
+	}
+
+	var flow models.ChatbotFlow
+	if err := a.DB.Where("id = ? AND organization_id = ?", id, orgID).
+		Preload("Steps", func(db *gorm.DB) *gorm.DB {
+			return db.Order("step_order ASC")
+		}).
+		First(&flow).Error; err != nil {
+		return nil, r.SendErrorEnvelope(fasthttp.StatusNotFound, "Flow not found", nil, "")
+	}
+	return &flow, nil
+}
+
+// loadChatbotFlowVersion loads a version of a flow, or its draft for "draft"
+func (a *App) loadChatbotFlowVersion(flow *models.ChatbotFlow, value string) (*models.ChatbotFlowVersion, error) {
+	if value == "draft" {
+		draft := chatbotFlowVersionSnapshot(flow, flow.Steps)
+		return &draft, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 1 {
+		return nil, errors.New("invalid version")
+	}
+	var version models.ChatbotFlowVersion
+	if err := a.DB.Where("flow_id = ? AND version = ?", flow.ID, number).First(&version).Error; err != nil {
+		return nil, errors.New("version not found")
+	}
+	return &version, nil
+}
+
+// ListChatbotFlowVersions returns the versions of a flow, newest first, and what its
+// draft changes against the latest version
+func (a *App) ListChatbotFlowVersions(r *fastglue.Request) error {
+	flow, errResp := a.versionedChatbotFlow(r)
+	if flow == nil {
+		return errResp
+	}
+
+	var versions []models.ChatbotFlowVersion
+	if err := a.DB.Where("flow_id = ?", flow.ID).Order("version DESC").Find(&versions).Error; err != nil {
+		a.Log.Error("Failed to list flow versions", "error", err, "flow_id", flow.ID)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to list flow versions", nil, "")
+	}
+	draftChanges, err := flowDraftChanges(a.DB, flow, flow.Steps)
+	if err != nil {
+		a.Log.Error("Failed to compare flow draft", "error", err, "flow_id", flow.ID)
+	}
+
+	return r.SendEnvelope(map[string]interface{}{
+		"flow_id":           flow.ID,
+		"published_version": flow.PublishedVersion,
+		"rollout_version":   flow.RolloutVersion,
+		"rollout_percent":   flow.RolloutPercent,
+		"has_draft":         flow.HasDraft,
+		"draft_changes":     draftChanges,
+		"versions":          versions,
+	})
+}
+
+// GetChatbotFlowVersion returns a single version of a flow
+func (a *App) GetChatbotFlowVersion(r *fastglue.Request) error {
+	flow, errResp := a.versionedChatbotFlow(r)
+	if flow == nil {
+		return errResp
+	}
+
+	value, _ := r.RequestCtx.UserValue("version").(string)
+	version, err := a.loadChatbotFlowVersion(flow, value)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Flow version not found", nil, "")
+	}
+	return r.SendEnvelope(version)
+}
+
+// DiffChatbotFlowVersions compares two versions of a flow, given as ?from= and ?to= version
+// numbers or "draft". They default to the published version and the draft.
+func (a *App) DiffChatbotFlowVersions(r *fastglue.Request) error {
+	flow, errResp := a.versionedChatbotFlow(r)
+	if flow == nil {
+		return errResp
+	}
+
+	fromValue := string(r.RequestCtx.QueryArgs().Peek("from"))
+	if fromValue == "" {
+		fromValue = strconv.Itoa(flow.PublishedVersion)
+	}
+	toValue := string(r.RequestCtx.QueryArgs().Peek("to"))
+	if toValue == "" {
+		toValue = "draft"
+	}
+	from, err := a.loadChatbotFlowVersion(flow, fromValue)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "from: "+err.Error(), nil, "")
+	}
+	to, err := a.loadChatbotFlowVersion(flow, toValue)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "to: "+err.Error(), nil, "")
+	}
+
+	return r.SendEnvelope(map[string]interface{}{
+		"from":    fromValue,
+		"to":      toValue,
+		"changes": chatbotFlowVersionChanges(from, to),
+	})
+}
+
+// PublishChatbotFlow publishes a flow's draft as its next version. With rollout_percent,
+// the new version starts that share of new runs and the published version the rest;
+// otherwise it starts all new runs. Sessions keep running the version they started on.
+func (a *App) PublishChatbotFlow(r *fastglue.Request) error {
+	flow, errResp := a.versionedChatbotFlow(r)
+	if flow == nil {
+		return errResp
+	}
+
+	var req struct {
+		Notes          string `json:"notes"`
+		RolloutPercent int    `json:"rollout_percent"`
+	}
+	if body := r.RequestCtx.PostBody(); len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
+		}
+	}
+	if req.RolloutPercent < 0 || req.RolloutPercent > 100 {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "rollout_percent must be between 0 and 100", nil, "")
+	}
+	if req.RolloutPercent > 0 && flow.PublishedVersion == 0 {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "A rollout needs a published version to compare with", nil, "")
+	}
+	if problems := validateFlowGraph(flow.ID, flow.Steps, a.flowStepsFunc(flow.OrganizationID)); len(problems) > 0 {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid flow: "+strings.Join(problems, "; "), map[string]interface{}{"problems": problems}, "")
+	}
+
+	var latest models.ChatbotFlowVersion
+	a.DB.Where("flow_id = ?", flow.ID).Order("version DESC").First(&latest)
+	if latest.Version > 0 {
+		draft := chatbotFlowVersionSnapshot(flow, flow.Steps)
+		if len(chatbotFlowVersionChanges(&latest, &draft)) == 0 {
+			if latest.Version == flow.PublishedVersion && flow.RolloutVersion == 0 {
+				return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "No changes to publish", nil, "")
+			}
+			// The draft is the latest version, e.g. after a rollback: publish it again
+			if req.RolloutPercent > 0 && latest.Version == flow.PublishedVersion {
+				return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Version is already published", nil, "")
+			}
+			return a.activateChatbotFlowVersion(r, flow, latest.Version, req.RolloutPercent)
+		}
+	}
+
+	userID, _ := r.RequestCtx.UserValue("user_id").(uuid.UUID)
+	var version *models.ChatbotFlowVersion
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if version, err = recordChatbotFlowVersion(tx, flow, flow.Steps, req.Notes, &userID); err != nil {
+			return err
+		}
+		return setFlowVersions(tx, flow, version.Version, req.RolloutPercent)
+	})
+	if err != nil {
+		a.Log.Error("Failed to publish flow", "error", err, "flow_id", flow.ID)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to publish flow", nil, "")
+	}
+	a.InvalidateChatbotFlowsCache(flow.OrganizationID)
+
+	return r.SendEnvelope(map[string]interface{}{
+		"message":           "Flow published",
+		"version":           version.Version,
+		"published_version": flow.PublishedVersion,
+		"rollout_version":   flow.RolloutVersion,
+		"rollout_percent":   flow.RolloutPercent,
+	})
+}
+
+// RollbackChatbotFlow makes an earlier version the published one and ends any rollout.
+// The version defaults to the latest one before the published version. The draft is
+// left as is.
+func (a *App) RollbackChatbotFlow(r *fastglue.Request) error {
+	flow, errResp := a.versionedChatbotFlow(r)
+	if flow == nil {
+		return errResp
+	}
+
+	var req struct {
+		Version int `json:"version"`
+	}
+	if body := r.RequestCtx.PostBody(); len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
+		}
+	}
+
+	var version models.ChatbotFlowVersion
+	query := a.DB.Where("flow_id = ?", flow.ID)
+	if req.Version > 0 {
+		query = query.Where("version = ?", req.Version)
+	} else {
+		query = query.Where("version < ?", flow.PublishedVersion).Order("version DESC")
+	}
+	if err := query.First(&version).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "No version to roll back to", nil, "")
+	}
+	return a.activateChatbotFlowVersion(r, flow, version.Version, 0)
+}
+
+// UpdateChatbotFlowRollout starts, changes or ends (percent 0) the rollout of a version
+// to a share of new runs
+func (a *App) UpdateChatbotFlowRollout(r *fastglue.Request) error {
+	flow, errResp := a.versionedChatbotFlow(r)
+	if flow == nil {
+		return errResp
+	}
+
+	var req struct {
+		Version int `json:"version"`
+		Percent int `json:"percent"`
+	}
+	if err := json.Unmarshal(r.RequestCtx.PostBody(), &req); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
+	}
+	if req.Percent < 0 || req.Percent > 100 {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "percent must be between 0 and 100", nil, "")
+	}
+	if req.Percent > 0 {
+		if req.Version == flow.PublishedVersion {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Version is already published", nil, "")
+		}
+		var count int64
+		a.DB.Model(&models.ChatbotFlowVersion{}).Where("flow_id = ? AND version = ?", flow.ID, req.Version).Count(&count)
+		if count == 0 {
+			return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Flow version not found", nil, "")
+		}
+	} else {
+		req.Version = 0
+	}
+
+	flow.RolloutVersion = req.Version
+	flow.RolloutPercent = req.Percent
+	if err := a.DB.Model(&models.ChatbotFlow{}).Where("id = ?", flow.ID).Updates(map[string]interface{}{
+		"rollout_version": flow.RolloutVersion,
+		"rollout_percent": flow.RolloutPercent,
+	}).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to update rollout", nil, "")
+	}
+	a.InvalidateChatbotFlowsCache(flow.OrganizationID)
+
+	return r.SendEnvelope(map[string]interface{}{
+		"message":           "Rollout updated",
+		"published_version": flow.PublishedVersion,
+		"rollout_version":   flow.RolloutVersion,
+		"rollout_percent":   flow.RolloutPercent,
+	})
+}
+
+// GetChatbotFlowMetrics returns the runs of each version of a flow by outcome
+func (a *App) GetChatbotFlowMetrics(r *fastglue.Request) error {
+	flow, errResp := a.versionedChatbotFlow(r)
+	if flow == nil {
+		return errResp
+	}
+
+	var rows []struct {
+		Version int
+		Status  string
+		Count   int64
+	}
+	if err := a.DB.Model(&models.ChatbotFlowRun{}).
+		Select("version, status, COUNT(*) AS count").
+		Where("flow_id = ? AND organization_id = ?", flow.ID, flow.OrganizationID).
+		Group("version, status").
+		Scan(&rows).Error; err != nil {
+		a.Log.Error("Failed to load flow metrics", "error", err, "flow_id", flow.ID)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to load flow metrics", nil, "")
+	}
+
+	byVersion := map[int]*FlowVersionMetrics{}
+	for _, row := range rows {
+		m, ok := byVersion[row.Version]
+		if !ok {
+			m = &FlowVersionMetrics{Version: row.Version}
+			byVersion[row.Version] = m
+		}
+		m.Started += row.Count
+		switch row.Status {
+		case FlowRunCompleted:
+			m.Completed += row.Count
+		case FlowRunCancelled:
+			m.Cancelled += row.Count
+		default:
+			m.Active += row.Count
+		}
+	}
+	metrics := make([]FlowVersionMetrics, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Started > 0 {
+			m.CompletionRate = float64(m.Completed) / float64(m.Started) * 100
+		}
+		metrics = append(metrics, *m)
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Version > metrics[j].Version })
+
+	return r.SendEnvelope(map[string]interface{}{
+		"flow_id":           flow.ID,
+		"published_version": flow.PublishedVersion,
+		"rollout_version":   flow.RolloutVersion,
+		"rollout_percent":   flow.RolloutPercent,
+		"versions":          metrics,
+	})
+}
+
+// activateChatbotFlowVersion publishes an existing version, or rolls it out to a share
+// of new runs, and responds with the flow's versions
+func (a *App) activateChatbotFlowVersion(r *fastglue.Request, flow *models.ChatbotFlow, version, rolloutPercent int) error {
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		return setFlowVersions(tx, flow, version, rolloutPercent)
+	})
+	if err != nil {
+		a.Log.Error("Failed to publish flow version", "error", err, "flow_id", flow.ID, "version", version)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to publish flow version", nil, "")
+	}
+	a.InvalidateChatbotFlowsCache(flow.OrganizationID)
+
+	return r.SendEnvelope(map[string]interface{}{
+		"message":           "Flow version published",
+		"version":           version,
+		"published_version": flow.PublishedVersion,
+		"rollout_version":   flow.RolloutVersion,
+		"rollout_percent":   flow.RolloutPercent,
+	})
+}
+
+// setFlowVersions makes version the published version of flow, or its rollout version
+// when rolloutPercent is set, and updates whether the draft differs from the result
+func setFlowVersions(tx *gorm.DB, flow *models.ChatbotFlow, version, rolloutPercent int) error {
+	if rolloutPercent > 0 {
+		flow.RolloutVersion = version
+		flow.RolloutPercent = rolloutPercent
+	} else {
+		flow.PublishedVersion = version
+		flow.RolloutVersion = 0
+		flow.RolloutPercent = 0
+	}
+	if err := tx.Model(&models.ChatbotFlow{}).Where("id = ?", flow.ID).Updates(map[string]interface{}{
+		"published_version": flow.PublishedVersion,
+		"rollout_version":   flow.RolloutVersion,
+		"rollout_percent":   flow.RolloutPercent,
+	}).Error; err != nil {
+		return err
+	}
+	return updateFlowHasDraft(tx, flow, flow.Steps)
+}
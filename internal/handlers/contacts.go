@@ -4,11 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -19,6 +18,7 @@ import (
 	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
 	"github.com/valyala/fasthttp"
 	"github.com/zerodha/fastglue"
+	"gorm.io/gorm"
 )
 
 // ContactResponse represents a contact with additional fields for the frontend
@@ -28,13 +28,15 @@ type ContactResponse struct {
 	Name               string     `json:"name"`
 	ProfileName        string     `json:"profile_name"`
 	AvatarURL          string     `json:"avatar_url"`
-	Status             string     `json:"status"`
+	Status             string     `json:"status"` // active, opted_out
+	Locale             string     `json:"locale"`
 	Tags               []string   `json:"tags"`
 	CustomFields       any        `json:"custom_fields"`
 	LastMessageAt      *time.Time `json:"last_message_at"`
 	LastMessagePreview string     `json:"last_message_preview"`
 	UnreadCount        int        `json:"unread_count"`
 	AssignedUserID     *uuid.UUID `json:"assigned_user_id,omitempty"`
+	WindowExpiresAt    *time.Time `json:"window_expires_at"` // When the 24h customer service window closes (nil if the contact never wrote in)
 	CreatedAt          time.Time  `json:"created_at"`
 	UpdatedAt          time.Time  `json:"updated_at"`
 }
@@ -123,46 +125,11 @@ func (a *App) ListContacts(r *fastglue.Request) error {
 	// Check if phone masking is enabled
 	shouldMask := a.ShouldMaskPhoneNumbers(orgID)
 
-	// Convert to response format
+	// Convert to response format, loading the page's service windows in one query
+	windows := a.getServiceWindowExpiries(contacts)
 	response := make([]ContactResponse, len(contacts))
 	for i, c := range contacts {
-		// Count unread messages
-		var unreadCount int64
-		a.DB.Model(&models.Message{}).
-			Where("contact_id = ? AND direction = ? AND status != ?", c.ID, "incoming", "read").
-			Count(&unreadCount)
-
-		tags := []string{}
-		if c.Tags != nil {
-			for _, t := range c.Tags {
-				if s, ok := t.(string); ok {
-					tags = append(tags, s)
-				}
-			}
-		}
-
-		phoneNumber := c.PhoneNumber
-		profileName := c.ProfileName
-		if shouldMask {
-			phoneNumber = MaskPhoneNumber(phoneNumber)
-			profileName = MaskIfPhoneNumber(profileName)
-		}
-
-		response[i] = ContactResponse{
-			ID:                 c.ID,
-			PhoneNumber:        phoneNumber,
-			Name:               profileName,
-			ProfileName:        profileName,
-			Status:             "active",
-			Tags:               tags,
-			CustomFields:       c.Metadata,
-			LastMessageAt:      c.LastMessageAt,
-			LastMessagePreview: c.LastMessagePreview,
-			UnreadCount:        int(unreadCount),
-			AssignedUserID:     c.AssignedUserID,
-			CreatedAt:          c.CreatedAt,
-			UpdatedAt:          c.UpdatedAt,
-		}
+		response[i] = a.contactToResponse(&c, shouldMask, windows[c.ID])
 	}
 
 	return r.SendEnvelope(map[string]any{
@@ -198,46 +165,277 @@ func (a *App) GetContact(r *fastglue.Request) error {
 		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Contact not found", nil, "")
 	}
 
+	response := a.contactToResponse(&contact, a.ShouldMaskPhoneNumbers(orgID), a.getServiceWindowExpiry(contact.ID, contact.WhatsAppAccount))
+
+	return r.SendEnvelope(response)
+}
+
+// CreateContactRequest represents the request body for creating a contact
+type CreateContactRequest struct {
+	PhoneNumber     string                 `json:"phone_number"`
+	ProfileName     string                 `json:"profile_name"`
+	WhatsAppAccount string                 `json:"whatsapp_account"`
+	Locale          string                 `json:"locale"` // Preferred template language, e.g. pt_BR
+	Tags            []string               `json:"tags"`
+	Metadata        map[string]interface{} `json:"metadata"`
+	AssignedUserID  *uuid.UUID             `json:"assigned_user_id"`
+}
+
+// UpdateContactRequest represents the request body for updating a contact
+// Omitted fields are left unchanged; tags and metadata replace the existing values
+type UpdateContactRequest struct {
+	ProfileName *string                `json:"profile_name"`
+	Locale      *string                `json:"locale"`
+	Tags        *[]string              `json:"tags"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+// CreateContact creates a contact with a normalized phone number
+// Agents can create contacts, which are assigned to themselves
+func (a *App) CreateContact(r *fastglue.Request) error {
+	orgID := r.RequestCtx.UserValue("organization_id").(uuid.UUID)
+	userID, _ := r.RequestCtx.UserValue("user_id").(uuid.UUID)
+	userRole, _ := r.RequestCtx.UserValue("role").(string)
+
+	var req CreateContactRequest
+	if err := r.Decode(&req, "json"); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
+	}
+
+	phoneNumber, err := whatsapp.NormalizePhoneNumber(req.PhoneNumber)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid phone number: use international format with country code", nil, "")
+	}
+
+	if req.WhatsAppAccount != "" {
+		var count int64
+		a.DB.Model(&models.WhatsAppAccount{}).Where("organization_id = ? AND name = ?", orgID, req.WhatsAppAccount).Count(&count)
+		if count == 0 {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "WhatsApp account not found", nil, "")
+		}
+	}
+
+	// Agents always own the contacts they create; admins and managers may assign them
+	assignedUserID := req.AssignedUserID
+	if userRole == "agent" {
+		assignedUserID = &userID
+	} else if assignedUserID != nil {
+		var user models.User
+		if err := a.DB.Where("id = ? AND organization_id = ?", assignedUserID, orgID).First(&user).Error; err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "User not found", nil, "")
+		}
+	}
+
+	// Phone numbers are unique per organization; a previously deleted contact is restored
+	var contact models.Contact
+	err = a.DB.Unscoped().Where("organization_id = ? AND phone_number = ?", orgID, phoneNumber).First(&contact).Error
+	if err == nil && !contact.DeletedAt.Valid {
+		return r.SendErrorEnvelope(fasthttp.StatusConflict, "Contact with this phone number already exists", nil, "")
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		a.Log.Error("Failed to check existing contact", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to create contact", nil, "")
+	}
+
+	restored := err == nil
+	if !restored {
+		contact = models.Contact{
+			BaseModel:      models.BaseModel{ID: uuid.New()},
+			OrganizationID: orgID,
+			PhoneNumber:    phoneNumber,
+		}
+	}
+	contact.ProfileName = strings.TrimSpace(req.ProfileName)
+	contact.WhatsAppAccount = req.WhatsAppAccount
+	contact.Locale = whatsapp.NormalizeLanguageCode(req.Locale)
+	contact.AssignedUserID = assignedUserID
+	contact.Tags = tagsToJSONBArray(req.Tags)
+	contact.Metadata = models.JSONB(req.Metadata)
+	if contact.Metadata == nil {
+		contact.Metadata = models.JSONB{}
+	}
+
+	if restored {
+		contact.DeletedAt = gorm.DeletedAt{}
+		err = a.DB.Unscoped().Save(&contact).Error
+	} else {
+		err = a.DB.Create(&contact).Error
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "duplicate key") {
+			return r.SendErrorEnvelope(fasthttp.StatusConflict, "Contact with this phone number already exists", nil, "")
+		}
+		a.Log.Error("Failed to create contact", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to create contact", nil, "")
+	}
+
+	a.DispatchWebhook(orgID, EventContactCreated, contactEventData(&contact))
+
+	return r.SendEnvelope(a.contactToResponse(&contact, a.ShouldMaskPhoneNumbers(orgID), a.getServiceWindowExpiry(contact.ID, contact.WhatsAppAccount)))
+}
+
+// UpdateContact updates a contact's profile name, tags and metadata
+// Agents can only update contacts assigned to them
+func (a *App) UpdateContact(r *fastglue.Request) error {
+	orgID := r.RequestCtx.UserValue("organization_id").(uuid.UUID)
+	userID, _ := r.RequestCtx.UserValue("user_id").(uuid.UUID)
+	userRole, _ := r.RequestCtx.UserValue("role").(string)
+
+	contactID, err := uuid.Parse(r.RequestCtx.UserValue("id").(string))
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid contact ID", nil, "")
+	}
+
+	var req UpdateContactRequest
+	if err := r.Decode(&req, "json"); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
+	}
+
+	var contact models.Contact
+	query := a.DB.Where("id = ? AND organization_id = ?", contactID, orgID)
+	if userRole == "agent" {
+		query = query.Where("assigned_user_id = ?", userID)
+	}
+	if err := query.First(&contact).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Contact not found", nil, "")
+	}
+
+	updates := map[string]interface{}{}
+	if req.ProfileName != nil {
+		contact.ProfileName = strings.TrimSpace(*req.ProfileName)
+		updates["profile_name"] = contact.ProfileName
+	}
+	if req.Locale != nil {
+		contact.Locale = whatsapp.NormalizeLanguageCode(*req.Locale)
+		updates["locale"] = contact.Locale
+	}
+	if req.Tags != nil {
+		contact.Tags = tagsToJSONBArray(*req.Tags)
+		updates["tags"] = contact.Tags
+	}
+	if req.Metadata != nil {
+		contact.Metadata = models.JSONB(req.Metadata)
+		updates["metadata"] = contact.Metadata
+	}
+
+	if len(updates) > 0 {
+		if err := a.DB.Model(&contact).Updates(updates).Error; err != nil {
+			a.Log.Error("Failed to update contact", "error", err)
+			return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to update contact", nil, "")
+		}
+		a.DispatchWebhook(orgID, EventContactUpdated, contactEventData(&contact))
+	}
+
+	return r.SendEnvelope(a.contactToResponse(&contact, a.ShouldMaskPhoneNumbers(orgID), a.getServiceWindowExpiry(contact.ID, contact.WhatsAppAccount)))
+}
+
+// DeleteContact deletes a contact (message history is kept)
+// Only admin and manager can delete contacts
+func (a *App) DeleteContact(r *fastglue.Request) error {
+	orgID := r.RequestCtx.UserValue("organization_id").(uuid.UUID)
+	userRole, _ := r.RequestCtx.UserValue("role").(string)
+
+	if userRole == "agent" {
+		return r.SendErrorEnvelope(fasthttp.StatusForbidden, "Only admin and manager can delete contacts", nil, "")
+	}
+
+	contactID, err := uuid.Parse(r.RequestCtx.UserValue("id").(string))
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid contact ID", nil, "")
+	}
+
+	var contact models.Contact
+	if err := a.DB.Where("id = ? AND organization_id = ?", contactID, orgID).First(&contact).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Contact not found", nil, "")
+	}
+
+	if err := a.DB.Delete(&contact).Error; err != nil {
+		a.Log.Error("Failed to delete contact", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to delete contact", nil, "")
+	}
+
+	a.DispatchWebhook(orgID, EventContactDeleted, contactEventData(&contact))
+
+	return r.SendEnvelope(map[string]string{"message": "Contact deleted successfully"})
+}
+
+// contactEventData builds the outbound webhook payload for contact events
+func contactEventData(c *models.Contact) ContactEventData {
+	return ContactEventData{
+		ContactID:       c.ID.String(),
+		ContactPhone:    c.PhoneNumber,
+		ContactName:     c.ProfileName,
+		WhatsAppAccount: c.WhatsAppAccount,
+		Locale:          c.Locale,
+		Tags:            contactTags(c),
+		Metadata:        c.Metadata,
+	}
+}
+
+// tagsToJSONBArray trims, de-duplicates and converts tags for storage
+func tagsToJSONBArray(tags []string) models.JSONBArray {
+	result := models.JSONBArray{}
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		result = append(result, t)
+	}
+	return result
+}
+
+// contactToResponse converts a contact to its API representation, including the unread count
+// and the given expiry of its customer service window
+func (a *App) contactToResponse(c *models.Contact, shouldMask bool, windowExpiresAt *time.Time) ContactResponse {
 	// Count unread messages
 	var unreadCount int64
 	a.DB.Model(&models.Message{}).
-		Where("contact_id = ? AND direction = ? AND status != ?", contact.ID, "incoming", "read").
+		Where("contact_id = ? AND direction = ? AND status != ?", c.ID, "incoming", "read").
 		Count(&unreadCount)
 
-	tags := []string{}
-	if contact.Tags != nil {
-		for _, t := range contact.Tags {
-			if s, ok := t.(string); ok {
-				tags = append(tags, s)
-			}
-		}
-	}
-
-	phoneNumber := contact.PhoneNumber
-	profileName := contact.ProfileName
-	shouldMask := a.ShouldMaskPhoneNumbers(orgID)
+	phoneNumber := c.PhoneNumber
+	profileName := c.ProfileName
 	if shouldMask {
 		phoneNumber = MaskPhoneNumber(phoneNumber)
 		profileName = MaskIfPhoneNumber(profileName)
 	}
 
-	response := ContactResponse{
-		ID:                 contact.ID,
+	status := "active"
+	if a.isOptedOut(c.OrganizationID, c.PhoneNumber) {
+		status = "opted_out"
+	}
+
+	return ContactResponse{
+		ID:                 c.ID,
 		PhoneNumber:        phoneNumber,
 		Name:               profileName,
 		ProfileName:        profileName,
-		Status:             "active",
-		Tags:               tags,
-		CustomFields:       contact.Metadata,
-		LastMessageAt:      contact.LastMessageAt,
-		LastMessagePreview: contact.LastMessagePreview,
+		Status:             status,
+		Locale:             c.Locale,
+		Tags:               contactTags(c),
+		CustomFields:       c.Metadata,
+		LastMessageAt:      c.LastMessageAt,
+		LastMessagePreview: c.LastMessagePreview,
 		UnreadCount:        int(unreadCount),
-		AssignedUserID:     contact.AssignedUserID,
-		CreatedAt:          contact.CreatedAt,
-		UpdatedAt:          contact.UpdatedAt,
+		AssignedUserID:     c.AssignedUserID,
+		WindowExpiresAt:    windowExpiresAt,
+		CreatedAt:          c.CreatedAt,
+		UpdatedAt:          c.UpdatedAt,
 	}
+}
 
-	return r.SendEnvelope(response)
+// contactTags returns the contact's tags as strings
+func contactTags(c *models.Contact) []string {
+	tags := []string{}
+	for _, t := range c.Tags {
+		if s, ok := t.(string); ok {
+			tags = append(tags, s)
+		}
+	}
+	return tags
 }
 
 // GetMessages returns messages for a contact
@@ -458,12 +656,15 @@ func (a *App) markMessagesAsRead(orgID uuid.UUID, contactID uuid.UUID, contact *
 	}
 }
 
-// SendMessageRequest represents a send message request
+// SendMessageRequest represents a send message request. Type is text (default), location,
+// contacts, sticker, buttons, list, cta_url, location_request, product or product_list; the
+// matching MessageSpec field holds the message, and content.body its body text.
 type SendMessageRequest struct {
 	Type    string `json:"type"`
 	Content struct {
 		Body string `json:"body"`
 	} `json:"content"`
+	MessageSpec
 	ReplyToMessageID string `json:"reply_to_message_id,omitempty"`
 }
 
@@ -485,6 +686,11 @@ func (a *App) SendMessage(r *fastglue.Request) error {
 	if err := json.Unmarshal(r.RequestCtx.PostBody(), &req); err != nil {
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
 	}
+	outgoing, err := req.build(req.Type, req.Content.Body)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+	messageType, content, interactiveData := outgoingMessageRecord(outgoing)
 
 	// Get contact (agents can only message their assigned contacts)
 	var contact models.Contact
@@ -512,6 +718,11 @@ func (a *App) SendMessage(r *fastglue.Request) error {
 		}
 	}
 
+	// Free-form messages are only allowed within the customer service window
+	if ok, err := a.requireServiceWindow(r, &contact, &account); !ok {
+		return err
+	}
+
 	// Create message record
 	message := models.Message{
 		BaseModel:       models.BaseModel{ID: uuid.New()},
@@ -519,8 +730,9 @@ func (a *App) SendMessage(r *fastglue.Request) error {
 		WhatsAppAccount: account.Name,
 		ContactID:       contactID,
 		Direction:       "outgoing",
-		MessageType:     req.Type,
-		Content:         req.Content.Body,
+		MessageType:     messageType,
+		Content:         content,
+		InteractiveData: interactiveData,
 		Status:          "pending",
 		SentByUserID:    &userID,
 	}
@@ -545,25 +757,30 @@ func (a *App) SendMessage(r *fastglue.Request) error {
 	}
 
 	// Send via WhatsApp API
-	go a.sendWhatsAppMessage(&account, &contact, &message)
+	go a.sendWhatsAppMessage(&account, &contact, &message, outgoing)
 
 	// Update contact's last message
+	preview := truncateString(content, 100)
+	if messageType == "location" || messageType == "contacts" || messageType == "sticker" {
+		preview = "[" + messageType + "]"
+	}
 	now := time.Now()
 	a.DB.Model(&contact).Updates(map[string]any{
 		"last_message_at":      now,
-		"last_message_preview": truncateString(req.Content.Body, 100),
+		"last_message_preview": preview,
 	})
 
 	response := MessageResponse{
-		ID:          message.ID,
-		ContactID:   message.ContactID,
-		Direction:   message.Direction,
-		MessageType: message.MessageType,
-		Content:     map[string]string{"body": message.Content},
-		Status:      message.Status,
-		IsReply:     message.IsReply,
-		CreatedAt:   message.CreatedAt,
-		UpdatedAt:   message.UpdatedAt,
+		ID:              message.ID,
+		ContactID:       message.ContactID,
+		Direction:       message.Direction,
+		MessageType:     message.MessageType,
+		Content:         map[string]string{"body": message.Content},
+		InteractiveData: message.InteractiveData,
+		Status:          message.Status,
+		IsReply:         message.IsReply,
+		CreatedAt:       message.CreatedAt,
+		UpdatedAt:       message.UpdatedAt,
 	}
 
 	// Add reply context to response
@@ -581,15 +798,16 @@ func (a *App) SendMessage(r *fastglue.Request) error {
 	// Broadcast new outgoing message via WebSocket
 	if a.WSHub != nil {
 		wsPayload := map[string]any{
-			"id":           message.ID,
-			"contact_id":   message.ContactID,
-			"direction":    message.Direction,
-			"message_type": message.MessageType,
-			"content":      map[string]string{"body": message.Content},
-			"status":       message.Status,
-			"created_at":   message.CreatedAt,
-			"updated_at":   message.UpdatedAt,
-			"is_reply":     message.IsReply,
+			"id":               message.ID,
+			"contact_id":       message.ContactID,
+			"direction":        message.Direction,
+			"message_type":     message.MessageType,
+			"content":          map[string]string{"body": message.Content},
+			"interactive_data": message.InteractiveData,
+			"status":           message.Status,
+			"created_at":       message.CreatedAt,
+			"updated_at":       message.UpdatedAt,
+			"is_reply":         message.IsReply,
 		}
 		if message.IsReply && message.ReplyToMessageID != nil && replyToMessage != nil {
 			wsPayload["reply_to_message_id"] = message.ReplyToMessageID.String()
@@ -610,121 +828,55 @@ func (a *App) SendMessage(r *fastglue.Request) error {
 }
 
 // sendWhatsAppMessage sends a message via the WhatsApp Cloud API
-func (a *App) sendWhatsAppMessage(account *models.WhatsAppAccount, contact *models.Contact, message *models.Message) {
-	url := fmt.Sprintf("https://graph.facebook.com/%s/%s/messages", account.APIVersion, account.PhoneID)
-
-	payload := map[string]any{
-		"messaging_product": "whatsapp",
-		"recipient_type":    "individual",
-		"to":                contact.PhoneNumber,
-		"type":              message.MessageType,
-	}
-
-	if message.MessageType == "text" {
-		payload["text"] = map[string]any{
-			"preview_url": false,
-			"body":        message.Content,
-		}
+func (a *App) sendWhatsAppMessage(account *models.WhatsAppAccount, contact *models.Contact, message *models.Message, outgoing whatsapp.OutgoingMessage) {
+	waAccount := &whatsapp.Account{
+		PhoneID:     account.PhoneID,
+		BusinessID:  account.BusinessID,
+		APIVersion:  account.APIVersion,
+		AccessToken: account.AccessToken,
 	}
 
 	// Add reply context if this is a reply
+	var replyTo string
 	if message.IsReply && message.ReplyToMessageID != nil {
 		var replyToMsg models.Message
-		if err := a.DB.First(&replyToMsg, message.ReplyToMessageID).Error; err == nil && replyToMsg.WhatsAppMessageID != "" {
-			payload["context"] = map[string]any{
-				"message_id": replyToMsg.WhatsAppMessageID,
-			}
+		if err := a.DB.First(&replyToMsg, message.ReplyToMessageID).Error; err == nil {
+			replyTo = replyToMsg.WhatsAppMessageID
 		}
 	}
 
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		a.Log.Error("Failed to marshal message payload", "error", err)
-		a.DB.Model(message).Updates(map[string]any{
-			"status":        "failed",
-			"error_message": "Failed to create request",
-		})
-		return
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
+	wamid, err := a.WhatsApp.Send(context.Background(), waAccount, contact.PhoneNumber, outgoing, replyTo)
 	if err != nil {
-		a.Log.Error("Failed to create request", "error", err)
-		a.DB.Model(message).Updates(map[string]any{
-			"status":        "failed",
-			"error_message": "Failed to create request",
-		})
-		return
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+account.AccessToken)
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		a.Log.Error("Failed to send message", "error", err)
+		a.Log.Error("Failed to send message", "error", err, "type", outgoing.Type())
 		a.DB.Model(message).Updates(map[string]any{
 			"status":        "failed",
 			"error_message": err.Error(),
 		})
 		return
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
-
-	if resp.StatusCode != 200 {
-		var errResp struct {
-			Error struct {
-				Message string `json:"message"`
-				Code    int    `json:"code"`
-			} `json:"error"`
-		}
-		json.Unmarshal(body, &errResp)
-		a.Log.Error("WhatsApp API error",
-			"status", resp.StatusCode,
-			"code", errResp.Error.Code,
-			"message", errResp.Error.Message,
-		)
-		a.DB.Model(message).Updates(map[string]any{
-			"status":        "failed",
-			"error_message": errResp.Error.Message,
-		})
-		return
-	}
-
-	var result struct {
-		Messages []struct {
-			ID string `json:"id"`
-		} `json:"messages"`
-	}
-	json.Unmarshal(body, &result)
-
-	if len(result.Messages) > 0 {
-		a.DB.Model(message).Updates(map[string]any{
-			"status":               "sent",
-			"whats_app_message_id": result.Messages[0].ID,
-		})
-		a.Log.Info("Message sent successfully", "message_id", result.Messages[0].ID, "to", contact.PhoneNumber)
+	a.DB.Model(message).Updates(map[string]any{
+		"status":               "sent",
+		"whats_app_message_id": wamid,
+	})
+	a.Log.Info("Message sent successfully", "message_id", wamid, "to", contact.PhoneNumber)
 
-		// Dispatch webhook for message sent
-		var sentByUserID string
-		if message.SentByUserID != nil {
-			sentByUserID = message.SentByUserID.String()
-		}
-		a.DispatchWebhook(account.OrganizationID, EventMessageSent, MessageEventData{
-			MessageID:       message.ID.String(),
-			ContactID:       contact.ID.String(),
-			ContactPhone:    contact.PhoneNumber,
-			ContactName:     contact.ProfileName,
-			MessageType:     message.MessageType,
-			Content:         message.Content,
-			WhatsAppAccount: account.Name,
-			Direction:       "outgoing",
-			SentByUserID:    sentByUserID,
-		})
+	// Dispatch webhook for message sent
+	var sentByUserID string
+	if message.SentByUserID != nil {
+		sentByUserID = message.SentByUserID.String()
 	}
+	a.DispatchWebhook(account.OrganizationID, EventMessageSent, MessageEventData{
+		MessageID:       message.ID.String(),
+		ContactID:       contact.ID.String(),
+		ContactPhone:    contact.PhoneNumber,
+		ContactName:     contact.ProfileName,
+		MessageType:     message.MessageType,
+		Content:         message.Content,
+		WhatsAppAccount: account.Name,
+		Direction:       "outgoing",
+		SentByUserID:    sentByUserID,
+	})
 }
 
 func truncateString(s string, maxLen int) string {
@@ -819,6 +971,11 @@ func (a *App) SendMediaMessage(r *fastglue.Request) error {
 		}
 	}
 
+	// Free-form messages are only allowed within the customer service window
+	if ok, err := a.requireServiceWindow(r, &contact, &account); !ok {
+		return err
+	}
+
 	// Save file locally
 	waAccount := &whatsapp.Account{
 		PhoneID:     account.PhoneID,
@@ -827,10 +984,10 @@ func (a *App) SendMediaMessage(r *fastglue.Request) error {
 		AccessToken: account.AccessToken,
 	}
 
-	// Save locally first
-	localPath, err := a.saveMediaLocally(fileData, mimeType, fileHeader.Filename)
+	// Save to media storage first
+	localPath, err := a.saveMedia(r.RequestCtx, fileData, mimeType, fileHeader.Filename)
 	if err != nil {
-		a.Log.Error("Failed to save media locally", "error", err)
+		a.Log.Error("Failed to save media", "error", err)
 		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to save media", nil, "")
 	}
 
@@ -909,26 +1066,8 @@ func (a *App) SendMediaMessage(r *fastglue.Request) error {
 	return r.SendEnvelope(response)
 }
 
-// saveMediaLocally saves media data to local storage and returns the relative path
-func (a *App) saveMediaLocally(data []byte, mimeType, filename string) (string, error) {
-	// Determine subdirectory based on MIME type
-	var subdir string
-	switch {
-	case strings.HasPrefix(mimeType, "image/"):
-		subdir = "images"
-	case strings.HasPrefix(mimeType, "video/"):
-		subdir = "videos"
-	case strings.HasPrefix(mimeType, "audio/"):
-		subdir = "audio"
-	default:
-		subdir = "documents"
-	}
-
-	// Ensure directory exists
-	if err := a.ensureMediaDir(subdir); err != nil {
-		return "", fmt.Errorf("failed to create media directory: %w", err)
-	}
-
+// saveMedia saves media data to media storage and returns its key
+func (a *App) saveMedia(ctx context.Context, data []byte, mimeType, filename string) (string, error) {
 	// Get extension from MIME type or filename
 	ext := getExtensionFromMimeType(mimeType)
 	if ext == "" {
@@ -940,20 +1079,14 @@ func (a *App) saveMediaLocally(data []byte, mimeType, filename string) (string,
 		}
 	}
 
-	// Generate unique filename
-	newFilename := uuid.New().String() + ext
-	filePath := filepath.Join(a.getMediaStoragePath(), subdir, newFilename)
-
-	// Save file
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	key := mediaKey(mimeType, ext)
+	if err := a.Storage.Put(ctx, key, data, mimeType); err != nil {
 		return "", fmt.Errorf("failed to save media file: %w", err)
 	}
 
-	// Return relative path
-	relativePath := filepath.Join(subdir, newFilename)
-	a.Log.Info("Media saved locally", "path", relativePath, "size", len(data))
+	a.Log.Info("Media saved", "path", key, "size", len(data))
 
-	return relativePath, nil
+	return key, nil
 }
 
 // SendReactionRequest represents a request to send a reaction
@@ -1099,7 +1232,7 @@ func (a *App) sendWhatsAppReaction(account *models.WhatsAppAccount, contact *mod
 		return
 	}
 
-	url := fmt.Sprintf("https://graph.facebook.com/%s/%s/messages", account.APIVersion, account.PhoneID)
+	url := a.WhatsApp.GraphURL(&whatsapp.Account{APIVersion: account.APIVersion}, account.PhoneID+"/messages")
 
 	payload := map[string]any{
 		"messaging_product": "whatsapp",
@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+)
+
+// Modes of a flow's data-exchange endpoint
+const (
+	FlowEndpointStatic = "static" // Responses configured on the flow
+	FlowEndpointHTTP   = "http"   // Requests forwarded to an external API
+)
+
+// Status codes WhatsApp expects from a flow endpoint
+const (
+	statusFlowKeyMismatch      = 421 // Request could not be decrypted; WhatsApp refreshes the public key
+	statusFlowInvalidSignature = 432
+)
+
+const (
+	flowDataAPIVersion          = "3.0"
+	flowEndpointForwardTimeout  = 8 * time.Second // WhatsApp gives up on the endpoint after 10s
+	flowEndpointMaxResponseSize = 1 << 20
+)
+
+// FlowEndpointConfigRequest configures a flow's data-exchange endpoint
+type FlowEndpointConfigRequest struct {
+	Mode      string                 `json:"mode"`      // static, http, or empty to disable
+	URL       string                 `json:"url"`       // http
+	Secret    *string                `json:"secret"`    // http; signs forwarded requests
+	Responses map[string]interface{} `json:"responses"` // static; keyed by "action:screen" or action
+}
+
+// ConfigureFlowEndpoint sets how a flow's data-exchange requests are answered. The account's
+// key pair is generated on first use and its public key (re)uploaded to Meta, and flows
+// already saved to Meta get their endpoint URI set.
+func (a *App) ConfigureFlowEndpoint(r *fastglue.Request) error {
+	orgID, err := getOrganizationID(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	idStr := r.RequestCtx.UserValue("id").(string)
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid flow ID", nil, "")
+	}
+
+	var flow models.WhatsAppFlow
+	if err := a.DB.Where("id = ? AND organization_id = ?", id, orgID).First(&flow).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Flow not found", nil, "")
+	}
+
+	var req FlowEndpointConfigRequest
+	if err := r.Decode(&req, "json"); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
+	}
+
+	// Written through the struct so that the secret goes through its encrypted serializer,
+	// which column and map updates skip
+	flow.EndpointMode = req.Mode
+	columns := []string{"endpoint_mode"}
+	switch req.Mode {
+	case "":
+	case FlowEndpointStatic:
+		if req.Responses != nil {
+			flow.EndpointResponses = models.JSONB(req.Responses)
+			columns = append(columns, "endpoint_responses")
+		}
+	case FlowEndpointHTTP:
+		u, err := url.Parse(req.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "A valid http(s) url is required", nil, "")
+		}
+		flow.EndpointURL = req.URL
+		columns = append(columns, "endpoint_url")
+		if req.Secret != nil {
+			flow.EndpointSecret = *req.Secret
+			columns = append(columns, "endpoint_secret")
+		}
+	default:
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "mode must be static, http or empty", nil, "")
+	}
+
+	var account models.WhatsAppAccount
+	if err := a.DB.Where("organization_id = ? AND name = ?", orgID, flow.WhatsAppAccount).First(&account).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "WhatsApp account not found", nil, "")
+	}
+
+	if err := a.DB.Model(&flow).Select(columns).Updates(&flow).Error; err != nil {
+		a.Log.Error("Failed to configure flow endpoint", "error", err, "flow_id", id)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to configure flow endpoint", nil, "")
+	}
+	a.DB.First(&flow, id)
+
+	endpointURI := a.flowEndpointURI(r, flow.ID)
+	if req.Mode != "" {
+		waAccount := &whatsapp.Account{
+			PhoneID:     account.PhoneID,
+			BusinessID:  account.BusinessID,
+			APIVersion:  account.APIVersion,
+			AccessToken: account.AccessToken,
+		}
+		ctx := context.Background()
+
+		if err := a.uploadFlowPublicKey(ctx, &account, waAccount); err != nil {
+			a.Log.Error("Failed to upload flow endpoint public key", "error", err, "account", account.Name)
+			return r.SendErrorEnvelope(fasthttp.StatusBadGateway, "Failed to upload public key to Meta: "+err.Error(), nil, "")
+		}
+		if flow.MetaFlowID != "" {
+			if err := a.WhatsApp.SetFlowEndpoint(ctx, waAccount, flow.MetaFlowID, endpointURI); err != nil {
+				a.Log.Error("Failed to set flow endpoint in Meta", "error", err, "flow_id", id, "meta_flow_id", flow.MetaFlowID)
+				return r.SendErrorEnvelope(fasthttp.StatusBadGateway, "Failed to set flow endpoint in Meta: "+err.Error(), nil, "")
+			}
+		}
+	}
+
+	a.Log.Info("Flow endpoint configured", "flow_id", flow.ID, "mode", flow.EndpointMode)
+
+	return r.SendEnvelope(map[string]interface{}{
+		"flow":         flowToResponse(flow),
+		"endpoint_uri": endpointURI,
+	})
+}
+
+// uploadFlowPublicKey uploads the public key of the account's flow endpoint key pair to
+// Meta, generating the pair first if the account has none
+func (a *App) uploadFlowPublicKey(ctx context.Context, account *models.WhatsAppAccount, waAccount *whatsapp.Account) error {
+	var publicKey string
+	if account.FlowPrivateKey == "" {
+		privateKey, pub, err := whatsapp.GenerateFlowKeyPair()
+		if err != nil {
+			return err
+		}
+		// Through the struct, so that the key is encrypted like on other saves
+		account.FlowPrivateKey = privateKey
+		if err := a.DB.Model(account).Select("flow_private_key").Updates(account).Error; err != nil {
+			account.FlowPrivateKey = ""
+			return fmt.Errorf("failed to save key: %w", err)
+		}
+		publicKey = pub
+	} else {
+		pub, err := whatsapp.FlowPublicKey(account.FlowPrivateKey)
+		if err != nil {
+			return err
+		}
+		publicKey = pub
+	}
+	return a.WhatsApp.SetBusinessPublicKey(ctx, waAccount, publicKey)
+}
+
+// flowEndpointURI returns the public URI of a flow's data-exchange endpoint, from
+// server.root_url or else the host the request reached
+func (a *App) flowEndpointURI(r *fastglue.Request, flowID uuid.UUID) string {
+	root := strings.TrimRight(a.Config.Server.RootURL, "/")
+	if root == "" {
+		host := string(r.RequestCtx.Request.Header.Peek("X-Forwarded-Host"))
+		if host == "" {
+			host = string(r.RequestCtx.Host())
+		}
+		root = "https://" + host
+	}
+	return root + "/api/flow-endpoint/" + flowID.String()
+}
+
+// FlowDataExchange serves a flow's data-exchange requests from WhatsApp (public, the
+// requests are encrypted with the account's key pair and signed with the app secret)
+func (a *App) FlowDataExchange(r *fastglue.Request) error {
+	id, err := uuid.Parse(r.RequestCtx.UserValue("id").(string))
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Flow not found", nil, "")
+	}
+
+	var flow models.WhatsAppFlow
+	if err := a.DB.Where("id = ?", id).First(&flow).Error; err != nil || flow.EndpointMode == "" {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Flow not found", nil, "")
+	}
+
+	var account models.WhatsAppAccount
+	if err := a.DB.Where("organization_id = ? AND name = ?", flow.OrganizationID, flow.WhatsAppAccount).First(&account).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Flow not found", nil, "")
+	}
+
+	body := r.RequestCtx.PostBody()
+	signature := string(r.RequestCtx.Request.Header.Peek(whatsapp.SignatureHeader))
+	if !a.verifyFlowSignature(&account, body, signature) {
+		a.Log.Warn("Rejected flow request with invalid signature", "flow_id", flow.ID)
+		r.RequestCtx.SetStatusCode(statusFlowInvalidSignature)
+		return nil
+	}
+
+	req, flowCipher, err := whatsapp.DecryptFlowRequest(body, account.FlowPrivateKey)
+	if err != nil {
+		a.Log.Warn("Failed to decrypt flow request", "error", err, "flow_id", flow.ID)
+		r.RequestCtx.SetStatusCode(statusFlowKeyMismatch)
+		return nil
+	}
+
+	resp, err := a.flowEndpointResponse(&flow, req)
+	if err != nil {
+		a.Log.Error("Failed to answer flow request", "error", err, "flow_id", flow.ID, "action", req.Action, "screen", req.Screen)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to answer flow request", nil, "")
+	}
+
+	encrypted, err := flowCipher.EncryptResponse(resp)
+	if err != nil {
+		a.Log.Error("Failed to encrypt flow response", "error", err, "flow_id", flow.ID)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to answer flow request", nil, "")
+	}
+
+	r.RequestCtx.SetStatusCode(fasthttp.StatusOK)
+	r.RequestCtx.SetContentType("text/plain")
+	r.RequestCtx.SetBodyString(encrypted)
+	return nil
+}
+
+// verifyFlowSignature checks the X-Hub-Signature-256 header of a flow request against the
+// global app secret and the account's. Like webhooks, requests are rejected when neither is
+// set, unless signature verification is skipped outside production.
+func (a *App) verifyFlowSignature(account *models.WhatsAppAccount, body []byte, signature string) bool {
+	if a.Config.WhatsApp.SkipSignatureVerification && a.Config.App.Environment != "production" {
+		return true
+	}
+	return whatsapp.VerifySignature(body, signature, a.Config.WhatsApp.AppSecret) ||
+		whatsapp.VerifySignature(body, signature, account.AppSecret)
+}
+
+// flowEndpointResponse answers a decrypted data-exchange request. Health checks and error
+// notifications are answered directly; other actions per the flow's endpoint mode.
+func (a *App) flowEndpointResponse(flow *models.WhatsAppFlow, req *whatsapp.FlowEndpointRequest) (*whatsapp.FlowEndpointResponse, error) {
+	if req.Action == whatsapp.FlowActionPing {
+		return &whatsapp.FlowEndpointResponse{Data: map[string]interface{}{"status": "active"}}, nil
+	}
+	if req.IsErrorNotification() {
+		a.Log.Warn("Flow client reported an error", "flow_id", flow.ID, "screen", req.Screen, "error", req.Data["error"], "error_message", req.Data["error_message"])
+		return &whatsapp.FlowEndpointResponse{Data: map[string]interface{}{"acknowledged": true}}, nil
+	}
+
+	var resp *whatsapp.FlowEndpointResponse
+	var err error
+	switch flow.EndpointMode {
+	case FlowEndpointStatic:
+		resp, err = staticFlowResponse(flow, req)
+	case FlowEndpointHTTP:
+		resp, err = a.forwardFlowRequest(flow, req)
+	default:
+		err = fmt.Errorf("unknown endpoint mode %q", flow.EndpointMode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Completion responses must carry the flow token, which static responses cannot know
+	if ext, ok := resp.Data["extension_message_response"].(map[string]interface{}); ok {
+		if params, ok := ext["params"].(map[string]interface{}); ok && params["flow_token"] == nil {
+			params["flow_token"] = req.FlowToken
+		}
+	}
+	return resp, nil
+}
+
+// staticFlowResponse returns the response configured for the request's action and screen,
+// falling back to the one for its action
+func staticFlowResponse(flow *models.WhatsAppFlow, req *whatsapp.FlowEndpointRequest) (*whatsapp.FlowEndpointResponse, error) {
+	configured, ok := flow.EndpointResponses[req.Action+":"+req.Screen]
+	if !ok {
+		configured, ok = flow.EndpointResponses[req.Action]
+	}
+	if !ok {
+		return nil, fmt.Errorf("no response configured for %s on screen %q", req.Action, req.Screen)
+	}
+	return decodeFlowResponse(configured)
+}
+
+// forwardFlowRequest posts a decrypted request to the flow's external API and returns its
+// response. Requests are signed like outbound webhooks when the flow has a secret.
+func (a *App) forwardFlowRequest(flow *models.WhatsAppFlow, req *whatsapp.FlowEndpointRequest) (*whatsapp.FlowEndpointResponse, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flowEndpointForwardTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, flow.EndpointURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", "Whatomate-Flows/1.0")
+	httpReq.Header.Set("X-Whatomate-Flow-ID", flow.ID.String())
+	if flow.EndpointSecret != "" {
+		httpReq.Header.Set("X-Webhook-Signature", computeHMACSignature(payload, flow.EndpointSecret))
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, flowEndpointMaxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("flow endpoint returned status %d", resp.StatusCode)
+	}
+
+	var out whatsapp.FlowEndpointResponse
+	if err := json.Unmarshal(respBody, &out); err != nil {
+		return nil, fmt.Errorf("invalid response from flow endpoint: %w", err)
+	}
+	if out.Data == nil {
+		return nil, errors.New("flow endpoint response has no data")
+	}
+	return &out, nil
+}
+
+// decodeFlowResponse converts a configured {screen, data} response
+func decodeFlowResponse(v interface{}) (*whatsapp.FlowEndpointResponse, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var resp whatsapp.FlowEndpointResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("invalid configured response: %w", err)
+	}
+	if resp.Data == nil {
+		resp.Data = map[string]interface{}{}
+	}
+	return &resp, nil
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/isaee-xyz/whatomate/internal/config"
+	"github.com/isaee-xyz/whatomate/internal/models"
+)
+
+func TestVerifyFlowSignature(t *testing.T) {
+	body := []byte(`{"encrypted_flow_data":"x"}`)
+	sign := func(secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name          string
+		globalSecret  string
+		accountSecret string
+		skip          bool
+		environment   string
+		signature     string
+		want          bool
+	}{
+		{"no secret configured", "", "", false, "production", "", false},
+		{"no secret configured, signed", "", "", false, "production", sign(""), false},
+		{"skipped in development", "", "", true, "development", "", true},
+		{"skip ignored in production", "", "", true, "production", "", false},
+		{"global secret", "global", "", false, "production", sign("global"), true},
+		{"account secret", "global", "account", false, "production", sign("account"), true},
+		{"wrong secret", "global", "account", false, "production", sign("other"), false},
+		{"missing signature", "global", "account", false, "production", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &App{Config: &config.Config{}}
+			a.Config.App.Environment = tt.environment
+			a.Config.WhatsApp.AppSecret = tt.globalSecret
+			a.Config.WhatsApp.SkipSignatureVerification = tt.skip
+			account := &models.WhatsAppAccount{AppSecret: tt.accountSecret}
+			if got := a.verifyFlowSignature(account, body, tt.signature); got != tt.want {
+				t.Errorf("verifyFlowSignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,830 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/pkg/fakegraph"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxSimulationInputs caps the scripted inputs of a single simulation
+	maxSimulationInputs = 100
+	// maxSimulationDuration caps how long a simulation runs, and so holds its transaction open
+	maxSimulationDuration = 30 * time.Second
+)
+
+var (
+	// errSimulatedFlowNotFound is returned when the flow to simulate does not exist
+	errSimulatedFlowNotFound = errors.New("flow not found")
+	// errSimulationTimeout is returned when a simulation runs past its deadline
+	errSimulationTimeout = errors.New("simulation timed out")
+)
+
+// SimulateFlowRequest is a scripted conversation to run through the chatbot. The flow
+// given by flow_id or flow is started before the first input; without either, the
+// inputs go through keyword rules and flow triggers like real messages.
+type SimulateFlowRequest struct {
+	WhatsAppAccount   string           `json:"whatsapp_account"` // Account name; the oldest account when empty
+	FlowID            string           `json:"flow_id"`          // Saved flow, enabled or not
+	Version           int              `json:"version"`          // Version of the saved flow; its draft when 0
+	Flow              *SimulatedFlow   `json:"flow"`             // Unsaved flow definition
+	Contact           SimulatedContact `json:"contact"`
+	Inputs            []SimulatedInput `json:"inputs"`
+	Mocks             []SimulationMock `json:"mocks"`
+	AllowLiveRequests bool             `json:"allow_live_requests"` // Send HTTP requests that no mock matches
+}
+
+// SimulatedFlow is an unsaved flow definition, as sent to create a flow
+type SimulatedFlow struct {
+	Name              string                 `json:"name"`
+	InitialMessage    string                 `json:"initial_message"`
+	CompletionMessage string                 `json:"completion_message"`
+	OnCompleteAction  string                 `json:"on_complete_action"`
+	CompletionConfig  map[string]interface{} `json:"completion_config"`
+	CancelKeywords    []string               `json:"cancel_keywords"`
+	Steps             []FlowStepRequest      `json:"steps"`
+}
+
+// SimulatedContact is the contact the simulated messages come from
+type SimulatedContact struct {
+	PhoneNumber string `json:"phone_number"` // A random number when empty
+	Name        string `json:"name"`
+	Locale      string `json:"locale"`
+}
+
+// SimulatedInput is a message from the simulated contact and what the chatbot should
+// do with it
+type SimulatedInput struct {
+	Text         string                 `json:"text,omitempty"`
+	ButtonID     string                 `json:"button_id,omitempty"`     // Button or list reply; text is its title
+	FlowResponse map[string]interface{} `json:"flow_response,omitempty"` // WhatsApp Flow response; flow_token defaults to the current step's
+	Expect       *SimulationExpectation `json:"expect,omitempty"`
+}
+
+// SimulationExpectation is checked after an input is processed
+type SimulationExpectation struct {
+	Step      *string                `json:"step,omitempty"`      // Current step; empty when no flow is active
+	Messages  []string               `json:"messages,omitempty"`  // Texts that the sent messages must contain
+	Variables map[string]interface{} `json:"variables,omitempty"` // Session variables and their values
+	Transfer  *bool                  `json:"transfer,omitempty"`  // Whether the input transferred the contact to agents
+}
+
+// SimulationMock answers outgoing HTTP requests (API steps, completion webhooks, AI
+// providers) during a simulation
+type SimulationMock struct {
+	Method  string            `json:"method"` // Any method when empty
+	URL     string            `json:"url"`    // Exact URL, or a prefix when it ends with *
+	Status  int               `json:"status"` // 200 when empty
+	Body    interface{}       `json:"body"`   // Sent as is when a string, as JSON otherwise
+	Headers map[string]string `json:"headers"`
+}
+
+// SimulationResult is the outcome of a simulation, one turn per input. The first turn
+// has no input when the simulation starts a flow.
+type SimulationResult struct {
+	Passed bool             `json:"passed"`
+	Turns  []SimulationTurn `json:"turns"`
+}
+
+// SimulationTurn is what the chatbot did in response to an input
+type SimulationTurn struct {
+	Input     *SimulatedInput     `json:"input,omitempty"`
+	Messages  []SimulatedMessage  `json:"messages"`
+	Requests  []SimulatedRequest  `json:"requests"`
+	Transfers []SimulatedTransfer `json:"transfers"`
+	Events    []SimulatedEvent    `json:"events"`
+	State     SimulationState     `json:"state"`
+	Failures  []string            `json:"failures,omitempty"`
+}
+
+// SimulatedMessage is a message the chatbot sent to the contact
+type SimulatedMessage struct {
+	Type    string                 `json:"type"`
+	Text    string                 `json:"text,omitempty"`
+	Buttons []SimulatedButton      `json:"buttons,omitempty"` // Reply buttons and list rows
+	Payload map[string]interface{} `json:"payload"`           // Body sent to the Cloud API
+}
+
+// SimulatedButton is a reply button or list row of a sent message
+type SimulatedButton struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// SimulatedRequest is an outgoing HTTP request, with credentials redacted
+type SimulatedRequest struct {
+	Method   string            `json:"method"`
+	URL      string            `json:"url"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Body     string            `json:"body,omitempty"`
+	Mocked   bool              `json:"mocked"`
+	Status   int               `json:"status,omitempty"`
+	Response string            `json:"response,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// SimulatedTransfer is an agent transfer created during a turn
+type SimulatedTransfer struct {
+	Source string `json:"source"`
+	TeamID string `json:"team_id,omitempty"`
+	Notes  string `json:"notes,omitempty"`
+}
+
+// SimulatedEvent is an outbound webhook event the turn would have dispatched
+type SimulatedEvent struct {
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+// SimulationState is the contact's chatbot session after a turn
+type SimulationState struct {
+	FlowID        string            `json:"flow_id,omitempty"`
+	FlowName      string            `json:"flow_name,omitempty"`
+	FlowVersion   int               `json:"flow_version,omitempty"`
+	Step          string            `json:"step"`
+	SessionStatus string            `json:"session_status,omitempty"`
+	Variables     models.JSONB      `json:"variables"`
+	CallStack     models.JSONBArray `json:"call_stack,omitempty"`
+}
+
+// flowSimulation captures what the chatbot does while a simulation runs. The simulated
+// app works in a database transaction that is rolled back, sends WhatsApp messages to
+// an in-process fake Graph API and answers other HTTP requests from the mocks.
+type flowSimulation struct {
+	ctx       context.Context // Done when the simulation runs out of time
+	graph     *fakegraph.Server
+	mocks     []SimulationMock
+	allowLive bool
+	flows     map[uuid.UUID]*models.ChatbotFlow // Flows served regardless of the flows cache
+
+	mu       sync.Mutex
+	requests []SimulatedRequest
+	events   []SimulatedEvent
+}
+
+// recordEvent records a webhook event instead of dispatching it
+func (s *flowSimulation) recordEvent(eventType string, data interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, SimulatedEvent{Event: eventType, Data: data})
+}
+
+func (s *flowSimulation) recordRequest(req SimulatedRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req)
+}
+
+// findMock returns the first mock matching a request
+func (s *flowSimulation) findMock(method, rawURL string) *SimulationMock {
+	for i := range s.mocks {
+		mock := &s.mocks[i]
+		if mock.Method != "" && !strings.EqualFold(mock.Method, method) {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(mock.URL, "*"); ok {
+			if strings.HasPrefix(rawURL, prefix) {
+				return mock
+			}
+		} else if mock.URL == rawURL {
+			return mock
+		}
+	}
+	return nil
+}
+
+// outboundHTTPClient returns the client for requests the chatbot makes to external APIs
+// and AI providers. During a simulation they are answered from its mocks.
+func (a *App) outboundHTTPClient(timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+	if a.simulation != nil {
+		client.Transport = simulationTransport{sim: a.simulation}
+	}
+	return client
+}
+
+// simulationTransport records outgoing requests and answers them from the mocks, or
+// sends them when live requests are allowed
+type simulationTransport struct {
+	sim *flowSimulation
+}
+
+func (t simulationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.sim.ctx.Err(); err != nil {
+		return nil, fmt.Errorf("simulation: %w", err)
+	}
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	record := SimulatedRequest{
+		Method:  req.Method,
+		URL:     redactURL(req.URL),
+		Headers: redactHeaders(req.Header),
+		Body:    string(body),
+	}
+
+	if mock := t.sim.findMock(req.Method, req.URL.String()); mock != nil {
+		status := mock.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		respBody := mockBody(mock.Body)
+		header := make(http.Header)
+		for key, value := range mock.Headers {
+			header.Set(key, value)
+		}
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "application/json")
+		}
+		record.Mocked = true
+		record.Status = status
+		record.Response = respBody
+		t.sim.recordRequest(record)
+		return &http.Response{
+			StatusCode:    status,
+			Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(strings.NewReader(respBody)),
+			ContentLength: int64(len(respBody)),
+			Request:       req,
+		}, nil
+	}
+
+	if !t.sim.allowLive {
+		record.Error = "no mock matches this request"
+		t.sim.recordRequest(record)
+		return nil, fmt.Errorf("simulation: no mock for %s %s", req.Method, record.URL)
+	}
+	// Live requests end with the simulation; the body is read before returning
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	stop := context.AfterFunc(t.sim.ctx, cancel)
+	defer stop()
+	resp, err := http.DefaultTransport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		record.Error = err.Error()
+		t.sim.recordRequest(record)
+		return nil, err
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	record.Status = resp.StatusCode
+	record.Response = string(respBody)
+	t.sim.recordRequest(record)
+	return resp, nil
+}
+
+// handlerTransport serves requests with an in-process handler
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// mockBody returns the body of a mocked response
+func mockBody(body interface{}) string {
+	switch b := body.(type) {
+	case nil:
+		return ""
+	case string:
+		return b
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// isSecretName reports whether a header or query parameter likely carries a credential
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"authorization", "cookie", "key", "token", "secret", "password"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactHeaders(header http.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(header))
+	for key := range header {
+		if isSecretName(key) {
+			headers[key] = "REDACTED"
+		} else {
+			headers[key] = header.Get(key)
+		}
+	}
+	return headers
+}
+
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	query := redacted.Query()
+	changed := false
+	for key := range query {
+		if isSecretName(key) {
+			query.Set(key, "REDACTED")
+			changed = true
+		}
+	}
+	if changed {
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.String()
+}
+
+// SimulateChatbotFlow runs a scripted conversation through the chatbot without messaging
+// anyone: nothing is saved, WhatsApp messages go to a fake Cloud API, webhooks are
+// recorded instead of sent and outgoing HTTP requests are answered from mocks
+func (a *App) SimulateChatbotFlow(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	var req SimulateFlowRequest
+	if err := json.Unmarshal(r.RequestCtx.PostBody(), &req); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
+	}
+	if req.FlowID == "" && req.Flow == nil && len(req.Inputs) == 0 {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "flow_id, flow or inputs is required", nil, "")
+	}
+	if req.Version > 0 && req.FlowID == "" {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "version needs flow_id", nil, "")
+	}
+	if len(req.Inputs) > maxSimulationInputs {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, fmt.Sprintf("At most %d inputs are allowed", maxSimulationInputs), nil, "")
+	}
+	for i, input := range req.Inputs {
+		if input.Text == "" && input.ButtonID == "" && input.FlowResponse == nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, fmt.Sprintf("Input %d needs text, button_id or flow_response", i+1), nil, "")
+		}
+	}
+	if req.Flow != nil {
+		if err := validateFlowSteps(req.Flow.Steps); err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+		}
+		steps := flowStepsFromRequest(req.Flow.Steps)
+		if problems := validateFlowGraph(uuid.Nil, steps, a.flowStepsFunc(orgID)); len(problems) > 0 {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid flow: "+strings.Join(problems, "; "), map[string]interface{}{"problems": problems}, "")
+		}
+	}
+
+	query := a.DB.Where("organization_id = ?", orgID)
+	if req.WhatsAppAccount != "" {
+		query = query.Where("name = ?", req.WhatsAppAccount)
+	}
+	var account models.WhatsAppAccount
+	if err := query.Order("created_at ASC").First(&account).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "WhatsApp account not found", nil, "")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), maxSimulationDuration)
+	defer cancel()
+	result, err := a.runFlowSimulation(ctx, orgID, &account, &req)
+	if errors.Is(err, errSimulatedFlowNotFound) {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Flow not found", nil, "")
+	}
+	if errors.Is(err, errSimulationTimeout) {
+		return r.SendErrorEnvelope(fasthttp.StatusUnprocessableEntity, fmt.Sprintf("Simulation did not finish within %s", maxSimulationDuration), nil, "")
+	}
+	if err != nil {
+		a.Log.Error("Flow simulation failed", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to run simulation", nil, "")
+	}
+	return r.SendEnvelope(result)
+}
+
+// runFlowSimulation runs the inputs on a copy of the app whose database work happens in a
+// transaction that is rolled back when the simulation ends. Once ctx is done, database
+// and HTTP work fails and errSimulationTimeout is returned.
+func (a *App) runFlowSimulation(ctx context.Context, orgID uuid.UUID, account *models.WhatsAppAccount, req *SimulateFlowRequest) (*SimulationResult, error) {
+	result, err := a.simulate(ctx, orgID, account, req)
+	if ctx.Err() != nil {
+		// The chatbot logs and moves on from most database errors, so whatever was
+		// collected after the deadline is incomplete
+		return nil, errSimulationTimeout
+	}
+	return result, err
+}
+
+// simulate does the work of runFlowSimulation
+func (a *App) simulate(ctx context.Context, orgID uuid.UUID, account *models.WhatsAppAccount, req *SimulateFlowRequest) (*SimulationResult, error) {
+	tx := a.DB.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	defer tx.Rollback()
+
+	sim := &flowSimulation{
+		ctx:       ctx,
+		graph:     fakegraph.New(fakegraph.Options{}),
+		mocks:     req.Mocks,
+		allowLive: req.AllowLiveRequests,
+		flows:     map[uuid.UUID]*models.ChatbotFlow{},
+	}
+	waClient := *a.WhatsApp
+	waClient.HTTPClient = &http.Client{Transport: handlerTransport{handler: sim.graph}}
+	waClient.MaxRetries = 0
+
+	simApp := *a
+	simApp.DB = tx
+	simApp.WSHub = nil
+	simApp.WhatsApp = &waClient
+	simApp.simulation = sim
+
+	flow, err := simApp.simulatedFlow(orgID, account, req)
+	if err != nil {
+		return nil, err
+	}
+	if flow != nil {
+		sim.flows[flow.ID] = flow
+	}
+
+	phone := req.Contact.PhoneNumber
+	if phone == "" {
+		phone = fmt.Sprintf("1555%07d", rand.Intn(10000000))
+	}
+	name := req.Contact.Name
+	if name == "" {
+		name = "Simulator"
+	}
+	contact, _, err := simApp.getOrCreateContact(orgID, phone, name)
+	if err != nil {
+		return nil, err
+	}
+	if req.Contact.Locale != "" {
+		tx.Model(contact).Update("locale", req.Contact.Locale)
+		contact.Locale = req.Contact.Locale
+	}
+
+	turns := &simulationTurns{app: &simApp, sim: sim, contact: contact, account: account}
+	result := &SimulationResult{Passed: true}
+
+	if flow != nil {
+		// Cached like processIncomingMessageFull does, so the session is found again
+		waAccount, err := simApp.getWhatsAppAccountCached(account.PhoneID)
+		if err != nil {
+			return nil, err
+		}
+		timeoutMins := 30
+		if settings, err := simApp.getChatbotSettingsCached(orgID, account.Name); err == nil {
+			timeoutMins = settings.SessionTimeoutMins
+		}
+		session, _ := simApp.getOrCreateSession(orgID, contact.ID, account.Name, contact.PhoneNumber, timeoutMins)
+		simApp.startFlow(waAccount, session, contact, flow)
+		result.Turns = append(result.Turns, turns.end(nil))
+	}
+
+	for i := range req.Inputs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		input := &req.Inputs[i]
+		msg, err := turns.message(i, input)
+		if err != nil {
+			return nil, err
+		}
+		if err := simApp.processIncomingMessageFull(account.PhoneID, msg, contact.ProfileName); err != nil {
+			return nil, err
+		}
+		turn := turns.end(input)
+		if input.Expect != nil {
+			turn.Failures = input.Expect.check(&turn)
+		}
+		if len(turn.Failures) > 0 {
+			result.Passed = false
+		}
+		result.Turns = append(result.Turns, turn)
+	}
+	return result, nil
+}
+
+// simulatedFlow loads the saved flow to simulate, or saves the unsaved one in the
+// simulation's transaction so sessions can refer to it
+func (a *App) simulatedFlow(orgID uuid.UUID, account *models.WhatsAppAccount, req *SimulateFlowRequest) (*models.ChatbotFlow, error) {
+	if req.Flow != nil {
+		def := req.Flow
+		name := def.Name
+		if name == "" {
+			name = "Simulated flow"
+		}
+		flow := models.ChatbotFlow{
+			BaseModel:         models.BaseModel{ID: uuid.New()},
+			OrganizationID:    orgID,
+			WhatsAppAccount:   account.Name,
+			Name:              name,
+			InitialMessage:    def.InitialMessage,
+			CompletionMessage: def.CompletionMessage,
+			OnCompleteAction:  def.OnCompleteAction,
+			CompletionConfig:  models.JSONB(def.CompletionConfig),
+			CancelKeywords:    def.CancelKeywords,
+		}
+		if err := a.DB.Create(&flow).Error; err != nil {
+			return nil, err
+		}
+		// Disabled so that its triggers stay out of the flows cache
+		if err := a.DB.Model(&flow).Update("is_enabled", false).Error; err != nil {
+			return nil, err
+		}
+		flow.IsEnabled = false
+		for _, step := range flowStepsFromRequest(def.Steps) {
+			step.FlowID = flow.ID
+			if err := a.DB.Create(&step).Error; err != nil {
+				return nil, err
+			}
+			flow.Steps = append(flow.Steps, step)
+		}
+		return &flow, nil
+	}
+
+	if req.FlowID == "" {
+		return nil, nil
+	}
+	flowID, err := uuid.Parse(req.FlowID)
+	if err != nil {
+		return nil, errSimulatedFlowNotFound
+	}
+	var flow models.ChatbotFlow
+	if err := a.DB.Where("id = ? AND organization_id = ?", flowID, orgID).
+		Preload("Steps", func(db *gorm.DB) *gorm.DB {
+			return db.Order("step_order ASC")
+		}).
+		First(&flow).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errSimulatedFlowNotFound
+		}
+		return nil, err
+	}
+	// The draft or the requested version runs, whatever the flow's rollout
+	flow.RolloutVersion = 0
+	if req.Version == 0 {
+		return &flow, nil
+	}
+	var version models.ChatbotFlowVersion
+	if err := a.DB.Where("flow_id = ? AND version = ?", flow.ID, req.Version).First(&version).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errSimulatedFlowNotFound
+		}
+		return nil, err
+	}
+	versioned := chatbotFlowFromVersion(&flow, &version)
+	return &versioned, nil
+}
+
+// simulationTurns collects what happened since the previous turn
+type simulationTurns struct {
+	app     *App
+	sim     *flowSimulation
+	contact *models.Contact
+	account *models.WhatsAppAccount
+
+	messages  int
+	requests  int
+	events    int
+	transfers int
+}
+
+// message builds the webhook message of an input
+func (t *simulationTurns) message(index int, input *SimulatedInput) (IncomingTextMessage, error) {
+	raw := map[string]interface{}{
+		"from":      t.contact.PhoneNumber,
+		"id":        fmt.Sprintf("wamid.SIMULATED%d%d", time.Now().UnixNano(), index),
+		"timestamp": fmt.Sprintf("%d", time.Now().Unix()),
+	}
+	switch {
+	case input.FlowResponse != nil:
+		response := make(map[string]interface{}, len(input.FlowResponse)+1)
+		for key, value := range input.FlowResponse {
+			response[key] = value
+		}
+		if _, ok := response["flow_token"]; !ok {
+			if session := t.session(); session != nil && session.CurrentStep != "" {
+				response["flow_token"] = chatbotFlowToken(session.ID, session.CurrentStep)
+			}
+		}
+		responseJSON, err := json.Marshal(response)
+		if err != nil {
+			return IncomingTextMessage{}, err
+		}
+		raw["type"] = "interactive"
+		raw["interactive"] = map[string]interface{}{
+			"type": "nfm_reply",
+			"nfm_reply": map[string]interface{}{
+				"name":          "flow",
+				"body":          input.Text,
+				"response_json": string(responseJSON),
+			},
+		}
+	case input.ButtonID != "":
+		title := input.Text
+		if title == "" {
+			title = input.ButtonID
+		}
+		raw["type"] = "interactive"
+		raw["interactive"] = map[string]interface{}{
+			"type":         "button_reply",
+			"button_reply": map[string]string{"id": input.ButtonID, "title": title},
+		}
+	default:
+		raw["type"] = "text"
+		raw["text"] = map[string]string{"body": input.Text}
+	}
+
+	var msg IncomingTextMessage
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return msg, err
+	}
+	err = json.Unmarshal(data, &msg)
+	return msg, err
+}
+
+// session returns the contact's latest chatbot session
+func (t *simulationTurns) session() *models.ChatbotSession {
+	var session models.ChatbotSession
+	if err := t.app.DB.Where("contact_id = ? AND whats_app_account = ?", t.contact.ID, t.account.Name).
+		Order("updated_at DESC").
+		First(&session).Error; err != nil {
+		return nil
+	}
+	return &session
+}
+
+// end collects the messages, requests, events and transfers since the previous turn and
+// the session state
+func (t *simulationTurns) end(input *SimulatedInput) SimulationTurn {
+	turn := SimulationTurn{
+		Input:     input,
+		Messages:  []SimulatedMessage{},
+		Requests:  []SimulatedRequest{},
+		Transfers: []SimulatedTransfer{},
+		Events:    []SimulatedEvent{},
+	}
+
+	sent := t.sim.graph.Messages()
+	for _, m := range sent[t.messages:] {
+		turn.Messages = append(turn.Messages, simulatedMessage(m))
+	}
+	t.messages = len(sent)
+
+	t.sim.mu.Lock()
+	turn.Requests = append(turn.Requests, t.sim.requests[t.requests:]...)
+	turn.Events = append(turn.Events, t.sim.events[t.events:]...)
+	t.requests = len(t.sim.requests)
+	t.events = len(t.sim.events)
+	t.sim.mu.Unlock()
+
+	var transfers []models.AgentTransfer
+	t.app.DB.Where("contact_id = ?", t.contact.ID).Order("created_at ASC").Find(&transfers)
+	if t.transfers < len(transfers) {
+		for _, transfer := range transfers[t.transfers:] {
+			st := SimulatedTransfer{Source: transfer.Source, Notes: transfer.Notes}
+			if transfer.TeamID != nil {
+				st.TeamID = transfer.TeamID.String()
+			}
+			turn.Transfers = append(turn.Transfers, st)
+		}
+	}
+	t.transfers = len(transfers)
+
+	turn.State.Variables = models.JSONB{}
+	if session := t.session(); session != nil {
+		turn.State.SessionStatus = session.Status
+		turn.State.Step = session.CurrentStep
+		if session.SessionData != nil {
+			turn.State.Variables = session.SessionData
+		}
+		turn.State.CallStack = session.CallStack
+		if session.CurrentFlowID != nil {
+			turn.State.FlowID = session.CurrentFlowID.String()
+			turn.State.FlowVersion = session.FlowVersion
+			if flow, err := t.app.getChatbotFlowVersionCached(t.contact.OrganizationID, *session.CurrentFlowID, session.FlowVersion); err == nil {
+				turn.State.FlowName = flow.Name
+			}
+		} else {
+			turn.State.Step = ""
+		}
+	}
+	return turn
+}
+
+// simulatedMessage extracts the text and buttons of a message sent to the fake Cloud API
+func simulatedMessage(m fakegraph.Message) SimulatedMessage {
+	msg := SimulatedMessage{Type: m.Type, Payload: m.Payload}
+	content, _ := m.Payload[m.Type].(map[string]interface{})
+	switch m.Type {
+	case "text":
+		msg.Text, _ = content["body"].(string)
+	case "interactive":
+		if body, ok := content["body"].(map[string]interface{}); ok {
+			msg.Text, _ = body["text"].(string)
+		}
+		action, _ := content["action"].(map[string]interface{})
+		if buttons, ok := action["buttons"].([]interface{}); ok {
+			for _, b := range buttons {
+				button, _ := b.(map[string]interface{})
+				reply, _ := button["reply"].(map[string]interface{})
+				id, _ := reply["id"].(string)
+				title, _ := reply["title"].(string)
+				msg.Buttons = append(msg.Buttons, SimulatedButton{ID: id, Title: title})
+			}
+		}
+		if sections, ok := action["sections"].([]interface{}); ok {
+			for _, s := range sections {
+				section, _ := s.(map[string]interface{})
+				rows, _ := section["rows"].([]interface{})
+				for _, r := range rows {
+					row, _ := r.(map[string]interface{})
+					id, _ := row["id"].(string)
+					title, _ := row["title"].(string)
+					msg.Buttons = append(msg.Buttons, SimulatedButton{ID: id, Title: title})
+				}
+			}
+		}
+	default:
+		msg.Text, _ = content["caption"].(string)
+	}
+	return msg
+}
+
+// check returns the ways a turn does not meet the expectation
+func (e *SimulationExpectation) check(turn *SimulationTurn) []string {
+	var failures []string
+	if e.Step != nil && *e.Step != turn.State.Step {
+		failures = append(failures, fmt.Sprintf("expected step %q, got %q", *e.Step, turn.State.Step))
+	}
+	for _, want := range e.Messages {
+		found := false
+		for _, msg := range turn.Messages {
+			if strings.Contains(msg.Text, want) {
+				found = true
+			}
+			for _, button := range msg.Buttons {
+				if strings.Contains(button.Title, want) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			failures = append(failures, fmt.Sprintf("no message contains %q", want))
+		}
+	}
+	for name, want := range e.Variables {
+		got, ok := turn.State.Variables[name]
+		if !ok {
+			failures = append(failures, fmt.Sprintf("variable %s is not set", name))
+			continue
+		}
+		wantStr, _ := sessionValueString(want)
+		gotStr, _ := sessionValueString(got)
+		if wantStr != gotStr {
+			failures = append(failures, fmt.Sprintf("expected variable %s to be %q, got %q", name, wantStr, gotStr))
+		}
+	}
+	if e.Transfer != nil {
+		transferred := len(turn.Transfers) > 0
+		if *e.Transfer && !transferred {
+			failures = append(failures, "expected a transfer to agents")
+		} else if !*e.Transfer && transferred {
+			failures = append(failures, "expected no transfer to agents")
+		}
+	}
+	return failures
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/valyala/fasthttp"
+)
+
+func TestSimulationTransport(t *testing.T) {
+	sim := &flowSimulation{
+		ctx: context.Background(),
+		mocks: []SimulationMock{
+			{Method: "GET", URL: "https://api.example.com/orders/*", Body: map[string]string{"status": "shipped"}},
+			{URL: "https://api.example.com/fail", Status: http.StatusBadGateway, Body: "down"},
+		},
+	}
+	client := &http.Client{Transport: simulationTransport{sim: sim}}
+
+	resp, err := client.Get("https://api.example.com/orders/42?api_key=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != `{"status":"shipped"}` {
+		t.Errorf("prefix mock = %d %s", resp.StatusCode, body)
+	}
+
+	resp, err = client.Post("https://api.example.com/fail", "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("exact mock status = %d, want 502", resp.StatusCode)
+	}
+
+	if _, err := client.Get("https://api.example.com/unmocked"); err == nil {
+		t.Error("unmocked request succeeded without allow_live_requests")
+	}
+
+	if len(sim.requests) != 3 {
+		t.Fatalf("recorded %d requests, want 3", len(sim.requests))
+	}
+	if got := sim.requests[0].URL; got != "https://api.example.com/orders/42?api_key=REDACTED" {
+		t.Errorf("recorded URL = %s, want the key redacted", got)
+	}
+	if !sim.requests[0].Mocked || sim.requests[2].Mocked || sim.requests[2].Error == "" {
+		t.Errorf("recorded requests = %+v", sim.requests)
+	}
+}
+
+func TestSimulationTransportDeadline(t *testing.T) {
+	// A live API that answers only once the request is cancelled
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	sim := &flowSimulation{ctx: ctx, allowLive: true}
+	client := &http.Client{Transport: simulationTransport{sim: sim}, Timeout: 30 * time.Second}
+
+	start := time.Now()
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatal("live request succeeded past the simulation's deadline")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("live request took %s, want it cut off at the simulation's deadline", elapsed)
+	}
+
+	// Once the deadline has passed, even mocked requests fail
+	sim.mocks = []SimulationMock{{URL: srv.URL}}
+	if _, err := client.Get(srv.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestE2ESimulateFlow(t *testing.T) {
+	f := newE2EFixture(t)
+	phone := fmt.Sprintf("1555%07d", time.Now().UnixNano()%10000000)
+
+	step := "confirm"
+	r := f.request(SimulateFlowRequest{
+		Flow: &SimulatedFlow{
+			Name: "Order status",
+			Steps: []FlowStepRequest{
+				{StepName: "ask_order", Message: "Your order number?", MessageType: "text", InputType: "text", StoreAs: "order_id"},
+				{StepName: "confirm", Message: "Checking order {{order_id}}", MessageType: "text", InputType: "text"},
+			},
+		},
+		Contact: SimulatedContact{PhoneNumber: phone},
+		Inputs: []SimulatedInput{
+			{Text: "12345", Expect: &SimulationExpectation{Step: &step, Messages: []string{"Checking order 12345"}, Variables: map[string]interface{}{"order_id": "12345"}}},
+		},
+	}, nil)
+	if err := f.app.SimulateChatbotFlow(r); err != nil {
+		t.Fatal(err)
+	}
+	if code := r.RequestCtx.Response.StatusCode(); code != fasthttp.StatusOK {
+		t.Fatalf("status = %d, body %s", code, r.RequestCtx.Response.Body())
+	}
+
+	var envelope struct {
+		Data SimulationResult `json:"data"`
+	}
+	if err := json.Unmarshal(r.RequestCtx.Response.Body(), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	result := envelope.Data
+	if !result.Passed || len(result.Turns) != 2 {
+		t.Fatalf("result = %+v, want 2 passing turns", result)
+	}
+	if msgs := result.Turns[0].Messages; len(msgs) != 1 || msgs[0].Text != "Your order number?" {
+		t.Errorf("first turn messages = %+v", msgs)
+	}
+
+	// Nothing the simulation did is kept
+	var count int64
+	e2eDB.Model(&models.Contact{}).Where("organization_id = ? AND phone_number = ?", f.org.ID, phone).Count(&count)
+	if count != 0 {
+		t.Errorf("simulated contact was saved")
+	}
+	if n := len(f.graph.Messages()); n != 0 {
+		t.Errorf("real graph received %d messages, want none", n)
+	}
+}
+
+func TestE2ESimulateFlowTimeout(t *testing.T) {
+	f := newE2EFixture(t)
+
+	// An API step whose live API never answers
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	req := &SimulateFlowRequest{
+		Flow: &SimulatedFlow{
+			Steps: []FlowStepRequest{
+				{StepName: "fetch", MessageType: "api_fetch", InputType: "none", ApiConfig: map[string]interface{}{"url": srv.URL}},
+				{StepName: "ask", Message: "Anything else?", MessageType: "text", InputType: "text"},
+			},
+		},
+		Inputs:            []SimulatedInput{{Text: "no"}},
+		AllowLiveRequests: true,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := f.app.runFlowSimulation(ctx, f.org.ID, &f.account, req); !errors.Is(err, errSimulationTimeout) {
+		t.Fatalf("error = %v, want errSimulationTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("simulation took %s, want it stopped at its deadline", elapsed)
+	}
+
+	var count int64
+	e2eDB.Model(&models.ChatbotFlow{}).Where("organization_id = ?", f.org.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("simulated flow was saved")
+	}
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
+)
+
+// chatbotFlowTokenPrefix marks the flow tokens of WhatsApp Flows opened by chatbot flow steps
+const chatbotFlowTokenPrefix = "chatbot"
+
+// flowSubmission is a WhatsApp Flow response (nfm_reply) and what its flow token points to
+type flowSubmission struct {
+	Token        string
+	Response     map[string]interface{} // Submitted fields, without the flow token
+	Session      *models.ChatbotSession // Set when the flow was opened by a chatbot flow step
+	ChatbotFlow  *models.ChatbotFlow
+	Step         *models.ChatbotFlowStep
+	WhatsAppFlow *models.WhatsAppFlow
+	Err          error // Why the response does not match the WhatsApp Flow's screens
+}
+
+// chatbotFlowToken returns the flow token of the WhatsApp Flow sent by a session's step
+func chatbotFlowToken(sessionID uuid.UUID, stepName string) string {
+	return chatbotFlowTokenPrefix + ":" + sessionID.String() + ":" + stepName
+}
+
+// parseChatbotFlowToken returns the session and step a flow token was issued for
+func parseChatbotFlowToken(token string) (uuid.UUID, string, bool) {
+	parts := strings.SplitN(token, ":", 3)
+	if len(parts) != 3 || parts[0] != chatbotFlowTokenPrefix || parts[2] == "" {
+		return uuid.Nil, "", false
+	}
+	sessionID, err := uuid.Parse(parts[1])
+	if err != nil {
+		return uuid.Nil, "", false
+	}
+	return sessionID, parts[2], true
+}
+
+// expectsFlowResponse reports whether a step waits for the response of a WhatsApp Flow
+func expectsFlowResponse(step *models.ChatbotFlowStep) bool {
+	return step.MessageType == "whatsapp_flow" || step.InputType == "whatsapp_flow"
+}
+
+// findWhatsAppFlow loads a WhatsApp Flow of the organization by its ID or Meta flow ID
+func (a *App) findWhatsAppFlow(orgID uuid.UUID, flowID string) (*models.WhatsAppFlow, error) {
+	query := a.DB.Where("organization_id = ?", orgID)
+	if id, err := uuid.Parse(flowID); err == nil {
+		query = query.Where("id = ?", id)
+	} else {
+		query = query.Where("meta_flow_id = ?", flowID)
+	}
+	var flow models.WhatsAppFlow
+	if err := query.First(&flow).Error; err != nil {
+		return nil, err
+	}
+	return &flow, nil
+}
+
+// resolveFlowSubmission parses a flow response and, when its token was issued by a chatbot
+// flow step, loads the session and step and validates the response against the screens
+// of the step's WhatsApp Flow
+func (a *App) resolveFlowSubmission(account *models.WhatsAppAccount, responseJSON string) *flowSubmission {
+	response, err := whatsapp.ParseFlowResponse(responseJSON)
+	if err != nil {
+		return &flowSubmission{Response: map[string]interface{}{}, Err: err}
+	}
+	sub := &flowSubmission{Response: response}
+	sub.Token, _ = response["flow_token"].(string)
+	delete(response, "flow_token")
+
+	sessionID, stepName, ok := parseChatbotFlowToken(sub.Token)
+	if !ok {
+		return sub
+	}
+	var session models.ChatbotSession
+	if err := a.DB.Where("id = ? AND organization_id = ?", sessionID, account.OrganizationID).First(&session).Error; err != nil {
+		return sub
+	}
+	sub.Session = &session
+	if session.CurrentFlowID == nil {
+		return sub
+	}
+	flow, err := a.getChatbotFlowVersionCached(account.OrganizationID, *session.CurrentFlowID, session.FlowVersion)
+	if err != nil {
+		return sub
+	}
+	sub.ChatbotFlow = flow
+	for i := range flow.Steps {
+		if flow.Steps[i].StepName == stepName {
+			sub.Step = &flow.Steps[i]
+			break
+		}
+	}
+	if sub.Step == nil {
+		return sub
+	}
+
+	flowID, _ := sub.Step.InputConfig["whatsapp_flow_id"].(string)
+	if flowID == "" {
+		return sub
+	}
+	waFlow, err := a.findWhatsAppFlow(account.OrganizationID, flowID)
+	if err != nil {
+		a.Log.Warn("WhatsApp Flow of step not found, accepting response as is", "flow_id", flowID, "step", stepName)
+		return sub
+	}
+	sub.WhatsAppFlow = waFlow
+	sub.Err = whatsapp.ValidateFlowResponse(whatsapp.FlowCompletions(waFlow.Screens), response)
+	return sub
+}
+
+// record returns the response as stored on the incoming message, with its flow token
+func (s *flowSubmission) record() models.JSONB {
+	record := make(models.JSONB, len(s.Response)+1)
+	for key, value := range s.Response {
+		record[key] = value
+	}
+	if s.Token != "" {
+		record["flow_token"] = s.Token
+	}
+	return record
+}
+
+// dispatchFlowSubmitted sends the flow.submitted webhook for a saved flow response
+func (a *App) dispatchFlowSubmitted(account *models.WhatsAppAccount, contact *models.Contact, message *models.Message, sub *flowSubmission) {
+	data := FlowSubmittedEventData{
+		MessageID:       message.ID.String(),
+		ContactID:       contact.ID.String(),
+		ContactPhone:    contact.PhoneNumber,
+		ContactName:     contact.ProfileName,
+		WhatsAppAccount: account.Name,
+		FlowToken:       sub.Token,
+		Valid:           sub.Err == nil,
+		Response:        sub.Response,
+	}
+	if sub.Err != nil {
+		data.ValidationError = sub.Err.Error()
+	}
+	if sub.WhatsAppFlow != nil {
+		data.WhatsAppFlowID = sub.WhatsAppFlow.ID.String()
+		data.WhatsAppFlowName = sub.WhatsAppFlow.Name
+	}
+	if sub.ChatbotFlow != nil {
+		data.ChatbotFlowID = sub.ChatbotFlow.ID.String()
+	}
+	if sub.Step != nil {
+		data.StepName = sub.Step.StepName
+	}
+	a.DispatchWebhook(account.OrganizationID, EventFlowSubmitted, data)
+}
+
+// sendFlowStepMessage sends the WhatsApp Flow configured on a step, with a flow token
+// that ties its response back to the session and step
+func (a *App) sendFlowStepMessage(account *models.WhatsAppAccount, session *models.ChatbotSession, contact *models.Contact, step *models.ChatbotFlowStep, body string) error {
+	config := step.InputConfig
+	flowID, _ := config["whatsapp_flow_id"].(string)
+	if flowID == "" {
+		return fmt.Errorf("%w: whatsapp_flow_id is required", whatsapp.ErrInvalidMessage)
+	}
+	waFlow, err := a.findWhatsAppFlow(account.OrganizationID, flowID)
+	if err != nil {
+		return fmt.Errorf("WhatsApp Flow %s not found: %w", flowID, err)
+	}
+	if waFlow.MetaFlowID == "" {
+		return errors.New("WhatsApp Flow is not saved to Meta")
+	}
+
+	replace := func(key string) string {
+		s, _ := config[key].(string)
+		return a.replaceVariables(s, session.SessionData)
+	}
+	msg := &whatsapp.FlowMessage{
+		Header:    replace("flow_header"),
+		Body:      body,
+		Footer:    replace("flow_footer"),
+		FlowID:    waFlow.MetaFlowID,
+		FlowToken: chatbotFlowToken(session.ID, step.StepName),
+		CTA:       replace("flow_cta"),
+		Screen:    replace("flow_screen"),
+		Draft:     !strings.EqualFold(waFlow.Status, "PUBLISHED"),
+	}
+	defaultString(&msg.CTA, "Open")
+	if data, ok := config["flow_data"].(map[string]interface{}); ok {
+		msg.Data, _ = replaceStringValues(data, func(s string) string {
+			return a.replaceVariables(s, session.SessionData)
+		}).(map[string]interface{})
+	}
+	if _, err := msg.Content(); err != nil {
+		return err
+	}
+	return a.sendAndSaveMessage(account, contact, msg)
+}
+
+// storeFlowResponse saves the fields of a flow response in the session data: each field
+// as <store_as>_<field> and the whole response as <store_as>, or each field under its own
+// name when the step has no store_as
+func (a *App) storeFlowResponse(session *models.ChatbotSession, step *models.ChatbotFlowStep, response map[string]interface{}) {
+	sessionData := session.SessionData
+	if sessionData == nil {
+		sessionData = models.JSONB{}
+	}
+	for field, value := range response {
+		if step.StoreAs != "" {
+			sessionData[step.StoreAs+"_"+field] = value
+		} else {
+			sessionData[field] = value
+		}
+	}
+	if step.StoreAs != "" {
+		sessionData[step.StoreAs] = response
+	}
+	a.DB.Model(session).Update("session_data", sessionData)
+	session.SessionData = sessionData
+}
+
+// sessionValueString formats a session data value for variable replacement
+func sessionValueString(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return val, true
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(val), true
+	case bool:
+		return strconv.FormatBool(val), true
+	case []interface{}:
+		items := make([]string, 0, len(val))
+		for _, item := range val {
+			if s, ok := sessionValueString(item); ok {
+				items = append(items, s)
+			}
+		}
+		return strings.Join(items, ", "), true
+	case map[string]interface{}, models.JSONB:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return "", false
+		}
+		return string(data), true
+	}
+	return "", false
+}
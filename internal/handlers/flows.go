@@ -23,18 +23,21 @@ type FlowRequest struct {
 
 // FlowResponse represents the response for a flow
 type FlowResponse struct {
-	ID              uuid.UUID              `json:"id"`
-	WhatsAppAccount string                 `json:"whatsapp_account"`
-	MetaFlowID      string                 `json:"meta_flow_id"`
-	Name            string                 `json:"name"`
-	Status          string                 `json:"status"`
-	Category        string                 `json:"category"`
-	JSONVersion     string                 `json:"json_version"`
-	FlowJSON        map[string]interface{} `json:"flow_json"`
-	Screens         []interface{}          `json:"screens"`
-	PreviewURL      string                 `json:"preview_url"`
-	CreatedAt       string                 `json:"created_at"`
-	UpdatedAt       string                 `json:"updated_at"`
+	ID                uuid.UUID              `json:"id"`
+	WhatsAppAccount   string                 `json:"whatsapp_account"`
+	MetaFlowID        string                 `json:"meta_flow_id"`
+	Name              string                 `json:"name"`
+	Status            string                 `json:"status"`
+	Category          string                 `json:"category"`
+	JSONVersion       string                 `json:"json_version"`
+	FlowJSON          map[string]interface{} `json:"flow_json"`
+	Screens           []interface{}          `json:"screens"`
+	PreviewURL        string                 `json:"preview_url"`
+	EndpointMode      string                 `json:"endpoint_mode"`
+	EndpointURL       string                 `json:"endpoint_url,omitempty"`
+	EndpointResponses map[string]interface{} `json:"endpoint_responses,omitempty"`
+	CreatedAt         string                 `json:"created_at"`
+	UpdatedAt         string                 `json:"updated_at"`
 }
 
 // ListFlows returns all flows for the organization
@@ -275,7 +278,7 @@ func (a *App) SaveFlowToMeta(r *fastglue.Request) error {
 	}
 
 	// Create WhatsApp API client
-	waClient := whatsapp.New(a.Log)
+	waClient := a.WhatsApp
 	waAccount := &whatsapp.Account{
 		PhoneID:     account.PhoneID,
 		BusinessID:  account.BusinessID,
@@ -317,6 +320,16 @@ func (a *App) SaveFlowToMeta(r *fastglue.Request) error {
 			Version: flow.JSONVersion,
 			Screens: flow.Screens,
 		}
+		// Flows backed by a data endpoint declare its API version and screen routing
+		if flow.EndpointMode != "" {
+			flowJSON.DataAPIVersion = flowDataAPIVersion
+			if v, ok := flow.FlowJSON["data_api_version"].(string); ok && v != "" {
+				flowJSON.DataAPIVersion = v
+			}
+			if routing, ok := flow.FlowJSON["routing_model"].(map[string]interface{}); ok {
+				flowJSON.RoutingModel = routing
+			}
+		}
 
 		if err := waClient.UpdateFlowJSON(ctx, waAccount, metaFlowID, flowJSON); err != nil {
 			a.Log.Error("Failed to update flow JSON in Meta", "error", err, "flow_id", id, "meta_flow_id", metaFlowID)
@@ -328,6 +341,13 @@ func (a *App) SaveFlowToMeta(r *fastglue.Request) error {
 		}
 	}
 
+	// Point a newly created flow at its data endpoint
+	if flow.EndpointMode != "" && flow.MetaFlowID == "" {
+		if err := waClient.SetFlowEndpoint(ctx, waAccount, metaFlowID, a.flowEndpointURI(r, flow.ID)); err != nil {
+			a.Log.Error("Failed to set flow endpoint in Meta", "error", err, "flow_id", id, "meta_flow_id", metaFlowID)
+		}
+	}
+
 	// Update local database with meta flow ID
 	if err := a.DB.Model(&flow).Updates(map[string]interface{}{
 		"meta_flow_id": metaFlowID,
@@ -382,7 +402,7 @@ func (a *App) PublishFlow(r *fastglue.Request) error {
 	}
 
 	// Create WhatsApp API client
-	waClient := whatsapp.New(a.Log)
+	waClient := a.WhatsApp
 	waAccount := &whatsapp.Account{
 		PhoneID:     account.PhoneID,
 		BusinessID:  account.BusinessID,
@@ -456,7 +476,7 @@ func (a *App) DeprecateFlow(r *fastglue.Request) error {
 			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "WhatsApp account not found", nil, "")
 		}
 
-		waClient := whatsapp.New(a.Log)
+		waClient := a.WhatsApp
 		waAccount := &whatsapp.Account{
 			PhoneID:     account.PhoneID,
 			BusinessID:  account.BusinessID,
@@ -515,7 +535,7 @@ func (a *App) SyncFlows(r *fastglue.Request) error {
 	}
 
 	// Create WhatsApp API client
-	waClient := whatsapp.New(a.Log)
+	waClient := a.WhatsApp
 	waAccount := &whatsapp.Account{
 		PhoneID:     account.PhoneID,
 		BusinessID:  account.BusinessID,
@@ -622,17 +642,20 @@ func (a *App) SyncFlows(r *fastglue.Request) error {
 // flowToResponse converts a flow model to response
 func flowToResponse(f models.WhatsAppFlow) FlowResponse {
 	return FlowResponse{
-		ID:              f.ID,
-		WhatsAppAccount: f.WhatsAppAccount,
-		MetaFlowID:      f.MetaFlowID,
-		Name:            f.Name,
-		Status:          f.Status,
-		Category:        f.Category,
-		JSONVersion:     f.JSONVersion,
-		FlowJSON:        map[string]interface{}(f.FlowJSON),
-		Screens:         []interface{}(f.Screens),
-		PreviewURL:      f.PreviewURL,
-		CreatedAt:       f.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		UpdatedAt:       f.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+		ID:                f.ID,
+		WhatsAppAccount:   f.WhatsAppAccount,
+		MetaFlowID:        f.MetaFlowID,
+		Name:              f.Name,
+		Status:            f.Status,
+		Category:          f.Category,
+		JSONVersion:       f.JSONVersion,
+		FlowJSON:          map[string]interface{}(f.FlowJSON),
+		Screens:           []interface{}(f.Screens),
+		PreviewURL:        f.PreviewURL,
+		EndpointMode:      f.EndpointMode,
+		EndpointURL:       f.EndpointURL,
+		EndpointResponses: map[string]interface{}(f.EndpointResponses),
+		CreatedAt:         f.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		UpdatedAt:         f.UpdatedAt.Format("2006-01-02T15:04:05Z"),
 	}
 }
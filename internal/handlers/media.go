@@ -2,31 +2,35 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/internal/storage"
 	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
 	"github.com/valyala/fasthttp"
 	"github.com/zerodha/fastglue"
 )
 
-// getMediaStoragePath returns the base path for media storage
-func (a *App) getMediaStoragePath() string {
-	basePath := a.Config.Storage.LocalPath
-	if basePath == "" {
-		basePath = "./media"
+// mediaKey returns a new storage key for media of the given type, e.g. "images/<uuid>.jpg"
+func mediaKey(mimeType, ext string) string {
+	var subdir string
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		subdir = "images"
+	case strings.HasPrefix(mimeType, "video/"):
+		subdir = "videos"
+	case strings.HasPrefix(mimeType, "audio/"):
+		subdir = "audio"
+	default:
+		subdir = "documents"
 	}
-	return basePath
-}
-
-// ensureMediaDir ensures the media directory exists
-func (a *App) ensureMediaDir(subdir string) error {
-	path := filepath.Join(a.getMediaStoragePath(), subdir)
-	return os.MkdirAll(path, 0755)
+	return path.Join(subdir, uuid.New().String()+ext)
 }
 
 // getExtensionFromMimeType returns file extension based on mime type
@@ -75,8 +79,8 @@ func getExtensionFromMimeType(mimeType string) string {
 	}
 }
 
-// DownloadAndSaveMedia downloads media from Meta and saves it locally
-// Returns the local file path (relative to media storage) or error
+// DownloadAndSaveMedia downloads media from Meta and saves it to media storage
+// Returns the storage key or error
 func (a *App) DownloadAndSaveMedia(ctx context.Context, mediaID string, mimeType string, account *whatsapp.Account) (string, error) {
 	// Get the media URL from Meta
 	mediaURL, err := a.WhatsApp.GetMediaURL(ctx, mediaID, account)
@@ -96,47 +100,24 @@ func (a *App) DownloadAndSaveMedia(ctx context.Context, mediaID string, mimeType
 		ext = ".bin"
 	}
 
-	// Generate unique filename
-	filename := uuid.New().String() + ext
-
-	// Determine subdirectory based on media type
-	var subdir string
-	switch {
-	case strings.HasPrefix(mimeType, "image/"):
-		subdir = "images"
-	case strings.HasPrefix(mimeType, "video/"):
-		subdir = "videos"
-	case strings.HasPrefix(mimeType, "audio/"):
-		subdir = "audio"
-	default:
-		subdir = "documents"
-	}
-
-	// Ensure directory exists
-	if err := a.ensureMediaDir(subdir); err != nil {
-		return "", fmt.Errorf("failed to create media directory: %w", err)
-	}
-
-	// Save file
-	filePath := filepath.Join(a.getMediaStoragePath(), subdir, filename)
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	key := mediaKey(mimeType, ext)
+	if err := a.Storage.Put(ctx, key, data, mimeType); err != nil {
 		return "", fmt.Errorf("failed to save media file: %w", err)
 	}
 
-	// Return relative path for storage in database
-	relativePath := filepath.Join(subdir, filename)
-	a.Log.Info("Media saved", "path", relativePath, "size", len(data))
+	// The key is what gets stored in the database
+	a.Log.Info("Media saved", "path", key, "size", len(data))
 
-	return relativePath, nil
+	return key, nil
 }
 
-// ServeMedia serves media files from local storage
-// Only authorized users who have access to the message can view the media
-func (a *App) ServeMedia(r *fastglue.Request) error {
+// mediaMessage loads the message named by the message_id URL parameter, checking that the
+// user may view it. On failure it returns the HTTP status and error message to send.
+func (a *App) mediaMessage(r *fastglue.Request) (*models.Message, int, string) {
 	// Get auth context
 	orgID, ok := r.RequestCtx.UserValue("organization_id").(uuid.UUID)
 	if !ok {
-		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+		return nil, fasthttp.StatusUnauthorized, "Unauthorized"
 	}
 	userID, _ := r.RequestCtx.UserValue("user_id").(uuid.UUID)
 	userRole, _ := r.RequestCtx.UserValue("role").(string)
@@ -145,92 +126,152 @@ func (a *App) ServeMedia(r *fastglue.Request) error {
 	messageIDStr := r.RequestCtx.UserValue("message_id").(string)
 	messageID, err := uuid.Parse(messageIDStr)
 	if err != nil {
-		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid message ID", nil, "")
+		return nil, fasthttp.StatusBadRequest, "Invalid message ID"
 	}
 
 	// Find the message and verify access
 	var message models.Message
 	if err := a.DB.Where("id = ? AND organization_id = ?", messageID, orgID).First(&message).Error; err != nil {
-		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Message not found", nil, "")
+		return nil, fasthttp.StatusNotFound, "Message not found"
 	}
 
 	// For agents, verify they have access to this contact
 	if userRole == "agent" {
 		var contact models.Contact
 		if err := a.DB.Where("id = ? AND assigned_user_id = ?", message.ContactID, userID).First(&contact).Error; err != nil {
-			return r.SendErrorEnvelope(fasthttp.StatusForbidden, "Access denied", nil, "")
+			return nil, fasthttp.StatusForbidden, "Access denied"
 		}
 	}
 
 	// Check if message has media
 	if message.MediaURL == "" {
-		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "No media found", nil, "")
+		return nil, fasthttp.StatusNotFound, "No media found"
 	}
 
-	// Security: prevent directory traversal
-	filePath := message.MediaURL
-	if strings.Contains(filePath, "..") {
-		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid file path", nil, "")
+	// Security: prevent directory traversal (keys of older messages used OS separators)
+	message.MediaURL = filepath.ToSlash(message.MediaURL)
+	if !storage.ValidKey(message.MediaURL) {
+		return nil, fasthttp.StatusBadRequest, "Invalid file path"
 	}
 
-	// Build full path
-	fullPath := filepath.Join(a.getMediaStoragePath(), filePath)
+	return &message, 0, ""
+}
+
+// ServeMedia serves media files from media storage
+// Only authorized users who have access to the message can view the media
+func (a *App) ServeMedia(r *fastglue.Request) error {
+	message, status, errMsg := a.mediaMessage(r)
+	if message == nil {
+		return r.SendErrorEnvelope(status, errMsg, nil, "")
+	}
 
-	// Check if file exists
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+	data, err := a.Storage.Get(r.RequestCtx, message.MediaURL)
+	if errors.Is(err, storage.ErrNotFound) {
 		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "File not found", nil, "")
 	}
+	if err != nil {
+		a.Log.Error("Failed to read media file", "path", message.MediaURL, "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to read file", nil, "")
+	}
+
+	r.RequestCtx.Response.Header.Set("Content-Type", mediaContentType(message.MediaURL))
+	r.RequestCtx.Response.Header.Set("Cache-Control", "private, max-age=3600") // Cache for 1 hour, private
+	r.RequestCtx.SetBody(data)
+
+	return nil
+}
+
+// GetMediaURL returns a signed, time-limited URL from which the message's media can be
+// downloaded without an auth header (e.g. as an <img> src or from another replica)
+func (a *App) GetMediaURL(r *fastglue.Request) error {
+	message, status, errMsg := a.mediaMessage(r)
+	if message == nil {
+		return r.SendErrorEnvelope(status, errMsg, nil, "")
+	}
+
+	expiry := time.Duration(a.Config.Storage.SignedURLExpiryMins) * time.Minute
+	url, err := a.Storage.SignedURL(r.RequestCtx, message.MediaURL, expiry)
+	if err != nil {
+		a.Log.Error("Failed to sign media URL", "path", message.MediaURL, "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to create media URL", nil, "")
+	}
+
+	return r.SendEnvelope(map[string]any{
+		"url":        url,
+		"expires_at": time.Now().Add(expiry),
+	})
+}
+
+// ServeSignedMedia serves a media file from local storage to the holder of a signed URL.
+// It is public; the signature stands in for authentication. S3 signed URLs point at the
+// bucket and never reach it.
+func (a *App) ServeSignedMedia(r *fastglue.Request) error {
+	local, ok := a.Storage.(*storage.Local)
+	if !ok {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Not found", nil, "")
+	}
 
-	// Read file
-	data, err := os.ReadFile(fullPath)
+	key, _ := r.RequestCtx.UserValue("key").(string)
+	expires := string(r.RequestCtx.QueryArgs().Peek("expires"))
+	signature := string(r.RequestCtx.QueryArgs().Peek("signature"))
+	if !storage.ValidKey(key) || local.Verify(key, expires, signature) != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusForbidden, "Invalid or expired link", nil, "")
+	}
+
+	data, err := local.Get(r.RequestCtx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "File not found", nil, "")
+	}
 	if err != nil {
-		a.Log.Error("Failed to read media file", "path", fullPath, "error", err)
+		a.Log.Error("Failed to read media file", "path", key, "error", err)
 		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to read file", nil, "")
 	}
 
-	// Determine content type from extension
-	ext := strings.ToLower(filepath.Ext(filePath))
-	contentType := "application/octet-stream"
-	switch ext {
+	r.RequestCtx.Response.Header.Set("Content-Type", mediaContentType(key))
+	r.RequestCtx.Response.Header.Set("Cache-Control", "private, max-age=300")
+	r.RequestCtx.SetBody(data)
+
+	return nil
+}
+
+// mediaContentType determines the content type from the file extension
+func mediaContentType(key string) string {
+	switch strings.ToLower(path.Ext(key)) {
 	case ".jpg", ".jpeg":
-		contentType = "image/jpeg"
+		return "image/jpeg"
 	case ".png":
-		contentType = "image/png"
+		return "image/png"
 	case ".gif":
-		contentType = "image/gif"
+		return "image/gif"
 	case ".webp":
-		contentType = "image/webp"
+		return "image/webp"
 	case ".mp4":
-		contentType = "video/mp4"
+		return "video/mp4"
 	case ".3gp":
-		contentType = "video/3gpp"
+		return "video/3gpp"
 	case ".mp3":
-		contentType = "audio/mpeg"
+		return "audio/mpeg"
 	case ".aac":
-		contentType = "audio/aac"
+		return "audio/aac"
 	case ".m4a":
-		contentType = "audio/mp4"
+		return "audio/mp4"
 	case ".ogg":
-		contentType = "audio/ogg"
+		return "audio/ogg"
 	case ".amr":
-		contentType = "audio/amr"
+		return "audio/amr"
 	case ".pdf":
-		contentType = "application/pdf"
+		return "application/pdf"
 	case ".doc":
-		contentType = "application/msword"
+		return "application/msword"
 	case ".docx":
-		contentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+		return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
 	case ".xls":
-		contentType = "application/vnd.ms-excel"
+		return "application/vnd.ms-excel"
 	case ".xlsx":
-		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
 	case ".txt":
-		contentType = "text/plain"
+		return "text/plain"
+	default:
+		return "application/octet-stream"
 	}
-
-	r.RequestCtx.Response.Header.Set("Content-Type", contentType)
-	r.RequestCtx.Response.Header.Set("Cache-Control", "private, max-age=3600") // Cache for 1 hour, private
-	r.RequestCtx.SetBody(data)
-
-	return nil
 }
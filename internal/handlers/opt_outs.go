@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Opt-out sources and actions recorded in the registry and its audit trail
+const (
+	OptOutSourceKeyword = "keyword"
+	OptOutSourceAPI     = "api"
+
+	OptOutActionOptOut = "opt_out"
+	OptOutActionOptIn  = "opt_in"
+)
+
+// errContactOptedOut is returned when a message is not sent because the recipient opted out
+var errContactOptedOut = errors.New("recipient has opted out")
+
+// Inbound messages matching these exactly (case-insensitive) opt the contact out or back in
+var (
+	optOutKeywords = map[string]bool{"STOP": true, "STOP ALL": true, "STOPALL": true, "UNSUBSCRIBE": true, "OPT OUT": true, "OPTOUT": true}
+	optInKeywords  = map[string]bool{"START": true, "UNSTOP": true, "SUBSCRIBE": true}
+)
+
+// OptOutRequest represents a manual opt-out request
+type OptOutRequest struct {
+	PhoneNumber string `json:"phone_number"`
+	Reason      string `json:"reason"`
+}
+
+// normalizeOptOutKeyword uppercases the message, reads underscores and hyphens as spaces, collapses
+// whitespace and drops trailing punctuation
+func normalizeOptOutKeyword(text string) string {
+	text = strings.NewReplacer("_", " ", "-", " ").Replace(text)
+	text = strings.ToUpper(strings.Join(strings.Fields(text), " "))
+	return strings.TrimRight(text, ".!")
+}
+
+// optOutPhone normalizes a phone number for registry lookups, falling back to the raw value
+func optOutPhone(phone string) string {
+	if normalized, err := whatsapp.NormalizePhoneNumber(phone); err == nil {
+		return normalized
+	}
+	return phone
+}
+
+// isOptedOut reports whether the phone number is on the organization's opt-out list
+func (a *App) isOptedOut(orgID uuid.UUID, phone string) bool {
+	var count int64
+	a.DB.Model(&models.ContactOptOut{}).
+		Where("organization_id = ? AND phone_number = ?", orgID, optOutPhone(phone)).
+		Count(&count)
+	return count > 0
+}
+
+// optOut adds the phone number to the opt-out list and records the change.
+// Returns false if it was already opted out.
+func (a *App) optOut(orgID uuid.UUID, phone string, contactID *uuid.UUID, source, reason string, userID *uuid.UUID) (*models.ContactOptOut, bool, error) {
+	entry := models.ContactOptOut{
+		OrganizationID: orgID,
+		PhoneNumber:    optOutPhone(phone),
+		ContactID:      contactID,
+		Source:         source,
+		Reason:         reason,
+		CreatedBy:      userID,
+	}
+
+	created := false
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return tx.Where("organization_id = ? AND phone_number = ?", orgID, entry.PhoneNumber).First(&entry).Error
+		}
+		created = true
+		return tx.Create(&models.ContactOptOutEvent{
+			OrganizationID: orgID,
+			PhoneNumber:    entry.PhoneNumber,
+			ContactID:      contactID,
+			Action:         OptOutActionOptOut,
+			Source:         source,
+			Reason:         reason,
+			UserID:         userID,
+		}).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if created {
+		a.Log.Info("Contact opted out", "org_id", orgID, "phone", entry.PhoneNumber, "source", source)
+	}
+	return &entry, created, nil
+}
+
+// optIn removes the phone number from the opt-out list and records the change.
+// Returns false if it was not opted out.
+func (a *App) optIn(orgID uuid.UUID, phone string, contactID *uuid.UUID, source, reason string, userID *uuid.UUID) (bool, error) {
+	phone = optOutPhone(phone)
+
+	removed := false
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("organization_id = ? AND phone_number = ?", orgID, phone).Delete(&models.ContactOptOut{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		removed = true
+		return tx.Create(&models.ContactOptOutEvent{
+			OrganizationID: orgID,
+			PhoneNumber:    phone,
+			ContactID:      contactID,
+			Action:         OptOutActionOptIn,
+			Source:         source,
+			Reason:         reason,
+			UserID:         userID,
+		}).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	if removed {
+		a.Log.Info("Contact opted in", "org_id", orgID, "phone", phone, "source", source)
+	}
+	return removed, nil
+}
+
+// optOutKeywordCandidates returns the parts of an inbound message that may be an opt-out or
+// opt-in keyword: the text, or the title and ID or payload of a button or list reply
+func optOutKeywordCandidates(msg IncomingTextMessage) []string {
+	var candidates []string
+	switch {
+	case msg.Type == "text" && msg.Text != nil:
+		candidates = append(candidates, msg.Text.Body)
+	case msg.Type == "button" && msg.Button != nil:
+		candidates = append(candidates, msg.Button.Text, msg.Button.Payload)
+	case msg.Type == "interactive" && msg.Interactive != nil:
+		if msg.Interactive.ButtonReply != nil {
+			candidates = append(candidates, msg.Interactive.ButtonReply.Title, msg.Interactive.ButtonReply.ID)
+		}
+		if msg.Interactive.ListReply != nil {
+			candidates = append(candidates, msg.Interactive.ListReply.Title, msg.Interactive.ListReply.ID)
+		}
+	}
+	return candidates
+}
+
+// handleOptOutKeyword updates the opt-out list when an inbound message is an opt-out or
+// opt-in keyword. Returns true if the message was such a keyword.
+func (a *App) handleOptOutKeyword(account *models.WhatsAppAccount, contact *models.Contact, messageText string) bool {
+	keyword := normalizeOptOutKeyword(messageText)
+	switch {
+	case optOutKeywords[keyword]:
+		if _, _, err := a.optOut(account.OrganizationID, contact.PhoneNumber, &contact.ID, OptOutSourceKeyword, keyword, nil); err != nil {
+			a.Log.Error("Failed to record opt-out", "error", err, "contact_id", contact.ID)
+		}
+		return true
+	case optInKeywords[keyword]:
+		if _, err := a.optIn(account.OrganizationID, contact.PhoneNumber, &contact.ID, OptOutSourceKeyword, keyword, nil); err != nil {
+			a.Log.Error("Failed to record opt-in", "error", err, "contact_id", contact.ID)
+		}
+		return true
+	}
+	return false
+}
+
+// ListOptOuts returns the organization's opt-out list
+func (a *App) ListOptOuts(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	page, limit := optOutPagination(r)
+	query := a.DB.Model(&models.ContactOptOut{}).Where("organization_id = ?", orgID)
+	if search := string(r.RequestCtx.QueryArgs().Peek("search")); search != "" {
+		query = query.Where("phone_number LIKE ?", "%"+search+"%")
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var optOuts []models.ContactOptOut
+	if err := query.Order("created_at DESC").Offset((page - 1) * limit).Limit(limit).Find(&optOuts).Error; err != nil {
+		a.Log.Error("Failed to list opt-outs", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to list opt-outs", nil, "")
+	}
+
+	if a.ShouldMaskPhoneNumbers(orgID) {
+		for i := range optOuts {
+			optOuts[i].PhoneNumber = MaskPhoneNumber(optOuts[i].PhoneNumber)
+		}
+	}
+
+	return r.SendEnvelope(map[string]interface{}{
+		"opt_outs": optOuts,
+		"total":    total,
+		"page":     page,
+		"limit":    limit,
+	})
+}
+
+// CreateOptOut adds a phone number to the opt-out list
+func (a *App) CreateOptOut(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+	userID, _ := r.RequestCtx.UserValue("user_id").(uuid.UUID)
+
+	var req OptOutRequest
+	if err := r.Decode(&req, "json"); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
+	}
+	phone, err := whatsapp.NormalizePhoneNumber(req.PhoneNumber)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid phone number: use international format with country code", nil, "")
+	}
+
+	var contactID *uuid.UUID
+	var contact models.Contact
+	if err := a.DB.Where("organization_id = ? AND phone_number = ?", orgID, phone).First(&contact).Error; err == nil {
+		contactID = &contact.ID
+	}
+
+	entry, created, err := a.optOut(orgID, phone, contactID, OptOutSourceAPI, req.Reason, &userID)
+	if err != nil {
+		a.Log.Error("Failed to create opt-out", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to create opt-out", nil, "")
+	}
+	if !created {
+		return r.SendErrorEnvelope(fasthttp.StatusConflict, "Phone number has already opted out", nil, "")
+	}
+
+	return r.SendEnvelope(entry)
+}
+
+// DeleteOptOut removes a phone number from the opt-out list (opts it back in)
+func (a *App) DeleteOptOut(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+	userID, _ := r.RequestCtx.UserValue("user_id").(uuid.UUID)
+
+	id, err := uuid.Parse(r.RequestCtx.UserValue("id").(string))
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid opt-out ID", nil, "")
+	}
+
+	var entry models.ContactOptOut
+	if err := a.DB.Where("id = ? AND organization_id = ?", id, orgID).First(&entry).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Opt-out not found", nil, "")
+	}
+
+	reason := string(r.RequestCtx.QueryArgs().Peek("reason"))
+	if _, err := a.optIn(orgID, entry.PhoneNumber, entry.ContactID, OptOutSourceAPI, reason, &userID); err != nil {
+		a.Log.Error("Failed to delete opt-out", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to delete opt-out", nil, "")
+	}
+
+	return r.SendEnvelope(map[string]interface{}{
+		"message": "Opt-out removed successfully",
+	})
+}
+
+// ListOptOutEvents returns the audit trail of opt-out changes, optionally for one phone number
+func (a *App) ListOptOutEvents(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	page, limit := optOutPagination(r)
+	query := a.DB.Model(&models.ContactOptOutEvent{}).Where("organization_id = ?", orgID)
+	if phone := string(r.RequestCtx.QueryArgs().Peek("phone_number")); phone != "" {
+		query = query.Where("phone_number = ?", optOutPhone(phone))
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var events []models.ContactOptOutEvent
+	if err := query.Order("created_at DESC").Offset((page - 1) * limit).Limit(limit).Find(&events).Error; err != nil {
+		a.Log.Error("Failed to list opt-out events", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to list opt-out events", nil, "")
+	}
+
+	if a.ShouldMaskPhoneNumbers(orgID) {
+		for i := range events {
+			events[i].PhoneNumber = MaskPhoneNumber(events[i].PhoneNumber)
+		}
+	}
+
+	return r.SendEnvelope(map[string]interface{}{
+		"events": events,
+		"total":  total,
+		"page":   page,
+		"limit":  limit,
+	})
+}
+
+func optOutPagination(r *fastglue.Request) (page, limit int) {
+	page, _ = strconv.Atoi(string(r.RequestCtx.QueryArgs().Peek("page")))
+	limit, _ = strconv.Atoi(string(r.RequestCtx.QueryArgs().Peek("limit")))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	return page, limit
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOptOutKeywordCandidates(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string // the candidate that is an opt-out keyword, "" if none
+	}{
+		{"text", `{"type":"text","text":{"body":"stop."}}`, "STOP"},
+		{"text not a keyword", `{"type":"text","text":{"body":"please stop by"}}`, ""},
+		{"template button text", `{"type":"button","button":{"text":"Stop","payload":"x1"}}`, "STOP"},
+		{"template button payload", `{"type":"button","button":{"text":"No more offers","payload":"STOP_ALL"}}`, "STOP ALL"},
+		{"button reply title", `{"type":"interactive","interactive":{"type":"button_reply","button_reply":{"id":"b1","title":"Unsubscribe"}}}`, "UNSUBSCRIBE"},
+		{"button reply id", `{"type":"interactive","interactive":{"type":"button_reply","button_reply":{"id":"opt-out","title":"Leave"}}}`, "OPT OUT"},
+		{"list reply id", `{"type":"interactive","interactive":{"type":"list_reply","list_reply":{"id":"stop","title":"Quit"}}}`, "STOP"},
+		{"button reply not a keyword", `{"type":"interactive","interactive":{"type":"button_reply","button_reply":{"id":"yes","title":"Yes"}}}`, ""},
+		{"image caption", `{"type":"image","image":{"id":"m1","caption":"STOP"}}`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg IncomingTextMessage
+			if err := json.Unmarshal([]byte(tt.payload), &msg); err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			for _, c := range optOutKeywordCandidates(msg) {
+				if k := normalizeOptOutKeyword(c); optOutKeywords[k] {
+					got = k
+					break
+				}
+			}
+			if got != tt.want {
+				t.Errorf("opt-out keyword = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/internal/templates"
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
 	"github.com/valyala/fasthttp"
 	"github.com/zerodha/fastglue"
 )
@@ -13,6 +16,9 @@ type OrganizationSettings struct {
 	MaskPhoneNumbers bool   `json:"mask_phone_numbers"`
 	Timezone         string `json:"timezone"`
 	DateFormat       string `json:"date_format"`
+
+	// Template languages tried in order when a template is not available in a contact's locale
+	TemplateLanguageFallback []string `json:"template_language_fallback"`
 }
 
 // GetOrganizationSettings returns the organization settings
@@ -44,6 +50,10 @@ func (a *App) GetOrganizationSettings(r *fastglue.Request) error {
 		if v, ok := org.Settings["date_format"].(string); ok && v != "" {
 			settings.DateFormat = v
 		}
+		settings.TemplateLanguageFallback = templates.LanguageFallbackSetting(org.Settings)
+	}
+	if settings.TemplateLanguageFallback == nil {
+		settings.TemplateLanguageFallback = []string{}
 	}
 
 	return r.SendEnvelope(map[string]interface{}{
@@ -64,6 +74,8 @@ func (a *App) UpdateOrganizationSettings(r *fastglue.Request) error {
 		Timezone         *string `json:"timezone"`
 		DateFormat       *string `json:"date_format"`
 		Name             *string `json:"name"`
+
+		TemplateLanguageFallback *[]string `json:"template_language_fallback"`
 	}
 
 	if err := json.Unmarshal(r.RequestCtx.PostBody(), &req); err != nil {
@@ -84,11 +96,24 @@ func (a *App) UpdateOrganizationSettings(r *fastglue.Request) error {
 		org.Settings["mask_phone_numbers"] = *req.MaskPhoneNumbers
 	}
 	if req.Timezone != nil {
+		// Campaign schedules are interpreted in this timezone, so it must be a valid IANA name
+		if _, err := time.LoadLocation(*req.Timezone); err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid timezone", nil, "")
+		}
 		org.Settings["timezone"] = *req.Timezone
 	}
 	if req.DateFormat != nil {
 		org.Settings["date_format"] = *req.DateFormat
 	}
+	if req.TemplateLanguageFallback != nil {
+		fallback := make([]string, 0, len(*req.TemplateLanguageFallback))
+		for _, code := range *req.TemplateLanguageFallback {
+			if code = whatsapp.NormalizeLanguageCode(code); code != "" {
+				fallback = append(fallback, code)
+			}
+		}
+		org.Settings["template_language_fallback"] = fallback
+	}
 	if req.Name != nil && *req.Name != "" {
 		org.Name = *req.Name
 	}
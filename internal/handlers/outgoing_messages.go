@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
+)
+
+// MessageSpec holds the type-specific part of an outgoing message. Only the field
+// matching the message type is used; the message body comes from the caller.
+type MessageSpec struct {
+	Location    *whatsapp.LocationMessage    `json:"location,omitempty"`
+	Contacts    whatsapp.ContactsMessage     `json:"contacts,omitempty"`
+	Sticker     *whatsapp.StickerMessage     `json:"sticker,omitempty"`
+	Buttons     *whatsapp.ButtonsMessage     `json:"buttons,omitempty"`
+	List        *whatsapp.ListMessage        `json:"list,omitempty"`
+	CTAURL      *whatsapp.CTAURLMessage      `json:"cta_url,omitempty"`
+	Product     *whatsapp.ProductMessage     `json:"product,omitempty"`
+	ProductList *whatsapp.ProductListMessage `json:"product_list,omitempty"`
+}
+
+// specMessageTypes are the message types built from a MessageSpec (besides text)
+var specMessageTypes = map[string]bool{
+	"location":         true,
+	"contacts":         true,
+	"sticker":          true,
+	"buttons":          true,
+	"list":             true,
+	"cta_url":          true,
+	"location_request": true,
+	"product":          true,
+	"product_list":     true,
+}
+
+// build returns the message of type msgType. body fills the body of interactive
+// messages that don't set their own. Invalid messages wrap whatsapp.ErrInvalidMessage.
+func (s *MessageSpec) build(msgType, body string) (whatsapp.OutgoingMessage, error) {
+	var msg whatsapp.OutgoingMessage
+	switch msgType {
+	case "", "text":
+		msg = &whatsapp.TextMessage{Body: body}
+	case "location":
+		if s.Location != nil {
+			msg = s.Location
+		}
+	case "contacts":
+		if len(s.Contacts) > 0 {
+			msg = s.Contacts
+		}
+	case "sticker":
+		if s.Sticker != nil {
+			msg = s.Sticker
+		}
+	case "buttons":
+		if s.Buttons != nil {
+			defaultString(&s.Buttons.Body, body)
+			msg = s.Buttons
+		}
+	case "list":
+		if s.List != nil {
+			defaultString(&s.List.Body, body)
+			msg = s.List
+		}
+	case "cta_url":
+		if s.CTAURL != nil {
+			defaultString(&s.CTAURL.Body, body)
+			msg = s.CTAURL
+		}
+	case "location_request":
+		msg = &whatsapp.LocationRequestMessage{Body: body}
+	case "product":
+		if s.Product != nil {
+			defaultString(&s.Product.Body, body)
+			msg = s.Product
+		}
+	case "product_list":
+		if s.ProductList != nil {
+			defaultString(&s.ProductList.Body, body)
+			msg = s.ProductList
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported message type %q", whatsapp.ErrInvalidMessage, msgType)
+	}
+	if msg == nil {
+		return nil, fmt.Errorf("%w: %s is required for %s messages", whatsapp.ErrInvalidMessage, msgType, msgType)
+	}
+
+	// Check the limits now rather than when sending
+	if _, err := msg.Content(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// stepMessageSpec decodes the message config of a chatbot flow step
+func stepMessageSpec(config map[string]interface{}) (*MessageSpec, error) {
+	spec := &MessageSpec{}
+	if len(config) == 0 {
+		return spec, nil
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("%w: invalid message_config: %v", whatsapp.ErrInvalidMessage, err)
+	}
+	return spec, nil
+}
+
+// replaceStringValues applies replace to every string in a decoded JSON value, so that
+// variables can be used anywhere in a step's message config without breaking its JSON
+func replaceStringValues(v interface{}, replace func(string) string) interface{} {
+	switch val := v.(type) {
+	case string:
+		return replace(val)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = replaceStringValues(item, replace)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = replaceStringValues(item, replace)
+		}
+		return out
+	}
+	return v
+}
+
+func defaultString(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+// outgoingMessageRecord returns how an outgoing message is stored: its MessageType,
+// Content and InteractiveData, in the shapes used for incoming messages of the same type
+func outgoingMessageRecord(msg whatsapp.OutgoingMessage) (messageType, content string, interactiveData models.JSONB) {
+	switch m := msg.(type) {
+	case *whatsapp.TextMessage:
+		return "text", m.Body, nil
+	case *whatsapp.LocationMessage:
+		data, _ := json.Marshal(m)
+		return "location", string(data), nil
+	case whatsapp.ContactsMessage:
+		contacts := make([]map[string]any, 0, len(m))
+		for _, card := range m {
+			contact := map[string]any{"name": card.Name.FormattedName}
+			if len(card.Phones) > 0 {
+				phones := make([]string, 0, len(card.Phones))
+				for _, p := range card.Phones {
+					phones = append(phones, p.Phone)
+				}
+				contact["phones"] = phones
+			}
+			contacts = append(contacts, contact)
+		}
+		data, _ := json.Marshal(contacts)
+		return "contacts", string(data), nil
+	case *whatsapp.StickerMessage:
+		return "sticker", "", nil
+	case *whatsapp.ButtonsMessage:
+		buttons := make([]interface{}, 0, len(m.Buttons))
+		for _, btn := range m.Buttons {
+			buttons = append(buttons, map[string]interface{}{"id": btn.ID, "title": btn.Title})
+		}
+		return "interactive", m.Body, models.JSONB{
+			"type":    "button",
+			"header":  m.Header,
+			"body":    m.Body,
+			"footer":  m.Footer,
+			"buttons": buttons,
+		}
+	case *whatsapp.ListMessage:
+		// rows lists every option across sections, as for lists sent by the chatbot
+		var rows []interface{}
+		for _, section := range m.Sections {
+			for _, row := range section.Rows {
+				rows = append(rows, map[string]interface{}{"id": row.ID, "title": row.Title})
+			}
+		}
+		return "interactive", m.Body, models.JSONB{
+			"type":     "list",
+			"header":   m.Header,
+			"body":     m.Body,
+			"footer":   m.Footer,
+			"button":   m.ButtonText,
+			"sections": m.Sections,
+			"rows":     rows,
+		}
+	case *whatsapp.CTAURLMessage:
+		return "interactive", m.Body, models.JSONB{
+			"type":         "cta_url",
+			"header":       m.Header,
+			"body":         m.Body,
+			"footer":       m.Footer,
+			"display_text": m.DisplayText,
+			"url":          m.URL,
+		}
+	case *whatsapp.LocationRequestMessage:
+		return "interactive", m.Body, models.JSONB{
+			"type": "location_request",
+			"body": m.Body,
+		}
+	case *whatsapp.ProductMessage:
+		return "interactive", m.Body, models.JSONB{
+			"type":                "product",
+			"body":                m.Body,
+			"footer":              m.Footer,
+			"catalog_id":          m.CatalogID,
+			"product_retailer_id": m.ProductRetailerID,
+		}
+	case *whatsapp.ProductListMessage:
+		return "interactive", m.Body, models.JSONB{
+			"type":       "product_list",
+			"header":     m.Header,
+			"body":       m.Body,
+			"footer":     m.Footer,
+			"catalog_id": m.CatalogID,
+			"sections":   m.Sections,
+		}
+	case *whatsapp.FlowMessage:
+		return "interactive", m.Body, models.JSONB{
+			"type":    "flow",
+			"header":  m.Header,
+			"body":    m.Body,
+			"footer":  m.Footer,
+			"flow_id": m.FlowID,
+			"cta":     m.CTA,
+			"screen":  m.Screen,
+		}
+	}
+	return msg.Type(), "", nil
+}
@@ -0,0 +1,526 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/internal/spreadsheet"
+	"github.com/isaee-xyz/whatomate/internal/templates"
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
+	"github.com/redis/go-redis/v9"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+)
+
+const (
+	recipientImportBatchSize = 1000
+	importPreviewRows        = 5
+	importInlineErrors       = 100
+	importReportMaxRows      = 100000
+	importReportTTL          = 24 * time.Hour
+)
+
+// RecipientColumnMapping maps spreadsheet columns (by header name) to recipient fields.
+// Params maps template parameter keys ("1", "first_name", "header", "button_0", ...) to columns.
+type RecipientColumnMapping struct {
+	Phone  string            `json:"phone"`
+	Name   string            `json:"name"`
+	Params map[string]string `json:"params"`
+}
+
+// RecipientImportPreview describes an uploaded file before it is imported
+type RecipientImportPreview struct {
+	Columns          []string                    `json:"columns"`
+	SampleRows       [][]string                  `json:"sample_rows"`
+	SuggestedMapping RecipientColumnMapping      `json:"suggested_mapping"`
+	TemplateParams   int                         `json:"template_params"`
+	ParamKeys        []whatsapp.TemplateParamKey `json:"param_keys"`
+}
+
+// RecipientImportError describes a row that was not imported
+type RecipientImportError struct {
+	Row         int    `json:"row"`
+	PhoneNumber string `json:"phone_number"`
+	Error       string `json:"error"`
+}
+
+// RecipientImportResult summarises a file import
+type RecipientImportResult struct {
+	AddedCount      int                    `json:"added_count"`
+	DuplicateCount  int                    `json:"duplicate_count"`
+	ErrorCount      int                    `json:"error_count"`
+	TotalRecipients int64                  `json:"total_recipients"`
+	Errors          []RecipientImportError `json:"errors"`
+	ReportID        string                 `json:"report_id,omitempty"`
+}
+
+// PreviewRecipientImport reads the header and first rows of an uploaded CSV/XLSX file
+// and suggests a column mapping for the campaign's template
+func (a *App) PreviewRecipientImport(r *fastglue.Request) error {
+	campaign, errResp := a.loadImportableCampaign(r)
+	if campaign == nil {
+		return errResp
+	}
+
+	reader, closeFile, err := openRecipientFile(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+	defer closeFile()
+
+	header, err := reader.Read()
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "File is empty or unreadable", nil, "")
+	}
+	columns := normalizeHeader(header)
+
+	samples := make([][]string, 0, importPreviewRows)
+	for len(samples) < importPreviewRows {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+		}
+		if isBlankRow(row) {
+			continue
+		}
+		samples = append(samples, row)
+	}
+
+	keys := importParamKeys(campaign)
+
+	return r.SendEnvelope(RecipientImportPreview{
+		Columns:          columns,
+		SampleRows:       samples,
+		SuggestedMapping: suggestColumnMapping(columns, keys),
+		TemplateParams:   importTemplateParamCount(campaign),
+		ParamKeys:        keys,
+	})
+}
+
+// UploadRecipients imports recipients from an uploaded CSV/XLSX file using a column mapping.
+// Rows are streamed and inserted in batches; invalid and duplicate rows are skipped and
+// listed in a downloadable CSV report.
+func (a *App) UploadRecipients(r *fastglue.Request) error {
+	campaign, errResp := a.loadImportableCampaign(r)
+	if campaign == nil {
+		return errResp
+	}
+
+	form, err := r.RequestCtx.MultipartForm()
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid multipart form", nil, "")
+	}
+
+	var mapping RecipientColumnMapping
+	mappingValues := form.Value["mapping"]
+	if len(mappingValues) == 0 {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "mapping is required", nil, "")
+	}
+	if err := json.Unmarshal([]byte(mappingValues[0]), &mapping); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid mapping", nil, "")
+	}
+
+	reader, closeFile, err := openRecipientFile(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+	defer closeFile()
+
+	header, err := reader.Read()
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "File is empty or unreadable", nil, "")
+	}
+
+	cols, err := resolveColumnMapping(normalizeHeader(header), mapping, importParamKeys(campaign))
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+
+	// Existing recipients, for de-duplication; new numbers are added as rows are read
+	var existing []string
+	if err := a.DB.Model(&models.BulkMessageRecipient{}).
+		Where("campaign_id = ?", campaign.ID).
+		Pluck("phone_number", &existing).Error; err != nil {
+		a.Log.Error("Failed to load campaign recipients", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to import recipients", nil, "")
+	}
+	seen := make(map[string]bool, len(existing))
+	for _, p := range existing {
+		seen[p] = true
+	}
+
+	result := RecipientImportResult{Errors: []RecipientImportError{}}
+	var report []RecipientImportError
+	addError := func(e RecipientImportError) {
+		if len(result.Errors) < importInlineErrors {
+			result.Errors = append(result.Errors, e)
+		}
+		if len(report) < importReportMaxRows {
+			report = append(report, e)
+		}
+	}
+
+	batch := make([]models.BulkMessageRecipient, 0, recipientImportBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := a.DB.Create(&batch).Error; err != nil {
+			return err
+		}
+		result.AddedCount += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	// Spreadsheet row numbers: the header is row 1
+	rowNum := 1
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		rowNum++
+		if err != nil {
+			// A malformed line in a CSV is reported; anything else aborts the import
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				result.ErrorCount++
+				addError(RecipientImportError{Row: rowNum, Error: "Malformed row"})
+				continue
+			}
+			a.Log.Error("Failed to read recipient file", "error", err, "row", rowNum)
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, fmt.Sprintf("Failed to read file at row %d", rowNum), nil, "")
+		}
+		if isBlankRow(row) {
+			continue
+		}
+
+		rawPhone := cell(row, cols.phone)
+		phoneNumber, err := whatsapp.NormalizePhoneNumber(rawPhone)
+		if err != nil {
+			result.ErrorCount++
+			addError(RecipientImportError{Row: rowNum, PhoneNumber: rawPhone, Error: "Invalid phone number"})
+			continue
+		}
+
+		if seen[phoneNumber] {
+			result.DuplicateCount++
+			addError(RecipientImportError{Row: rowNum, PhoneNumber: rawPhone, Error: "Duplicate recipient"})
+			continue
+		}
+
+		params := models.JSONB{}
+		var missing []string
+		for _, p := range cols.params {
+			value := cell(row, p.index)
+			if value == "" {
+				if p.required {
+					missing = append(missing, templateParamLabel(p.key))
+				}
+				continue
+			}
+			params[p.key] = value
+		}
+		if len(missing) > 0 {
+			result.ErrorCount++
+			addError(RecipientImportError{Row: rowNum, PhoneNumber: rawPhone, Error: "Missing value for " + strings.Join(missing, ", ")})
+			continue
+		}
+
+		seen[phoneNumber] = true
+		batch = append(batch, models.BulkMessageRecipient{
+			CampaignID:     campaign.ID,
+			PhoneNumber:    phoneNumber,
+			RecipientName:  cell(row, cols.name),
+			TemplateParams: params,
+			Status:         "pending",
+		})
+		if len(batch) >= recipientImportBatchSize {
+			if err := flush(); err != nil {
+				a.Log.Error("Failed to add recipients", "error", err)
+				return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to add recipients", nil, "")
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		a.Log.Error("Failed to add recipients", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to add recipients", nil, "")
+	}
+
+	// Update total recipients count
+	a.DB.Model(&models.BulkMessageRecipient{}).Where("campaign_id = ?", campaign.ID).Count(&result.TotalRecipients)
+	a.DB.Model(campaign).Update("total_recipients", result.TotalRecipients)
+
+	if len(report) > 0 {
+		reportID, err := a.saveImportReport(r.RequestCtx, campaign.ID, report)
+		if err != nil {
+			a.Log.Error("Failed to save import report", "error", err, "campaign_id", campaign.ID)
+		} else {
+			result.ReportID = reportID
+		}
+	}
+
+	a.Log.Info("Recipients imported from file", "campaign_id", campaign.ID,
+		"added", result.AddedCount, "duplicates", result.DuplicateCount, "errors", result.ErrorCount)
+
+	return r.SendEnvelope(result)
+}
+
+// DownloadRecipientImportReport returns the CSV report of rows skipped by an import
+func (a *App) DownloadRecipientImportReport(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	id, err := uuid.Parse(r.RequestCtx.UserValue("id").(string))
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid campaign ID", nil, "")
+	}
+	reportID := r.RequestCtx.UserValue("report_id").(string)
+
+	var campaign models.BulkMessageCampaign
+	if err := a.DB.Select("id").Where("id = ? AND organization_id = ?", id, orgID).First(&campaign).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Campaign not found", nil, "")
+	}
+
+	data, err := a.Redis.Get(r.RequestCtx, importReportKey(id, reportID)).Bytes()
+	if err == redis.Nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Report not found or expired", nil, "")
+	}
+	if err != nil {
+		a.Log.Error("Failed to load import report", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to load report", nil, "")
+	}
+
+	r.RequestCtx.Response.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	r.RequestCtx.Response.Header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="import-errors-%s.csv"`, reportID))
+	r.RequestCtx.SetBody(data)
+	return nil
+}
+
+// loadImportableCampaign loads the campaign from the request path and checks recipients can be added.
+// On failure it returns nil and the error response.
+func (a *App) loadImportableCampaign(r *fastglue.Request) (*models.BulkMessageCampaign, error) {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return nil, r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	id, err := uuid.Parse(r.RequestCtx.UserValue("id").(string))
+	if err != nil {
+		return nil, r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid campaign ID", nil, "")
+	}
+
+	var campaign models.BulkMessageCampaign
+	if err := a.DB.Where("id = ? AND organization_id = ?", id, orgID).Preload("Template").First(&campaign).Error; err != nil {
+		return nil, r.SendErrorEnvelope(fasthttp.StatusNotFound, "Campaign not found", nil, "")
+	}
+
+	if campaign.Status != "draft" && campaign.Status != "scheduled" {
+		return nil, r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Can only add recipients to draft or scheduled campaigns", nil, "")
+	}
+	return &campaign, nil
+}
+
+// openRecipientFile opens the uploaded "file" form field as a spreadsheet
+func openRecipientFile(r *fastglue.Request) (spreadsheet.Reader, func(), error) {
+	form, err := r.RequestCtx.MultipartForm()
+	if err != nil {
+		return nil, nil, errors.New("Invalid multipart form")
+	}
+	files := form.File["file"]
+	if len(files) == 0 {
+		return nil, nil, errors.New("file is required")
+	}
+
+	var file multipart.File
+	if file, err = files[0].Open(); err != nil {
+		return nil, nil, errors.New("Failed to read file")
+	}
+
+	reader, err := spreadsheet.Open(files[0].Filename, file, files[0].Size)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return reader, func() { file.Close() }, nil
+}
+
+// importTemplateParamCount returns the number of body variables in the campaign's template
+func importTemplateParamCount(campaign *models.BulkMessageCampaign) int {
+	if campaign.Template == nil {
+		return 0
+	}
+	return len(whatsapp.TemplateVariables(campaign.Template.BodyContent))
+}
+
+// importParamKeys returns the template parameters that can be mapped to columns
+func importParamKeys(campaign *models.BulkMessageCampaign) []whatsapp.TemplateParamKey {
+	if campaign.Template == nil {
+		return nil
+	}
+	return templates.Layout(campaign.Template).ParamKeys()
+}
+
+type importParamColumn struct {
+	key      string
+	index    int
+	required bool
+}
+
+type importColumns struct {
+	phone  int
+	name   int // -1 if not mapped
+	params []importParamColumn
+}
+
+// resolveColumnMapping turns a header-name mapping into column indexes, requiring the
+// phone column and a column for every required template parameter
+func resolveColumnMapping(columns []string, mapping RecipientColumnMapping, keys []whatsapp.TemplateParamKey) (*importColumns, error) {
+	index := make(map[string]int, len(columns))
+	for i, c := range columns {
+		if _, ok := index[strings.ToLower(c)]; !ok {
+			index[strings.ToLower(c)] = i
+		}
+	}
+	lookup := func(name string) (int, bool) {
+		i, ok := index[strings.ToLower(strings.TrimSpace(name))]
+		return i, ok
+	}
+
+	cols := &importColumns{name: -1}
+
+	phone, ok := lookup(mapping.Phone)
+	if mapping.Phone == "" || !ok {
+		return nil, fmt.Errorf("phone column %q not found in file", mapping.Phone)
+	}
+	cols.phone = phone
+
+	if mapping.Name != "" {
+		name, ok := lookup(mapping.Name)
+		if !ok {
+			return nil, fmt.Errorf("name column %q not found in file", mapping.Name)
+		}
+		cols.name = name
+	}
+
+	for _, k := range keys {
+		column := mapping.Params[k.Key]
+		if column == "" {
+			if k.Required {
+				return nil, fmt.Errorf("no column mapped for template parameter %s", templateParamLabel(k.Key))
+			}
+			continue
+		}
+		idx, ok := lookup(column)
+		if !ok {
+			return nil, fmt.Errorf("column %q for %s not found in file", column, templateParamLabel(k.Key))
+		}
+		cols.params = append(cols.params, importParamColumn{key: k.Key, index: idx, required: k.Required})
+	}
+	return cols, nil
+}
+
+// suggestColumnMapping guesses the mapping from common header names
+func suggestColumnMapping(columns []string, keys []whatsapp.TemplateParamKey) RecipientColumnMapping {
+	mapping := RecipientColumnMapping{Params: map[string]string{}}
+	isKey := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		isKey[k.Key] = true
+	}
+
+	for _, c := range columns {
+		key := strings.ToLower(strings.NewReplacer(" ", "_", "-", "_").Replace(c))
+		switch key {
+		case "phone", "phone_number", "phonenumber", "mobile", "mobile_number", "number", "whatsapp", "whatsapp_number", "msisdn":
+			if mapping.Phone == "" {
+				mapping.Phone = c
+			}
+		case "name", "recipient_name", "full_name", "contact_name":
+			if mapping.Name == "" {
+				mapping.Name = c
+			}
+		}
+
+		// Template variables: "1", "{{1}}", "param1", "param_1", "var1"
+		trimmed := strings.TrimSuffix(strings.TrimPrefix(key, "{{"), "}}")
+		for _, prefix := range []string{"param_", "param", "var_", "var"} {
+			trimmed = strings.TrimPrefix(trimmed, prefix)
+		}
+		// Named variables, header and button parameters match by their key
+		for _, candidate := range []string{trimmed, key} {
+			if isKey[candidate] {
+				if _, ok := mapping.Params[candidate]; !ok {
+					mapping.Params[candidate] = c
+				}
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+func normalizeHeader(header []string) []string {
+	columns := make([]string, len(header))
+	for i, h := range header {
+		columns[i] = strings.TrimSpace(h)
+	}
+	return columns
+}
+
+func isBlankRow(row []string) bool {
+	for _, v := range row {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// cell returns the trimmed value at index, or "" if the row is short or index is -1
+func cell(row []string, index int) string {
+	if index < 0 || index >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[index])
+}
+
+func importReportKey(campaignID uuid.UUID, reportID string) string {
+	return fmt.Sprintf("whatomate:campaign:%s:import_report:%s", campaignID, reportID)
+}
+
+// saveImportReport stores the skipped rows as CSV in Redis and returns the report ID
+func (a *App) saveImportReport(ctx context.Context, campaignID uuid.UUID, report []RecipientImportError) (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"row", "phone_number", "error"})
+	for _, e := range report {
+		_ = w.Write([]string{strconv.Itoa(e.Row), e.PhoneNumber, e.Error})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+
+	reportID := uuid.New().String()
+	if err := a.Redis.Set(ctx, importReportKey(campaignID, reportID), buf.Bytes(), importReportTTL).Err(); err != nil {
+		return "", err
+	}
+	return reportID, nil
+}
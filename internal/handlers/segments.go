@@ -0,0 +1,637 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/internal/templates"
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+	"gorm.io/gorm"
+)
+
+const segmentPreviewContacts = 10
+
+// SegmentFilter selects contacts. All set criteria must match.
+type SegmentFilter struct {
+	TagsInclude           []string            `json:"tags_include,omitempty"`
+	TagsMatch             string              `json:"tags_match,omitempty"` // any (default) or all of tags_include
+	TagsExclude           []string            `json:"tags_exclude,omitempty"`
+	Metadata              []MetadataCondition `json:"metadata,omitempty"`
+	LastMessageAfter      *time.Time          `json:"last_message_after,omitempty"`
+	LastMessageBefore     *time.Time          `json:"last_message_before,omitempty"`
+	LastMessageWithinDays int                 `json:"last_message_within_days,omitempty"` // Relative to when the segment is resolved
+	AssignedUserIDs       []uuid.UUID         `json:"assigned_user_ids,omitempty"`
+	Unassigned            bool                `json:"unassigned,omitempty"` // Also match contacts with no assigned agent
+	WhatsAppAccounts      []string            `json:"whatsapp_accounts,omitempty"`
+}
+
+// MetadataCondition compares a top-level Contact.Metadata field
+type MetadataCondition struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"` // eq, neq, contains, gt, gte, lt, lte, exists, not_exists
+	Value interface{} `json:"value,omitempty"`
+}
+
+// SegmentRequest represents segment create/update request
+type SegmentRequest struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Filter      SegmentFilter `json:"filter"`
+}
+
+// SegmentResponse represents a segment in API responses
+type SegmentResponse struct {
+	ID           uuid.UUID     `json:"id"`
+	Name         string        `json:"name"`
+	Description  string        `json:"description"`
+	Filter       SegmentFilter `json:"filter"`
+	ContactCount *int64        `json:"contact_count,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+}
+
+// SegmentPreviewResponse is the number of matching contacts and a sample of them
+type SegmentPreviewResponse struct {
+	Count    int64                  `json:"count"`
+	Contacts []SegmentContactSample `json:"contacts"`
+}
+
+// SegmentContactSample is a matching contact shown in a segment preview
+type SegmentContactSample struct {
+	ID              uuid.UUID  `json:"id"`
+	PhoneNumber     string     `json:"phone_number"`
+	ProfileName     string     `json:"profile_name"`
+	WhatsAppAccount string     `json:"whatsapp_account"`
+	Tags            []string   `json:"tags"`
+	LastMessageAt   *time.Time `json:"last_message_at,omitempty"`
+}
+
+// numericPattern guards casts of metadata values to numeric (no "?" so GORM does not see a placeholder)
+const numericPattern = `'^-{0,1}[0-9]+(\.[0-9]+){0,1}$'`
+
+// likeEscaper escapes the wildcards of a LIKE pattern for ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// validate checks the filter is well-formed
+func (f *SegmentFilter) validate() error {
+	if f.TagsMatch != "" && f.TagsMatch != "any" && f.TagsMatch != "all" {
+		return errors.New("tags_match must be any or all")
+	}
+	if f.LastMessageWithinDays < 0 {
+		return errors.New("last_message_within_days must be positive")
+	}
+	for _, c := range f.Metadata {
+		if strings.TrimSpace(c.Field) == "" {
+			return errors.New("metadata condition field is required")
+		}
+		switch c.Op {
+		case "exists", "not_exists":
+		case "eq", "neq", "contains":
+			if c.Value == nil {
+				return fmt.Errorf("metadata condition on %q requires a value", c.Field)
+			}
+		case "gt", "gte", "lt", "lte":
+			if _, ok := metadataNumber(c.Value); !ok {
+				return fmt.Errorf("metadata condition on %q requires a numeric value", c.Field)
+			}
+		default:
+			return fmt.Errorf("unknown metadata operator %q", c.Op)
+		}
+	}
+	return nil
+}
+
+// apply adds the filter's conditions to a contacts query
+func (f *SegmentFilter) apply(q *gorm.DB) *gorm.DB {
+	// Tags are a JSONB array of strings; containment (@>) is used per tag
+	if len(f.TagsInclude) > 0 {
+		if f.TagsMatch == "all" {
+			for _, tag := range f.TagsInclude {
+				q = q.Where("tags @> ?::jsonb", jsonString([]string{tag}))
+			}
+		} else {
+			or := q.Session(&gorm.Session{NewDB: true})
+			for i, tag := range f.TagsInclude {
+				if i == 0 {
+					or = or.Where("tags @> ?::jsonb", jsonString([]string{tag}))
+				} else {
+					or = or.Or("tags @> ?::jsonb", jsonString([]string{tag}))
+				}
+			}
+			q = q.Where(or)
+		}
+	}
+	for _, tag := range f.TagsExclude {
+		q = q.Where("NOT (COALESCE(tags, '[]'::jsonb) @> ?::jsonb)", jsonString([]string{tag}))
+	}
+
+	for _, c := range f.Metadata {
+		field := strings.TrimSpace(c.Field)
+		switch c.Op {
+		case "exists":
+			q = q.Where("metadata -> ? IS NOT NULL", field)
+		case "not_exists":
+			q = q.Where("metadata -> ? IS NULL", field)
+		case "eq":
+			q = q.Where("metadata ->> ? = ?", field, fmt.Sprint(c.Value))
+		case "neq":
+			q = q.Where("metadata ->> ? IS DISTINCT FROM ?", field, fmt.Sprint(c.Value))
+		case "contains":
+			q = q.Where(`metadata ->> ? ILIKE ? ESCAPE '\'`, field, "%"+likeEscaper.Replace(fmt.Sprint(c.Value))+"%")
+		case "gt", "gte", "lt", "lte":
+			n, _ := metadataNumber(c.Value)
+			op := map[string]string{"gt": ">", "gte": ">=", "lt": "<", "lte": "<="}[c.Op]
+			q = q.Where(fmt.Sprintf("(CASE WHEN metadata ->> ? ~ %s THEN (metadata ->> ?)::numeric END) %s ?", numericPattern, op),
+				field, field, n)
+		}
+	}
+
+	if f.LastMessageAfter != nil {
+		q = q.Where("last_message_at >= ?", *f.LastMessageAfter)
+	}
+	if f.LastMessageBefore != nil {
+		q = q.Where("last_message_at <= ?", *f.LastMessageBefore)
+	}
+	if f.LastMessageWithinDays > 0 {
+		q = q.Where("last_message_at >= ?", time.Now().AddDate(0, 0, -f.LastMessageWithinDays))
+	}
+
+	switch {
+	case len(f.AssignedUserIDs) > 0 && f.Unassigned:
+		q = q.Where("(assigned_user_id IN ? OR assigned_user_id IS NULL)", f.AssignedUserIDs)
+	case len(f.AssignedUserIDs) > 0:
+		q = q.Where("assigned_user_id IN ?", f.AssignedUserIDs)
+	case f.Unassigned:
+		q = q.Where("assigned_user_id IS NULL")
+	}
+
+	if len(f.WhatsAppAccounts) > 0 {
+		q = q.Where("whats_app_account IN ?", f.WhatsAppAccounts)
+	}
+	return q
+}
+
+func metadataNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func jsonString(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// segmentFilterFromJSONB decodes a stored segment filter
+func segmentFilterFromJSONB(j models.JSONB) (*SegmentFilter, error) {
+	var f SegmentFilter
+	b, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func segmentFilterToJSONB(f *SegmentFilter) models.JSONB {
+	var j models.JSONB
+	b, _ := json.Marshal(f)
+	_ = json.Unmarshal(b, &j)
+	return j
+}
+
+// segmentContactsQuery returns a query over the organization's contacts matching the filter
+func (a *App) segmentContactsQuery(orgID uuid.UUID, f *SegmentFilter) *gorm.DB {
+	return f.apply(a.DB.Model(&models.Contact{}).Where("organization_id = ?", orgID))
+}
+
+func segmentToResponse(s *models.Segment) SegmentResponse {
+	resp := SegmentResponse{
+		ID:          s.ID,
+		Name:        s.Name,
+		Description: s.Description,
+		CreatedAt:   s.CreatedAt,
+		UpdatedAt:   s.UpdatedAt,
+	}
+	if f, err := segmentFilterFromJSONB(s.Filter); err == nil {
+		resp.Filter = *f
+	}
+	return resp
+}
+
+// ListSegments returns the organization's saved segments
+func (a *App) ListSegments(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	var segments []models.Segment
+	if err := a.DB.Where("organization_id = ?", orgID).Order("name ASC").Find(&segments).Error; err != nil {
+		a.Log.Error("Failed to list segments", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to list segments", nil, "")
+	}
+
+	response := make([]SegmentResponse, len(segments))
+	for i := range segments {
+		response[i] = segmentToResponse(&segments[i])
+	}
+
+	return r.SendEnvelope(map[string]interface{}{
+		"segments": response,
+	})
+}
+
+// CreateSegment saves a new segment
+func (a *App) CreateSegment(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+	userID, _ := r.RequestCtx.UserValue("user_id").(uuid.UUID)
+
+	var req SegmentRequest
+	if err := r.Decode(&req, "json"); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Name is required", nil, "")
+	}
+	if err := req.Filter.validate(); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+
+	segment := models.Segment{
+		OrganizationID: orgID,
+		Name:           strings.TrimSpace(req.Name),
+		Description:    req.Description,
+		Filter:         segmentFilterToJSONB(&req.Filter),
+		CreatedBy:      userID,
+	}
+	if err := a.DB.Create(&segment).Error; err != nil {
+		a.Log.Error("Failed to create segment", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to create segment", nil, "")
+	}
+
+	return r.SendEnvelope(segmentToResponse(&segment))
+}
+
+// GetSegment returns a segment with its current contact count
+func (a *App) GetSegment(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	segment, err := a.findSegment(r, orgID)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Segment not found", nil, "")
+	}
+
+	resp := segmentToResponse(segment)
+	var count int64
+	if err := a.segmentContactsQuery(orgID, &resp.Filter).Count(&count).Error; err != nil {
+		a.Log.Error("Failed to count segment contacts", "error", err, "segment_id", segment.ID)
+	} else {
+		resp.ContactCount = &count
+	}
+
+	return r.SendEnvelope(resp)
+}
+
+// UpdateSegment updates a segment's name, description and filter
+func (a *App) UpdateSegment(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	segment, err := a.findSegment(r, orgID)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Segment not found", nil, "")
+	}
+
+	var req SegmentRequest
+	if err := r.Decode(&req, "json"); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Name is required", nil, "")
+	}
+	if err := req.Filter.validate(); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+
+	if err := a.DB.Model(segment).Updates(map[string]interface{}{
+		"name":        strings.TrimSpace(req.Name),
+		"description": req.Description,
+		"filter":      segmentFilterToJSONB(&req.Filter),
+	}).Error; err != nil {
+		a.Log.Error("Failed to update segment", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to update segment", nil, "")
+	}
+
+	a.DB.Where("id = ?", segment.ID).First(segment)
+	return r.SendEnvelope(segmentToResponse(segment))
+}
+
+// DeleteSegment deletes a segment that no unstarted campaign depends on
+func (a *App) DeleteSegment(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	segment, err := a.findSegment(r, orgID)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Segment not found", nil, "")
+	}
+
+	var inUse int64
+	a.DB.Model(&models.BulkMessageCampaign{}).
+		Where("segment_id = ? AND status IN ?", segment.ID, []string{"draft", "scheduled"}).
+		Count(&inUse)
+	if inUse > 0 {
+		return r.SendErrorEnvelope(fasthttp.StatusConflict, "Segment is used by campaigns that have not started", nil, "")
+	}
+
+	if err := a.DB.Delete(segment).Error; err != nil {
+		a.Log.Error("Failed to delete segment", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to delete segment", nil, "")
+	}
+
+	return r.SendEnvelope(map[string]interface{}{
+		"message": "Segment deleted successfully",
+	})
+}
+
+// PreviewSegment counts the contacts matching a filter (saved or ad hoc) and returns a sample
+func (a *App) PreviewSegment(r *fastglue.Request) error {
+	orgID, err := a.getOrgIDFromContext(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	var filter SegmentFilter
+	if _, ok := r.RequestCtx.UserValue("id").(string); ok {
+		segment, err := a.findSegment(r, orgID)
+		if err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Segment not found", nil, "")
+		}
+		f, err := segmentFilterFromJSONB(segment.Filter)
+		if err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Invalid segment filter", nil, "")
+		}
+		filter = *f
+	} else {
+		var req struct {
+			Filter SegmentFilter `json:"filter"`
+		}
+		if err := r.Decode(&req, "json"); err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
+		}
+		filter = req.Filter
+	}
+	if err := filter.validate(); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+
+	var count int64
+	if err := a.segmentContactsQuery(orgID, &filter).Count(&count).Error; err != nil {
+		a.Log.Error("Failed to count segment contacts", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to preview segment", nil, "")
+	}
+
+	var contacts []models.Contact
+	if err := a.segmentContactsQuery(orgID, &filter).
+		Order("last_message_at DESC NULLS LAST").
+		Limit(segmentPreviewContacts).
+		Find(&contacts).Error; err != nil {
+		a.Log.Error("Failed to load segment contacts", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to preview segment", nil, "")
+	}
+
+	shouldMask := a.ShouldMaskPhoneNumbers(orgID)
+	samples := make([]SegmentContactSample, len(contacts))
+	for i, c := range contacts {
+		phone := c.PhoneNumber
+		if shouldMask {
+			phone = MaskPhoneNumber(phone)
+		}
+		samples[i] = SegmentContactSample{
+			ID:              c.ID,
+			PhoneNumber:     phone,
+			ProfileName:     c.ProfileName,
+			WhatsAppAccount: c.WhatsAppAccount,
+			Tags:            contactTags(&c),
+			LastMessageAt:   c.LastMessageAt,
+		}
+	}
+
+	return r.SendEnvelope(SegmentPreviewResponse{
+		Count:    count,
+		Contacts: samples,
+	})
+}
+
+func (a *App) findSegment(r *fastglue.Request, orgID uuid.UUID) (*models.Segment, error) {
+	id, err := uuid.Parse(r.RequestCtx.UserValue("id").(string))
+	if err != nil {
+		return nil, err
+	}
+	var segment models.Segment
+	if err := a.DB.Where("id = ? AND organization_id = ?", id, orgID).First(&segment).Error; err != nil {
+		return nil, err
+	}
+	return &segment, nil
+}
+
+// contactFieldValue reads a contact field for a template variable:
+// profile_name, phone_number, whatsapp_account or metadata.<key>
+func contactFieldValue(c *models.Contact, field string) string {
+	switch field {
+	case "profile_name":
+		return c.ProfileName
+	case "phone_number":
+		return c.PhoneNumber
+	case "whatsapp_account":
+		return c.WhatsAppAccount
+	}
+	if key, ok := strings.CutPrefix(field, "metadata."); ok && c.Metadata != nil {
+		if v, exists := c.Metadata[key]; exists && v != nil {
+			return strings.TrimSpace(fmt.Sprint(v))
+		}
+	}
+	return ""
+}
+
+// validateParamFields checks the template parameter -> contact field mapping of a segment campaign
+func validateParamFields(fields map[string]string, keys []whatsapp.TemplateParamKey) error {
+	known := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		known[k.Key] = true
+	}
+	for key, field := range fields {
+		if !known[key] {
+			return fmt.Errorf("invalid template parameter %q in param_fields", key)
+		}
+		switch {
+		case field == "profile_name", field == "phone_number", field == "whatsapp_account":
+		case strings.HasPrefix(field, "metadata.") && len(field) > len("metadata."):
+		default:
+			return fmt.Errorf("invalid contact field %q for %s", field, templateParamLabel(key))
+		}
+	}
+	for _, k := range keys {
+		if k.Required && fields[k.Key] == "" {
+			return fmt.Errorf("no contact field mapped for template parameter %s", templateParamLabel(k.Key))
+		}
+	}
+	return nil
+}
+
+// resolveSegmentRecipients adds the contacts of the campaign's segment as recipients, filling
+// template params from contact fields. Contacts already in the campaign are skipped, as are
+// contacts missing a mapped field. Returns how many recipients were added and skipped.
+func (a *App) resolveSegmentRecipients(campaign *models.BulkMessageCampaign) (added, skipped int, err error) {
+	if campaign.SegmentID == nil {
+		return 0, 0, nil
+	}
+
+	var segment models.Segment
+	if err := a.DB.Where("id = ? AND organization_id = ?", *campaign.SegmentID, campaign.OrganizationID).First(&segment).Error; err != nil {
+		return 0, 0, fmt.Errorf("segment not found: %w", err)
+	}
+	filter, err := segmentFilterFromJSONB(segment.Filter)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid segment filter: %w", err)
+	}
+
+	var template models.Template
+	if err := a.DB.Where("id = ?", campaign.TemplateID).First(&template).Error; err != nil {
+		return 0, 0, fmt.Errorf("template not found: %w", err)
+	}
+	keys := templates.Layout(&template).ParamKeys()
+
+	fields := make(map[string]string, len(campaign.ParamFields))
+	for k, v := range campaign.ParamFields {
+		if s, ok := v.(string); ok {
+			fields[k] = s
+		}
+	}
+	if err := validateParamFields(fields, keys); err != nil {
+		return 0, 0, err
+	}
+
+	var existing []string
+	if err := a.DB.Model(&models.BulkMessageRecipient{}).
+		Where("campaign_id = ?", campaign.ID).
+		Pluck("phone_number", &existing).Error; err != nil {
+		return 0, 0, err
+	}
+	seen := make(map[string]bool, len(existing))
+	for _, p := range existing {
+		seen[p] = true
+	}
+
+	var contacts []models.Contact
+	result := a.segmentContactsQuery(campaign.OrganizationID, filter).
+		FindInBatches(&contacts, recipientImportBatchSize, func(tx *gorm.DB, batchNum int) error {
+			batch := make([]models.BulkMessageRecipient, 0, len(contacts))
+			for i := range contacts {
+				c := &contacts[i]
+				if seen[c.PhoneNumber] {
+					continue
+				}
+
+				params := models.JSONB{}
+				complete := true
+				for _, k := range keys {
+					if fields[k.Key] == "" {
+						continue
+					}
+					value := contactFieldValue(c, fields[k.Key])
+					if value == "" {
+						if k.Required {
+							complete = false
+							break
+						}
+						continue
+					}
+					params[k.Key] = value
+				}
+				if !complete {
+					skipped++
+					continue
+				}
+
+				seen[c.PhoneNumber] = true
+				batch = append(batch, models.BulkMessageRecipient{
+					CampaignID:     campaign.ID,
+					PhoneNumber:    c.PhoneNumber,
+					RecipientName:  c.ProfileName,
+					TemplateParams: params,
+					Status:         "pending",
+				})
+			}
+			if len(batch) == 0 {
+				return nil
+			}
+			if err := a.DB.Create(&batch).Error; err != nil {
+				return err
+			}
+			added += len(batch)
+			return nil
+		})
+	if result.Error != nil {
+		return added, skipped, result.Error
+	}
+
+	var total int64
+	a.DB.Model(&models.BulkMessageRecipient{}).Where("campaign_id = ?", campaign.ID).Count(&total)
+	a.DB.Model(campaign).Update("total_recipients", total)
+
+	a.Log.Info("Segment recipients resolved", "campaign_id", campaign.ID, "segment_id", segment.ID, "added", added, "skipped", skipped)
+	return added, skipped, nil
+}
+
+// validateCampaignSegment checks a campaign's segment and param field mapping against its template
+func (a *App) validateCampaignSegment(orgID uuid.UUID, segmentID string, paramFields map[string]string, template *models.Template) (*uuid.UUID, models.JSONB, error) {
+	fields := models.JSONB{}
+	if segmentID == "" {
+		return nil, fields, nil
+	}
+
+	id, err := uuid.Parse(segmentID)
+	if err != nil {
+		return nil, nil, errors.New("invalid segment ID")
+	}
+	var count int64
+	a.DB.Model(&models.Segment{}).Where("id = ? AND organization_id = ?", id, orgID).Count(&count)
+	if count == 0 {
+		return nil, nil, errors.New("segment not found")
+	}
+
+	if err := validateParamFields(paramFields, templates.Layout(template).ParamKeys()); err != nil {
+		return nil, nil, err
+	}
+	for k, v := range paramFields {
+		fields[k] = v
+	}
+	return &id, fields, nil
+}
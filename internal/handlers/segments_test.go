@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestSegmentFilterContains(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1 port=1 user=x dbname=x sslmode=disable"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"gold", "%gold%"},
+		{"100%", `%100\%%`},
+		{"vip_tier", `%vip\_tier%`},
+		{`C:\path`, `%C:\\path%`},
+	}
+
+	for _, tt := range tests {
+		f := &SegmentFilter{Metadata: []MetadataCondition{{Field: "plan", Op: "contains", Value: tt.value}}}
+		var rows []map[string]interface{}
+		stmt := f.apply(db.Table("contacts")).Find(&rows).Statement
+
+		if sql := stmt.SQL.String(); !strings.Contains(sql, `ILIKE $2 ESCAPE '\'`) {
+			t.Errorf("SQL = %s, want ILIKE with ESCAPE '\\'", sql)
+		}
+		if len(stmt.Vars) != 2 || stmt.Vars[1] != tt.want {
+			t.Errorf("contains %q vars = %q, want pattern %q", tt.value, stmt.Vars, tt.want)
+		}
+	}
+}
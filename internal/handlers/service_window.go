@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// customerServiceWindow is how long after a contact's last inbound message
+// free-form (non-template) messages may be sent
+const customerServiceWindow = 24 * time.Hour
+
+// ServiceWindowClosedResponse is returned when a free-form send is outside the customer service window.
+// It lists the approved templates of the account that can be sent instead.
+type ServiceWindowClosedResponse struct {
+	Code            string             `json:"code"`
+	LastInboundAt   *time.Time         `json:"last_inbound_at"`
+	WindowExpiresAt *time.Time         `json:"window_expires_at"`
+	Templates       []TemplateFallback `json:"templates"`
+}
+
+// TemplateFallback is an approved template offered as an alternative to a free-form message
+type TemplateFallback struct {
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	Language string    `json:"language"`
+	Category string    `json:"category"`
+}
+
+// parseWebhookTimestamp parses Meta's unix-seconds timestamp, falling back to now
+func parseWebhookTimestamp(ts string) time.Time {
+	if secs, err := strconv.ParseInt(ts, 10, 64); err == nil && secs > 0 {
+		return time.Unix(secs, 0)
+	}
+	return time.Now()
+}
+
+// recordInboundMessage opens (or extends) the customer service window for a contact on an account
+func (a *App) recordInboundMessage(orgID, contactID uuid.UUID, accountName string, at time.Time) {
+	window := models.ContactServiceWindow{
+		BaseModel:       models.BaseModel{ID: uuid.New()},
+		OrganizationID:  orgID,
+		ContactID:       contactID,
+		WhatsAppAccount: accountName,
+		LastInboundAt:   at,
+	}
+	err := a.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "contact_id"}, {Name: "whats_app_account"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"last_inbound_at": gorm.Expr("GREATEST(contact_service_windows.last_inbound_at, EXCLUDED.last_inbound_at)"),
+			"updated_at":      time.Now(),
+		}),
+	}).Create(&window).Error
+	if err != nil {
+		a.Log.Error("Failed to record inbound message time", "error", err, "contact_id", contactID, "account", accountName)
+	}
+}
+
+// getLastInboundAt returns the contact's last inbound message time on the account,
+// or across all accounts if accountName is empty. Returns nil if the contact never wrote in.
+func (a *App) getLastInboundAt(contactID uuid.UUID, accountName string) *time.Time {
+	var window models.ContactServiceWindow
+	query := a.DB.Where("contact_id = ?", contactID)
+	if accountName != "" {
+		query = query.Where("whats_app_account = ?", accountName)
+	}
+	if err := query.Order("last_inbound_at DESC").First(&window).Error; err != nil {
+		return nil
+	}
+	return &window.LastInboundAt
+}
+
+// getServiceWindowExpiry returns when the contact's customer service window closes on the account
+func (a *App) getServiceWindowExpiry(contactID uuid.UUID, accountName string) *time.Time {
+	lastInbound := a.getLastInboundAt(contactID, accountName)
+	if lastInbound == nil {
+		return nil
+	}
+	expiry := lastInbound.Add(customerServiceWindow)
+	return &expiry
+}
+
+// getServiceWindowExpiries returns when the customer service windows of the contacts close on
+// their current accounts (or any account if they have none), keyed by contact ID. Contacts
+// that never wrote in are left out.
+func (a *App) getServiceWindowExpiries(contacts []models.Contact) map[uuid.UUID]*time.Time {
+	expiries := make(map[uuid.UUID]*time.Time, len(contacts))
+	if len(contacts) == 0 {
+		return expiries
+	}
+
+	ids := make([]uuid.UUID, len(contacts))
+	for i, c := range contacts {
+		ids[i] = c.ID
+	}
+	var windows []models.ContactServiceWindow
+	if err := a.DB.Where("contact_id IN ?", ids).Find(&windows).Error; err != nil {
+		a.Log.Error("Failed to load service windows", "error", err)
+		return expiries
+	}
+
+	accounts := make(map[uuid.UUID]string, len(contacts))
+	for _, c := range contacts {
+		accounts[c.ID] = c.WhatsAppAccount
+	}
+	for _, w := range windows {
+		if account := accounts[w.ContactID]; account != "" && w.WhatsAppAccount != account {
+			continue
+		}
+		expiry := w.LastInboundAt.Add(customerServiceWindow)
+		if current := expiries[w.ContactID]; current == nil || expiry.After(*current) {
+			expiries[w.ContactID] = &expiry
+		}
+	}
+	return expiries
+}
+
+// requireServiceWindow checks that a free-form message may be sent to the contact from the account.
+// If the window is closed it sends a 422 response offering the account's approved templates and returns false.
+func (a *App) requireServiceWindow(r *fastglue.Request, contact *models.Contact, account *models.WhatsAppAccount) (bool, error) {
+	lastInbound := a.getLastInboundAt(contact.ID, account.Name)
+	if lastInbound != nil && time.Since(*lastInbound) < customerServiceWindow {
+		return true, nil
+	}
+
+	var templates []models.Template
+	a.DB.Where("organization_id = ? AND whats_app_account = ? AND UPPER(status) = ?", account.OrganizationID, account.Name, "APPROVED").
+		Order("name ASC").Find(&templates)
+
+	resp := ServiceWindowClosedResponse{
+		Code:          "service_window_closed",
+		LastInboundAt: lastInbound,
+		Templates:     make([]TemplateFallback, 0, len(templates)),
+	}
+	if lastInbound != nil {
+		expiry := lastInbound.Add(customerServiceWindow)
+		resp.WindowExpiresAt = &expiry
+	}
+	for _, t := range templates {
+		resp.Templates = append(resp.Templates, TemplateFallback{
+			ID:       t.ID,
+			Name:     t.Name,
+			Language: t.Language,
+			Category: t.Category,
+		})
+	}
+
+	return false, r.SendErrorEnvelope(fasthttp.StatusUnprocessableEntity,
+		"The 24-hour customer service window is closed for this contact. Send an approved template to re-open the conversation.",
+		resp, "")
+}
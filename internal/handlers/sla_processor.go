@@ -255,6 +255,10 @@ func (p *SLAProcessor) notifyEscalation(transfer models.AgentTransfer, settings
 
 // sendSLAWarningToCustomer sends a warning message to the customer
 func (p *SLAProcessor) sendSLAWarningToCustomer(transfer models.AgentTransfer, message string) {
+	if p.app.isOptedOut(transfer.OrganizationID, transfer.PhoneNumber) {
+		return
+	}
+
 	// Get WhatsApp account
 	var account models.WhatsAppAccount
 	if err := p.app.DB.Where("name = ?", transfer.WhatsAppAccount).First(&account).Error; err != nil {
@@ -284,6 +288,10 @@ func (p *SLAProcessor) sendSLAWarningToCustomer(transfer models.AgentTransfer, m
 
 // sendSLAAutoCloseToCustomer sends an auto-close notification message to the customer
 func (p *SLAProcessor) sendSLAAutoCloseToCustomer(transfer models.AgentTransfer, message string) {
+	if p.app.isOptedOut(transfer.OrganizationID, transfer.PhoneNumber) {
+		return
+	}
+
 	// Get WhatsApp account
 	var account models.WhatsAppAccount
 	if err := p.app.DB.Where("name = ?", transfer.WhatsAppAccount).First(&account).Error; err != nil {
@@ -442,7 +450,7 @@ func (p *SLAProcessor) processClientInactivity(orgID uuid.UUID, settings models.
 
 // sendChatbotReminder sends a reminder message to an inactive client during chatbot conversation
 func (p *SLAProcessor) sendChatbotReminder(contact models.Contact, settings models.ChatbotSettings, now time.Time) {
-	if settings.ClientReminderMessage == "" {
+	if settings.ClientReminderMessage == "" || p.app.isOptedOut(contact.OrganizationID, contact.PhoneNumber) {
 		return
 	}
 
@@ -537,7 +545,7 @@ func (p *SLAProcessor) autoCloseChatbotSession(contact models.Contact, settings
 	}
 
 	// Send auto-close message if configured
-	if settings.ClientAutoCloseMessage != "" {
+	if settings.ClientAutoCloseMessage != "" && !p.app.isOptedOut(contact.OrganizationID, contact.PhoneNumber) {
 		var account models.WhatsAppAccount
 		if err := p.app.DB.Where("name = ?", contact.WhatsAppAccount).First(&account).Error; err == nil {
 			waAccount := &whatsapp.Account{
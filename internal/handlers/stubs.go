@@ -9,18 +9,7 @@ import (
 
 // Stub handlers - not yet implemented
 
-// Contact handlers
-func (a *App) CreateContact(r *fastglue.Request) error {
-	return r.SendErrorEnvelope(fasthttp.StatusNotImplemented, "Not implemented yet", nil, "")
-}
-
-func (a *App) UpdateContact(r *fastglue.Request) error {
-	return r.SendErrorEnvelope(fasthttp.StatusNotImplemented, "Not implemented yet", nil, "")
-}
-
-func (a *App) DeleteContact(r *fastglue.Request) error {
-	return r.SendErrorEnvelope(fasthttp.StatusNotImplemented, "Not implemented yet", nil, "")
-}
+// CreateContact, UpdateContact and DeleteContact are implemented in contacts.go
 
 // AssignContactRequest represents the request to assign a contact to a user
 type AssignContactRequest struct {
@@ -79,10 +68,7 @@ func (a *App) AssignContact(r *fastglue.Request) error {
 }
 
 // Message handlers
-func (a *App) SendTemplateMessage(r *fastglue.Request) error {
-	return r.SendErrorEnvelope(fasthttp.StatusNotImplemented, "Not implemented yet", nil, "")
-}
-
+// SendTemplateMessage is implemented in template_messages.go
 // SendMediaMessage is implemented in contacts.go
 
 func (a *App) MarkMessageRead(r *fastglue.Request) error {
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+)
+
+// TemplateGroupResponse is a template name with the languages it exists in on an account
+type TemplateGroupResponse struct {
+	Name            string                     `json:"name"`
+	WhatsAppAccount string                     `json:"whatsapp_account"`
+	Category        string                     `json:"category"`
+	Languages       []TemplateLanguageResponse `json:"languages"`
+}
+
+// TemplateLanguageResponse is one language of a template group
+type TemplateLanguageResponse struct {
+	ID       uuid.UUID `json:"id"`
+	Language string    `json:"language"`
+	Status   string    `json:"status"`
+	Version  int       `json:"version"`
+}
+
+// ListTemplateGroups returns the organization's templates grouped by account and name,
+// with the languages each is available in
+func (a *App) ListTemplateGroups(r *fastglue.Request) error {
+	orgID, err := getOrganizationID(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	query := a.DB.Where("organization_id = ?", orgID)
+	if accountName := string(r.RequestCtx.QueryArgs().Peek("account")); accountName != "" {
+		query = query.Where("whats_app_account = ?", accountName)
+	}
+
+	var templates []models.Template
+	if err := query.Order("whats_app_account, name, language").Find(&templates).Error; err != nil {
+		a.Log.Error("Failed to list template groups", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to list templates", nil, "")
+	}
+
+	groups := []TemplateGroupResponse{}
+	for _, t := range templates {
+		if n := len(groups); n == 0 || groups[n-1].Name != t.Name || groups[n-1].WhatsAppAccount != t.WhatsAppAccount {
+			groups = append(groups, TemplateGroupResponse{
+				Name:            t.Name,
+				WhatsAppAccount: t.WhatsAppAccount,
+				Category:        t.Category,
+			})
+		}
+		group := &groups[len(groups)-1]
+		group.Languages = append(group.Languages, TemplateLanguageResponse{
+			ID:       t.ID,
+			Language: t.Language,
+			Status:   t.Status,
+			Version:  t.Version,
+		})
+	}
+
+	return r.SendEnvelope(map[string]interface{}{
+		"groups": groups,
+	})
+}
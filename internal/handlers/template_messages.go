@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/internal/templates"
+	"github.com/isaee-xyz/whatomate/internal/websocket"
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+	"gorm.io/gorm"
+)
+
+// templateVariablePattern matches positional template variables like {{1}}
+var templateVariablePattern = regexp.MustCompile(`\{\{\s*(\d+)\s*\}\}`)
+
+// SendTemplateMessageRequest represents a one-off template send
+// The contact is identified by contact_id or phone_number; the template by template_id or name + language.
+// A name without a language is sent in the language best suited to the contact's locale.
+// Names are unique per account, so a name on several accounts needs whatsapp_account.
+type SendTemplateMessageRequest struct {
+	ContactID       string                         `json:"contact_id"`
+	PhoneNumber     string                         `json:"phone_number"`
+	TemplateID      string                         `json:"template_id"`
+	TemplateName    string                         `json:"template_name"`
+	Language        string                         `json:"language"`
+	WhatsAppAccount string                         `json:"whatsapp_account"`
+	BodyParams      []string                       `json:"body_params"`
+	HeaderParam     *whatsapp.TemplateHeaderParam  `json:"header_param"`
+	ButtonParams    []whatsapp.TemplateButtonParam `json:"button_params"`
+}
+
+// SendTemplateMessage sends an approved template to a contact
+// Agents can only send to their assigned contacts (or new contacts, which are assigned to them)
+func (a *App) SendTemplateMessage(r *fastglue.Request) error {
+	orgID := r.RequestCtx.UserValue("organization_id").(uuid.UUID)
+	userID, _ := r.RequestCtx.UserValue("user_id").(uuid.UUID)
+	userRole, _ := r.RequestCtx.UserValue("role").(string)
+
+	var req SendTemplateMessageRequest
+	if err := r.Decode(&req, "json"); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
+	}
+
+	// Resolve template
+	var template models.Template
+	templateQuery := a.DB.Where("organization_id = ?", orgID)
+	if req.TemplateID != "" {
+		templateID, err := uuid.Parse(req.TemplateID)
+		if err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid template ID", nil, "")
+		}
+		templateQuery = templateQuery.Where("id = ?", templateID)
+	} else if req.TemplateName != "" {
+		templateQuery = templateQuery.Where("name = ?", req.TemplateName)
+		if req.Language != "" {
+			templateQuery = templateQuery.Where("language = ?", req.Language)
+		}
+		if req.WhatsAppAccount != "" {
+			templateQuery = templateQuery.Where("whats_app_account = ?", req.WhatsAppAccount)
+		} else {
+			var accounts []string
+			if err := templateQuery.Session(&gorm.Session{}).Model(&models.Template{}).Distinct().Pluck("whats_app_account", &accounts).Error; err != nil {
+				a.Log.Error("Failed to look up template", "error", err, "name", req.TemplateName)
+				return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to look up template", nil, "")
+			}
+			if len(accounts) > 1 {
+				return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Template name exists on several WhatsApp accounts; set whatsapp_account", nil, "")
+			}
+		}
+		// An approved language first when none is given; the rest only makes the choice stable
+		templateQuery = templateQuery.Order("UPPER(status) = 'APPROVED' DESC").Order("language").Order("id")
+	} else {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "template_id or template_name is required", nil, "")
+	}
+	if err := templateQuery.First(&template).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Template not found", nil, "")
+	}
+	if !strings.EqualFold(template.Status, "APPROVED") {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Template is not approved", nil, "")
+	}
+
+	// Templates belong to the account (WABA) they were created on
+	var account models.WhatsAppAccount
+	if err := a.DB.Where("name = ? AND organization_id = ?", template.WhatsAppAccount, orgID).First(&account).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "WhatsApp account not found", nil, "")
+	}
+
+	// Resolve contact
+	var contact *models.Contact
+	if req.ContactID != "" {
+		contactID, err := uuid.Parse(req.ContactID)
+		if err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid contact ID", nil, "")
+		}
+		var c models.Contact
+		query := a.DB.Where("id = ? AND organization_id = ?", contactID, orgID)
+		if userRole == "agent" {
+			query = query.Where("assigned_user_id = ?", userID)
+		}
+		if err := query.First(&c).Error; err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Contact not found", nil, "")
+		}
+		contact = &c
+	} else if req.PhoneNumber != "" {
+		phoneNumber, err := whatsapp.NormalizePhoneNumber(req.PhoneNumber)
+		if err != nil {
+			return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid phone number: use international format with country code", nil, "")
+		}
+		c, isNew, err := a.getOrCreateContact(orgID, phoneNumber, "")
+		if err != nil {
+			a.Log.Error("Failed to get or create contact", "error", err, "phone", phoneNumber)
+			return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to create contact", nil, "")
+		}
+		if isNew {
+			if userRole == "agent" {
+				a.DB.Model(c).Update("assigned_user_id", userID)
+				c.AssignedUserID = &userID
+			}
+			a.DispatchWebhook(orgID, EventContactCreated, contactEventData(c))
+		} else if userRole == "agent" && (c.AssignedUserID == nil || *c.AssignedUserID != userID) {
+			return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Contact not found", nil, "")
+		}
+		contact = c
+	} else {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "contact_id or phone_number is required", nil, "")
+	}
+
+	if a.isOptedOut(orgID, contact.PhoneNumber) {
+		return r.SendErrorEnvelope(fasthttp.StatusUnprocessableEntity, "Contact has opted out of messages", map[string]string{"code": "contact_opted_out"}, "")
+	}
+
+	params := &whatsapp.TemplateSendParams{
+		Header:  req.HeaderParam,
+		Body:    req.BodyParams,
+		Buttons: req.ButtonParams,
+	}
+	// Without a language, the template is sent in the language best suited to the contact
+	if req.TemplateID == "" && req.Language == "" {
+		fits := func(t *models.Template) bool {
+			p := *params
+			if params.Header != nil {
+				header := *params.Header
+				p.Header = &header
+			}
+			return validateTemplateSendParams(t, &p) == nil
+		}
+		template = *templates.Localized(templates.Variants(a.DB, &template, template.WhatsAppAccount), &template, contact.Locale, templates.LanguageFallback(a.DB, orgID), fits)
+	}
+	if err := validateTemplateSendParams(&template, params); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, err.Error(), nil, "")
+	}
+
+	// Create message record
+	content := renderTemplateBody(template.BodyContent, req.BodyParams)
+	message := models.Message{
+		BaseModel:       models.BaseModel{ID: uuid.New()},
+		OrganizationID:  orgID,
+		WhatsAppAccount: account.Name,
+		ContactID:       contact.ID,
+		Direction:       "outgoing",
+		MessageType:     "template",
+		Content:         content,
+		TemplateName:    template.Name,
+		TemplateParams:  templateParamsToJSONB(params),
+		Status:          "pending",
+		SentByUserID:    &userID,
+		Metadata: models.JSONB{
+			"template_id":       template.ID.String(),
+			"template_language": template.Language,
+			"template_version":  template.Version,
+		},
+	}
+	if err := a.DB.Create(&message).Error; err != nil {
+		a.Log.Error("Failed to create message", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to create message", nil, "")
+	}
+
+	// Send synchronously so the caller learns whether the conversation was re-opened
+	waAccount := &whatsapp.Account{
+		PhoneID:     account.PhoneID,
+		BusinessID:  account.BusinessID,
+		APIVersion:  account.APIVersion,
+		AccessToken: account.AccessToken,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	wamid, sendErr := a.WhatsApp.SendTemplateMessageWithComponents(ctx, waAccount, contact.PhoneNumber, template.Name, template.Language, whatsapp.BuildTemplateComponents(params))
+	if sendErr != nil {
+		message.Status = "failed"
+		message.ErrorMessage = sendErr.Error()
+		a.DB.Model(&message).Updates(map[string]any{
+			"status":        message.Status,
+			"error_message": message.ErrorMessage,
+		})
+	} else {
+		message.Status = "sent"
+		message.WhatsAppMessageID = wamid
+		a.DB.Model(&message).Updates(map[string]any{
+			"status":               message.Status,
+			"whats_app_message_id": wamid,
+		})
+	}
+
+	// Update contact's last message
+	now := time.Now()
+	a.DB.Model(contact).Updates(map[string]any{
+		"last_message_at":      now,
+		"last_message_preview": truncateString(content, 100),
+	})
+
+	// Broadcast new outgoing message via WebSocket
+	if a.WSHub != nil {
+		a.WSHub.BroadcastToOrg(orgID, websocket.WSMessage{
+			Type: websocket.TypeNewMessage,
+			Payload: map[string]any{
+				"id":            message.ID,
+				"contact_id":    message.ContactID,
+				"direction":     message.Direction,
+				"message_type":  message.MessageType,
+				"content":       map[string]string{"body": message.Content},
+				"status":        message.Status,
+				"wamid":         message.WhatsAppMessageID,
+				"error_message": message.ErrorMessage,
+				"created_at":    message.CreatedAt,
+				"updated_at":    message.UpdatedAt,
+			},
+		})
+	}
+
+	if sendErr != nil {
+		a.Log.Error("Failed to send template message", "error", sendErr, "template", template.Name, "contact_id", contact.ID)
+		return r.SendErrorEnvelope(fasthttp.StatusBadGateway, "Failed to send template message", nil, "")
+	}
+
+	a.DispatchWebhook(orgID, EventMessageSent, MessageEventData{
+		MessageID:       message.ID.String(),
+		ContactID:       contact.ID.String(),
+		ContactPhone:    contact.PhoneNumber,
+		ContactName:     contact.ProfileName,
+		MessageType:     message.MessageType,
+		Content:         message.Content,
+		WhatsAppAccount: account.Name,
+		Direction:       "outgoing",
+		SentByUserID:    userID.String(),
+	})
+
+	return r.SendEnvelope(MessageResponse{
+		ID:          message.ID,
+		ContactID:   message.ContactID,
+		Direction:   message.Direction,
+		MessageType: message.MessageType,
+		Content:     map[string]string{"body": message.Content},
+		Status:      message.Status,
+		WAMID:       message.WhatsAppMessageID,
+		CreatedAt:   message.CreatedAt,
+		UpdatedAt:   message.UpdatedAt,
+	})
+}
+
+// templateVariableCount returns the highest positional variable index used in text
+func templateVariableCount(text string) int {
+	count := 0
+	for _, m := range templateVariablePattern.FindAllStringSubmatch(text, -1) {
+		if n, err := strconv.Atoi(m[1]); err == nil && n > count {
+			count = n
+		}
+	}
+	return count
+}
+
+// templateParamLabel formats a template parameter key for messages: {{1}}, {{name}}, header, button_0
+func templateParamLabel(key string) string {
+	if key == whatsapp.TemplateParamHeader || strings.HasPrefix(key, "button_") {
+		return key
+	}
+	return "{{" + key + "}}"
+}
+
+// renderTemplateBody substitutes positional variables with their parameter values
+func renderTemplateBody(body string, params []string) string {
+	return templateVariablePattern.ReplaceAllStringFunc(body, func(m string) string {
+		n, err := strconv.Atoi(templateVariablePattern.FindStringSubmatch(m)[1])
+		if err != nil || n < 1 || n > len(params) {
+			return m
+		}
+		return params[n-1]
+	})
+}
+
+// validateTemplateSendParams checks that all variables of the template have parameters
+func validateTemplateSendParams(template *models.Template, params *whatsapp.TemplateSendParams) error {
+	if n := templateVariableCount(template.BodyContent); len(params.Body) < n {
+		return fmt.Errorf("template body requires %d parameter(s), got %d", n, len(params.Body))
+	}
+
+	switch strings.ToUpper(template.HeaderType) {
+	case "TEXT":
+		if templateVariableCount(template.HeaderContent) > 0 && (params.Header == nil || params.Header.Text == "") {
+			return fmt.Errorf("template header requires a text parameter")
+		}
+	case "IMAGE", "DOCUMENT", "VIDEO":
+		if params.Header == nil || (params.Header.MediaID == "" && params.Header.Link == "") {
+			return fmt.Errorf("template header requires a %s (media_id or link)", strings.ToLower(template.HeaderType))
+		}
+		if params.Header.Type == "" {
+			params.Header.Type = strings.ToLower(template.HeaderType)
+		} else if !strings.EqualFold(params.Header.Type, template.HeaderType) {
+			return fmt.Errorf("template header expects %s, got %s", strings.ToLower(template.HeaderType), params.Header.Type)
+		}
+	}
+	if params.Header != nil && params.Header.Type == "" && params.Header.Text != "" {
+		params.Header.Type = "text"
+	}
+
+	provided := make(map[int]bool, len(params.Buttons))
+	for _, btn := range params.Buttons {
+		provided[btn.Index] = true
+	}
+	for i, b := range template.Buttons {
+		btn, ok := b.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		btnType, _ := btn["type"].(string)
+		btnURL, _ := btn["url"].(string)
+		needsParam := (strings.EqualFold(btnType, "URL") && templateVariableCount(btnURL) > 0) ||
+			strings.EqualFold(btnType, "COPY_CODE")
+		if needsParam && !provided[i] {
+			return fmt.Errorf("template button %d requires a parameter", i)
+		}
+	}
+
+	return nil
+}
+
+// templateParamsToJSONB stores template send parameters on the message
+func templateParamsToJSONB(params *whatsapp.TemplateSendParams) models.JSONB {
+	result := models.JSONB{}
+	if len(params.Body) > 0 {
+		result["body"] = params.Body
+	}
+	if params.Header != nil {
+		result["header"] = params.Header
+	}
+	if len(params.Buttons) > 0 {
+		result["buttons"] = params.Buttons
+	}
+	return result
+}
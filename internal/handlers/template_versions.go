@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+	"gorm.io/gorm"
+)
+
+// Sources of template versions
+const (
+	TemplateVersionInitial   = "initial" // State of a template that predates versioning, before its first recorded change
+	TemplateVersionCreated   = "created"
+	TemplateVersionEdited    = "edited"
+	TemplateVersionSubmitted = "submitted"
+	TemplateVersionSynced    = "synced"
+	TemplateVersionWebhook   = "webhook"
+	TemplateVersionImported  = "imported" // Created by importing a chatbot bundle
+)
+
+// templateVersionFields are the fields compared between versions to build a version's changes
+var templateVersionFields = []struct {
+	name  string
+	value func(v *models.TemplateVersion) interface{}
+}{
+	{"meta_template_id", func(v *models.TemplateVersion) interface{} { return v.MetaTemplateID }},
+	{"language", func(v *models.TemplateVersion) interface{} { return v.Language }},
+	{"category", func(v *models.TemplateVersion) interface{} { return v.Category }},
+	{"status", func(v *models.TemplateVersion) interface{} { return v.Status }},
+	{"header_type", func(v *models.TemplateVersion) interface{} { return v.HeaderType }},
+	{"header_content", func(v *models.TemplateVersion) interface{} { return v.HeaderContent }},
+	{"body_content", func(v *models.TemplateVersion) interface{} { return v.BodyContent }},
+	{"footer_content", func(v *models.TemplateVersion) interface{} { return v.FooterContent }},
+	{"buttons", func(v *models.TemplateVersion) interface{} { return convertFromJSONBArray(v.Buttons) }},
+	{"sample_values", func(v *models.TemplateVersion) interface{} { return convertFromJSONBArray(v.SampleValues) }},
+}
+
+// recordTemplateVersion records the current state of template as its next version and
+// sets template.Version. before is the template as it was before the change (nil for a
+// new template); templates that predate versioning get it recorded as their first version.
+// Nothing is recorded if neither the template nor the reason changed since the latest version.
+func recordTemplateVersion(tx *gorm.DB, before, template *models.Template, source, reason string, userID *uuid.UUID) error {
+	var latest models.TemplateVersion
+	err := tx.Where("template_id = ?", template.ID).Order("version DESC").First(&latest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) && before != nil {
+		latest = templateVersionSnapshot(before)
+		latest.Version = 1
+		latest.Source = TemplateVersionInitial
+		if err := tx.Create(&latest).Error; err != nil {
+			return err
+		}
+	} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	version := templateVersionSnapshot(template)
+	version.Version = latest.Version + 1
+	version.Source = source
+	version.RejectionReason = reason
+	version.CreatedBy = userID
+	if latest.Version > 0 {
+		version.Changes = templateVersionChanges(&latest, &version)
+		if len(version.Changes) == 0 && reason == latest.RejectionReason {
+			template.Version = latest.Version
+			return nil
+		}
+	}
+
+	if err := tx.Create(&version).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Model(&models.Template{}).Where("id = ?", template.ID).UpdateColumn("version", version.Version).Error; err != nil {
+		return err
+	}
+	template.Version = version.Version
+	return nil
+}
+
+// templateVersionSnapshot returns the versioned fields of a template
+func templateVersionSnapshot(t *models.Template) models.TemplateVersion {
+	return models.TemplateVersion{
+		OrganizationID: t.OrganizationID,
+		TemplateID:     t.ID,
+		MetaTemplateID: t.MetaTemplateID,
+		Name:           t.Name,
+		Language:       t.Language,
+		Category:       t.Category,
+		Status:         t.Status,
+		HeaderType:     t.HeaderType,
+		HeaderContent:  t.HeaderContent,
+		BodyContent:    t.BodyContent,
+		FooterContent:  t.FooterContent,
+		Buttons:        convertToJSONBArray(t.Buttons),
+		SampleValues:   convertToJSONBArray(t.SampleValues),
+		Changes:        models.JSONB{},
+	}
+}
+
+// templateVersionChanges returns field -> {from, to} for the fields that differ between versions
+func templateVersionChanges(from, to *models.TemplateVersion) models.JSONB {
+	changes := models.JSONB{}
+	for _, f := range templateVersionFields {
+		a, b := f.value(from), f.value(to)
+		aj, _ := json.Marshal(a)
+		bj, _ := json.Marshal(b)
+		if string(aj) != string(bj) {
+			changes[f.name] = map[string]interface{}{"from": a, "to": b}
+		}
+	}
+	return changes
+}
+
+// ListTemplateVersions returns the versions of a template, newest first
+func (a *App) ListTemplateVersions(r *fastglue.Request) error {
+	template, errResp := a.versionedTemplate(r)
+	if template == nil {
+		return errResp
+	}
+
+	var versions []models.TemplateVersion
+	if err := a.DB.Where("template_id = ?", template.ID).Order("version DESC").Find(&versions).Error; err != nil {
+		a.Log.Error("Failed to list template versions", "error", err, "template_id", template.ID)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to list template versions", nil, "")
+	}
+
+	return r.SendEnvelope(map[string]interface{}{
+		"template_id": template.ID,
+		"version":     template.Version,
+		"versions":    versions,
+	})
+}
+
+// GetTemplateVersion returns a single version of a template
+func (a *App) GetTemplateVersion(r *fastglue.Request) error {
+	template, errResp := a.versionedTemplate(r)
+	if template == nil {
+		return errResp
+	}
+
+	number, err := strconv.Atoi(r.RequestCtx.UserValue("version").(string))
+	if err != nil || number < 1 {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid version", nil, "")
+	}
+
+	var version models.TemplateVersion
+	if err := a.DB.Where("template_id = ? AND version = ?", template.ID, number).First(&version).Error; err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Template version not found", nil, "")
+	}
+
+	return r.SendEnvelope(version)
+}
+
+// versionedTemplate loads the template of a version request. Versions outlive their
+// template, so deleted templates are found too. Returns nil and the error response
+// if the template is not found.
+func (a *App) versionedTemplate(r *fastglue.Request) (*models.Template, error) {
+	orgID, err := getOrganizationID(r)
+	if err != nil {
+		return nil, r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	idStr, ok := r.RequestCtx.UserValue("id").(string)
+	if !ok || idStr == "" {
+		return nil, r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Missing template ID", nil, "")
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return nil, r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid template ID", nil, "")
+	}
+
+	var template models.Template
+	if err := a.DB.Unscoped().Where("id = ? AND organization_id = ?", id, orgID).First(&template).Error; err != nil {
+		return nil, r.SendErrorEnvelope(fasthttp.StatusNotFound, "Template not found", nil, "")
+	}
+	return &template, nil
+}
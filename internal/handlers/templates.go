@@ -10,6 +10,7 @@ import (
 	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
 	"github.com/valyala/fasthttp"
 	"github.com/zerodha/fastglue"
+	"gorm.io/gorm"
 )
 
 // TemplateRequest represents the request body for creating/updating a template
@@ -43,6 +44,7 @@ type TemplateResponse struct {
 	FooterContent   string        `json:"footer_content"`
 	Buttons         []interface{} `json:"buttons"`
 	SampleValues    []interface{} `json:"sample_values"`
+	Version         int           `json:"version"` // Latest version, see /api/templates/{id}/versions
 	CreatedAt       string        `json:"created_at"`
 	UpdatedAt       string        `json:"updated_at"`
 }
@@ -140,7 +142,14 @@ func (a *App) CreateTemplate(r *fastglue.Request) error {
 		SampleValues:    convertToJSONBArray(req.SampleValues),
 	}
 
-	if err := a.DB.Create(&template).Error; err != nil {
+	userID, _ := r.RequestCtx.UserValue("user_id").(uuid.UUID)
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&template).Error; err != nil {
+			return err
+		}
+		return recordTemplateVersion(tx, nil, &template, TemplateVersionCreated, "", &userID)
+	})
+	if err != nil {
 		a.Log.Error("Failed to create template", "error", err)
 		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to create template", nil, "")
 	}
@@ -203,7 +212,8 @@ func (a *App) UpdateTemplate(r *fastglue.Request) error {
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
 	}
 
-	// Update fields
+	// Update fields; the previous content stays available as a version
+	before := template
 	if req.DisplayName != "" {
 		template.DisplayName = req.DisplayName
 	}
@@ -228,7 +238,14 @@ func (a *App) UpdateTemplate(r *fastglue.Request) error {
 		template.SampleValues = convertToJSONBArray(req.SampleValues)
 	}
 
-	if err := a.DB.Save(&template).Error; err != nil {
+	userID, _ := r.RequestCtx.UserValue("user_id").(uuid.UUID)
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&template).Error; err != nil {
+			return err
+		}
+		return recordTemplateVersion(tx, &before, &template, TemplateVersionEdited, "", &userID)
+	})
+	if err != nil {
 		a.Log.Error("Failed to update template", "error", err)
 		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to update template", nil, "")
 	}
@@ -300,12 +317,20 @@ func (a *App) SubmitTemplate(r *fastglue.Request) error {
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Template already submitted to Meta", nil, "")
 	}
 
+	// Catch what Meta would reject before submitting
+	issues := whatsapp.ValidateTemplate(templateSubmission(&template))
+	if first := issues.FirstError(); first != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Template is invalid: "+first.Message, map[string]interface{}{"issues": issues}, "")
+	}
+
 	// Get the WhatsApp account
 	var account models.WhatsAppAccount
 	if err := a.DB.Where("name = ? AND organization_id = ?", template.WhatsAppAccount, orgID).First(&account).Error; err != nil {
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "WhatsApp account not found", nil, "")
 	}
 
+	before := template
+
 	// For rejected templates, delete the old one first then create new
 	if template.Status == "REJECTED" && template.MetaTemplateID != "" {
 		a.Log.Info("Deleting rejected template before resubmission", "template", template.Name)
@@ -324,7 +349,14 @@ func (a *App) SubmitTemplate(r *fastglue.Request) error {
 
 	// Update template status
 	template.Status = "PENDING"
-	if err := a.DB.Save(&template).Error; err != nil {
+	userID, _ := r.RequestCtx.UserValue("user_id").(uuid.UUID)
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&template).Error; err != nil {
+			return err
+		}
+		return recordTemplateVersion(tx, &before, &template, TemplateVersionSubmitted, "", &userID)
+	})
+	if err != nil {
 		a.Log.Error("Failed to update template after submission", "error", err)
 		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Template submitted but failed to update local record", nil, "")
 	}
@@ -346,20 +378,51 @@ func (a *App) submitTemplateToMeta(account *models.WhatsAppAccount, template *mo
 		AccessToken: account.AccessToken,
 	}
 
-	submission := &whatsapp.TemplateSubmission{
-		Name:          template.Name,
-		Language:      template.Language,
-		Category:      template.Category,
-		HeaderType:    template.HeaderType,
-		HeaderContent: template.HeaderContent,
-		BodyContent:   template.BodyContent,
-		FooterContent: template.FooterContent,
-		Buttons:       template.Buttons,
-		SampleValues:  template.SampleValues,
+	ctx := context.Background()
+	return a.WhatsApp.SubmitTemplate(ctx, waAccount, templateSubmission(template))
+}
+
+// ValidateTemplate checks a template against Meta's rules without saving or submitting it
+func (a *App) ValidateTemplate(r *fastglue.Request) error {
+	if _, err := getOrganizationID(r); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
 	}
 
-	ctx := context.Background()
-	return a.WhatsApp.SubmitTemplate(ctx, waAccount, submission)
+	var req TemplateRequest
+	if err := r.Decode(&req, "json"); err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid request body", nil, "")
+	}
+
+	// Validate the template as it would be saved
+	template := models.Template{
+		Name:          normalizeTemplateName(req.Name),
+		Language:      req.Language,
+		Category:      strings.ToUpper(req.Category),
+		HeaderType:    strings.ToUpper(req.HeaderType),
+		HeaderContent: req.HeaderContent,
+		BodyContent:   req.BodyContent,
+		FooterContent: req.FooterContent,
+		Buttons:       convertToJSONBArray(req.Buttons),
+		SampleValues:  convertToJSONBArray(req.SampleValues),
+	}
+
+	issues := whatsapp.ValidateTemplate(templateSubmission(&template))
+	if template.Name != req.Name && template.Name != "" {
+		issues = append(issues, whatsapp.TemplateIssue{
+			Field:    "name",
+			Severity: whatsapp.TemplateIssueWarning,
+			Message:  fmt.Sprintf("name will be saved as %s", template.Name),
+		})
+	}
+	if issues == nil {
+		issues = whatsapp.TemplateIssues{}
+	}
+
+	return r.SendEnvelope(map[string]interface{}{
+		"valid":  !issues.HasErrors(),
+		"name":   template.Name,
+		"issues": issues,
+	})
 }
 
 // SyncTemplates syncs templates from Meta API
@@ -431,13 +494,23 @@ func (a *App) SyncTemplates(r *fastglue.Request) error {
 			}
 		}
 
-		// Upsert (including soft-deleted templates to restore them)
-		existing := models.Template{}
-		if err := a.DB.Unscoped().Where("organization_id = ? AND whats_app_account = ? AND name = ? AND language = ?",
-			orgID, account.Name, template.Name, template.Language).First(&existing).Error; err == nil {
+		// Upsert (including soft-deleted templates to restore them). Meta's copy wins;
+		// local edits it replaces remain in the template's versions.
+		err := a.DB.Transaction(func(tx *gorm.DB) error {
+			existing := models.Template{}
+			if err := tx.Unscoped().Where("organization_id = ? AND whats_app_account = ? AND name = ? AND language = ?",
+				orgID, account.Name, template.Name, template.Language).First(&existing).Error; err != nil {
+				// Create new
+				if err := tx.Create(&template).Error; err != nil {
+					return err
+				}
+				return recordTemplateVersion(tx, nil, &template, TemplateVersionSynced, "", nil)
+			}
+
 			// Update existing and restore if soft-deleted (explicitly set deleted_at to NULL)
 			template.ID = existing.ID
-			a.DB.Unscoped().Model(&template).Updates(map[string]interface{}{
+			template.SampleValues = existing.SampleValues
+			if err := tx.Unscoped().Model(&template).Updates(map[string]interface{}{
 				"meta_template_id": template.MetaTemplateID,
 				"display_name":     template.DisplayName,
 				"category":         template.Category,
@@ -448,10 +521,14 @@ func (a *App) SyncTemplates(r *fastglue.Request) error {
 				"footer_content":   template.FooterContent,
 				"buttons":          template.Buttons,
 				"deleted_at":       nil, // Restore soft-deleted template
-			})
-		} else {
-			// Create new
-			a.DB.Create(&template)
+			}).Error; err != nil {
+				return err
+			}
+			return recordTemplateVersion(tx, &existing, &template, TemplateVersionSynced, "", nil)
+		})
+		if err != nil {
+			a.Log.Error("Failed to sync template", "error", err, "template", template.Name, "language", template.Language)
+			continue
 		}
 		synced++
 	}
@@ -490,6 +567,21 @@ func (a *App) deleteTemplateFromMeta(account *models.WhatsAppAccount, templateNa
 
 // Helper functions
 
+// templateSubmission returns the template as submitted to Meta
+func templateSubmission(template *models.Template) *whatsapp.TemplateSubmission {
+	return &whatsapp.TemplateSubmission{
+		Name:          template.Name,
+		Language:      template.Language,
+		Category:      template.Category,
+		HeaderType:    template.HeaderType,
+		HeaderContent: template.HeaderContent,
+		BodyContent:   template.BodyContent,
+		FooterContent: template.FooterContent,
+		Buttons:       template.Buttons,
+		SampleValues:  template.SampleValues,
+	}
+}
+
 func templateToResponse(t models.Template) TemplateResponse {
 	return TemplateResponse{
 		ID:              t.ID,
@@ -506,6 +598,7 @@ func templateToResponse(t models.Template) TemplateResponse {
 		FooterContent:   t.FooterContent,
 		Buttons:         convertFromJSONBArray(t.Buttons),
 		SampleValues:    convertFromJSONBArray(t.SampleValues),
+		Version:         t.Version,
 		CreatedAt:       t.CreatedAt.Format("2006-01-02T15:04:05Z"),
 		UpdatedAt:       t.UpdatedAt.Format("2006-01-02T15:04:05Z"),
 	}
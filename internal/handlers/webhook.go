@@ -1,15 +1,25 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/internal/queue"
 	"github.com/isaee-xyz/whatomate/internal/websocket"
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
 	"github.com/valyala/fasthttp"
 	"github.com/zerodha/fastglue"
+	"gorm.io/gorm"
 )
 
+// webhookRejectedCachePrefix is the Redis key prefix for rejected webhook counters (per reason)
+const webhookRejectedCachePrefix = "webhook:rejected:"
+
 // WebhookVerify handles Meta's webhook verification challenge
 func (a *App) WebhookVerify(r *fastglue.Request) error {
 	mode := string(r.RequestCtx.QueryArgs().Peek("hub.mode"))
@@ -183,13 +193,48 @@ type WebhookPayload struct {
 
 // WebhookHandler processes incoming webhook events from Meta
 func (a *App) WebhookHandler(r *fastglue.Request) error {
+	body := r.RequestCtx.PostBody()
+
 	var payload WebhookPayload
-	if err := json.Unmarshal(r.RequestCtx.PostBody(), &payload); err != nil {
+	if err := json.Unmarshal(body, &payload); err != nil {
 		a.Log.Error("Failed to parse webhook payload", "error", err)
 		return r.SendErrorEnvelope(fasthttp.StatusBadRequest, "Invalid payload", nil, "")
 	}
 
-	// Process each entry
+	// Reject payloads that are not signed by Meta with a known app secret
+	signature := string(r.RequestCtx.Request.Header.Peek(whatsapp.SignatureHeader))
+	if ok, reason := a.verifyWebhookSignature(&payload, body, signature); !ok {
+		a.recordRejectedWebhook(reason)
+		a.Log.Warn("Rejected webhook with invalid signature",
+			"reason", reason,
+			"remote_ip", r.RequestCtx.RemoteIP().String(),
+		)
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Invalid signature", nil, "")
+	}
+
+	events := a.buildWebhookEvents(&payload)
+	if len(events) == 0 {
+		return r.SendEnvelope(map[string]string{"status": "ok"})
+	}
+
+	// Persist before acknowledging so nothing is lost if the process dies mid-processing.
+	// If the queue is unavailable, fail the request so Meta redelivers the payload.
+	if err := a.WebhookQueue.Enqueue(context.Background(), events); err != nil {
+		a.Log.Error("Failed to enqueue webhook events", "error", err, "count", len(events))
+		return r.SendErrorEnvelope(fasthttp.StatusServiceUnavailable, "Failed to queue webhook", nil, "")
+	}
+
+	// Respond with 200 to acknowledge receipt; events are processed by the webhook processor
+	return r.SendEnvelope(map[string]string{"status": "ok"})
+}
+
+// buildWebhookEvents splits a webhook payload into queue events.
+// Messages and statuses are keyed by phone number ID and contact so that events
+// for the same conversation are processed in the order Meta sent them.
+func (a *App) buildWebhookEvents(payload *WebhookPayload) []queue.WebhookEvent {
+	var events []queue.WebhookEvent
+	receivedAt := time.Now()
+
 	for _, entry := range payload.Entry {
 		for _, change := range entry.Changes {
 			// Handle template status updates
@@ -200,7 +245,24 @@ func (a *App) WebhookHandler(r *fastglue.Request) error {
 					"template_language", change.Value.MessageTemplateLanguage,
 					"waba_id", entry.ID,
 				)
-				go a.processTemplateStatusUpdate(entry.ID, change.Value.Event, change.Value.MessageTemplateName, change.Value.MessageTemplateLanguage, change.Value.Reason)
+				data, err := json.Marshal(TemplateStatusUpdate{
+					Event:                   change.Value.Event,
+					MessageTemplateID:       change.Value.MessageTemplateID,
+					MessageTemplateName:     change.Value.MessageTemplateName,
+					MessageTemplateLanguage: change.Value.MessageTemplateLanguage,
+					Reason:                  change.Value.Reason,
+				})
+				if err != nil {
+					a.Log.Error("Failed to marshal template status update", "error", err)
+					continue
+				}
+				events = append(events, queue.WebhookEvent{
+					Type:       queue.WebhookEventTemplateStatus,
+					WABAID:     entry.ID,
+					Key:        "waba:" + entry.ID,
+					Payload:    data,
+					ReceivedAt: receivedAt,
+				})
 				continue
 			}
 
@@ -210,7 +272,7 @@ func (a *App) WebhookHandler(r *fastglue.Request) error {
 
 			phoneNumberID := change.Value.Metadata.PhoneNumberID
 
-			// Process messages
+			// Queue messages
 			for _, msg := range change.Value.Messages {
 				a.Log.Info("Received message",
 					"from", msg.From,
@@ -227,71 +289,176 @@ func (a *App) WebhookHandler(r *fastglue.Request) error {
 					}
 				}
 
-				// Process message asynchronously
-				go a.processIncomingMessage(phoneNumberID, msg, profileName)
+				data, err := json.Marshal(msg)
+				if err != nil {
+					a.Log.Error("Failed to marshal message", "error", err)
+					continue
+				}
+				events = append(events, queue.WebhookEvent{
+					Type:          queue.WebhookEventMessage,
+					PhoneNumberID: phoneNumberID,
+					WABAID:        entry.ID,
+					Key:           phoneNumberID + ":" + msg.From,
+					ProfileName:   profileName,
+					Payload:       data,
+					ReceivedAt:    receivedAt,
+				})
 			}
 
-			// Process status updates
+			// Queue status updates
 			for _, status := range change.Value.Statuses {
 				a.Log.Info("Received status update",
 					"message_id", status.ID,
 					"status", status.Status,
 				)
 
-				go a.processStatusUpdate(phoneNumberID, status)
+				data, err := json.Marshal(status)
+				if err != nil {
+					a.Log.Error("Failed to marshal status update", "error", err)
+					continue
+				}
+				events = append(events, queue.WebhookEvent{
+					Type:          queue.WebhookEventStatus,
+					PhoneNumberID: phoneNumberID,
+					WABAID:        entry.ID,
+					Key:           phoneNumberID + ":" + status.RecipientID,
+					Payload:       data,
+					ReceivedAt:    receivedAt,
+				})
 			}
 		}
 	}
 
-	// Always respond with 200 to acknowledge receipt
-	return r.SendEnvelope(map[string]string{"status": "ok"})
+	return events
+}
+
+// HandleWebhookEvent processes a single queued webhook event.
+// A returned error causes the event to be retried and eventually dead-lettered.
+func (a *App) HandleWebhookEvent(ctx context.Context, event *queue.WebhookEvent) error {
+	switch event.Type {
+	case queue.WebhookEventMessage:
+		return a.processIncomingMessage(event.PhoneNumberID, event.Payload, event.ProfileName)
+	case queue.WebhookEventStatus:
+		var status WebhookStatus
+		if err := json.Unmarshal(event.Payload, &status); err != nil {
+			return fmt.Errorf("failed to unmarshal status update: %w", err)
+		}
+		return a.processStatusUpdate(event.PhoneNumberID, status)
+	case queue.WebhookEventTemplateStatus:
+		var update TemplateStatusUpdate
+		if err := json.Unmarshal(event.Payload, &update); err != nil {
+			return fmt.Errorf("failed to unmarshal template status update: %w", err)
+		}
+		return a.processTemplateStatusUpdate(event.WABAID, update.Event, update.MessageTemplateName, update.MessageTemplateLanguage, update.Reason)
+	default:
+		return fmt.Errorf("unknown webhook event type: %s", event.Type)
+	}
+}
+
+// verifyWebhookSignature checks the X-Hub-Signature-256 header against the global app secret
+// and the app secrets of the accounts referenced by the payload (by WABA ID or phone number ID).
+// Returns whether the payload is trusted and, if not, the reason it was rejected.
+func (a *App) verifyWebhookSignature(payload *WebhookPayload, body []byte, signature string) (bool, string) {
+	if a.Config.WhatsApp.SkipSignatureVerification && a.Config.App.Environment != "production" {
+		return true, ""
+	}
+
+	if signature == "" {
+		return false, "missing_signature"
+	}
+
+	if a.Config.WhatsApp.AppSecret != "" && whatsapp.VerifySignature(body, signature, a.Config.WhatsApp.AppSecret) {
+		return true, ""
+	}
+
+	// Collect identifiers of the accounts this payload is addressed to
+	var businessIDs, phoneIDs []string
+	for _, entry := range payload.Entry {
+		if entry.ID != "" {
+			businessIDs = append(businessIDs, entry.ID)
+		}
+		for _, change := range entry.Changes {
+			if change.Value.Metadata.PhoneNumberID != "" {
+				phoneIDs = append(phoneIDs, change.Value.Metadata.PhoneNumberID)
+			}
+		}
+	}
+	if len(businessIDs) == 0 && len(phoneIDs) == 0 {
+		return false, "unknown_account"
+	}
+
+	var accounts []models.WhatsAppAccount
+	if err := a.DB.Select("id", "app_secret").
+		Where("app_secret <> '' AND (business_id IN ? OR phone_id IN ?)", businessIDs, phoneIDs).
+		Find(&accounts).Error; err != nil {
+		a.Log.Error("Failed to load accounts for webhook signature check", "error", err)
+		return false, "lookup_failed"
+	}
+
+	if len(accounts) == 0 && a.Config.WhatsApp.AppSecret == "" {
+		return false, "no_app_secret"
+	}
+
+	for _, account := range accounts {
+		if whatsapp.VerifySignature(body, signature, account.AppSecret) {
+			return true, ""
+		}
+	}
+
+	return false, "invalid_signature"
 }
 
-func (a *App) processIncomingMessage(phoneNumberID string, msg interface{}, profileName string) {
-	// Convert msg interface to the message struct
-	msgBytes, err := json.Marshal(msg)
-	if err != nil {
-		a.Log.Error("Failed to marshal message", "error", err)
-		return
+// recordRejectedWebhook increments the rejected webhook counter for the given reason
+func (a *App) recordRejectedWebhook(reason string) {
+	if err := a.Redis.Incr(context.Background(), webhookRejectedCachePrefix+reason).Err(); err != nil {
+		a.Log.Error("Failed to record rejected webhook", "error", err, "reason", reason)
 	}
+}
 
+func (a *App) processIncomingMessage(phoneNumberID string, msgBytes []byte, profileName string) error {
 	var textMsg IncomingTextMessage
 	if err := json.Unmarshal(msgBytes, &textMsg); err != nil {
-		a.Log.Error("Failed to unmarshal message", "error", err)
-		return
+		return fmt.Errorf("failed to unmarshal message: %w", err)
 	}
 
 	// Check for duplicate message - Meta sometimes sends the same message multiple times
 	if textMsg.ID != "" {
 		var existingMsg models.Message
-		if err := a.DB.Where("whats_app_message_id = ?", textMsg.ID).First(&existingMsg).Error; err == nil {
+		err := a.DB.Where("whats_app_message_id = ?", textMsg.ID).First(&existingMsg).Error
+		if err == nil {
 			a.Log.Debug("Duplicate message detected, skipping", "message_id", textMsg.ID)
-			return
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to check for duplicate message: %w", err)
 		}
 	}
 
 	// Process the message with chatbot logic
-	a.processIncomingMessageFull(phoneNumberID, textMsg, profileName)
+	return a.processIncomingMessageFull(phoneNumberID, textMsg, profileName)
 }
 
-func (a *App) processStatusUpdate(phoneNumberID string, status WebhookStatus) {
+func (a *App) processStatusUpdate(phoneNumberID string, status WebhookStatus) error {
 	messageID := status.ID
 	statusValue := status.Status
 
 	a.Log.Info("Processing status update", "message_id", messageID, "status", statusValue, "phone_number_id", phoneNumberID)
 
 	// Update messages table - this also handles campaign stats via incrementCampaignStat
-	a.updateMessageStatus(messageID, statusValue, status.Errors)
+	return a.updateMessageStatus(messageID, statusValue, status.Errors)
 }
 
 // updateMessageStatus updates the status of a regular message in the messages table
-func (a *App) updateMessageStatus(whatsappMsgID, statusValue string, errors []WebhookStatusError) {
+func (a *App) updateMessageStatus(whatsappMsgID, statusValue string, statusErrors []WebhookStatusError) error {
 	// Find the message by WhatsApp message ID
 	var message models.Message
 	result := a.DB.Where("whats_app_message_id = ?", whatsappMsgID).First(&message)
 	if result.Error != nil {
+		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to find message for status update: %w", result.Error)
+		}
 		a.Log.Debug("No message found for status update", "whats_app_message_id", whatsappMsgID)
-		return
+		return nil
 	}
 
 	updates := map[string]interface{}{}
@@ -305,17 +472,16 @@ func (a *App) updateMessageStatus(whatsappMsgID, statusValue string, errors []We
 		updates["status"] = "read"
 	case "failed":
 		updates["status"] = "failed"
-		if len(errors) > 0 {
-			updates["error_message"] = errors[0].Message
+		if len(statusErrors) > 0 {
+			updates["error_message"] = statusErrors[0].Message
 		}
 	default:
 		a.Log.Debug("Ignoring message status update", "status", statusValue)
-		return
+		return nil
 	}
 
 	if err := a.DB.Model(&message).Updates(updates).Error; err != nil {
-		a.Log.Error("Failed to update message status", "error", err, "message_id", message.ID)
-		return
+		return fmt.Errorf("failed to update message status: %w", err)
 	}
 
 	a.Log.Info("Updated message status", "message_id", message.ID, "status", statusValue)
@@ -337,49 +503,71 @@ func (a *App) updateMessageStatus(whatsappMsgID, statusValue string, errors []We
 			},
 		})
 	}
+	return nil
 }
 
 // processTemplateStatusUpdate updates template status when Meta sends a status update webhook
-func (a *App) processTemplateStatusUpdate(wabaID, event, templateName, templateLanguage, reason string) {
+func (a *App) processTemplateStatusUpdate(wabaID, event, templateName, templateLanguage, reason string) error {
 	if templateName == "" {
 		a.Log.Warn("Template status update missing template name")
-		return
+		return nil
 	}
 
 	// Map Meta's event names to lowercase status values
 	// Events: APPROVED, REJECTED, PENDING, DISABLED, PENDING_DELETION, DELETED, REINSTATED, FLAGGED
 	status := strings.ToLower(event)
+	if strings.EqualFold(reason, "NONE") {
+		reason = "" // Meta sends NONE when there is no reason, e.g. on approval
+	}
 
 	// Find WhatsApp accounts that use this WABA ID
 	var accounts []models.WhatsAppAccount
-	if err := a.DB.Where("whatsapp_business_account_id = ?", wabaID).Find(&accounts).Error; err != nil {
-		a.Log.Error("Failed to find WhatsApp accounts for WABA", "error", err, "waba_id", wabaID)
-		return
+	if err := a.DB.Where("business_id = ?", wabaID).Find(&accounts).Error; err != nil {
+		return fmt.Errorf("failed to find WhatsApp accounts for WABA %s: %w", wabaID, err)
 	}
 
 	if len(accounts) == 0 {
 		a.Log.Warn("No WhatsApp accounts found for WABA", "waba_id", wabaID)
-		return
+		return nil
 	}
 
 	// Update template for each account that has it
+	var updateErr error
 	for _, account := range accounts {
-		// Find and update the template
-		result := a.DB.Model(&models.Template{}).
-			Where("whats_app_account = ? AND name = ? AND language = ?", account.Name, templateName, templateLanguage).
-			Update("status", status)
+		// Find and update the template, recording the status and Meta's reason as a version
+		var updated bool
+		err := a.DB.Transaction(func(tx *gorm.DB) error {
+			var templates []models.Template
+			if err := tx.Where("whats_app_account = ? AND name = ? AND language = ?", account.Name, templateName, templateLanguage).
+				Find(&templates).Error; err != nil {
+				return err
+			}
+			for i := range templates {
+				before := templates[i]
+				templates[i].Status = status
+				if err := tx.Model(&templates[i]).Update("status", status).Error; err != nil {
+					return err
+				}
+				if err := recordTemplateVersion(tx, &before, &templates[i], TemplateVersionWebhook, reason, nil); err != nil {
+					return err
+				}
+				updated = true
+			}
+			return nil
+		})
 
-		if result.Error != nil {
+		if err != nil {
 			a.Log.Error("Failed to update template status",
-				"error", result.Error,
+				"error", err,
 				"account", account.Name,
 				"template", templateName,
 				"language", templateLanguage,
 			)
+			updateErr = err
 			continue
 		}
 
-		if result.RowsAffected > 0 {
+		if updated {
 			a.Log.Info("Updated template status from webhook",
 				"account", account.Name,
 				"template", templateName,
@@ -389,4 +577,5 @@ func (a *App) processTemplateStatusUpdate(wabaID, event, templateName, templateL
 			)
 		}
 	}
+	return updateErr
 }
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/internal/queue"
+	"github.com/redis/go-redis/v9"
+	"github.com/valyala/fasthttp"
+	"github.com/zerodha/fastglue"
+)
+
+// deadLetterScanLimit is how many dead letters are scanned when listing for an organization
+const deadLetterScanLimit = 1000
+
+// ListWebhookDeadLetters returns inbound webhook events that exhausted their retries
+// for the organization's WhatsApp accounts
+func (a *App) ListWebhookDeadLetters(r *fastglue.Request) error {
+	orgID, err := getOrganizationID(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	limit, _ := strconv.Atoi(string(r.RequestCtx.QueryArgs().Peek("limit")))
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+
+	owns, err := a.deadLetterOwnership(orgID)
+	if err != nil {
+		a.Log.Error("Failed to load accounts for dead letters", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to list dead letters", nil, "")
+	}
+
+	letters, err := a.WebhookQueue.ListDeadLetters(context.Background(), deadLetterScanLimit)
+	if err != nil {
+		a.Log.Error("Failed to list dead letters", "error", err)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to list dead letters", nil, "")
+	}
+
+	result := make([]queue.WebhookDeadLetter, 0, limit)
+	for _, letter := range letters {
+		if !owns(&letter.Event) {
+			continue
+		}
+		result = append(result, letter)
+		if len(result) >= limit {
+			break
+		}
+	}
+
+	return r.SendEnvelope(map[string]interface{}{
+		"dead_letters": result,
+	})
+}
+
+// ReplayWebhookDeadLetter re-queues a dead-lettered webhook event for processing
+func (a *App) ReplayWebhookDeadLetter(r *fastglue.Request) error {
+	orgID, err := getOrganizationID(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	id := r.RequestCtx.UserValue("id").(string)
+	if _, err := a.getOwnedDeadLetter(orgID, id); err != nil {
+		return a.sendDeadLetterError(r, err)
+	}
+
+	if err := a.WebhookQueue.ReplayDeadLetter(context.Background(), id); err != nil {
+		a.Log.Error("Failed to replay dead letter", "error", err, "id", id)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to replay dead letter", nil, "")
+	}
+
+	a.Log.Info("Replayed webhook dead letter", "id", id, "organization_id", orgID)
+	return r.SendEnvelope(map[string]string{"message": "Dead letter replayed"})
+}
+
+// DeleteWebhookDeadLetter discards a dead-lettered webhook event
+func (a *App) DeleteWebhookDeadLetter(r *fastglue.Request) error {
+	orgID, err := getOrganizationID(r)
+	if err != nil {
+		return r.SendErrorEnvelope(fasthttp.StatusUnauthorized, "Unauthorized", nil, "")
+	}
+
+	id := r.RequestCtx.UserValue("id").(string)
+	if _, err := a.getOwnedDeadLetter(orgID, id); err != nil {
+		return a.sendDeadLetterError(r, err)
+	}
+
+	if err := a.WebhookQueue.DeleteDeadLetter(context.Background(), id); err != nil {
+		a.Log.Error("Failed to delete dead letter", "error", err, "id", id)
+		return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to delete dead letter", nil, "")
+	}
+
+	return r.SendEnvelope(map[string]string{"message": "Dead letter deleted"})
+}
+
+// errDeadLetterNotFound is returned when a dead letter does not exist or belongs to another organization
+var errDeadLetterNotFound = errors.New("dead letter not found")
+
+// getOwnedDeadLetter loads a dead letter and checks it belongs to one of the organization's accounts
+func (a *App) getOwnedDeadLetter(orgID uuid.UUID, id string) (*queue.WebhookDeadLetter, error) {
+	letter, err := a.WebhookQueue.GetDeadLetter(context.Background(), id)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, errDeadLetterNotFound
+		}
+		return nil, err
+	}
+
+	owns, err := a.deadLetterOwnership(orgID)
+	if err != nil {
+		return nil, err
+	}
+	if !owns(&letter.Event) {
+		return nil, errDeadLetterNotFound
+	}
+	return letter, nil
+}
+
+// deadLetterOwnership returns a matcher for events addressed to the organization's accounts
+func (a *App) deadLetterOwnership(orgID uuid.UUID) (func(event *queue.WebhookEvent) bool, error) {
+	var accounts []models.WhatsAppAccount
+	if err := a.DB.Select("phone_id", "business_id").Where("organization_id = ?", orgID).Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+
+	phoneIDs := make(map[string]bool, len(accounts))
+	businessIDs := make(map[string]bool, len(accounts))
+	for _, account := range accounts {
+		phoneIDs[account.PhoneID] = true
+		businessIDs[account.BusinessID] = true
+	}
+
+	return func(event *queue.WebhookEvent) bool {
+		if event.PhoneNumberID != "" {
+			return phoneIDs[event.PhoneNumberID]
+		}
+		return event.WABAID != "" && businessIDs[event.WABAID]
+	}, nil
+}
+
+// sendDeadLetterError maps dead letter lookup errors to responses
+func (a *App) sendDeadLetterError(r *fastglue.Request, err error) error {
+	if errors.Is(err, errDeadLetterNotFound) {
+		return r.SendErrorEnvelope(fasthttp.StatusNotFound, "Dead letter not found", nil, "")
+	}
+	a.Log.Error("Failed to load dead letter", "error", err)
+	return r.SendErrorEnvelope(fasthttp.StatusInternalServerError, "Failed to load dead letter", nil, "")
+}
@@ -18,9 +18,12 @@ const (
 	EventMessageIncoming  = "message.incoming"
 	EventMessageSent      = "message.sent"
 	EventContactCreated   = "contact.created"
+	EventContactUpdated   = "contact.updated"
+	EventContactDeleted   = "contact.deleted"
 	EventTransferCreated  = "transfer.created"
 	EventTransferAssigned = "transfer.assigned"
 	EventTransferResumed  = "transfer.resumed"
+	EventFlowSubmitted    = "flow.submitted"
 )
 
 // OutboundWebhookPayload represents the structure sent to external webhook endpoints
@@ -45,10 +48,13 @@ type MessageEventData struct {
 
 // ContactEventData represents data for contact events
 type ContactEventData struct {
-	ContactID       string `json:"contact_id"`
-	ContactPhone    string `json:"contact_phone"`
-	ContactName     string `json:"contact_name"`
-	WhatsAppAccount string `json:"whatsapp_account"`
+	ContactID       string                 `json:"contact_id"`
+	ContactPhone    string                 `json:"contact_phone"`
+	ContactName     string                 `json:"contact_name"`
+	WhatsAppAccount string                 `json:"whatsapp_account"`
+	Locale          string                 `json:"locale,omitempty"`
+	Tags            []string               `json:"tags,omitempty"`
+	Metadata        map[string]interface{} `json:"metadata,omitempty"`
 }
 
 // TransferEventData represents data for transfer events
@@ -64,8 +70,29 @@ type TransferEventData struct {
 	WhatsAppAccount string  `json:"whatsapp_account"`
 }
 
+// FlowSubmittedEventData represents data for WhatsApp Flow responses
+type FlowSubmittedEventData struct {
+	MessageID        string                 `json:"message_id"`
+	ContactID        string                 `json:"contact_id"`
+	ContactPhone     string                 `json:"contact_phone"`
+	ContactName      string                 `json:"contact_name"`
+	WhatsAppAccount  string                 `json:"whatsapp_account"`
+	FlowToken        string                 `json:"flow_token,omitempty"`
+	WhatsAppFlowID   string                 `json:"whatsapp_flow_id,omitempty"`
+	WhatsAppFlowName string                 `json:"whatsapp_flow_name,omitempty"`
+	ChatbotFlowID    string                 `json:"chatbot_flow_id,omitempty"`
+	StepName         string                 `json:"step_name,omitempty"`
+	Valid            bool                   `json:"valid"`
+	ValidationError  string                 `json:"validation_error,omitempty"`
+	Response         map[string]interface{} `json:"response"`
+}
+
 // DispatchWebhook sends an event to all matching webhooks for the organization
 func (a *App) DispatchWebhook(orgID uuid.UUID, eventType string, data interface{}) {
+	if a.simulation != nil {
+		a.simulation.recordEvent(eventType, data)
+		return
+	}
 	go a.dispatchWebhookAsync(orgID, eventType, data)
 }
 
@@ -38,9 +38,12 @@ var AvailableWebhookEvents = []map[string]string{
 	{"value": EventMessageIncoming, "label": "Message Incoming", "description": "When a new message is received from a contact"},
 	{"value": EventMessageSent, "label": "Message Sent", "description": "When an agent sends a message"},
 	{"value": EventContactCreated, "label": "Contact Created", "description": "When a new contact is created"},
+	{"value": EventContactUpdated, "label": "Contact Updated", "description": "When a contact's name, tags or metadata are edited"},
+	{"value": EventContactDeleted, "label": "Contact Deleted", "description": "When a contact is deleted"},
 	{"value": EventTransferCreated, "label": "Transfer Created", "description": "When a transfer to human agent is requested"},
 	{"value": EventTransferAssigned, "label": "Transfer Assigned", "description": "When a transfer is assigned to an agent"},
 	{"value": EventTransferResumed, "label": "Transfer Resumed", "description": "When chatbot is resumed (transfer closed)"},
+	{"value": EventFlowSubmitted, "label": "Flow Submitted", "description": "When a contact completes a WhatsApp Flow"},
 }
 
 // ListWebhooks returns all webhooks for the organization
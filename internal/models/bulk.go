@@ -13,7 +13,7 @@ type BulkMessageCampaign struct {
 	WhatsAppAccount string     `gorm:"size:100;index;not null" json:"whatsapp_account"` // References WhatsAppAccount.Name
 	Name            string     `gorm:"size:255;not null" json:"name"`
 	TemplateID      uuid.UUID  `gorm:"type:uuid;not null" json:"template_id"`
-	Status          string     `gorm:"size:20;default:'draft'" json:"status"` // draft, queued, processing, completed, failed
+	Status          string     `gorm:"size:20;default:'draft'" json:"status"` // draft, scheduled, queued, processing, paused, completed, failed, cancelled
 	TotalRecipients int        `gorm:"default:0" json:"total_recipients"`
 	SentCount       int        `gorm:"default:0" json:"sent_count"`
 	DeliveredCount  int        `gorm:"default:0" json:"delivered_count"`
@@ -23,10 +23,15 @@ type BulkMessageCampaign struct {
 	StartedAt       *time.Time `json:"started_at,omitempty"`
 	CompletedAt     *time.Time `json:"completed_at,omitempty"`
 	CreatedBy       uuid.UUID  `gorm:"type:uuid;not null" json:"created_by"`
+	SegmentID       *uuid.UUID `gorm:"type:uuid;index" json:"segment_id,omitempty"` // Recipients are resolved from this segment on start
+	ParamFields     JSONB      `gorm:"type:jsonb;default:'{}'" json:"param_fields"` // Template variable ("1", "2", ...) -> contact field, for segment recipients
+	HeaderMedia     JSONB      `gorm:"type:jsonb;default:'{}'" json:"header_media"` // {type, media_id or link, filename, uploaded_at} for templates with a media header
+	TemplateVersion int        `gorm:"default:0" json:"template_version"`           // Template version when the campaign was last started
 
 	// Relations
 	Organization *Organization          `gorm:"foreignKey:OrganizationID" json:"organization,omitempty"`
 	Template     *Template              `gorm:"foreignKey:TemplateID" json:"template,omitempty"`
+	Segment      *Segment               `gorm:"foreignKey:SegmentID" json:"segment,omitempty"`
 	Creator      *User                  `gorm:"foreignKey:CreatedBy" json:"creator,omitempty"`
 	Recipients   []BulkMessageRecipient `gorm:"foreignKey:CampaignID" json:"recipients,omitempty"`
 }
@@ -42,10 +47,14 @@ type BulkMessageRecipient struct {
 	PhoneNumber        string     `gorm:"size:20;not null" json:"phone_number"`
 	RecipientName      string     `gorm:"size:255" json:"recipient_name"`
 	TemplateParams     JSONB      `gorm:"type:jsonb;default:'{}'" json:"template_params"`
-	Status             string     `gorm:"size:20;default:'pending'" json:"status"` // pending, sent, delivered, read, failed
+	Status             string     `gorm:"size:20;default:'pending'" json:"status"` // pending, sent, delivered, read, failed, opted_out
 	WhatsAppMessageID  string     `gorm:"column:whats_app_message_id;size:100;index" json:"whatsapp_message_id,omitempty"`
 	MessageID          *uuid.UUID `gorm:"type:uuid" json:"message_id,omitempty"`
 	ErrorMessage       string     `gorm:"type:text" json:"error_message"`
+	ErrorCode          int        `gorm:"default:0" json:"error_code,omitempty"`       // Meta error code of the last failure
+	ErrorClass         string     `gorm:"size:20" json:"error_class,omitempty"`        // retryable, permanent or auth
+	TemplateLanguage   string     `gorm:"size:10" json:"template_language,omitempty"`  // Template language of the last send, from the contact's locale
+	TemplateVersion    int        `gorm:"default:0" json:"template_version,omitempty"` // Version of that template
 	SentAt             *time.Time `json:"sent_at,omitempty"`
 	DeliveredAt        *time.Time `json:"delivered_at,omitempty"`
 	ReadAt             *time.Time `json:"read_at,omitempty"`
@@ -81,3 +90,20 @@ type NotificationRule struct {
 func (NotificationRule) TableName() string {
 	return "notification_rules"
 }
+
+// Segment is a saved audience filter over an organization's contacts
+type Segment struct {
+	BaseModel
+	OrganizationID uuid.UUID `gorm:"type:uuid;index;not null" json:"organization_id"`
+	Name           string    `gorm:"size:255;not null" json:"name"`
+	Description    string    `gorm:"type:text" json:"description"`
+	Filter         JSONB     `gorm:"type:jsonb;default:'{}'" json:"filter"` // tags, metadata conditions, last message date, agents, accounts
+	CreatedBy      uuid.UUID `gorm:"type:uuid" json:"created_by"`
+
+	// Relations
+	Organization *Organization `gorm:"foreignKey:OrganizationID" json:"organization,omitempty"`
+}
+
+func (Segment) TableName() string {
+	return "segments"
+}
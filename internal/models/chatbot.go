@@ -44,7 +44,7 @@ type ChatbotSettings struct {
 
 	AIEnabled            bool        `gorm:"column:ai_enabled;default:false" json:"ai_enabled"`
 	AIProvider           string      `gorm:"column:ai_provider;size:20" json:"ai_provider"` // openai, anthropic, google
-	AIAPIKey             string      `gorm:"column:ai_api_key;type:text" json:"-"`         // encrypted
+	AIAPIKey             string      `gorm:"column:ai_api_key;type:text;serializer:encrypted" json:"-"` // encrypted
 	AIModel              string      `gorm:"column:ai_model;size:100" json:"ai_model"`
 	AIMaxTokens          int         `gorm:"column:ai_max_tokens;default:500" json:"ai_max_tokens"`
 	AITemperature        float64     `gorm:"column:ai_temperature;type:decimal(3,2);default:0.7" json:"ai_temperature"`
@@ -106,6 +106,14 @@ type ChatbotFlow struct {
 	TimeoutMessage     string      `gorm:"type:text" json:"timeout_message"`
 	CancelKeywords     StringArray `gorm:"type:jsonb" json:"cancel_keywords"`
 
+	// Versioning: the flow's own fields and steps are its draft; new sessions start on the
+	// published version (or the draft, for flows never published) and stay on it
+	PublishedVersion int  `gorm:"default:0" json:"published_version"` // Version new sessions start on; 0 runs the draft
+	HasDraft         bool `gorm:"default:false" json:"has_draft"`     // Draft has changes not in any version yet
+	RolloutVersion   int  `gorm:"default:0" json:"rollout_version"`   // Version tried on a share of new sessions; 0 for none
+	RolloutPercent   int  `gorm:"default:0" json:"rollout_percent"`   // Share (0-100) of new sessions started on RolloutVersion
+	Version          int  `gorm:"-" json:"version,omitempty"`         // Version a loaded flow definition comes from; not stored
+
 	// Relations
 	Organization    *Organization     `gorm:"foreignKey:OrganizationID" json:"organization,omitempty"`
 	InitialTemplate *Template         `gorm:"foreignKey:InitialTemplateID" json:"initial_template,omitempty"`
@@ -123,11 +131,13 @@ type ChatbotFlowStep struct {
 	StepName        string     `gorm:"size:100;not null" json:"step_name"`
 	StepOrder       int        `gorm:"not null" json:"step_order"`
 	Message         string     `gorm:"type:text;not null" json:"message"`
-	MessageType     string     `gorm:"size:20;default:'text'" json:"message_type"` // text, template, script, api_fetch, buttons, transfer
+	MessageType     string     `gorm:"size:20;default:'text'" json:"message_type"` // text, template, script, api_fetch, buttons, transfer, location, contacts, sticker, list, cta_url, location_request, product, product_list, whatsapp_flow, condition, goto, call_flow, jump_flow
 	TemplateID      *uuid.UUID `gorm:"type:uuid" json:"template_id,omitempty"`
 	ApiConfig       JSONB      `gorm:"type:jsonb" json:"api_config"`      // {url, method, headers, body, response_path, fallback_message}
 	Buttons         JSONBArray `gorm:"type:jsonb" json:"buttons"`         // [{id, title}] - max 10 options (3=buttons, 4-10=list)
 	TransferConfig  JSONB      `gorm:"type:jsonb" json:"transfer_config"` // {team_id: uuid, notes: string} - for transfer message type
+	MessageConfig   JSONB      `gorm:"type:jsonb" json:"message_config"`  // {location|contacts|sticker|list|cta_url|product|product_list: {...}} - for those message types
+	ControlConfig   JSONB      `gorm:"type:jsonb" json:"control_config"`  // {branches} for condition, {target, max_iterations, exit_step} for goto, {flow_id} for call_flow and jump_flow
 	InputType       string     `gorm:"size:20" json:"input_type"`         // none, text, number, email, phone, date, select, button, whatsapp_flow
 	InputConfig     JSONB      `gorm:"type:jsonb" json:"input_config"`
 	ValidationRegex string     `gorm:"size:255" json:"validation_regex"`
@@ -148,6 +158,52 @@ func (ChatbotFlowStep) TableName() string {
 	return "chatbot_flow_steps"
 }
 
+// ChatbotFlowVersion is an immutable published snapshot of a chatbot flow and its steps.
+// Versions are numbered from 1 per flow.
+type ChatbotFlowVersion struct {
+	BaseModel
+	OrganizationID     uuid.UUID   `gorm:"type:uuid;index;not null" json:"organization_id"`
+	FlowID             uuid.UUID   `gorm:"type:uuid;not null;uniqueIndex:idx_chatbot_flow_versions_flow_version" json:"flow_id"`
+	Version            int         `gorm:"not null;uniqueIndex:idx_chatbot_flow_versions_flow_version" json:"version"`
+	Name               string      `gorm:"size:255;not null" json:"name"`
+	TriggerKeywords    StringArray `gorm:"type:jsonb" json:"trigger_keywords"`
+	TriggerButtonID    string      `gorm:"size:100" json:"trigger_button_id"`
+	InitialMessage     string      `gorm:"type:text" json:"initial_message"`
+	InitialMessageType string      `gorm:"size:20" json:"initial_message_type"`
+	InitialTemplateID  *uuid.UUID  `gorm:"type:uuid" json:"initial_template_id,omitempty"`
+	CompletionMessage  string      `gorm:"type:text" json:"completion_message"`
+	OnCompleteAction   string      `gorm:"size:20" json:"on_complete_action"`
+	CompletionConfig   JSONB       `gorm:"type:jsonb" json:"completion_config"`
+	TimeoutMessage     string      `gorm:"type:text" json:"timeout_message"`
+	CancelKeywords     StringArray `gorm:"type:jsonb" json:"cancel_keywords"`
+	Steps              JSONBArray  `gorm:"type:jsonb;default:'[]'" json:"steps"`   // Steps as published, without IDs
+	Changes            JSONB       `gorm:"type:jsonb;default:'{}'" json:"changes"` // field -> {from, to} and steps {added, removed, changed} against the previous version
+	Notes              string      `gorm:"type:text" json:"notes"`
+	PublishedBy        *uuid.UUID  `gorm:"type:uuid" json:"published_by,omitempty"`
+}
+
+func (ChatbotFlowVersion) TableName() string {
+	return "chatbot_flow_versions"
+}
+
+// ChatbotFlowRun is a session's run of a flow version, from the flow starting to the
+// session completing or leaving it. Sub-flows run as part of the run that called them.
+type ChatbotFlowRun struct {
+	BaseModel
+	OrganizationID uuid.UUID  `gorm:"type:uuid;index;not null" json:"organization_id"`
+	FlowID         uuid.UUID  `gorm:"type:uuid;not null;index:idx_chatbot_flow_runs_flow_version" json:"flow_id"`
+	Version        int        `gorm:"not null;index:idx_chatbot_flow_runs_flow_version" json:"version"` // 0 for runs of a draft
+	SessionID      uuid.UUID  `gorm:"type:uuid;index;not null" json:"session_id"`
+	ContactID      uuid.UUID  `gorm:"type:uuid;not null" json:"contact_id"`
+	Status         string     `gorm:"size:20;default:'active'" json:"status"` // active, completed, cancelled
+	StartedAt      time.Time  `gorm:"autoCreateTime" json:"started_at"`
+	EndedAt        *time.Time `json:"ended_at,omitempty"`
+}
+
+func (ChatbotFlowRun) TableName() string {
+	return "chatbot_flow_runs"
+}
+
 // ChatbotSession tracks active conversation sessions
 type ChatbotSession struct {
 	BaseModel
@@ -160,6 +216,10 @@ type ChatbotSession struct {
 	CurrentStep     string     `gorm:"size:100" json:"current_step"`
 	StepRetries     int        `gorm:"default:0" json:"step_retries"`
 	SessionData     JSONB      `gorm:"type:jsonb;default:'{}'" json:"session_data"`
+	CallStack       JSONBArray `gorm:"type:jsonb;default:'[]'" json:"call_stack"`  // [{flow_id, version, return_step}] - flows to return to when a called sub-flow completes
+	LoopCounts      JSONB      `gorm:"type:jsonb;default:'{}'" json:"loop_counts"` // Iterations taken by goto steps, keyed by "flow_id:step_name"
+	FlowVersion     int        `gorm:"default:0" json:"flow_version"`              // Version of the current flow the session runs
+	FlowRunID       *uuid.UUID `gorm:"type:uuid" json:"flow_run_id,omitempty"`     // Run of the flow the session started
 	StartedAt       time.Time  `gorm:"autoCreateTime" json:"started_at"`
 	LastActivityAt  time.Time  `json:"last_activity_at"`
 	CompletedAt     *time.Time `json:"completed_at,omitempty"`
@@ -203,7 +203,7 @@ type SSOProvider struct {
 	OrganizationID  uuid.UUID `gorm:"type:uuid;index;not null" json:"organization_id"`
 	Provider        string    `gorm:"size:50;not null" json:"provider"` // google, microsoft, github, facebook, custom
 	ClientID        string    `gorm:"size:500;not null" json:"client_id"`
-	ClientSecret    string    `gorm:"size:500;not null" json:"-"` // Never exposed in JSON
+	ClientSecret    string    `gorm:"type:text;not null;serializer:encrypted" json:"-"` // Never exposed in JSON
 	IsEnabled       bool      `gorm:"default:false" json:"is_enabled"`
 	AllowAutoCreate bool      `gorm:"default:false" json:"allow_auto_create"` // Auto-create new users on SSO login
 	DefaultRole     string    `gorm:"size:50;default:'agent'" json:"default_role"` // Role for auto-created users
@@ -247,15 +247,19 @@ type WhatsAppAccount struct {
 	OrganizationID     uuid.UUID `gorm:"type:uuid;index;not null" json:"organization_id"`
 	Name               string    `gorm:"size:100;uniqueIndex:idx_wa_org_name;not null" json:"name"` // Unique per org, used as reference
 	AppID              string    `gorm:"size:100" json:"app_id"`                                    // Meta App ID
+	AppSecret          string    `gorm:"type:text;serializer:encrypted" json:"-"`                   // Meta App Secret for webhook signature verification
 	PhoneID            string    `gorm:"size:100;not null" json:"phone_id"`
 	BusinessID         string    `gorm:"size:100;not null" json:"business_id"`
-	AccessToken        string    `gorm:"type:text;not null" json:"-"` // encrypted
+	AccessToken        string    `gorm:"type:text;not null;serializer:encrypted" json:"-"` // encrypted
 	WebhookVerifyToken string    `gorm:"size:255" json:"webhook_verify_token"`
 	APIVersion         string    `gorm:"size:20;default:'v21.0'" json:"api_version"`
 	IsDefaultIncoming  bool      `gorm:"default:false" json:"is_default_incoming"`
 	IsDefaultOutgoing  bool      `gorm:"default:false" json:"is_default_outgoing"`
 	AutoReadReceipt    bool      `gorm:"default:false" json:"auto_read_receipt"`
 	Status             string    `gorm:"size:20;default:'active'" json:"status"`
+	ThroughputTier     string    `gorm:"size:20;default:'standard'" json:"throughput_tier"` // standard (80 msg/s), high (1000 msg/s)
+	MessagesPerSecond  int       `gorm:"default:0" json:"messages_per_second"`              // Overrides the tier's rate when > 0
+	FlowPrivateKey     string    `gorm:"type:text;serializer:encrypted" json:"-"`           // RSA key that decrypts WhatsApp Flows data-exchange requests
 
 	// Relations
 	Organization *Organization `gorm:"foreignKey:OrganizationID" json:"organization,omitempty"`
@@ -272,6 +276,7 @@ type Contact struct {
 	PhoneNumber        string     `gorm:"size:20;not null" json:"phone_number"`
 	ProfileName        string     `gorm:"size:255" json:"profile_name"`
 	WhatsAppAccount    string     `gorm:"size:100;index" json:"whatsapp_account"` // References WhatsAppAccount.Name
+	Locale             string     `gorm:"size:20" json:"locale"`                  // Preferred template language, e.g. pt_BR
 	AssignedUserID     *uuid.UUID `gorm:"type:uuid;index" json:"assigned_user_id,omitempty"`
 	LastMessageAt      *time.Time `json:"last_message_at,omitempty"`
 	LastMessagePreview string     `gorm:"type:text" json:"last_message_preview"`
@@ -293,6 +298,52 @@ func (Contact) TableName() string {
 	return "contacts"
 }
 
+// ContactServiceWindow tracks the last inbound message of a contact per WhatsApp account.
+// Free-form messages can only be sent within 24 hours of it (Meta's customer service window).
+type ContactServiceWindow struct {
+	BaseModel
+	OrganizationID  uuid.UUID `gorm:"type:uuid;index;not null" json:"organization_id"`
+	ContactID       uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_service_windows_contact_account" json:"contact_id"`
+	WhatsAppAccount string    `gorm:"size:100;not null;uniqueIndex:idx_service_windows_contact_account" json:"whatsapp_account"` // References WhatsAppAccount.Name
+	LastInboundAt   time.Time `gorm:"not null" json:"last_inbound_at"`
+}
+
+func (ContactServiceWindow) TableName() string {
+	return "contact_service_windows"
+}
+
+// ContactOptOut marks a phone number that must not receive campaigns, template sends or
+// automated messages from the organization. Opting back in deletes the row.
+type ContactOptOut struct {
+	BaseModel
+	OrganizationID uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_opt_outs_org_phone" json:"organization_id"`
+	PhoneNumber    string     `gorm:"size:20;not null;uniqueIndex:idx_opt_outs_org_phone" json:"phone_number"`
+	ContactID      *uuid.UUID `gorm:"type:uuid;index" json:"contact_id,omitempty"`
+	Source         string     `gorm:"size:20;not null" json:"source"` // keyword, api
+	Reason         string     `gorm:"type:text" json:"reason"`
+	CreatedBy      *uuid.UUID `gorm:"type:uuid" json:"created_by,omitempty"`
+}
+
+func (ContactOptOut) TableName() string {
+	return "contact_opt_outs"
+}
+
+// ContactOptOutEvent is the audit trail of opt-out and opt-in changes
+type ContactOptOutEvent struct {
+	BaseModel
+	OrganizationID uuid.UUID  `gorm:"type:uuid;index;not null" json:"organization_id"`
+	PhoneNumber    string     `gorm:"size:20;index;not null" json:"phone_number"`
+	ContactID      *uuid.UUID `gorm:"type:uuid" json:"contact_id,omitempty"`
+	Action         string     `gorm:"size:20;not null" json:"action"` // opt_out, opt_in
+	Source         string     `gorm:"size:20;not null" json:"source"` // keyword, api
+	Reason         string     `gorm:"type:text" json:"reason"`        // Free text, or the keyword the contact sent
+	UserID         *uuid.UUID `gorm:"type:uuid" json:"user_id,omitempty"`
+}
+
+func (ContactOptOutEvent) TableName() string {
+	return "contact_opt_out_events"
+}
+
 // Message represents a WhatsApp message
 type Message struct {
 	BaseModel
@@ -346,6 +397,7 @@ type Template struct {
 	FooterContent   string     `gorm:"type:text" json:"footer_content"`
 	Buttons         JSONBArray `gorm:"type:jsonb;default:'[]'" json:"buttons"`
 	SampleValues    JSONBArray `gorm:"type:jsonb;default:'[]'" json:"sample_values"`
+	Version         int        `gorm:"default:0" json:"version"` // Number of the latest TemplateVersion
 
 	// Relations
 	Organization *Organization `gorm:"foreignKey:OrganizationID" json:"organization,omitempty"`
@@ -355,6 +407,34 @@ func (Template) TableName() string {
 	return "templates"
 }
 
+// TemplateVersion is an immutable snapshot of a template, recorded on every change
+// to its content or status. Versions are numbered from 1 per template.
+type TemplateVersion struct {
+	BaseModel
+	OrganizationID  uuid.UUID  `gorm:"type:uuid;index;not null" json:"organization_id"`
+	TemplateID      uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_template_versions_template_version" json:"template_id"`
+	Version         int        `gorm:"not null;uniqueIndex:idx_template_versions_template_version" json:"version"`
+	Source          string     `gorm:"size:20;not null" json:"source"` // initial, created, edited, submitted, synced, webhook, imported
+	MetaTemplateID  string     `gorm:"size:100" json:"meta_template_id"`
+	Name            string     `gorm:"size:255;not null" json:"name"`
+	Language        string     `gorm:"size:10;not null" json:"language"`
+	Category        string     `gorm:"size:50" json:"category"`
+	Status          string     `gorm:"size:20" json:"status"`
+	RejectionReason string     `gorm:"type:text" json:"rejection_reason"` // Reason Meta gave with a status webhook
+	HeaderType      string     `gorm:"size:20" json:"header_type"`
+	HeaderContent   string     `gorm:"type:text" json:"header_content"`
+	BodyContent     string     `gorm:"type:text" json:"body_content"`
+	FooterContent   string     `gorm:"type:text" json:"footer_content"`
+	Buttons         JSONBArray `gorm:"type:jsonb;default:'[]'" json:"buttons"`
+	SampleValues    JSONBArray `gorm:"type:jsonb;default:'[]'" json:"sample_values"`
+	Changes         JSONB      `gorm:"type:jsonb;default:'{}'" json:"changes"` // field -> {from, to} against the previous version
+	CreatedBy       *uuid.UUID `gorm:"type:uuid" json:"created_by,omitempty"`   // User who made a local change
+}
+
+func (TemplateVersion) TableName() string {
+	return "template_versions"
+}
+
 // WhatsAppFlow represents a WhatsApp interactive flow
 type WhatsAppFlow struct {
 	BaseModel
@@ -369,6 +449,12 @@ type WhatsAppFlow struct {
 	Screens         JSONBArray `gorm:"type:jsonb;default:'[]'" json:"screens"`
 	PreviewURL      string     `gorm:"type:text" json:"preview_url"`
 
+	// Data-exchange endpoint, for flows with screens backed by the server
+	EndpointMode      string `gorm:"size:20" json:"endpoint_mode"`                      // Empty (no endpoint), static or http
+	EndpointURL       string `gorm:"type:text" json:"endpoint_url"`                     // External API requests are forwarded to (http)
+	EndpointSecret    string `gorm:"type:text;serializer:encrypted" json:"-"`           // Signs forwarded requests (http)
+	EndpointResponses JSONB  `gorm:"type:jsonb;default:'{}'" json:"endpoint_responses"` // Screen responses keyed by "action:screen" or action (static)
+
 	// Relations
 	Organization *Organization `gorm:"foreignKey:OrganizationID" json:"organization,omitempty"`
 }
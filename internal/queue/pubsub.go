@@ -16,13 +16,14 @@ const (
 
 // CampaignStatsUpdate represents a campaign stats update message
 type CampaignStatsUpdate struct {
-	CampaignID     string    `json:"campaign_id"`
-	OrganizationID uuid.UUID `json:"organization_id"`
-	Status         string    `json:"status"`
-	SentCount      int       `json:"sent_count"`
-	DeliveredCount int       `json:"delivered_count"`
-	ReadCount      int       `json:"read_count"`
-	FailedCount    int       `json:"failed_count"`
+	CampaignID        string    `json:"campaign_id"`
+	OrganizationID    uuid.UUID `json:"organization_id"`
+	Status            string    `json:"status"`
+	SentCount         int       `json:"sent_count"`
+	DeliveredCount    int       `json:"delivered_count"`
+	ReadCount         int       `json:"read_count"`
+	FailedCount       int       `json:"failed_count"`
+	MessagesPerSecond float64   `json:"messages_per_second,omitempty"` // Achieved send throughput of the current run
 }
 
 // Publisher publishes messages to Redis pub/sub channels
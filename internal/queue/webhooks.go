@@ -0,0 +1,511 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zerodha/logf"
+)
+
+const (
+	// WebhookStreamPrefix is the prefix of the partitioned Redis streams for inbound Meta webhooks
+	// (e.g. "whatomate:webhooks:0", "whatomate:webhooks:1", ...)
+	WebhookStreamPrefix = "whatomate:webhooks:"
+
+	// WebhookDeadLetterStream holds webhook events that exhausted their retries
+	WebhookDeadLetterStream = "whatomate:webhooks:dead"
+
+	// WebhookConsumerGroup is the consumer group name for webhook processors
+	WebhookConsumerGroup = "webhook-processors"
+
+	// webhookLeasePrefix is the Redis key prefix for partition leases
+	webhookLeasePrefix = "whatomate:webhooks:lease:"
+
+	// WebhookLeaseTTL is how long a partition lease is held without renewal
+	WebhookLeaseTTL = 60 * time.Second
+
+	// WebhookLeaseRetryInterval is how long to wait before retrying to acquire a held partition
+	WebhookLeaseRetryInterval = 5 * time.Second
+
+	// DefaultWebhookPartitions is the default number of webhook stream partitions
+	DefaultWebhookPartitions = 8
+
+	// DefaultWebhookMaxAttempts is the default number of processing attempts before dead-lettering
+	DefaultWebhookMaxAttempts = 5
+
+	// webhookStreamBacklogWarn is the partition stream length above which enqueueing logs a
+	// warning; streams are only trimmed of acknowledged events, so a stalled consumer makes them grow
+	webhookStreamBacklogWarn = 100000
+
+	// webhookDeadLetterMaxLen caps the dead-letter stream
+	webhookDeadLetterMaxLen = 10000
+)
+
+// WebhookEventType represents the kind of inbound webhook event
+type WebhookEventType string
+
+const (
+	// WebhookEventMessage is an incoming message from a contact
+	WebhookEventMessage WebhookEventType = "message"
+	// WebhookEventStatus is a delivery status update for an outgoing message
+	WebhookEventStatus WebhookEventType = "status"
+	// WebhookEventTemplateStatus is a template review status update
+	WebhookEventTemplateStatus WebhookEventType = "template_status"
+)
+
+// WebhookEvent is a single unit of work extracted from a Meta webhook payload
+type WebhookEvent struct {
+	Type          WebhookEventType `json:"type"`
+	PhoneNumberID string           `json:"phone_number_id,omitempty"`
+	WABAID        string           `json:"waba_id,omitempty"`
+	// Key determines the partition; events with the same key are processed in order
+	Key         string          `json:"key"`
+	ProfileName string          `json:"profile_name,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	ReceivedAt  time.Time       `json:"received_at"`
+}
+
+// WebhookDeadLetter is a webhook event that could not be processed
+type WebhookDeadLetter struct {
+	ID         string       `json:"id"`
+	Event      WebhookEvent `json:"event"`
+	Error      string       `json:"error"`
+	Attempts   int          `json:"attempts"`
+	Partition  int          `json:"partition"`
+	OriginalID string       `json:"original_id"`
+	FailedAt   time.Time    `json:"failed_at"`
+}
+
+// WebhookQueue persists inbound webhook events to partitioned Redis streams
+type WebhookQueue struct {
+	client     *redis.Client
+	log        logf.Logger
+	partitions int
+}
+
+// NewWebhookQueue creates a new webhook queue with the given number of partitions
+func NewWebhookQueue(client *redis.Client, log logf.Logger, partitions int) *WebhookQueue {
+	if partitions <= 0 {
+		partitions = DefaultWebhookPartitions
+	}
+	return &WebhookQueue{
+		client:     client,
+		log:        log,
+		partitions: partitions,
+	}
+}
+
+// Partitions returns the number of stream partitions
+func (q *WebhookQueue) Partitions() int {
+	return q.partitions
+}
+
+// webhookStreamName returns the stream name for a partition
+func webhookStreamName(partition int) string {
+	return WebhookStreamPrefix + strconv.Itoa(partition)
+}
+
+// partitionFor returns the partition for an ordering key
+func (q *WebhookQueue) partitionFor(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(q.partitions))
+}
+
+// Enqueue atomically adds the events to their partition streams
+func (q *WebhookQueue) Enqueue(ctx context.Context, events []WebhookEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	lengths := make(map[string]*redis.IntCmd)
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range events {
+			if events[i].ReceivedAt.IsZero() {
+				events[i].ReceivedAt = time.Now()
+			}
+			data, err := json.Marshal(events[i])
+			if err != nil {
+				return fmt.Errorf("failed to marshal webhook event: %w", err)
+			}
+			stream := webhookStreamName(q.partitionFor(events[i].Key))
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: stream,
+				Values: map[string]interface{}{
+					"type":    string(events[i].Type),
+					"payload": string(data),
+				},
+			})
+			lengths[stream] = pipe.XLen(ctx, stream)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enqueue webhook events: %w", err)
+	}
+
+	// Unprocessed events are never dropped, so a growing backlog is reported instead
+	for stream, length := range lengths {
+		if n := length.Val(); n > webhookStreamBacklogWarn {
+			q.log.Warn("Webhook stream backlog is growing, check the webhook consumers", "stream", stream, "length", n)
+		}
+	}
+
+	q.log.Debug("Webhook events enqueued", "count", len(events))
+	return nil
+}
+
+// ListDeadLetters returns up to count dead-lettered events, newest first
+func (q *WebhookQueue) ListDeadLetters(ctx context.Context, count int64) ([]WebhookDeadLetter, error) {
+	messages, err := q.client.XRevRangeN(ctx, WebhookDeadLetterStream, "+", "-", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letters: %w", err)
+	}
+
+	letters := make([]WebhookDeadLetter, 0, len(messages))
+	for _, msg := range messages {
+		letter, err := parseDeadLetter(msg)
+		if err != nil {
+			q.log.Warn("Skipping malformed dead letter", "error", err, "message_id", msg.ID)
+			continue
+		}
+		letters = append(letters, *letter)
+	}
+	return letters, nil
+}
+
+// GetDeadLetter returns a single dead-lettered event
+func (q *WebhookQueue) GetDeadLetter(ctx context.Context, id string) (*WebhookDeadLetter, error) {
+	messages, err := q.client.XRange(ctx, WebhookDeadLetterStream, id, id).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letter: %w", err)
+	}
+	if len(messages) == 0 {
+		return nil, redis.Nil
+	}
+	return parseDeadLetter(messages[0])
+}
+
+// ReplayDeadLetter re-enqueues a dead-lettered event and removes it from the dead-letter stream
+func (q *WebhookQueue) ReplayDeadLetter(ctx context.Context, id string) error {
+	letter, err := q.GetDeadLetter(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := q.Enqueue(ctx, []WebhookEvent{letter.Event}); err != nil {
+		return err
+	}
+	return q.DeleteDeadLetter(ctx, id)
+}
+
+// DeleteDeadLetter removes an event from the dead-letter stream
+func (q *WebhookQueue) DeleteDeadLetter(ctx context.Context, id string) error {
+	if err := q.client.XDel(ctx, WebhookDeadLetterStream, id).Err(); err != nil {
+		return fmt.Errorf("failed to delete dead letter: %w", err)
+	}
+	return nil
+}
+
+// parseDeadLetter converts a dead-letter stream entry into a WebhookDeadLetter
+func parseDeadLetter(msg redis.XMessage) (*WebhookDeadLetter, error) {
+	payload, ok := msg.Values["payload"].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid dead letter: missing payload")
+	}
+
+	letter := WebhookDeadLetter{ID: msg.ID}
+	if err := json.Unmarshal([]byte(payload), &letter.Event); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal dead letter: %w", err)
+	}
+
+	letter.Error, _ = msg.Values["error"].(string)
+	letter.OriginalID, _ = msg.Values["original_id"].(string)
+	if v, ok := msg.Values["attempts"].(string); ok {
+		letter.Attempts, _ = strconv.Atoi(v)
+	}
+	if v, ok := msg.Values["partition"].(string); ok {
+		letter.Partition, _ = strconv.Atoi(v)
+	}
+	if v, ok := msg.Values["failed_at"].(string); ok {
+		letter.FailedAt, _ = time.Parse(time.RFC3339, v)
+	}
+	return &letter, nil
+}
+
+// renewLeaseScript extends a lease only if it is still held by the caller
+var renewLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseLeaseScript deletes a lease only if it is still held by the caller
+var releaseLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// WebhookConsumer processes webhook events from the partitioned streams.
+// Each partition is processed by at most one consumer at a time (guarded by a
+// Redis lease), which keeps events for the same contact in order across instances.
+type WebhookConsumer struct {
+	client      *redis.Client
+	log         logf.Logger
+	partitions  int
+	maxAttempts int
+	consumerID  string
+}
+
+// NewWebhookConsumer creates a new webhook consumer and ensures the consumer groups exist
+func NewWebhookConsumer(client *redis.Client, log logf.Logger, partitions, maxAttempts int) (*WebhookConsumer, error) {
+	if partitions <= 0 {
+		partitions = DefaultWebhookPartitions
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultWebhookMaxAttempts
+	}
+
+	hostname, _ := os.Hostname()
+	consumer := &WebhookConsumer{
+		client:      client,
+		log:         log,
+		partitions:  partitions,
+		maxAttempts: maxAttempts,
+		consumerID:  fmt.Sprintf("server-%s-%d", hostname, os.Getpid()),
+	}
+
+	ctx := context.Background()
+	for p := 0; p < partitions; p++ {
+		err := client.XGroupCreateMkStream(ctx, webhookStreamName(p), WebhookConsumerGroup, "0").Err()
+		if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+			return nil, fmt.Errorf("failed to create webhook consumer group: %w", err)
+		}
+	}
+
+	log.Info("Webhook consumer initialized", "consumer_id", consumer.consumerID, "partitions", partitions)
+	return consumer, nil
+}
+
+// Consume processes all partitions until the context is cancelled.
+// A handler error causes the event to be retried with backoff; after maxAttempts
+// the event is moved to the dead-letter stream.
+func (c *WebhookConsumer) Consume(ctx context.Context, handler func(ctx context.Context, event *WebhookEvent) error) error {
+	var wg sync.WaitGroup
+	for p := 0; p < c.partitions; p++ {
+		wg.Add(1)
+		go func(partition int) {
+			defer wg.Done()
+			c.consumePartition(ctx, partition, handler)
+		}(p)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// consumePartition acquires the partition lease and processes its events while it is held
+func (c *WebhookConsumer) consumePartition(ctx context.Context, partition int, handler func(ctx context.Context, event *WebhookEvent) error) {
+	leaseKey := webhookLeasePrefix + strconv.Itoa(partition)
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		acquired, err := c.client.SetNX(ctx, leaseKey, c.consumerID, WebhookLeaseTTL).Result()
+		if err != nil && ctx.Err() == nil {
+			c.log.Error("Failed to acquire webhook partition lease", "error", err, "partition", partition)
+		}
+		if !acquired {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(WebhookLeaseRetryInterval):
+			}
+			continue
+		}
+
+		c.log.Debug("Acquired webhook partition lease", "partition", partition)
+		c.processPartition(ctx, partition, leaseKey, handler)
+
+		// Release with a fresh context so shutdown does not leave the lease dangling
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		releaseLeaseScript.Run(releaseCtx, c.client, []string{leaseKey}, c.consumerID)
+		cancel()
+	}
+}
+
+// processPartition reads and handles events until the lease is lost or the context is cancelled.
+// All instances read a partition as the same group consumer, so a new lease holder picks up
+// events left pending by a crashed one before reading new events.
+func (c *WebhookConsumer) processPartition(ctx context.Context, partition int, leaseKey string, handler func(ctx context.Context, event *WebhookEvent) error) {
+	stream := webhookStreamName(partition)
+	consumerName := "partition-" + strconv.Itoa(partition)
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		renewed, err := renewLeaseScript.Run(ctx, c.client, []string{leaseKey}, c.consumerID, WebhookLeaseTTL.Milliseconds()).Int()
+		if err != nil || renewed == 0 {
+			if ctx.Err() == nil {
+				c.log.Warn("Lost webhook partition lease", "partition", partition, "error", err)
+			}
+			return
+		}
+
+		// Pending (previously delivered but unacknowledged) events first, then new ones
+		msg, err := c.readOne(ctx, stream, consumerName, "0", 0)
+		if err == nil && msg == nil {
+			msg, err = c.readOne(ctx, stream, consumerName, ">", BlockTimeout)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.log.Error("Failed to read webhook stream", "error", err, "partition", partition)
+			time.Sleep(time.Second) // Back off on error
+			continue
+		}
+		if msg == nil {
+			continue
+		}
+
+		if !c.handleMessage(ctx, partition, leaseKey, *msg, handler) {
+			return
+		}
+	}
+}
+
+// readOne reads at most one message for the consumer; returns nil if none is available
+func (c *WebhookConsumer) readOne(ctx context.Context, stream, consumerName, id string, block time.Duration) (*redis.XMessage, error) {
+	args := &redis.XReadGroupArgs{
+		Group:    WebhookConsumerGroup,
+		Consumer: consumerName,
+		Streams:  []string{stream, id},
+		Count:    1,
+		Block:    block,
+	}
+	if block == 0 {
+		args.Block = -1 // Do not block when reading the pending list
+	}
+
+	streams, err := c.client.XReadGroup(ctx, args).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, s := range streams {
+		if len(s.Messages) > 0 {
+			return &s.Messages[0], nil
+		}
+	}
+	return nil, nil
+}
+
+// handleMessage runs the handler with retries, dead-lettering the event once attempts are exhausted.
+// Returns false if processing of the partition should stop (context cancelled or lease lost).
+func (c *WebhookConsumer) handleMessage(ctx context.Context, partition int, leaseKey string, msg redis.XMessage, handler func(ctx context.Context, event *WebhookEvent) error) bool {
+	stream := webhookStreamName(partition)
+
+	var event WebhookEvent
+	payload, _ := msg.Values["payload"].(string)
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		// Malformed events can never succeed - dead-letter them right away
+		c.deadLetter(ctx, partition, msg, fmt.Sprintf("invalid payload: %v", err), 1)
+		return true
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= c.maxAttempts; attempt++ {
+		lastErr = handler(ctx, &event)
+		if lastErr == nil {
+			_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.XAck(ctx, stream, WebhookConsumerGroup, msg.ID)
+				trimAcked(ctx, pipe, stream, msg.ID)
+				return nil
+			})
+			if err != nil {
+				c.log.Error("Failed to ACK webhook event", "error", err, "message_id", msg.ID)
+			}
+			return true
+		}
+
+		c.log.Warn("Webhook event processing failed",
+			"error", lastErr,
+			"type", event.Type,
+			"message_id", msg.ID,
+			"attempt", attempt,
+			"max_attempts", c.maxAttempts,
+		)
+		if attempt == c.maxAttempts {
+			break
+		}
+
+		// Exponential backoff: 1s, 2s, 4s, ... capped at 30s
+		backoff := time.Second << (attempt - 1)
+		if backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return false // Left pending; the next lease holder will retry it
+		case <-time.After(backoff):
+		}
+
+		renewed, err := renewLeaseScript.Run(ctx, c.client, []string{leaseKey}, c.consumerID, WebhookLeaseTTL.Milliseconds()).Int()
+		if err != nil || renewed == 0 {
+			return false
+		}
+	}
+
+	c.deadLetter(ctx, partition, msg, lastErr.Error(), c.maxAttempts)
+	return true
+}
+
+// deadLetter moves an event to the dead-letter stream and acknowledges it on its partition
+func (c *WebhookConsumer) deadLetter(ctx context.Context, partition int, msg redis.XMessage, reason string, attempts int) {
+	payload, _ := msg.Values["payload"].(string)
+
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: WebhookDeadLetterStream,
+			MaxLen: webhookDeadLetterMaxLen,
+			Approx: true,
+			Values: map[string]interface{}{
+				"payload":     payload,
+				"error":       reason,
+				"attempts":    attempts,
+				"partition":   partition,
+				"original_id": msg.ID,
+				"failed_at":   time.Now().UTC().Format(time.RFC3339),
+			},
+		})
+		pipe.XAck(ctx, webhookStreamName(partition), WebhookConsumerGroup, msg.ID)
+		trimAcked(ctx, pipe, webhookStreamName(partition), msg.ID)
+		return nil
+	})
+	if err != nil {
+		c.log.Error("Failed to dead-letter webhook event", "error", err, "message_id", msg.ID)
+		return
+	}
+
+	c.log.Error("Webhook event moved to dead-letter stream", "message_id", msg.ID, "partition", partition, "error", reason)
+}
+
+// trimAcked removes the events before id from a partition stream. A partition is read by a
+// single consumer in order, pending events first, so once id is acknowledged every earlier
+// event has been acknowledged too and unprocessed events are never trimmed.
+func trimAcked(ctx context.Context, pipe redis.Pipeliner, stream, id string) {
+	pipe.XTrimMinIDApprox(ctx, stream, id, 0)
+}
@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Throughput tiers for a WhatsApp business phone number.
+// Meta allows 80 messages per second by default and up to 1000 after a throughput upgrade.
+const (
+	TierStandard = "standard"
+	TierHigh     = "high"
+)
+
+// TierRates maps each throughput tier to its messages-per-second limit
+var TierRates = map[string]int{
+	TierStandard: 80,
+	TierHigh:     1000,
+}
+
+// MessagesPerSecond returns the send rate for an account: the explicit override if set,
+// otherwise the tier's rate (standard if the tier is unknown)
+func MessagesPerSecond(tier string, override int) int {
+	if override > 0 {
+		return override
+	}
+	if rate, ok := TierRates[tier]; ok {
+		return rate
+	}
+	return TierRates[TierStandard]
+}
+
+const keyPrefix = "whatomate:ratelimit:"
+
+// takeScript takes one token from a bucket refilled at ARGV[1] tokens/sec up to ARGV[2].
+// Returns 0 if a token was taken, otherwise the milliseconds to wait before trying again.
+// Uses the Redis clock so processes on different hosts share the same view of time.
+var takeScript = redis.NewScript(`
+local backoff = redis.call('PTTL', KEYS[2])
+if backoff > 0 then
+	return backoff
+end
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return wait
+`)
+
+// backoffScript extends the backoff window to ARGV[1] milliseconds unless a longer one is already set
+var backoffScript = redis.NewScript(`
+local current = redis.call('PTTL', KEYS[1])
+if current < tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[1], '1', 'PX', ARGV[1])
+end
+return 1
+`)
+
+// Limiter is a token-bucket rate limiter keyed by sender (e.g. a WhatsApp phone number ID)
+type Limiter struct {
+	client *redis.Client
+}
+
+// New creates a new Limiter
+func New(client *redis.Client) *Limiter {
+	return &Limiter{client: client}
+}
+
+func bucketKey(key string) string {
+	return keyPrefix + key
+}
+
+func backoffKey(key string) string {
+	return keyPrefix + key + ":backoff"
+}
+
+// Wait blocks until a send is allowed for key at the given messages-per-second rate,
+// or until the context is cancelled
+func (l *Limiter) Wait(ctx context.Context, key string, perSecond int) error {
+	if perSecond <= 0 {
+		return fmt.Errorf("invalid rate %d for %s", perSecond, key)
+	}
+
+	for {
+		waitMs, err := takeScript.Run(ctx, l.client, []string{bucketKey(key), backoffKey(key)}, perSecond, perSecond).Int64()
+		if err != nil {
+			return fmt.Errorf("rate limiter: %w", err)
+		}
+		if waitMs <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(time.Duration(waitMs) * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Backoff pauses all sends for key for at least d, e.g. after Meta reports throttling
+func (l *Limiter) Backoff(ctx context.Context, key string, d time.Duration) error {
+	if err := backoffScript.Run(ctx, l.client, []string{backoffKey(key)}, d.Milliseconds()).Err(); err != nil {
+		return fmt.Errorf("rate limiter backoff: %w", err)
+	}
+	return nil
+}
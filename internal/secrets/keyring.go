@@ -0,0 +1,197 @@
+// Package secrets encrypts credentials stored in the database (access tokens, API keys,
+// client secrets) with envelope encryption: every value is encrypted with its own random
+// data key, and the data key is encrypted ("wrapped") with a master key from the config.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/isaee-xyz/whatomate/internal/config"
+)
+
+// Encrypted values look like "enc:v1:<key id>:<wrapped data key>:<ciphertext>", both
+// binary parts unpadded base64url. Values without the prefix are legacy plaintext.
+const (
+	prefix     = "enc:v1:"
+	keySize    = 32
+	dataKeyLen = 32
+)
+
+var (
+	// ErrUnknownKey is returned when a value was encrypted with a key that is not configured
+	ErrUnknownKey = errors.New("secret was encrypted with an unknown key")
+	// ErrMalformed is returned for values with the encrypted prefix that cannot be parsed or opened
+	ErrMalformed = errors.New("malformed encrypted secret")
+)
+
+// Keyring holds the master keys by ID. New values are encrypted with the current key;
+// values encrypted with any configured key can be decrypted, so old keys stay in the
+// config until Reencrypt has moved every row to the current one.
+type Keyring struct {
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// NewKeyring creates a keyring from the base64-encoded 32-byte master keys in cfg
+func NewKeyring(cfg *config.EncryptionConfig) (*Keyring, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("no encryption keys configured (set encryption.keys)")
+	}
+
+	k := &Keyring{keys: make(map[string]cipher.AEAD, len(cfg.Keys)), current: cfg.Current}
+	for id, encoded := range cfg.Keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid encryption key ID %q", id)
+		}
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(raw) != keySize {
+			return nil, fmt.Errorf("encryption key %q must be %d bytes, base64-encoded", id, keySize)
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+	}
+
+	if k.current == "" {
+		if len(k.keys) > 1 {
+			return nil, errors.New("several encryption keys configured; set encryption.current to the one to encrypt with")
+		}
+		for id := range k.keys {
+			k.current = id
+		}
+	}
+	if _, ok := k.keys[k.current]; !ok {
+		return nil, fmt.Errorf("current encryption key %q is not configured", k.current)
+	}
+	return k, nil
+}
+
+// CurrentKeyID returns the ID of the key new values are encrypted with
+func (k *Keyring) CurrentKeyID() string {
+	return k.current
+}
+
+// KeyIDs returns the IDs of all configured keys
+func (k *Keyring) KeyIDs() []string {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Encrypt encrypts plaintext with a new data key wrapped by the current key.
+// The empty string stays empty so "not set" checks keep working on the column.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, dataKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.keys[k.current], dataKey, []byte(k.current))
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(aead, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + k.current + ":" + encode(wrapped) + ":" + encode(ciphertext), nil
+}
+
+// Decrypt decrypts a value produced by Encrypt. Legacy plaintext values are returned as is.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", ErrMalformed
+	}
+	master, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, parts[0])
+	}
+	wrapped, err1 := decode(parts[1])
+	ciphertext, err2 := decode(parts[2])
+	if err1 != nil || err2 != nil {
+		return "", ErrMalformed
+	}
+
+	dataKey, err := open(master, wrapped, []byte(parts[0]))
+	if err != nil {
+		return "", ErrMalformed
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", ErrMalformed
+	}
+	plaintext, err := open(aead, ciphertext, nil)
+	if err != nil {
+		return "", ErrMalformed
+	}
+	return string(plaintext), nil
+}
+
+// IsCurrent reports whether value needs no re-encryption: it is empty or encrypted with the current key
+func (k *Keyring) IsCurrent(value string) bool {
+	return value == "" || strings.HasPrefix(value, prefix+k.current+":")
+}
+
+// IsEncrypted reports whether value was produced by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts data and prepends the random nonce
+func seal(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, additional), nil
+}
+
+func open(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,152 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/isaee-xyz/whatomate/internal/config"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), keySize)))
+}
+
+func newTestKeyring(t *testing.T, current string, keys map[string]string) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(&config.EncryptionConfig{Keys: keys, Current: current})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	k := newTestKeyring(t, "", map[string]string{"k1": testKey('a')})
+
+	for _, plaintext := range []string{"EAAG-access-token", "ü 東京 ☃", strings.Repeat("x", 10000)} {
+		encrypted, err := k.Encrypt(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncrypted(encrypted) || !strings.HasPrefix(encrypted, "enc:v1:k1:") || strings.Contains(encrypted, plaintext) {
+			t.Errorf("Encrypt(%.20q) = %.40q, want an enc:v1:k1: value without the plaintext", plaintext, encrypted)
+		}
+		if !k.IsCurrent(encrypted) {
+			t.Errorf("IsCurrent(%.40q) = false", encrypted)
+		}
+		decrypted, err := k.Decrypt(encrypted)
+		if err != nil || decrypted != plaintext {
+			t.Errorf("Decrypt = %.20q, %v; want %.20q", decrypted, err, plaintext)
+		}
+	}
+
+	// Every value gets its own data key and nonces
+	a, _ := k.Encrypt("same")
+	b, _ := k.Encrypt("same")
+	if a == b {
+		t.Error("encrypting the same value twice gave the same ciphertext")
+	}
+}
+
+func TestKeyringEmptyAndPlaintext(t *testing.T) {
+	k := newTestKeyring(t, "", map[string]string{"k1": testKey('a')})
+
+	if encrypted, err := k.Encrypt(""); err != nil || encrypted != "" {
+		t.Errorf("Encrypt(\"\") = %q, %v; want \"\"", encrypted, err)
+	}
+	if !k.IsCurrent("") {
+		t.Error("IsCurrent(\"\") = false, want true")
+	}
+
+	// Values from before encryption was introduced are returned as they are
+	for _, legacy := range []string{"", "EAAG-legacy-token", "enc-but-not-prefixed"} {
+		if got, err := k.Decrypt(legacy); err != nil || got != legacy {
+			t.Errorf("Decrypt(%q) = %q, %v; want it unchanged", legacy, got, err)
+		}
+	}
+	if k.IsCurrent("EAAG-legacy-token") {
+		t.Error("IsCurrent(plaintext) = true, want false so Reencrypt picks it up")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	old := newTestKeyring(t, "", map[string]string{"old": testKey('a')})
+	encrypted, err := old.Encrypt("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After rotation, values encrypted with the old key still decrypt, but are not current
+	rotated := newTestKeyring(t, "new", map[string]string{"old": testKey('a'), "new": testKey('b')})
+	if got, err := rotated.Decrypt(encrypted); err != nil || got != "token" {
+		t.Errorf("Decrypt with the old key = %q, %v; want token", got, err)
+	}
+	if rotated.IsCurrent(encrypted) {
+		t.Error("IsCurrent of a value encrypted with the old key = true")
+	}
+	fresh, _ := rotated.Encrypt("token")
+	if !strings.HasPrefix(fresh, "enc:v1:new:") {
+		t.Errorf("Encrypt after rotation = %.20q, want the new key", fresh)
+	}
+
+	// Once the old key is removed, its values can no longer be read
+	newOnly := newTestKeyring(t, "", map[string]string{"new": testKey('b')})
+	if _, err := newOnly.Decrypt(encrypted); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt without the old key error = %v, want ErrUnknownKey", err)
+	}
+
+	// A key with the same ID but different bytes cannot unwrap the data key
+	replaced := newTestKeyring(t, "", map[string]string{"old": testKey('c')})
+	if _, err := replaced.Decrypt(encrypted); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Decrypt with a replaced key error = %v, want ErrMalformed", err)
+	}
+}
+
+func TestKeyringMalformed(t *testing.T) {
+	k := newTestKeyring(t, "", map[string]string{"k1": testKey('a')})
+	valid, _ := k.Encrypt("token")
+	parts := strings.Split(valid, ":")
+
+	tampered := []byte(parts[4])
+	if tampered[0] == 'A' {
+		tampered[0] = 'B'
+	} else {
+		tampered[0] = 'A'
+	}
+
+	for name, value := range map[string]string{
+		"missing parts":       "enc:v1:k1:abc",
+		"bad base64":          "enc:v1:k1:!!!:!!!",
+		"short ciphertext":    "enc:v1:k1:" + parts[3] + ":AA",
+		"tampered ciphertext": strings.Join(append(parts[:4:4], string(tampered)), ":"),
+		"swapped parts":       "enc:v1:k1:" + parts[4] + ":" + parts[3],
+	} {
+		if _, err := k.Decrypt(value); !errors.Is(err, ErrMalformed) {
+			t.Errorf("%s: Decrypt error = %v, want ErrMalformed", name, err)
+		}
+	}
+}
+
+func TestNewKeyringErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.EncryptionConfig
+	}{
+		{"no keys", config.EncryptionConfig{}},
+		{"short key", config.EncryptionConfig{Keys: map[string]string{"k1": base64.StdEncoding.EncodeToString([]byte("short"))}}},
+		{"not base64", config.EncryptionConfig{Keys: map[string]string{"k1": "not base64!"}}},
+		{"colon in ID", config.EncryptionConfig{Keys: map[string]string{"k:1": testKey('a')}}},
+		{"several keys without current", config.EncryptionConfig{Keys: map[string]string{"k1": testKey('a'), "k2": testKey('b')}}},
+		{"unknown current", config.EncryptionConfig{Keys: map[string]string{"k1": testKey('a')}, Current: "k2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeyring(&tt.cfg); err == nil {
+				t.Error("NewKeyring succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package secrets
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// reencryptBatchSize is the number of rows read per query while re-encrypting
+const reencryptBatchSize = 500
+
+// Reencrypt rewrites every encrypted column of the given models that is plaintext or
+// encrypted with an old key, so that it is encrypted with the current key. It covers
+// soft-deleted rows too and is safe to re-run. Returns the number of values rewritten.
+func Reencrypt(db *gorm.DB, k *Keyring, models ...interface{}) (int, error) {
+	total := 0
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return total, err
+		}
+
+		var columns []string
+		for _, field := range stmt.Schema.Fields {
+			if field.TagSettings["SERIALIZER"] == SerializerName {
+				columns = append(columns, field.DBName)
+			}
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		if stmt.Schema.PrioritizedPrimaryField == nil {
+			return total, fmt.Errorf("%s has encrypted columns but no primary key", stmt.Schema.Table)
+		}
+
+		n, err := reencryptTable(db, k, stmt.Schema.Table, stmt.Schema.PrioritizedPrimaryField.DBName, columns)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("failed to re-encrypt %s: %w", stmt.Schema.Table, err)
+		}
+	}
+	return total, nil
+}
+
+// reencryptTable works on raw column values through db.Table, bypassing the serializer,
+// so it sees what is actually stored
+func reencryptTable(db *gorm.DB, k *Keyring, table, pk string, columns []string) (int, error) {
+	total := 0
+	var lastID interface{}
+	for {
+		var rows []map[string]interface{}
+		query := db.Table(table).Select(append([]string{pk}, columns...)).Order(pk).Limit(reencryptBatchSize)
+		if lastID != nil {
+			query = query.Where(pk+" > ?", lastID)
+		}
+		if err := query.Find(&rows).Error; err != nil {
+			return total, err
+		}
+		if len(rows) == 0 {
+			return total, nil
+		}
+
+		for _, row := range rows {
+			lastID = row[pk]
+
+			updates := map[string]interface{}{}
+			for _, column := range columns {
+				value, _ := row[column].(string)
+				if k.IsCurrent(value) {
+					continue
+				}
+				plaintext, err := k.Decrypt(value)
+				if err != nil {
+					return total, fmt.Errorf("%s of row %v: %w", column, row[pk], err)
+				}
+				encrypted, err := k.Encrypt(plaintext)
+				if err != nil {
+					return total, err
+				}
+				updates[column] = encrypted
+			}
+			if len(updates) == 0 {
+				continue
+			}
+			if err := db.Table(table).Where(pk+" = ?", row[pk]).Updates(updates).Error; err != nil {
+				return total, err
+			}
+			total += len(updates)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+// SerializerName is the GORM serializer that encrypts a string column, used as
+// `gorm:"serializer:encrypted"` on model fields
+const SerializerName = "encrypted"
+
+// Register installs the keyring as the GORM serializer for encrypted columns. It must be
+// called before the first query, as models using the serializer fail to parse without it.
+func Register(k *Keyring) {
+	schema.RegisterSerializer(SerializerName, serializer{keyring: k})
+}
+
+// serializer encrypts string fields on write and decrypts them on read
+type serializer struct {
+	keyring *Keyring
+}
+
+// Scan decrypts the column value into the field
+func (s serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("unsupported value %T for encrypted field %s", dbValue, field.Name)
+	}
+
+	plaintext, err := s.keyring.Decrypt(value)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", field.Name, err)
+	}
+	field.ReflectValueOf(ctx, dst).SetString(plaintext)
+	return nil
+}
+
+// Value encrypts the field value for the column
+func (s serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plaintext, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("encrypted field %s must be a string", field.Name)
+	}
+	return s.keyring.Encrypt(plaintext)
+}
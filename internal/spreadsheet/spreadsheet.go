@@ -0,0 +1,81 @@
+package spreadsheet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsupportedFormat is returned for files that are neither CSV nor XLSX
+var ErrUnsupportedFormat = errors.New("unsupported file format: expected .csv or .xlsx")
+
+// Reader reads a spreadsheet one row at a time. Read returns io.EOF after the last row.
+type Reader interface {
+	Read() ([]string, error)
+}
+
+// Open returns a Reader for the file based on its extension
+func Open(filename string, r io.ReaderAt, size int64) (Reader, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv", ".txt":
+		return NewCSVReader(io.NewSectionReader(r, 0, size)), nil
+	case ".xlsx":
+		return NewXLSXReader(r, size)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// csvReader wraps encoding/csv, tolerating the quirks of spreadsheet exports
+type csvReader struct {
+	r     *csv.Reader
+	first bool
+}
+
+// NewCSVReader creates a CSV reader. The delimiter (comma, semicolon or tab) is detected
+// from the first line, and a UTF-8 byte order mark is stripped.
+func NewCSVReader(r io.Reader) Reader {
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	cr := csv.NewReader(br)
+	cr.Comma = detectDelimiter(br)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+
+	return &csvReader{r: cr, first: true}
+}
+
+func (c *csvReader) Read() ([]string, error) {
+	row, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if c.first {
+		c.first = false
+		if len(row) > 0 {
+			row[0] = strings.TrimPrefix(row[0], "\ufeff")
+		}
+	}
+	return row, nil
+}
+
+// detectDelimiter picks the most frequent of comma, semicolon and tab in the first line
+func detectDelimiter(br *bufio.Reader) rune {
+	peek, _ := br.Peek(br.Size())
+	if i := bytes.IndexByte(peek, '\n'); i >= 0 {
+		peek = peek[:i]
+	}
+
+	delimiter, best := ',', bytes.Count(peek, []byte{','})
+	for _, d := range []rune{';', '\t'} {
+		if n := bytes.Count(peek, []byte(string(d))); n > best {
+			delimiter, best = d, n
+		}
+	}
+	return delimiter
+}
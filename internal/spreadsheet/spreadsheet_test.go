@@ -0,0 +1,145 @@
+package spreadsheet
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		file string
+		want [][]string
+	}{
+		{
+			// UTF-8 BOM before the header; quoted cells keep scientific notation as text
+			file: "bom.csv",
+			want: [][]string{
+				{"phone", "name"},
+				{"+91 98765 43210", "Asha"},
+				{"9.1E+11", "Ravi, Jr."},
+			},
+		},
+		{
+			// Semicolon delimiter as exported by Excel in comma-decimal locales; blank lines are skipped
+			file: "semicolon.csv",
+			want: [][]string{
+				{"phone", "name", "city"},
+				{"919876543210", "Asha", "Pune; MH"},
+				{"919876543211", "Ravi", "Delhi"},
+			},
+		},
+		{
+			file: "tab.txt",
+			want: [][]string{
+				{"phone", "name"},
+				{"919876543210", "Asha"},
+			},
+		},
+		{
+			// First sheet is not sheet1.xml; shared strings with rich text and phonetic runs,
+			// an inline string, a number in scientific notation, a missing cell, missing rows,
+			// and formula and boolean cells
+			file: "contacts.xlsx",
+			want: [][]string{
+				{"phone", "name", "tags"},
+				{"910000000000", "Asha Rao", "vip"},
+				{"919876543210", "", "東京"},
+				{},
+				{},
+				{"919876543210", "1"},
+			},
+		},
+		{
+			// Only a worksheet: no workbook, relationships or shared strings, and rows without r
+			file: "minimal.xlsx",
+			want: [][]string{
+				{"phone"},
+				{"912345678901"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := Open(tt.file, f, info.Size())
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			got := readAll(t, r)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenUnsupportedFormat(t *testing.T) {
+	for _, name := range []string{"contacts.xls", "contacts.ods", "contacts"} {
+		if _, err := Open(name, strings.NewReader(""), 0); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("Open(%q) error = %v, want ErrUnsupportedFormat", name, err)
+		}
+	}
+}
+
+func TestOpenInvalidXLSX(t *testing.T) {
+	data := "phone,name\n"
+	if _, err := Open("contacts.xlsx", strings.NewReader(data), int64(len(data))); err == nil {
+		t.Error("Open of a CSV named .xlsx succeeded, want error")
+	}
+}
+
+func TestCSVDelimiterDetection(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  [][]string
+	}{
+		{"comma", "a,b\n1,2\n", [][]string{{"a", "b"}, {"1", "2"}}},
+		{"semicolon", "a;b\n1;2\n", [][]string{{"a", "b"}, {"1", "2"}}},
+		{"tab", "a\tb\n1\t2\n", [][]string{{"a", "b"}, {"1", "2"}}},
+		{"quoted delimiter in header", "\"a;x\",b\n1,2\n", [][]string{{"a;x", "b"}, {"1", "2"}}},
+		{"BOM only on first cell", "\ufeffa;b\n\ufeff1;2\n", [][]string{{"a", "b"}, {"\ufeff1", "2"}}},
+		{"CRLF", "a,b\r\n1,2\r\n", [][]string{{"a", "b"}, {"1", "2"}}},
+		{"single column", "phone\n919876543210\n", [][]string{{"phone"}, {"919876543210"}}},
+		{"empty", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readAll(t, NewCSVReader(strings.NewReader(tt.input)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func readAll(t *testing.T, r Reader) [][]string {
+	t.Helper()
+	var rows [][]string
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return rows
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		rows = append(rows, row)
+	}
+}
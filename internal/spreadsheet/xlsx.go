@@ -0,0 +1,338 @@
+package spreadsheet
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// xlsxReader streams the rows of the first worksheet of an XLSX workbook.
+// Only shared strings are held in memory; the worksheet XML is decoded token by token.
+type xlsxReader struct {
+	sheet   io.ReadCloser
+	dec     *xml.Decoder
+	shared  []string
+	nextRow int // 1-based number of the next row to return
+	pending []string
+	gap     int // empty rows still to emit before pending
+	done    bool
+}
+
+// NewXLSXReader opens the first worksheet of an XLSX workbook
+func NewXLSXReader(r io.ReaderAt, size int64) (Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid xlsx file: %w", err)
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	sheetPath, err := firstSheetPath(files)
+	if err != nil {
+		return nil, err
+	}
+	sheetFile, ok := files[sheetPath]
+	if !ok {
+		return nil, fmt.Errorf("invalid xlsx file: worksheet %s not found", sheetPath)
+	}
+
+	var shared []string
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		if shared, err = readSharedStrings(f); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := sheetFile.Open()
+	if err != nil {
+		return nil, fmt.Errorf("invalid xlsx file: %w", err)
+	}
+
+	return &xlsxReader{
+		sheet:   sheet,
+		dec:     xml.NewDecoder(sheet),
+		shared:  shared,
+		nextRow: 1,
+	}, nil
+}
+
+// firstSheetPath resolves the zip path of the workbook's first sheet
+func firstSheetPath(files map[string]*zip.File) (string, error) {
+	const fallback = "xl/worksheets/sheet1.xml"
+
+	var workbook struct {
+		Sheets []struct {
+			RID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodeZipXML(files["xl/workbook.xml"], &workbook); err != nil || len(workbook.Sheets) == 0 {
+		return fallback, nil
+	}
+
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodeZipXML(files["xl/_rels/workbook.xml.rels"], &rels); err != nil {
+		return fallback, nil
+	}
+
+	for _, rel := range rels.Relationships {
+		if rel.ID != workbook.Sheets[0].RID {
+			continue
+		}
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/"), nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+	return fallback, nil
+}
+
+func decodeZipXML(f *zip.File, v interface{}) error {
+	if f == nil {
+		return errors.New("missing part")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// readSharedStrings loads the shared string table. Rich-text runs are concatenated;
+// phonetic hints (rPh) are ignored.
+func readSharedStrings(f *zip.File) ([]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("invalid xlsx file: %w", err)
+	}
+	defer rc.Close()
+
+	var (
+		shared  []string
+		current strings.Builder
+		inSI    bool
+		inT     bool
+		inRPh   bool
+	)
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return shared, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid xlsx shared strings: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				inSI = true
+				current.Reset()
+			case "rPh":
+				inRPh = true
+			case "t":
+				inT = inSI && !inRPh
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				inSI = false
+				shared = append(shared, current.String())
+			case "rPh":
+				inRPh = false
+			case "t":
+				inT = false
+			}
+		case xml.CharData:
+			if inT {
+				current.Write(t)
+			}
+		}
+	}
+}
+
+func (x *xlsxReader) Read() ([]string, error) {
+	if x.gap > 0 {
+		x.gap--
+		x.nextRow++
+		return []string{}, nil
+	}
+	if x.pending != nil {
+		row := x.pending
+		x.pending = nil
+		x.nextRow++
+		return row, nil
+	}
+	if x.done {
+		return nil, io.EOF
+	}
+
+	for {
+		tok, err := x.dec.Token()
+		if err == io.EOF {
+			x.close()
+			return nil, io.EOF
+		}
+		if err != nil {
+			x.close()
+			return nil, fmt.Errorf("invalid xlsx worksheet: %w", err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		rowNum := x.nextRow
+		if v := attr(start, "r"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				rowNum = n
+			}
+		}
+
+		row, err := x.readRow()
+		if err != nil {
+			x.close()
+			return nil, err
+		}
+
+		// Rows missing from the sheet XML are empty; emit them so row numbers line up
+		if rowNum > x.nextRow {
+			x.gap = rowNum - x.nextRow - 1
+			x.pending = row
+			x.nextRow++
+			return []string{}, nil
+		}
+		x.nextRow++
+		return row, nil
+	}
+}
+
+// readRow decodes the cells of the current <row> element
+func (x *xlsxReader) readRow() ([]string, error) {
+	var row []string
+	for {
+		tok, err := x.dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("invalid xlsx worksheet: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "c" {
+				continue
+			}
+			col := len(row)
+			if ref := attr(t, "r"); ref != "" {
+				if c, ok := columnIndex(ref); ok {
+					col = c
+				}
+			}
+			value, err := x.readCell(attr(t, "t"))
+			if err != nil {
+				return nil, err
+			}
+			for len(row) < col {
+				row = append(row, "")
+			}
+			row = append(row, value)
+		case xml.EndElement:
+			if t.Name.Local == "row" {
+				return row, nil
+			}
+		}
+	}
+}
+
+// readCell decodes the value of the current <c> element
+func (x *xlsxReader) readCell(cellType string) (string, error) {
+	var (
+		value   strings.Builder
+		inValue bool
+	)
+	for {
+		tok, err := x.dec.Token()
+		if err != nil {
+			return "", fmt.Errorf("invalid xlsx worksheet: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			// <v> holds the value; inline strings use <is><t>
+			inValue = t.Name.Local == "v" || t.Name.Local == "t"
+		case xml.EndElement:
+			if t.Name.Local == "c" {
+				return x.cellValue(cellType, strings.TrimSpace(value.String())), nil
+			}
+			inValue = false
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+		}
+	}
+}
+
+func (x *xlsxReader) cellValue(cellType, raw string) string {
+	switch cellType {
+	case "s":
+		if i, err := strconv.Atoi(raw); err == nil && i >= 0 && i < len(x.shared) {
+			return x.shared[i]
+		}
+		return ""
+	case "", "n":
+		// Long numbers such as phone numbers may be stored in scientific notation
+		if strings.ContainsAny(raw, "eE") {
+			if f, err := strconv.ParseFloat(raw, 64); err == nil {
+				return strconv.FormatFloat(f, 'f', -1, 64)
+			}
+		}
+	}
+	return raw
+}
+
+func (x *xlsxReader) close() {
+	x.done = true
+	x.sheet.Close()
+}
+
+func attr(el xml.StartElement, name string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// columnIndex converts a cell reference such as "AB12" to a zero-based column index
+func columnIndex(ref string) (int, bool) {
+	col := 0
+	n := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		col = col*26 + int(c-'A'+1)
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return col - 1, true
+}
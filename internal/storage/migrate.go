@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+// MigrateResult counts the files handled by MigrateLocal
+type MigrateResult struct {
+	Copied  int
+	Skipped int // Already present in the destination
+	Failed  int
+}
+
+// MigrateLocal copies every file under src into dst under the same key, skipping objects
+// dst already has, so it can be re-run after an interruption. Files are left in place.
+// Failures of individual files are reported to onError and counted; walking continues.
+func MigrateLocal(ctx context.Context, src *Local, dst Store, onError func(key string, err error)) (MigrateResult, error) {
+	var res MigrateResult
+	err := filepath.WalkDir(src.Root(), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src.Root(), p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !ValidKey(key) {
+			return nil
+		}
+
+		exists, err := dst.Exists(ctx, key)
+		if err != nil {
+			res.Failed++
+			onError(key, err)
+			return nil
+		}
+		if exists {
+			res.Skipped++
+			return nil
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			res.Failed++
+			onError(key, err)
+			return nil
+		}
+		if err := dst.Put(ctx, key, data, mime.TypeByExtension(filepath.Ext(p))); err != nil {
+			res.Failed++
+			onError(key, err)
+			return nil
+		}
+		res.Copied++
+		return nil
+	})
+	if err != nil {
+		return res, fmt.Errorf("failed to walk %s: %w", src.Root(), err)
+	}
+	return res, nil
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/isaee-xyz/whatomate/internal/config"
+)
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// ErrInvalidExpiry is returned when a signed URL's expiry is outside what the store supports
+var ErrInvalidExpiry = errors.New("invalid signed URL expiry")
+
+// Store saves and serves media objects. Keys are slash-separated relative paths
+// such as "images/<uuid>.jpg"; they are what messages store in MediaURL.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a URL from which the object can be downloaded without
+	// other credentials until it expires
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// New creates the store selected by cfg.Type. Signed URLs of the local store point
+// at urlPrefix and are signed with signingKey.
+func New(cfg *config.StorageConfig, signingKey, urlPrefix string) (Store, error) {
+	// Check the configured expiry here so a bad value fails startup rather than every request
+	expiry := time.Duration(cfg.SignedURLExpiryMins) * time.Minute
+	if expiry <= 0 {
+		return nil, fmt.Errorf("%w: storage.signed_url_expiry_mins must be positive", ErrInvalidExpiry)
+	}
+
+	switch cfg.Type {
+	case "", "local":
+		return NewLocal(cfg.LocalPath, signingKey, urlPrefix)
+	case "s3":
+		if expiry > s3MaxPresignExpiry {
+			return nil, fmt.Errorf("%w: storage.signed_url_expiry_mins cannot exceed 7 days (10080) with s3 storage", ErrInvalidExpiry)
+		}
+		return NewS3(S3Options{
+			Endpoint:     cfg.S3Endpoint,
+			Region:       cfg.S3Region,
+			Bucket:       cfg.S3Bucket,
+			AccessKey:    cfg.S3Key,
+			SecretKey:    cfg.S3Secret,
+			UsePathStyle: cfg.S3UsePathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", cfg.Type)
+	}
+}
+
+// ValidKey reports whether key is a relative path that cannot escape the store
+func ValidKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package templates
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
+	"gorm.io/gorm"
+)
+
+// Variants returns the approved templates with the template's name on accountName, one
+// per language. The template itself is included if it is approved.
+func Variants(db *gorm.DB, template *models.Template, accountName string) []models.Template {
+	var variants []models.Template
+	db.Where("organization_id = ? AND whats_app_account = ? AND name = ? AND UPPER(status) = ?",
+		template.OrganizationID, accountName, template.Name, "APPROVED").
+		Find(&variants)
+	return variants
+}
+
+// LanguageFallback returns the organization's fallback chain of template languages,
+// tried in order when a template is not available in a contact's locale
+func LanguageFallback(db *gorm.DB, orgID uuid.UUID) []string {
+	var org models.Organization
+	if err := db.Select("settings").Where("id = ?", orgID).First(&org).Error; err != nil {
+		return nil
+	}
+	return LanguageFallbackSetting(org.Settings)
+}
+
+// LanguageFallbackSetting reads the fallback chain of template languages from
+// organization settings
+func LanguageFallbackSetting(settings models.JSONB) []string {
+	var out []string
+	switch list := settings["template_language_fallback"].(type) {
+	case []string:
+		out = append(out, list...)
+	case []interface{}:
+		for _, item := range list {
+			if s, ok := item.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// Localized returns the variant in the language best suited to locale, among those for
+// which fits returns true. The default is returned when locale is empty or no variant matches.
+func Localized(variants []models.Template, def *models.Template, locale string, fallback []string, fits func(*models.Template) bool) *models.Template {
+	if def == nil || locale == "" || len(variants) == 0 {
+		return def
+	}
+	byLanguage := make(map[string]*models.Template, len(variants))
+	var languages []string
+	for i := range variants {
+		t := &variants[i]
+		if fits != nil && !fits(t) {
+			continue
+		}
+		byLanguage[t.Language] = t
+		// The default's own language comes first, so it wins between regions of a language
+		if t.Language == def.Language {
+			languages = append([]string{t.Language}, languages...)
+		} else {
+			languages = append(languages, t.Language)
+		}
+	}
+
+	if lang := whatsapp.SelectTemplateLanguage(languages, locale, fallback); lang != "" {
+		return byLanguage[lang]
+	}
+	return def
+}
+
+// ForRecipient returns the campaign's template in the language best suited to the
+// recipient's contact, among the approved variants with the same header type that the
+// recipient's parameters fit
+func ForRecipient(variants []models.Template, def *models.Template, header *whatsapp.TemplateHeaderParam, recipient *models.BulkMessageRecipient, locale string, fallback []string) *models.Template {
+	return Localized(variants, def, locale, fallback, func(t *models.Template) bool {
+		if !strings.EqualFold(t.HeaderType, def.HeaderType) {
+			return false
+		}
+		_, err := Layout(t).ParamsFromValues(recipient.TemplateParams, header)
+		return err == nil
+	})
+}
@@ -0,0 +1,70 @@
+package templates
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/isaee-xyz/whatomate/internal/models"
+)
+
+func TestLocalized(t *testing.T) {
+	def := &models.Template{Name: "order_update", Language: "en_US", BodyContent: "Order {{1}}"}
+	variants := []models.Template{
+		{Name: "order_update", Language: "en_GB", BodyContent: "Order {{1}}"},
+		*def,
+		{Name: "order_update", Language: "pt_BR", BodyContent: "Pedido {{1}}"},
+		{Name: "order_update", Language: "de", BodyContent: "Bestellung {{1}} {{2}}"},
+		{Name: "order_update", Language: "fr", HeaderType: "IMAGE", BodyContent: "Commande {{1}}"},
+	}
+	recipient := &models.BulkMessageRecipient{TemplateParams: models.JSONB{"1": "42"}}
+
+	tests := []struct {
+		name     string
+		locale   string
+		fallback []string
+		want     string
+	}{
+		{"no locale", "", nil, "en_US"},
+		{"exact", "pt_BR", nil, "pt_BR"},
+		{"other region", "pt_PT", nil, "pt_BR"},
+		{"default's language wins between regions", "en_AU", nil, "en_US"},
+		{"parameters do not fit", "de", nil, "en_US"},
+		{"other header type", "fr", nil, "en_US"},
+		{"fallback", "ja", []string{"pt_BR"}, "pt_BR"},
+		{"no match", "ja", nil, "en_US"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ForRecipient(variants, def, nil, recipient, tt.locale, tt.fallback)
+			if got == nil || got.Language != tt.want {
+				t.Errorf("ForRecipient = %+v, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if got := Localized(nil, nil, "en", nil, nil); got != nil {
+		t.Errorf("Localized without a default = %+v, want nil", got)
+	}
+}
+
+func TestLanguageFallbackSetting(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings models.JSONB
+		want     []string
+	}{
+		{"unset", nil, nil},
+		{"from JSON", models.JSONB{"template_language_fallback": []interface{}{"es", "", 1, "en"}}, []string{"es", "en"}},
+		{"strings", models.JSONB{"template_language_fallback": []string{"pt_BR"}}, []string{"pt_BR"}},
+		{"not a list", models.JSONB{"template_language_fallback": "en"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LanguageFallbackSetting(tt.settings); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LanguageFallbackSetting = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+// Package templates has the message template helpers shared by the handlers and the
+// campaign worker.
+package templates
+
+import (
+	"github.com/isaee-xyz/whatomate/internal/models"
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
+)
+
+// Layout returns the parts of a template that take parameters when sending
+func Layout(template *models.Template) *whatsapp.TemplateLayout {
+	return &whatsapp.TemplateLayout{
+		HeaderType:    template.HeaderType,
+		HeaderContent: template.HeaderContent,
+		BodyContent:   template.BodyContent,
+		Buttons:       template.Buttons,
+	}
+}
+
+// CampaignHeaderParam returns the campaign's header media, or nil if it has none
+func CampaignHeaderParam(campaign *models.BulkMessageCampaign) *whatsapp.TemplateHeaderParam {
+	h := campaign.HeaderMedia
+	if len(h) == 0 {
+		return nil
+	}
+	str := func(key string) string {
+		s, _ := h[key].(string)
+		return s
+	}
+	return &whatsapp.TemplateHeaderParam{
+		Type:     str("type"),
+		MediaID:  str("media_id"),
+		Link:     str("link"),
+		Filename: str("filename"),
+	}
+}
@@ -3,7 +3,9 @@ package worker
 import (
 	"context"
 	"fmt"
-	"strings"
+	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,6 +13,8 @@ import (
 	"github.com/isaee-xyz/whatomate/internal/config"
 	"github.com/isaee-xyz/whatomate/internal/models"
 	"github.com/isaee-xyz/whatomate/internal/queue"
+	"github.com/isaee-xyz/whatomate/internal/ratelimit"
+	"github.com/isaee-xyz/whatomate/internal/templates"
 	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
 	"github.com/zerodha/logf"
 	"gorm.io/gorm"
@@ -25,6 +29,7 @@ type Worker struct {
 	WhatsApp  *whatsapp.Client
 	Consumer  *queue.RedisConsumer
 	Publisher *queue.Publisher
+	Limiter   *ratelimit.Limiter
 }
 
 // New creates a new Worker instance
@@ -36,14 +41,19 @@ func New(cfg *config.Config, db *gorm.DB, rdb *redis.Client, log logf.Logger) (*
 
 	publisher := queue.NewPublisher(rdb, log)
 
+	waClient := whatsapp.New(log)
+	waClient.BaseURL = cfg.WhatsApp.BaseURL
+	waClient.MaxRetries = cfg.WhatsApp.MaxRetries
+
 	return &Worker{
 		Config:    cfg,
 		DB:        db,
 		Redis:     rdb,
 		Log:       log,
-		WhatsApp:  whatsapp.New(log),
+		WhatsApp:  waClient,
 		Consumer:  consumer,
 		Publisher: publisher,
+		Limiter:   ratelimit.New(rdb),
 	}, nil
 }
 
@@ -111,178 +121,340 @@ func (w *Worker) processCampaign(ctx context.Context, campaignID uuid.UUID) erro
 
 	w.Log.Info("Processing recipients", "campaign_id", campaignID, "count", len(recipients))
 
-	sentCount := campaign.SentCount
-	failedCount := campaign.FailedCount
+	var variants []models.Template
+	if campaign.Template != nil {
+		variants = templates.Variants(w.DB, campaign.Template, campaign.WhatsAppAccount)
+	}
+	run := &campaignRun{
+		campaign:    &campaign,
+		account:     &account,
+		header:      templates.CampaignHeaderParam(&campaign),
+		variants:    variants,
+		fallback:    templates.LanguageFallback(w.DB, campaign.OrganizationID),
+		rate:        ratelimit.MessagesPerSecond(account.ThroughputTier, account.MessagesPerSecond),
+		startedAt:   time.Now(),
+		sentCount:   campaign.SentCount,
+		failedCount: campaign.FailedCount,
+	}
+
+	// Watch for pause/cancel and publish progress while recipients are being sent
+	monitorCtx, stopMonitor := context.WithCancel(ctx)
+	monitorDone := make(chan struct{})
+	go func() {
+		defer close(monitorDone)
+		w.monitorCampaign(monitorCtx, run)
+	}()
+
+	// Feed recipients to a bounded pool of senders; the per-account rate limiter paces them
+	concurrency := w.Config.WhatsApp.CampaignConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	jobs := make(chan *models.BulkMessageRecipient)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for recipient := range jobs {
+				w.sendToRecipient(ctx, run, recipient)
+			}
+		}()
+	}
 
-	for _, recipient := range recipients {
-		// Check context for cancellation
+	for i := range recipients {
+		if ctx.Err() != nil || run.stopped.Load() {
+			break
+		}
 		select {
+		case jobs <- &recipients[i]:
 		case <-ctx.Done():
-			w.Log.Info("Campaign processing cancelled by context", "campaign_id", campaignID)
-			return ctx.Err()
-		default:
 		}
+	}
+	close(jobs)
+	wg.Wait()
+	stopMonitor()
+	<-monitorDone
 
-		// Check if campaign is still active (not paused/cancelled)
-		var currentCampaign models.BulkMessageCampaign
-		w.DB.Where("id = ?", campaignID).First(&currentCampaign)
-		if currentCampaign.Status == "paused" || currentCampaign.Status == "cancelled" {
-			w.Log.Info("Campaign stopped", "campaign_id", campaignID, "status", currentCampaign.Status)
-			return nil
-		}
+	sentCount, failedCount, throughput := run.snapshot()
+	w.DB.Model(&campaign).Updates(map[string]interface{}{
+		"sent_count":   sentCount,
+		"failed_count": failedCount,
+	})
 
-		// Get or create contact for this recipient
-		contact, err := w.getOrCreateContact(campaign.OrganizationID, recipient.PhoneNumber, recipient.RecipientName)
-		if err != nil || contact == nil {
-			w.Log.Error("Failed to get or create contact", "error", err, "phone", recipient.PhoneNumber)
-			w.DB.Model(&recipient).Updates(map[string]interface{}{
-				"status":        "failed",
-				"error_message": "Failed to create contact",
-			})
-			failedCount++
-			continue
-		}
+	if ctx.Err() != nil {
+		w.Log.Info("Campaign processing cancelled by context", "campaign_id", campaignID)
+		return ctx.Err()
+	}
+	if run.stopped.Load() {
+		w.Log.Info("Campaign stopped", "campaign_id", campaignID, "sent", sentCount, "failed", failedCount)
+		return nil
+	}
 
-		// Send template message
-		waMessageID, err := w.sendTemplateMessage(ctx, &account, campaign.Template, &recipient)
-
-		// Create Message record with campaign_id in metadata
-		message := models.Message{
-			OrganizationID:    campaign.OrganizationID,
-			WhatsAppAccount:   campaign.WhatsAppAccount,
-			ContactID:         contact.ID,
-			WhatsAppMessageID: waMessageID,
-			Direction:         "outgoing",
-			MessageType:       "template",
-			TemplateParams:    recipient.TemplateParams,
-			Metadata: models.JSONB{
-				"campaign_id":    campaignID.String(),
-				"recipient_name": recipient.RecipientName,
-			},
-		}
-		if campaign.Template != nil {
-			message.TemplateName = campaign.Template.Name
-			// Store template body with substituted values for display in chat
-			content := campaign.Template.BodyContent
-			// Replace placeholders {{1}}, {{2}}, etc. with actual values
-			if recipient.TemplateParams != nil {
-				for i := 1; i <= 10; i++ {
-					key := fmt.Sprintf("%d", i)
-					if val, ok := recipient.TemplateParams[key]; ok {
-						placeholder := fmt.Sprintf("{{%d}}", i)
-						content = strings.ReplaceAll(content, placeholder, fmt.Sprintf("%v", val))
-					}
-				}
-			}
-			message.Content = content
-		}
+	// Mark campaign as completed
+	now := time.Now()
+	w.DB.Model(&campaign).Updates(map[string]interface{}{
+		"status":       "completed",
+		"completed_at": now,
+	})
 
-		if err != nil {
-			w.Log.Error("Failed to send message", "error", err, "recipient", recipient.PhoneNumber)
-			message.Status = "failed"
-			message.ErrorMessage = err.Error()
-			failedCount++
-		} else {
-			w.Log.Info("Message sent", "recipient", recipient.PhoneNumber, "message_id", waMessageID)
-			message.Status = "sent"
-			sentCount++
-		}
+	// Publish completion status via Redis pub/sub
+	w.Publisher.PublishCampaignStats(ctx, &queue.CampaignStatsUpdate{
+		CampaignID:        campaignID.String(),
+		OrganizationID:    campaign.OrganizationID,
+		Status:            "completed",
+		SentCount:         sentCount,
+		DeliveredCount:    0,
+		ReadCount:         0,
+		FailedCount:       failedCount,
+		MessagesPerSecond: throughput,
+	})
 
-		// Save message record
-		if err := w.DB.Create(&message).Error; err != nil {
-			w.Log.Error("Failed to save campaign message", "error", err, "recipient", recipient.PhoneNumber)
-		}
+	w.Log.Info("Campaign completed", "campaign_id", campaignID, "sent", sentCount, "failed", failedCount, "messages_per_second", throughput)
+	return nil
+}
+
+// campaignRun holds the shared state of one campaign processing run
+type campaignRun struct {
+	campaign   *models.BulkMessageCampaign
+	account    *models.WhatsAppAccount
+	header     *whatsapp.TemplateHeaderParam // campaign header media, nil if none
+	variants   []models.Template             // approved languages of the campaign's template
+	fallback   []string                      // organization's template language fallback chain
+	rate       int
+	startedAt  time.Time
+	stopped    atomic.Bool // set when the campaign is paused or cancelled
+	authFailed atomic.Bool // set when Meta rejected the account's credentials
+
+	mu          sync.Mutex
+	sentCount   int
+	failedCount int
+	processed   int // sends attempted in this run, for throughput
+}
+
+func (run *campaignRun) record(sent bool) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if sent {
+		run.sentCount++
+	} else {
+		run.failedCount++
+	}
+	run.processed++
+}
 
-		// Update BulkMessageRecipient status to track which recipients have been processed
-		recipientUpdate := map[string]interface{}{
-			"status":               message.Status,
-			"whats_app_message_id": waMessageID,
+// snapshot returns the current counts and the achieved messages per second in this run
+func (run *campaignRun) snapshot() (sent, failed int, throughput float64) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if elapsed := time.Since(run.startedAt).Seconds(); elapsed > 0 {
+		throughput = math.Round(float64(run.processed)/elapsed*100) / 100
+	}
+	return run.sentCount, run.failedCount, throughput
+}
+
+// monitorCampaign checks once a second whether the campaign was paused or cancelled,
+// and persists and publishes progress
+func (w *Worker) monitorCampaign(ctx context.Context, run *campaignRun) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
-		if message.Status == "failed" {
-			recipientUpdate["error_message"] = message.ErrorMessage
-		} else {
-			recipientUpdate["sent_at"] = time.Now()
+
+		var current models.BulkMessageCampaign
+		if err := w.DB.Select("status").Where("id = ?", run.campaign.ID).First(&current).Error; err == nil {
+			if current.Status == "paused" || current.Status == "cancelled" {
+				run.stopped.Store(true)
+			}
 		}
-		w.DB.Model(&recipient).Updates(recipientUpdate)
 
-		// Update campaign counts
-		w.DB.Model(&campaign).Updates(map[string]interface{}{
+		sentCount, failedCount, throughput := run.snapshot()
+		w.DB.Model(run.campaign).Updates(map[string]interface{}{
 			"sent_count":   sentCount,
 			"failed_count": failedCount,
 		})
 
 		// Publish stats update via Redis pub/sub for real-time WebSocket broadcast
 		w.Publisher.PublishCampaignStats(ctx, &queue.CampaignStatsUpdate{
-			CampaignID:     campaignID.String(),
-			OrganizationID: campaign.OrganizationID,
-			Status:         "processing",
-			SentCount:      sentCount,
-			DeliveredCount: 0,
-			ReadCount:      0,
-			FailedCount:    failedCount,
+			CampaignID:        run.campaign.ID.String(),
+			OrganizationID:    run.campaign.OrganizationID,
+			Status:            "processing",
+			SentCount:         sentCount,
+			DeliveredCount:    0,
+			ReadCount:         0,
+			FailedCount:       failedCount,
+			MessagesPerSecond: throughput,
 		})
+	}
+}
+
+// sendToRecipient sends the campaign template to one recipient and records the outcome
+func (w *Worker) sendToRecipient(ctx context.Context, run *campaignRun, recipient *models.BulkMessageRecipient) {
+	campaign := run.campaign
 
-		// Small delay to avoid rate limiting (WhatsApp has rate limits)
-		time.Sleep(100 * time.Millisecond)
+	// Recipients on the organization's opt-out list are skipped, not sent or failed
+	if w.isOptedOut(campaign.OrganizationID, recipient.PhoneNumber) {
+		w.Log.Info("Skipping opted-out recipient", "recipient", recipient.PhoneNumber, "campaign_id", campaign.ID)
+		w.DB.Model(recipient).Updates(map[string]interface{}{
+			"status":        "opted_out",
+			"error_message": "recipient has opted out",
+		})
+		return
 	}
 
-	// Mark campaign as completed
-	now := time.Now()
-	w.DB.Model(&campaign).Updates(map[string]interface{}{
-		"status":       "completed",
-		"completed_at": now,
-		"sent_count":   sentCount,
-		"failed_count": failedCount,
-	})
+	// Get or create contact for this recipient
+	contact, err := w.getOrCreateContact(campaign.OrganizationID, recipient.PhoneNumber, recipient.RecipientName)
+	if err != nil || contact == nil {
+		w.Log.Error("Failed to get or create contact", "error", err, "phone", recipient.PhoneNumber)
+		w.DB.Model(recipient).Updates(map[string]interface{}{
+			"status":        "failed",
+			"error_message": "Failed to create contact",
+		})
+		run.record(false)
+		return
+	}
 
-	// Publish completion status via Redis pub/sub
-	w.Publisher.PublishCampaignStats(ctx, &queue.CampaignStatsUpdate{
-		CampaignID:     campaignID.String(),
-		OrganizationID: campaign.OrganizationID,
-		Status:         "completed",
-		SentCount:      sentCount,
-		DeliveredCount: 0,
-		ReadCount:      0,
-		FailedCount:    failedCount,
-	})
+	// Send template message in the contact's language, backing off when Meta reports throttling
+	template := templates.ForRecipient(run.variants, run.campaign.Template, run.header, recipient, contact.Locale, run.fallback)
+	waMessageID, err := w.sendWithRateLimit(ctx, run, template, recipient)
+	if err != nil && ctx.Err() != nil {
+		// Shutting down; leave the recipient pending so it is sent when the job is picked up again
+		return
+	}
+	if err != nil && whatsapp.IsAuthError(err) {
+		// Every further send would fail the same way, so the campaign is paused and the
+		// recipient stays pending until the account's credentials are fixed
+		w.pauseForAuthError(run, err)
+		return
+	}
 
-	w.Log.Info("Campaign completed", "campaign_id", campaignID, "sent", sentCount, "failed", failedCount)
-	return nil
+	// Create Message record with campaign_id in metadata
+	message := models.Message{
+		OrganizationID:    campaign.OrganizationID,
+		WhatsAppAccount:   campaign.WhatsAppAccount,
+		ContactID:         contact.ID,
+		WhatsAppMessageID: waMessageID,
+		Direction:         "outgoing",
+		MessageType:       "template",
+		TemplateParams:    recipient.TemplateParams,
+		Metadata: models.JSONB{
+			"campaign_id":    campaign.ID.String(),
+			"recipient_name": recipient.RecipientName,
+		},
+	}
+	if err != nil {
+		message.Metadata["error_class"] = string(whatsapp.ClassOf(err))
+	}
+	if template != nil {
+		message.TemplateName = template.Name
+		message.Metadata["template_language"] = template.Language
+		message.Metadata["template_version"] = template.Version
+		// Store template body with substituted values for display in chat
+		message.Content = whatsapp.RenderTemplateText(template.BodyContent, recipient.TemplateParams)
+	}
+
+	if err != nil {
+		w.Log.Error("Failed to send message", "error", err, "recipient", recipient.PhoneNumber)
+		message.Status = "failed"
+		message.ErrorMessage = err.Error()
+	} else {
+		w.Log.Info("Message sent", "recipient", recipient.PhoneNumber, "message_id", waMessageID)
+		message.Status = "sent"
+	}
+	run.record(err == nil)
+
+	// Save message record
+	if err := w.DB.Create(&message).Error; err != nil {
+		w.Log.Error("Failed to save campaign message", "error", err, "recipient", recipient.PhoneNumber)
+	}
+
+	// Update BulkMessageRecipient status to track which recipients have been processed
+	recipientUpdate := map[string]interface{}{
+		"status":               message.Status,
+		"whats_app_message_id": waMessageID,
+	}
+	if template != nil {
+		recipientUpdate["template_language"] = template.Language
+		recipientUpdate["template_version"] = template.Version
+	}
+	if message.Status == "failed" {
+		// The class tells a later retry whether sending again can help
+		recipientUpdate["error_message"] = message.ErrorMessage
+		recipientUpdate["error_code"] = whatsapp.ErrorCode(err)
+		recipientUpdate["error_class"] = string(whatsapp.ClassOf(err))
+	} else {
+		recipientUpdate["sent_at"] = time.Now()
+	}
+	w.DB.Model(recipient).Updates(recipientUpdate)
 }
 
-// sendTemplateMessage sends a template message via WhatsApp Cloud API
-func (w *Worker) sendTemplateMessage(ctx context.Context, account *models.WhatsAppAccount, template *models.Template, recipient *models.BulkMessageRecipient) (string, error) {
-	waAccount := &whatsapp.Account{
-		PhoneID:     account.PhoneID,
-		BusinessID:  account.BusinessID,
-		APIVersion:  account.APIVersion,
-		AccessToken: account.AccessToken,
+// pauseForAuthError pauses the campaign and flags the account once Meta rejects the
+// account's access token or permissions
+func (w *Worker) pauseForAuthError(run *campaignRun, err error) {
+	if !run.authFailed.CompareAndSwap(false, true) {
+		return
+	}
+	run.stopped.Store(true)
+
+	w.Log.Error("Meta rejected the account's credentials, pausing campaign", "error", err, "account", run.account.Name, "campaign_id", run.campaign.ID)
+	if err := w.DB.Model(run.account).Update("status", "error").Error; err != nil {
+		w.Log.Error("Failed to update account status", "error", err, "account", run.account.Name)
+	}
+	if err := w.DB.Model(run.campaign).Update("status", "paused").Error; err != nil {
+		w.Log.Error("Failed to pause campaign", "error", err, "campaign_id", run.campaign.ID)
 	}
+}
 
-	// Build template components with parameters
-	var components []map[string]interface{}
+// sendWithRateLimit waits for the account's rate limiter before each attempt. On a Meta
+// throttling error it pauses the whole account (for every worker) with exponential back-off
+// and retries, up to the configured number of retries. Other transient errors are already
+// retried by the client; permanent and auth errors are returned at once.
+func (w *Worker) sendWithRateLimit(ctx context.Context, run *campaignRun, template *models.Template, recipient *models.BulkMessageRecipient) (string, error) {
+	limiterKey := run.account.PhoneID
+	backoff := time.Second
+
+	for attempt := 0; ; attempt++ {
+		if err := w.Limiter.Wait(ctx, limiterKey, run.rate); err != nil {
+			return "", err
+		}
 
-	// Add body parameters if template has variables
-	if recipient.TemplateParams != nil && len(recipient.TemplateParams) > 0 {
-		bodyParams := []map[string]interface{}{}
-		for i := 1; i <= 10; i++ {
-			key := fmt.Sprintf("%d", i)
-			if val, ok := recipient.TemplateParams[key]; ok {
-				bodyParams = append(bodyParams, map[string]interface{}{
-					"type": "text",
-					"text": val,
-				})
-			}
+		waMessageID, err := w.sendTemplateMessage(ctx, run, template, recipient)
+		if err == nil || !whatsapp.IsThrottlingError(err) || attempt >= w.Config.WhatsApp.ThrottleRetries {
+			return waMessageID, err
+		}
+
+		w.Log.Warn("Throttled by Meta, backing off", "error", err, "account", run.account.Name, "backoff", backoff, "attempt", attempt+1)
+		if err := w.Limiter.Backoff(ctx, limiterKey, backoff); err != nil {
+			w.Log.Error("Failed to set rate limit back-off", "error", err)
 		}
-		if len(bodyParams) > 0 {
-			components = append(components, map[string]interface{}{
-				"type":       "body",
-				"parameters": bodyParams,
-			})
+		if backoff < time.Minute {
+			backoff *= 2
 		}
 	}
+}
+
+// sendTemplateMessage sends the template (the campaign's, or its variant in the contact's
+// language) via WhatsApp Cloud API, with the campaign's header media unless the recipient has its own
+func (w *Worker) sendTemplateMessage(ctx context.Context, run *campaignRun, template *models.Template, recipient *models.BulkMessageRecipient) (string, error) {
+	account := run.account
+	params, err := templates.Layout(template).ParamsFromValues(recipient.TemplateParams, run.header)
+	if err != nil {
+		return "", err
+	}
+
+	waAccount := &whatsapp.Account{
+		PhoneID:     account.PhoneID,
+		BusinessID:  account.BusinessID,
+		APIVersion:  account.APIVersion,
+		AccessToken: account.AccessToken,
+	}
 
-	return w.WhatsApp.SendTemplateMessageWithComponents(ctx, waAccount, recipient.PhoneNumber, template.Name, template.Language, components)
+	return w.WhatsApp.SendTemplateMessageWithComponents(ctx, waAccount, recipient.PhoneNumber, template.Name, template.Language, whatsapp.BuildTemplateComponents(params))
 }
 
 // Close cleans up worker resources
@@ -293,24 +465,34 @@ func (w *Worker) Close() error {
 	return nil
 }
 
+// isOptedOut reports whether the phone number is on the organization's opt-out list
+func (w *Worker) isOptedOut(orgID uuid.UUID, phoneNumber string) bool {
+	if normalized, err := whatsapp.NormalizePhoneNumber(phoneNumber); err == nil {
+		phoneNumber = normalized
+	}
+	var count int64
+	w.DB.Model(&models.ContactOptOut{}).
+		Where("organization_id = ? AND phone_number = ?", orgID, phoneNumber).
+		Count(&count)
+	return count > 0
+}
+
 // getOrCreateContact finds or creates a contact for a phone number
 func (w *Worker) getOrCreateContact(orgID uuid.UUID, phoneNumber, name string) (*models.Contact, error) {
-	// Normalize phone number (remove + prefix if present)
-	normalizedPhone := phoneNumber
-	if len(normalizedPhone) > 0 && normalizedPhone[0] == '+' {
-		normalizedPhone = normalizedPhone[1:]
+	normalizedPhone, err := whatsapp.NormalizePhoneNumber(phoneNumber)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, phoneNumber)
 	}
 
-	// Try to find existing contact
+	// Try to find existing contact (including deleted ones, which are restored)
 	var contact models.Contact
-	err := w.DB.Where("organization_id = ? AND phone_number = ?", orgID, normalizedPhone).First(&contact).Error
-	if err == nil {
-		return &contact, nil
-	}
-
-	// Also try with + prefix
-	err = w.DB.Where("organization_id = ? AND phone_number = ?", orgID, "+"+normalizedPhone).First(&contact).Error
+	err = w.DB.Unscoped().Where("organization_id = ? AND phone_number = ?", orgID, normalizedPhone).First(&contact).Error
 	if err == nil {
+		if contact.DeletedAt.Valid {
+			if err := w.DB.Unscoped().Model(&contact).Update("deleted_at", nil).Error; err != nil {
+				return nil, fmt.Errorf("failed to restore contact: %w", err)
+			}
+		}
 		return &contact, nil
 	}
 
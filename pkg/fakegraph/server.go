@@ -0,0 +1,614 @@
+// Package fakegraph is an in-memory stand-in for the parts of the Meta Graph (WhatsApp
+// Cloud) API that Whatomate uses: sending messages, uploading and downloading media,
+// message templates and flows. It records what was sent, can be told to fail sends to
+// particular recipients, and can deliver webhooks back to the application.
+//
+// Use it from tests through net/http/httptest:
+//
+//	fake := fakegraph.New(fakegraph.Options{})
+//	srv := httptest.NewServer(fake)
+//	client.BaseURL = srv.URL
+//
+// or run it locally with cmd/fakegraph and set whatsapp.base_url to its address.
+package fakegraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
+)
+
+// Options configures the fake server
+type Options struct {
+	// AccessToken, if set, must be sent as the bearer token of every Graph request
+	AccessToken string
+	// WebhookURL receives webhooks from SendWebhook and the helpers built on it
+	WebhookURL string
+	// AppSecret signs delivered webhooks (X-Hub-Signature-256); unsigned if empty
+	AppSecret string
+	// AutoStatuses delivers "sent" and "delivered" status webhooks after each sent message
+	AutoStatuses bool
+	// TemplateStatus is the status given to submitted templates; defaults to APPROVED
+	TemplateStatus string
+}
+
+// Message is a message sent through the fake server
+type Message struct {
+	ID      string                 `json:"id"`
+	PhoneID string                 `json:"phone_id"`
+	To      string                 `json:"to"`
+	Type    string                 `json:"type"`
+	Payload map[string]interface{} `json:"payload"`
+	SentAt  time.Time              `json:"sent_at"`
+}
+
+// Media is an uploaded (or preloaded) media object
+type Media struct {
+	ID       string
+	MimeType string
+	Data     []byte
+}
+
+// Flow is a WhatsApp Flow held by the fake server
+type Flow struct {
+	ID         string
+	BusinessID string
+	Name       string
+	Status     string
+	Categories []string
+	JSON       []byte
+	// EndpointURI is the data endpoint set for the flow
+	EndpointURI string
+}
+
+// Server is the fake Graph API. It implements http.Handler.
+type Server struct {
+	opts   Options
+	client *http.Client
+
+	mu        sync.Mutex
+	seq       int
+	messages  []Message
+	media     map[string]*Media
+	templates map[string][]whatsapp.MetaTemplate // Business account ID -> templates
+	flows     map[string]*Flow
+	keys      map[string]string             // Phone number ID -> business public key (PEM)
+	failures  map[string]*whatsapp.APIError // Recipient -> error returned when sending to it
+}
+
+// New creates a fake Graph API server
+func New(opts Options) *Server {
+	if opts.TemplateStatus == "" {
+		opts.TemplateStatus = "APPROVED"
+	}
+	s := &Server{
+		opts:   opts,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	s.Reset()
+	return s
+}
+
+// Reset forgets all messages, media, templates, flows and injected failures
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+	s.media = make(map[string]*Media)
+	s.templates = make(map[string][]whatsapp.MetaTemplate)
+	s.flows = make(map[string]*Flow)
+	s.keys = make(map[string]string)
+	s.failures = make(map[string]*whatsapp.APIError)
+}
+
+// Messages returns the messages sent so far, oldest first
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// FailRecipient makes sends to the phone number fail with the given Graph API error
+// until ClearFailures or Reset is called
+func (s *Server) FailRecipient(phone string, code, subcode int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[phone] = &whatsapp.APIError{StatusCode: http.StatusBadRequest, Code: code, Subcode: subcode, Message: message}
+}
+
+// ClearFailures removes all injected send failures
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = make(map[string]*whatsapp.APIError)
+}
+
+// AddTemplate adds a template to a business account, as if created in WhatsApp Manager
+func (s *Server) AddTemplate(businessID string, t whatsapp.MetaTemplate) whatsapp.MetaTemplate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.ID == "" {
+		t.ID = s.nextID()
+	}
+	if t.Status == "" {
+		t.Status = s.opts.TemplateStatus
+	}
+	s.templates[businessID] = append(s.templates[businessID], t)
+	return t
+}
+
+// AddMedia stores media as if uploaded to or received by the business and returns its ID
+func (s *Server) AddMedia(data []byte, mimeType string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextID()
+	s.media[id] = &Media{ID: id, MimeType: mimeType, Data: data}
+	return id
+}
+
+// Media returns an uploaded media object
+func (s *Server) Media(id string) (*Media, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.media[id]
+	return m, ok
+}
+
+// Flow returns a flow by ID
+func (s *Server) Flow(id string) (*Flow, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.flows[id]
+	return f, ok
+}
+
+// BusinessPublicKey returns the public key uploaded for a phone number's flow endpoints
+func (s *Server) BusinessPublicKey(phoneID string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[phoneID]
+	return key, ok
+}
+
+// nextID returns a new numeric ID, like Graph object IDs. Callers hold mu.
+func (s *Server) nextID() string {
+	s.seq++
+	return strconv.Itoa(1000000000 + s.seq)
+}
+
+// ServeHTTP routes Graph API requests ("/<version>/<id>[/<edge>]"), media and flow JSON
+// downloads, and the /_fakegraph control endpoints
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	if parts[0] == "_fakegraph" {
+		s.serveControl(w, r, parts[1:])
+		return
+	}
+
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, 190, 0, "Invalid OAuth access token.")
+		return
+	}
+
+	switch {
+	case len(parts) == 3 && parts[0] == "download" && parts[1] == "media":
+		s.downloadMedia(w, parts[2])
+		return
+	case len(parts) == 3 && parts[0] == "download" && parts[1] == "flows":
+		s.downloadFlowJSON(w, parts[2])
+		return
+	}
+
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "v") {
+		writeError(w, http.StatusNotFound, 100, 0, "Unknown path components: "+r.URL.Path)
+		return
+	}
+	id, edge := parts[1], ""
+	if len(parts) > 2 {
+		edge = parts[2]
+	}
+
+	switch {
+	case edge == "messages" && r.Method == http.MethodPost:
+		s.sendMessage(w, r, id)
+	case edge == "media" && r.Method == http.MethodPost:
+		s.uploadMedia(w, r)
+	case edge == "message_templates":
+		s.serveTemplates(w, r, id)
+	case edge == "flows":
+		s.serveFlows(w, r, id)
+	case edge == "assets":
+		s.serveFlowAssets(w, r, id)
+	case edge == "publish" && r.Method == http.MethodPost:
+		s.setFlowStatus(w, id, "PUBLISHED")
+	case edge == "deprecate" && r.Method == http.MethodPost:
+		s.setFlowStatus(w, id, "DEPRECATED")
+	case edge == "whatsapp_business_encryption" && r.Method == http.MethodPost:
+		s.setBusinessPublicKey(w, r, id)
+	case edge == "" && r.Method == http.MethodPost:
+		s.updateFlow(w, r, id)
+	case edge == "" && r.Method == http.MethodGet:
+		s.getObject(w, r, id)
+	case edge == "" && r.Method == http.MethodDelete:
+		s.deleteFlow(w, id)
+	default:
+		writeError(w, http.StatusBadRequest, 100, 0, fmt.Sprintf("Unsupported %s request", r.Method))
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return false
+	}
+	return s.opts.AccessToken == "" || token == s.opts.AccessToken
+}
+
+// sendMessage records an outgoing message, or acknowledges a read receipt
+func (s *Server) sendMessage(w http.ResponseWriter, r *http.Request, phoneID string) {
+	var payload map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, 100, 0, "Invalid JSON body")
+		return
+	}
+	if payload["status"] == "read" {
+		writeJSON(w, map[string]interface{}{"success": true})
+		return
+	}
+
+	to, _ := payload["to"].(string)
+	msgType, _ := payload["type"].(string)
+	if to == "" {
+		writeError(w, http.StatusBadRequest, 100, 0, "(#100) The parameter to is required.")
+		return
+	}
+	if msgType == "" {
+		msgType = "text"
+	}
+	if _, ok := payload[msgType]; !ok {
+		writeError(w, http.StatusBadRequest, 100, 0, fmt.Sprintf("(#100) The parameter %s is required.", msgType))
+		return
+	}
+
+	s.mu.Lock()
+	if apiErr, ok := s.failures[to]; ok {
+		s.mu.Unlock()
+		writeError(w, apiErr.StatusCode, apiErr.Code, apiErr.Subcode, apiErr.Message)
+		return
+	}
+	msg := Message{
+		ID:      "wamid.FAKE" + s.nextID(),
+		PhoneID: phoneID,
+		To:      to,
+		Type:    msgType,
+		Payload: payload,
+		SentAt:  time.Now(),
+	}
+	s.messages = append(s.messages, msg)
+	s.mu.Unlock()
+
+	if s.opts.AutoStatuses && s.opts.WebhookURL != "" {
+		go func() {
+			for _, status := range []string{"sent", "delivered"} {
+				_ = s.SendStatus(phoneID, msg.ID, to, status)
+			}
+		}()
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"messaging_product": "whatsapp",
+		"contacts":          []map[string]string{{"input": to, "wa_id": to}},
+		"messages":          []map[string]string{{"id": msg.ID}},
+	})
+}
+
+func (s *Server) uploadMedia(w http.ResponseWriter, r *http.Request) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 100, 0, "(#100) The parameter file is required.")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 100, 0, "Failed to read file")
+		return
+	}
+	writeJSON(w, map[string]string{"id": s.AddMedia(data, header.Header.Get("Content-Type"))})
+}
+
+func (s *Server) downloadMedia(w http.ResponseWriter, id string) {
+	m, ok := s.Media(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, 100, 33, "Media not found")
+		return
+	}
+	w.Header().Set("Content-Type", m.MimeType)
+	w.Write(m.Data)
+}
+
+// getObject returns a media object's download details, a flow, or phone number details
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, id string) {
+	if m, ok := s.Media(id); ok {
+		writeJSON(w, map[string]interface{}{
+			"messaging_product": "whatsapp",
+			"id":                m.ID,
+			"url":               baseURL(r) + "/download/media/" + m.ID,
+			"mime_type":         m.MimeType,
+			"file_size":         len(m.Data),
+		})
+		return
+	}
+	if f, ok := s.Flow(id); ok {
+		writeJSON(w, flowResponse(r, f))
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"id":                   id,
+		"display_phone_number": "+1 555 000 " + lastDigits(id, 4),
+		"verified_name":        "Fake Business",
+		"quality_rating":       "GREEN",
+		"messaging_limit_tier": "TIER_1K",
+	})
+}
+
+func (s *Server) serveTemplates(w http.ResponseWriter, r *http.Request, businessID string) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		data := append([]whatsapp.MetaTemplate{}, s.templates[businessID]...)
+		s.mu.Unlock()
+		writeJSON(w, map[string]interface{}{"data": data})
+
+	case http.MethodPost:
+		var t whatsapp.MetaTemplate
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil || t.Name == "" || t.Language == "" {
+			writeError(w, http.StatusBadRequest, 100, 0, "(#100) Invalid parameter")
+			return
+		}
+		s.mu.Lock()
+		for _, existing := range s.templates[businessID] {
+			if existing.Name == t.Name && existing.Language == t.Language {
+				s.mu.Unlock()
+				writeError(w, http.StatusBadRequest, 100, 2388024, "Content in this language already exists")
+				return
+			}
+		}
+		s.mu.Unlock()
+		t = s.AddTemplate(businessID, whatsapp.MetaTemplate{
+			Name:       t.Name,
+			Language:   t.Language,
+			Category:   t.Category,
+			Components: t.Components,
+		})
+		writeJSON(w, map[string]string{"id": t.ID, "status": t.Status, "category": t.Category})
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		s.mu.Lock()
+		kept := s.templates[businessID][:0]
+		deleted := false
+		for _, t := range s.templates[businessID] {
+			if t.Name == name {
+				deleted = true
+				continue
+			}
+			kept = append(kept, t)
+		}
+		s.templates[businessID] = kept
+		s.mu.Unlock()
+		if !deleted {
+			writeError(w, http.StatusBadRequest, 100, 2593002, "Message template not found")
+			return
+		}
+		writeJSON(w, map[string]bool{"success": true})
+
+	default:
+		writeError(w, http.StatusBadRequest, 100, 0, "Unsupported request")
+	}
+}
+
+func (s *Server) serveFlows(w http.ResponseWriter, r *http.Request, businessID string) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		var data []map[string]interface{}
+		for _, f := range s.flows {
+			if f.BusinessID == businessID {
+				data = append(data, flowResponse(r, f))
+			}
+		}
+		s.mu.Unlock()
+		sort.Slice(data, func(i, j int) bool { return data[i]["id"].(string) < data[j]["id"].(string) })
+		writeJSON(w, map[string]interface{}{"data": data})
+
+	case http.MethodPost:
+		var req whatsapp.FlowCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			writeError(w, http.StatusBadRequest, 100, 0, "(#100) The parameter name is required.")
+			return
+		}
+		s.mu.Lock()
+		f := &Flow{ID: s.nextID(), BusinessID: businessID, Name: req.Name, Status: "DRAFT", Categories: req.Categories}
+		s.flows[f.ID] = f
+		s.mu.Unlock()
+		writeJSON(w, map[string]string{"id": f.ID})
+
+	default:
+		writeError(w, http.StatusBadRequest, 100, 0, "Unsupported request")
+	}
+}
+
+func (s *Server) serveFlowAssets(w http.ResponseWriter, r *http.Request, flowID string) {
+	if _, ok := s.Flow(flowID); !ok {
+		writeError(w, http.StatusNotFound, 100, 33, "Flow not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		f, _ := s.Flow(flowID)
+		var data []map[string]string
+		if f.JSON != nil {
+			data = append(data, map[string]string{
+				"name":         "flow.json",
+				"asset_type":   "FLOW_JSON",
+				"download_url": baseURL(r) + "/download/flows/" + flowID,
+			})
+		}
+		writeJSON(w, map[string]interface{}{"data": data})
+
+	case http.MethodPost:
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, 100, 0, "(#100) The parameter file is required.")
+			return
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil || !json.Valid(data) {
+			writeJSON(w, map[string]interface{}{"success": false, "validation_errors": []string{"INVALID_JSON"}})
+			return
+		}
+		s.mu.Lock()
+		s.flows[flowID].JSON = data
+		s.mu.Unlock()
+		writeJSON(w, map[string]interface{}{"success": true, "validation_errors": []string{}})
+
+	default:
+		writeError(w, http.StatusBadRequest, 100, 0, "Unsupported request")
+	}
+}
+
+func (s *Server) downloadFlowJSON(w http.ResponseWriter, flowID string) {
+	f, ok := s.Flow(flowID)
+	if !ok || f.JSON == nil {
+		writeError(w, http.StatusNotFound, 100, 33, "Flow JSON not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(f.JSON)
+}
+
+func (s *Server) setFlowStatus(w http.ResponseWriter, flowID, status string) {
+	s.mu.Lock()
+	f, ok := s.flows[flowID]
+	if ok {
+		f.Status = status
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, 100, 33, "Flow not found")
+		return
+	}
+	writeJSON(w, map[string]bool{"success": true})
+}
+
+func (s *Server) setBusinessPublicKey(w http.ResponseWriter, r *http.Request, phoneID string) {
+	key := r.FormValue("business_public_key")
+	if !strings.Contains(key, "PUBLIC KEY") {
+		writeError(w, http.StatusBadRequest, 100, 0, "(#100) The parameter business_public_key is required.")
+		return
+	}
+	s.mu.Lock()
+	s.keys[phoneID] = key
+	s.mu.Unlock()
+	writeJSON(w, map[string]bool{"success": true})
+}
+
+// updateFlow updates a flow's metadata; only endpoint_uri is supported
+func (s *Server) updateFlow(w http.ResponseWriter, r *http.Request, flowID string) {
+	var req struct {
+		EndpointURI string `json:"endpoint_uri"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, 100, 0, "(#100) Invalid parameter")
+		return
+	}
+	s.mu.Lock()
+	f, ok := s.flows[flowID]
+	if ok {
+		f.EndpointURI = req.EndpointURI
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, 100, 33, "Flow not found")
+		return
+	}
+	writeJSON(w, map[string]bool{"success": true})
+}
+
+func (s *Server) deleteFlow(w http.ResponseWriter, flowID string) {
+	s.mu.Lock()
+	f, ok := s.flows[flowID]
+	status := ""
+	if ok {
+		status = f.Status
+		if status == "DRAFT" {
+			delete(s.flows, flowID)
+		}
+	}
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		writeError(w, http.StatusNotFound, 100, 33, "Flow not found")
+	case status != "DRAFT":
+		writeError(w, http.StatusBadRequest, 139001, 0, "Only draft flows can be deleted")
+	default:
+		writeJSON(w, map[string]bool{"success": true})
+	}
+}
+
+func flowResponse(r *http.Request, f *Flow) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          f.ID,
+		"name":        f.Name,
+		"status":      f.Status,
+		"categories":  f.Categories,
+		"preview_url": baseURL(r) + "/download/flows/" + f.ID,
+	}
+}
+
+// baseURL returns the URL the request reached the server at
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func lastDigits(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the Graph API's format
+func writeError(w http.ResponseWriter, status, code, subcode int, message string) {
+	var body whatsapp.MetaAPIError
+	body.Error.Message = message
+	body.Error.Type = "OAuthException"
+	body.Error.Code = code
+	body.Error.ErrorSubcode = subcode
+	body.Error.FBTraceID = "fakegraph"
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,173 @@
+package fakegraph
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isaee-xyz/whatomate/pkg/whatsapp"
+)
+
+// SendWebhook delivers a webhook payload to Options.WebhookURL, signed with Options.AppSecret
+func (s *Server) SendWebhook(ctx context.Context, payload whatsapp.WebhookPayload) error {
+	if s.opts.WebhookURL == "" {
+		return errors.New("no webhook URL configured")
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.opts.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.opts.AppSecret != "" {
+		mac := hmac.New(sha256.New, []byte(s.opts.AppSecret))
+		mac.Write(body)
+		req.Header.Set(whatsapp.SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("webhook delivery returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SendInboundMessage delivers a webhook for a message from a customer to the business
+// phone number. msg.ID and msg.Timestamp are filled in if empty; the ID is returned.
+func (s *Server) SendInboundMessage(ctx context.Context, phoneID, profileName string, msg whatsapp.WebhookMessage) (string, error) {
+	if msg.ID == "" {
+		s.mu.Lock()
+		msg.ID = "wamid.FAKEIN" + s.nextID()
+		s.mu.Unlock()
+	}
+	if msg.Timestamp == "" {
+		msg.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+	}
+
+	contact := whatsapp.WebhookContact{WaID: msg.From}
+	contact.Profile.Name = profileName
+
+	return msg.ID, s.SendWebhook(ctx, changePayload(phoneID, whatsapp.WebhookValue{
+		Contacts: []whatsapp.WebhookContact{contact},
+		Messages: []whatsapp.WebhookMessage{msg},
+	}))
+}
+
+// SendInboundText delivers a webhook for a text message from a customer
+func (s *Server) SendInboundText(ctx context.Context, phoneID, from, profileName, text string) (string, error) {
+	return s.SendInboundMessage(ctx, phoneID, profileName, whatsapp.WebhookMessage{
+		From: from,
+		Type: "text",
+		Text: &whatsapp.WebhookText{Body: text},
+	})
+}
+
+// SendStatus delivers a status webhook (sent, delivered, read or failed) for a sent message
+func (s *Server) SendStatus(phoneID, messageID, recipient, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.SendWebhook(ctx, changePayload(phoneID, whatsapp.WebhookValue{
+		Statuses: []whatsapp.WebhookStatus{{
+			ID:          messageID,
+			Status:      status,
+			Timestamp:   strconv.FormatInt(time.Now().Unix(), 10),
+			RecipientID: recipient,
+		}},
+	}))
+}
+
+// changePayload wraps a value in the envelope Meta uses for WhatsApp webhooks
+func changePayload(phoneID string, value whatsapp.WebhookValue) whatsapp.WebhookPayload {
+	value.MessagingProduct = "whatsapp"
+	value.Metadata = whatsapp.WebhookMetadata{
+		DisplayPhoneNumber: "1555000" + lastDigits(phoneID, 4),
+		PhoneNumberID:      phoneID,
+	}
+	return whatsapp.WebhookPayload{
+		Object: "whatsapp_business_account",
+		Entry: []whatsapp.WebhookEntry{{
+			ID:      "fake-waba",
+			Changes: []whatsapp.WebhookChange{{Field: "messages", Value: value}},
+		}},
+	}
+}
+
+// serveControl handles the /_fakegraph endpoints used to drive the server when it runs
+// as a separate process:
+//
+//	GET  /_fakegraph/messages  sent messages
+//	POST /_fakegraph/inbound   {"phone_id", "from", "name", "text"} delivers an inbound text
+//	POST /_fakegraph/status    {"phone_id", "message_id", "recipient", "status"} delivers a status
+//	POST /_fakegraph/fail      {"recipient", "code", "subcode", "message"} fails sends to recipient
+//	POST /_fakegraph/reset     forgets everything
+func (s *Server) serveControl(w http.ResponseWriter, r *http.Request, parts []string) {
+	action := strings.Join(parts, "/")
+	if action == "messages" && r.Method == http.MethodGet {
+		writeJSON(w, map[string]interface{}{"data": s.Messages()})
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusNotFound, 100, 0, "Unknown control endpoint")
+		return
+	}
+
+	var req struct {
+		PhoneID   string `json:"phone_id"`
+		From      string `json:"from"`
+		Name      string `json:"name"`
+		Text      string `json:"text"`
+		MessageID string `json:"message_id"`
+		Recipient string `json:"recipient"`
+		Status    string `json:"status"`
+		Code      int    `json:"code"`
+		Subcode   int    `json:"subcode"`
+		Message   string `json:"message"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, 100, 0, "Invalid JSON body")
+			return
+		}
+	}
+
+	switch action {
+	case "inbound":
+		id, err := s.SendInboundText(r.Context(), req.PhoneID, req.From, req.Name, req.Text)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, 1, 0, err.Error())
+			return
+		}
+		writeJSON(w, map[string]string{"id": id})
+	case "status":
+		if err := s.SendStatus(req.PhoneID, req.MessageID, req.Recipient, req.Status); err != nil {
+			writeError(w, http.StatusBadGateway, 1, 0, err.Error())
+			return
+		}
+		writeJSON(w, map[string]bool{"success": true})
+	case "fail":
+		s.FailRecipient(req.Recipient, req.Code, req.Subcode, req.Message)
+		writeJSON(w, map[string]bool{"success": true})
+	case "reset":
+		s.Reset()
+		writeJSON(w, map[string]bool{"success": true})
+	default:
+		writeError(w, http.StatusNotFound, 100, 0, "Unknown control endpoint")
+	}
+}
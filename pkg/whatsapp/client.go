@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/zerodha/logf"
@@ -15,14 +17,25 @@ import (
 const (
 	// DefaultTimeout for HTTP requests
 	DefaultTimeout = 30 * time.Second
-	// BaseURL for Meta Graph API
-	BaseURL = "https://graph.facebook.com"
+	// DefaultBaseURL for Meta Graph API
+	DefaultBaseURL = "https://graph.facebook.com"
+	// DefaultMaxRetries of a request after a transient failure
+	DefaultMaxRetries = 2
+	// DefaultRetryBackoff before the first retry; it doubles on every further retry
+	DefaultRetryBackoff = 500 * time.Millisecond
 )
 
 // Client is the WhatsApp Cloud API client
 type Client struct {
 	HTTPClient *http.Client
 	Log        logf.Logger
+	// BaseURL of the Graph API, e.g. a mock server's URL. It applies to every account the
+	// client sends for; point a separate client at another server to use it.
+	BaseURL string
+	// MaxRetries of a GET or DELETE that failed with a transient error (see isTransient); 0 disables retries
+	MaxRetries int
+	// RetryBackoff before the first retry
+	RetryBackoff time.Duration
 }
 
 // New creates a new WhatsApp client
@@ -31,7 +44,10 @@ func New(log logf.Logger) *Client {
 		HTTPClient: &http.Client{
 			Timeout: DefaultTimeout,
 		},
-		Log: log,
+		Log:          log,
+		BaseURL:      DefaultBaseURL,
+		MaxRetries:   DefaultMaxRetries,
+		RetryBackoff: DefaultRetryBackoff,
 	}
 }
 
@@ -41,19 +57,64 @@ func NewWithTimeout(log logf.Logger, timeout time.Duration) *Client {
 		HTTPClient: &http.Client{
 			Timeout: timeout,
 		},
-		Log: log,
+		Log:          log,
+		BaseURL:      DefaultBaseURL,
+		MaxRetries:   DefaultMaxRetries,
+		RetryBackoff: DefaultRetryBackoff,
 	}
 }
 
-// doRequest performs an HTTP request to the Meta API
+// GraphURL returns the versioned Graph API URL for path (e.g. "<phone id>/messages")
+// under the client's base URL
+func (c *Client) GraphURL(account *Account, path string) string {
+	base := c.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	return fmt.Sprintf("%s/%s/%s", strings.TrimRight(base, "/"), account.APIVersion, path)
+}
+
+// doRequest performs a JSON request to the Meta API
 func (c *Client) doRequest(ctx context.Context, method, url string, body interface{}, accessToken string) ([]byte, error) {
-	var reqBody io.Reader
+	var jsonBody []byte
 	if body != nil {
-		jsonBody, err := json.Marshal(body)
+		var err error
+		jsonBody, err = json.Marshal(body)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
-		reqBody = bytes.NewBuffer(jsonBody)
+	}
+	return c.send(ctx, method, url, "application/json", jsonBody, accessToken)
+}
+
+// send performs a request to the Meta API, retrying transient failures with exponential
+// back-off. Error responses are returned as *APIError.
+func (c *Client) send(ctx context.Context, method, url, contentType string, body []byte, accessToken string) ([]byte, error) {
+	backoff := c.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		respBody, err := c.sendOnce(ctx, method, url, contentType, body, accessToken)
+		if err == nil || attempt >= c.MaxRetries || !isTransient(method, err) || ctx.Err() != nil {
+			return respBody, err
+		}
+
+		c.Log.Warn("Meta API request failed, retrying", "error", err, "url", url, "attempt", attempt+1, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) sendOnce(ctx context.Context, method, url, contentType string, body []byte, accessToken string) ([]byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
@@ -62,7 +123,9 @@ func (c *Client) doRequest(ctx context.Context, method, url string, body interfa
 	}
 
 	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Content-Type", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
@@ -75,25 +138,37 @@ func (c *Client) doRequest(ctx context.Context, method, url string, body interfa
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		var apiErr MetaAPIError
-		if err := json.Unmarshal(respBody, &apiErr); err == nil && apiErr.Error.Message != "" {
-			return nil, fmt.Errorf("API error %d: %s", apiErr.Error.Code, apiErr.Error.Message)
-		}
-		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(respBody))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, parseAPIError(resp.StatusCode, respBody)
 	}
 
 	return respBody, nil
 }
 
+// isTransient reports whether a failed request is retried inside the client. Only
+// idempotent methods are retried: a POST that failed with a 5xx or a timeout may still
+// have been delivered, so sends are left to the worker and the campaign retry path,
+// which record the failure instead of risking a duplicate message. Throttling is left
+// to the caller, which knows how to back off for the whole account.
+func isTransient(method string, err error) bool {
+	if method != http.MethodGet && method != http.MethodDelete {
+		return false
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	return apiErr.Class() == ErrorClassRetryable && !IsThrottlingError(apiErr)
+}
+
 // buildMessagesURL builds the messages endpoint URL
 func (c *Client) buildMessagesURL(account *Account) string {
-	return fmt.Sprintf("%s/%s/%s/messages", BaseURL, account.APIVersion, account.PhoneID)
+	return c.GraphURL(account, account.PhoneID+"/messages")
 }
 
 // buildTemplatesURL builds the message_templates endpoint URL
 func (c *Client) buildTemplatesURL(account *Account) string {
-	return fmt.Sprintf("%s/%s/%s/message_templates", BaseURL, account.APIVersion, account.BusinessID)
+	return c.GraphURL(account, account.BusinessID+"/message_templates")
 }
 
 // MediaURLResponse represents the response from Meta's media endpoint
@@ -107,7 +182,7 @@ type MediaURLResponse struct {
 
 // GetMediaURL retrieves the download URL for a media file from Meta's API
 func (c *Client) GetMediaURL(ctx context.Context, mediaID string, account *Account) (string, error) {
-	url := fmt.Sprintf("%s/%s/%s", BaseURL, account.APIVersion, mediaID)
+	url := c.GraphURL(account, mediaID)
 
 	respBody, err := c.doRequest(ctx, http.MethodGet, url, nil, account.AccessToken)
 	if err != nil {
@@ -128,29 +203,11 @@ func (c *Client) GetMediaURL(ctx context.Context, mediaID string, account *Accou
 
 // DownloadMedia downloads media content from Meta's CDN URL
 func (c *Client) DownloadMedia(ctx context.Context, mediaURL string, accessToken string) ([]byte, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mediaURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create download request: %w", err)
-	}
-
 	// Meta requires Bearer token for media download
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-
-	resp, err := c.HTTPClient.Do(req)
+	data, err := c.send(ctx, http.MethodGet, mediaURL, "", nil, accessToken)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download media: %w", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("media download failed with status %d", resp.StatusCode)
-	}
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read media content: %w", err)
-	}
-
 	return data, nil
 }
 
@@ -161,7 +218,7 @@ type UploadMediaResponse struct {
 
 // UploadMedia uploads media to WhatsApp's servers and returns the media ID
 func (c *Client) UploadMedia(ctx context.Context, account *Account, data []byte, mimeType, filename string) (string, error) {
-	url := fmt.Sprintf("%s/%s/%s/media", BaseURL, account.APIVersion, account.PhoneID)
+	url := c.GraphURL(account, account.PhoneID+"/media")
 
 	// Create multipart form body
 	body := &bytes.Buffer{}
@@ -180,28 +237,10 @@ func (c *Client) UploadMedia(ctx context.Context, account *Account, data []byte,
 
 	body.WriteString(fmt.Sprintf("--%s--\r\n", boundary))
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
-	if err != nil {
-		return "", fmt.Errorf("failed to create upload request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+account.AccessToken)
-	req.Header.Set("Content-Type", fmt.Sprintf("multipart/form-data; boundary=%s", boundary))
-
-	resp, err := c.HTTPClient.Do(req)
+	respBody, err := c.send(ctx, http.MethodPost, url, fmt.Sprintf("multipart/form-data; boundary=%s", boundary), body.Bytes(), account.AccessToken)
 	if err != nil {
 		return "", fmt.Errorf("failed to upload media: %w", err)
 	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read upload response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return "", fmt.Errorf("media upload failed with status %d: %s", resp.StatusCode, string(respBody))
-	}
 
 	var uploadResp UploadMediaResponse
 	if err := json.Unmarshal(respBody, &uploadResp); err != nil {
@@ -375,3 +414,20 @@ func (c *Client) MarkMessageRead(ctx context.Context, account *Account, messageI
 	c.Log.Debug("Read receipt sent", "message_id", messageID)
 	return nil
 }
+
+// GetPhoneNumber fetches the account's phone number details; it doubles as a check
+// that the access token works
+func (c *Client) GetPhoneNumber(ctx context.Context, account *Account) (*PhoneNumberInfo, error) {
+	url := c.GraphURL(account, account.PhoneID+"?fields=display_phone_number,verified_name,quality_rating,messaging_limit_tier")
+
+	respBody, err := c.doRequest(ctx, http.MethodGet, url, nil, account.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get phone number: %w", err)
+	}
+
+	var info PhoneNumberInfo
+	if err := json.Unmarshal(respBody, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse phone number response: %w", err)
+	}
+	return &info, nil
+}
@@ -0,0 +1,197 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zerodha/logf"
+)
+
+func TestAPIErrorClass(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        *APIError
+		class      ErrorClass
+		throttling bool
+	}{
+		{"expired token", &APIError{StatusCode: 401, Code: ErrCodeAccessToken}, ErrorClassAuth, false},
+		{"permission denied", &APIError{StatusCode: 403, Code: ErrCodePermissionDenied}, ErrorClassAuth, false},
+		{"permission code range", &APIError{StatusCode: 403, Code: 200}, ErrorClassAuth, false},
+		{"unauthorized without code", &APIError{StatusCode: 401}, ErrorClassAuth, false},
+		{"auth wins over transient", &APIError{StatusCode: 500, Code: ErrCodeAccessToken, IsTransient: true}, ErrorClassAuth, false},
+		{"throughput", &APIError{StatusCode: 400, Code: ErrCodeThroughputExceeded}, ErrorClassRetryable, true},
+		{"spam rate limit", &APIError{StatusCode: 400, Code: ErrCodeSpamRateLimit}, ErrorClassRetryable, true},
+		{"pair rate limit", &APIError{StatusCode: 400, Code: ErrCodePairRateLimit}, ErrorClassRetryable, true},
+		{"app rate limit", &APIError{StatusCode: 400, Code: ErrCodeAppRateLimit}, ErrorClassRetryable, true},
+		{"business rate limit", &APIError{StatusCode: 400, Code: ErrCodeBusinessRateLimit}, ErrorClassRetryable, true},
+		{"too many requests", &APIError{StatusCode: 429}, ErrorClassRetryable, true},
+		{"server error", &APIError{StatusCode: 503}, ErrorClassRetryable, false},
+		{"marked transient", &APIError{StatusCode: 400, Code: 100, IsTransient: true}, ErrorClassRetryable, false},
+		{"meta side failure", &APIError{StatusCode: 400, Code: ErrCodeGeneric}, ErrorClassRetryable, false},
+		{"service down", &APIError{StatusCode: 400, Code: ErrCodeServiceDown}, ErrorClassRetryable, false},
+		{"invalid parameter", &APIError{StatusCode: 400, Code: 100}, ErrorClassPermanent, false},
+		{"not a whatsapp user", &APIError{StatusCode: 400, Code: 131026}, ErrorClassPermanent, false},
+		{"bad request without code", &APIError{StatusCode: 400}, ErrorClassPermanent, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Class(); got != tt.class {
+				t.Errorf("Class = %s, want %s", got, tt.class)
+			}
+			wrapped := fmt.Errorf("failed to send message: %w", tt.err)
+			if got := ClassOf(wrapped); got != tt.class {
+				t.Errorf("ClassOf(wrapped) = %s, want %s", got, tt.class)
+			}
+			if got := IsThrottlingError(wrapped); got != tt.throttling {
+				t.Errorf("IsThrottlingError = %v, want %v", got, tt.throttling)
+			}
+		})
+	}
+}
+
+func TestClassOf(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		class ErrorClass
+	}{
+		{"nil", nil, ""},
+		{"network failure", errors.New("request failed: connection reset"), ErrorClassRetryable},
+		{"invalid message", fmt.Errorf("%w: text is empty", ErrInvalidMessage), ErrorClassPermanent},
+		{"missing parameters", fmt.Errorf("%w: {{1}}", ErrMissingTemplateParams), ErrorClassPermanent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassOf(tt.err); got != tt.class {
+				t.Errorf("ClassOf = %q, want %q", got, tt.class)
+			}
+		})
+	}
+	if IsThrottlingError(errors.New("timeout")) {
+		t.Error("IsThrottlingError of a network error = true")
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	network := errors.New("request failed: connection reset")
+	tests := []struct {
+		name   string
+		method string
+		err    error
+		want   bool
+	}{
+		{"GET network failure", http.MethodGet, network, true},
+		{"GET server error", http.MethodGet, &APIError{StatusCode: 500}, true},
+		{"DELETE server error", http.MethodDelete, &APIError{StatusCode: 502}, true},
+		{"GET meta side failure", http.MethodGet, &APIError{StatusCode: 400, Code: ErrCodeServerUnavailable}, true},
+		{"POST network failure", http.MethodPost, network, false},
+		{"POST server error", http.MethodPost, &APIError{StatusCode: 500}, false},
+		{"GET auth error", http.MethodGet, &APIError{StatusCode: 401, Code: ErrCodeAccessToken}, false},
+		{"GET throttled", http.MethodGet, &APIError{StatusCode: 429}, false},
+		{"GET rate limit code", http.MethodGet, &APIError{StatusCode: 400, Code: ErrCodeAppRateLimit}, false},
+		{"GET bad request", http.MethodGet, &APIError{StatusCode: 400, Code: 100}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.method, tt.err); got != tt.want {
+				t.Errorf("isTransient = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	const (
+		serverError = `{"error":{"message":"Service temporarily unavailable","code":2}}`
+		authError   = `{"error":{"message":"Error validating access token","type":"OAuthException","code":190}}`
+		throttled   = `{"error":{"message":"Rate limit hit","code":130429}}`
+	)
+
+	tests := []struct {
+		name     string
+		method   string
+		status   int
+		body     string
+		failures int // responses that fail before the server recovers; -1 to always fail
+		requests int32
+		class    ErrorClass // of the returned error; "" for success
+	}{
+		{"GET recovers from a 5xx", http.MethodGet, 503, serverError, 1, 2, ""},
+		{"GET gives up after MaxRetries", http.MethodGet, 500, serverError, -1, 3, ErrorClassRetryable},
+		{"POST is not retried", http.MethodPost, 503, serverError, 1, 1, ErrorClassRetryable},
+		{"auth error is not retried", http.MethodGet, 401, authError, 1, 1, ErrorClassAuth},
+		{"throttling is not retried", http.MethodGet, 429, throttled, 1, 1, ErrorClassRetryable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&requests, 1)
+				if r.Method != tt.method {
+					t.Errorf("method = %s, want %s", r.Method, tt.method)
+				}
+				if tt.failures < 0 || int(n) <= tt.failures {
+					w.WriteHeader(tt.status)
+					w.Write([]byte(tt.body))
+					return
+				}
+				w.Write([]byte(`{"id":"123","messages":[{"id":"wamid.1"}]}`))
+			}))
+			defer srv.Close()
+
+			c := New(logf.New(logf.Opts{Level: logf.ErrorLevel}))
+			c.BaseURL = srv.URL
+			c.RetryBackoff = time.Millisecond
+			account := &Account{PhoneID: "123", APIVersion: "v21.0", AccessToken: "token"}
+
+			var err error
+			if tt.method == http.MethodGet {
+				_, err = c.GetPhoneNumber(context.Background(), account)
+			} else {
+				_, err = c.SendTextMessage(context.Background(), account, "15550001111", "hello")
+			}
+
+			if got := atomic.LoadInt32(&requests); got != tt.requests {
+				t.Errorf("requests = %d, want %d", got, tt.requests)
+			}
+			if got := ClassOf(err); got != tt.class {
+				t.Errorf("error = %v (%q), want class %q", err, got, tt.class)
+			}
+			if tt.class == ErrorClassAuth && !IsAuthError(err) {
+				t.Errorf("IsAuthError(%v) = false", err)
+			}
+			if tt.status == 429 && !IsThrottlingError(err) {
+				t.Errorf("IsThrottlingError(%v) = false", err)
+			}
+		})
+	}
+}
+
+func TestClientRetriesDisabled(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := New(logf.New(logf.Opts{Level: logf.ErrorLevel}))
+	c.BaseURL = srv.URL
+	c.MaxRetries = 0
+	if _, err := c.GetPhoneNumber(context.Background(), &Account{PhoneID: "123", APIVersion: "v21.0"}); !IsRetryable(err) {
+		t.Errorf("error = %v, want a retryable error", err)
+	}
+	if requests := atomic.LoadInt32(&requests); requests != 1 {
+		t.Errorf("requests = %d, want 1 with retries disabled", requests)
+	}
+}
@@ -0,0 +1,164 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Meta error codes returned when a sender exceeds its throughput or pair rate limits
+const (
+	ErrCodeThroughputExceeded = 130429 // Cloud API messages-per-second limit reached
+	ErrCodeSpamRateLimit      = 131048 // Too many messages flagged; sending is restricted
+	ErrCodePairRateLimit      = 131056 // Too many messages to the same recipient in a short time
+)
+
+// Meta error codes used to classify errors
+const (
+	ErrCodeUnknown            = 1      // Unknown, possibly temporary error
+	ErrCodeServiceUnavailable = 2      // Service temporarily unavailable
+	ErrCodeAppRateLimit       = 4      // Application request limit reached
+	ErrCodePermissionDenied   = 10     // Permission not granted or removed
+	ErrCodeAccessToken        = 190    // Access token expired or invalid
+	ErrCodeBusinessRateLimit  = 80007  // WhatsApp Business Account rate limit reached
+	ErrCodeGeneric            = 131000 // Something went wrong on Meta's side
+	ErrCodeServiceDown        = 131016 // Service temporarily unavailable
+	ErrCodeServerUnavailable  = 133004 // Server temporarily unavailable
+)
+
+// ErrorClass tells callers how to react to a failed request
+type ErrorClass string
+
+const (
+	// ErrorClassRetryable errors are temporary: the same request may succeed later
+	ErrorClassRetryable ErrorClass = "retryable"
+	// ErrorClassPermanent errors will fail again for the same request (invalid number, bad template, ...)
+	ErrorClassPermanent ErrorClass = "permanent"
+	// ErrorClassAuth errors mean the account's access token or permissions need fixing
+	ErrorClassAuth ErrorClass = "auth"
+)
+
+// APIError is an error response from the Meta Graph API
+type APIError struct {
+	StatusCode  int
+	Code        int
+	Subcode     int
+	Type        string // e.g. OAuthException
+	Message     string
+	UserMessage string // error_user_msg, when Meta provides one
+	Details     string // error_data.details
+	FBTraceID   string // Quote this when contacting Meta support
+	IsTransient bool   // Meta's own hint that the request may be retried
+}
+
+func (e *APIError) Error() string {
+	if e.Code == 0 {
+		return fmt.Sprintf("API returned status %d: %s", e.StatusCode, e.Message)
+	}
+	msg := fmt.Sprintf("API error %d: %s", e.Code, e.Message)
+	if e.Details != "" {
+		msg += " (" + e.Details + ")"
+	}
+	return msg
+}
+
+// Class classifies the error as retryable, permanent or auth
+func (e *APIError) Class() ErrorClass {
+	switch {
+	case e.Code == ErrCodeAccessToken, e.Code == ErrCodePermissionDenied,
+		e.Code >= 200 && e.Code <= 299, e.StatusCode == http.StatusUnauthorized:
+		return ErrorClassAuth
+	case e.IsTransient, e.StatusCode == http.StatusTooManyRequests, e.StatusCode >= 500:
+		return ErrorClassRetryable
+	}
+	switch e.Code {
+	case ErrCodeThroughputExceeded, ErrCodeSpamRateLimit, ErrCodePairRateLimit,
+		ErrCodeAppRateLimit, ErrCodeBusinessRateLimit,
+		ErrCodeUnknown, ErrCodeServiceUnavailable, ErrCodeGeneric, ErrCodeServiceDown, ErrCodeServerUnavailable:
+		return ErrorClassRetryable
+	}
+	return ErrorClassPermanent
+}
+
+// ClassOf returns the class of err. Invalid messages are permanent; other errors that
+// are not API errors (network failures, timeouts) are retryable; nil has no class.
+func ClassOf(err error) ErrorClass {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, ErrInvalidMessage) || errors.Is(err, ErrMissingTemplateParams) {
+		return ErrorClassPermanent
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Class()
+	}
+	return ErrorClassRetryable
+}
+
+// ErrorCode returns the Meta error code of err, or 0 if err is not an API error
+func ErrorCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return 0
+}
+
+// IsRetryable reports whether the request that failed with err may succeed if repeated
+func IsRetryable(err error) bool {
+	return ClassOf(err) == ErrorClassRetryable
+}
+
+// IsPermanent reports whether the request that failed with err will keep failing
+func IsPermanent(err error) bool {
+	return ClassOf(err) == ErrorClassPermanent
+}
+
+// IsAuthError reports whether err means the account's credentials are invalid or lack permissions
+func IsAuthError(err error) bool {
+	return ClassOf(err) == ErrorClassAuth
+}
+
+// IsThrottlingError reports whether err is a Meta rate-limit error after which
+// the sender should back off before retrying
+func IsThrottlingError(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Code {
+	case ErrCodeThroughputExceeded, ErrCodeSpamRateLimit, ErrCodePairRateLimit, ErrCodeAppRateLimit, ErrCodeBusinessRateLimit:
+		return true
+	}
+	return apiErr.StatusCode == http.StatusTooManyRequests
+}
+
+// parseAPIError builds the error for a non-2xx response. Bodies that are not a Graph
+// API error still yield an *APIError so that they can be classified by status code.
+func parseAPIError(statusCode int, body []byte) *APIError {
+	var metaErr MetaAPIError
+	if err := json.Unmarshal(body, &metaErr); err == nil && metaErr.Error.Message != "" {
+		return &APIError{
+			StatusCode:  statusCode,
+			Code:        metaErr.Error.Code,
+			Subcode:     metaErr.Error.ErrorSubcode,
+			Type:        metaErr.Error.Type,
+			Message:     metaErr.Error.Message,
+			UserMessage: metaErr.Error.ErrorUserMsg,
+			Details:     metaErr.Error.ErrorData.Details,
+			FBTraceID:   metaErr.Error.FBTraceID,
+			IsTransient: metaErr.Error.IsTransient,
+		}
+	}
+
+	msg := string(body)
+	if len(msg) > 200 {
+		msg = msg[:200]
+	}
+	if msg == "" {
+		msg = http.StatusText(statusCode)
+	}
+	return &APIError{StatusCode: statusCode, Message: msg}
+}
@@ -5,7 +5,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
@@ -90,7 +89,7 @@ func (c *Client) CreateFlow(ctx context.Context, account *Account, name string,
 // UpdateFlowJSON updates the flow's JSON definition
 // This uses multipart form upload as required by Meta's API
 func (c *Client) UpdateFlowJSON(ctx context.Context, account *Account, flowID string, flowJSON *FlowJSON) error {
-	url := fmt.Sprintf("%s/%s/%s/assets", BaseURL, account.APIVersion, flowID)
+	url := c.GraphURL(account, flowID+"/assets")
 
 	// Convert flow JSON to bytes
 	jsonBytes, err := json.Marshal(flowJSON)
@@ -127,34 +126,11 @@ func (c *Client) UpdateFlowJSON(ctx context.Context, account *Account, flowID st
 
 	writer.Close()
 
-	// Create request
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &buf)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+account.AccessToken)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
 	c.Log.Info("Updating flow JSON", "flow_id", flowID)
 
-	resp, err := c.HTTPClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
+	respBody, err := c.send(ctx, http.MethodPost, url, writer.FormDataContentType(), buf.Bytes(), account.AccessToken)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		var apiErr MetaAPIError
-		if err := json.Unmarshal(respBody, &apiErr); err == nil && apiErr.Error.Message != "" {
-			return fmt.Errorf("API error %d: %s", apiErr.Error.Code, apiErr.Error.Message)
-		}
-		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(respBody))
+		return err
 	}
 
 	var result FlowUpdateResponse
@@ -175,7 +151,7 @@ func (c *Client) UpdateFlowJSON(ctx context.Context, account *Account, flowID st
 
 // PublishFlow publishes a draft flow
 func (c *Client) PublishFlow(ctx context.Context, account *Account, flowID string) error {
-	url := fmt.Sprintf("%s/%s/%s/publish", BaseURL, account.APIVersion, flowID)
+	url := c.GraphURL(account, flowID+"/publish")
 
 	c.Log.Info("Publishing flow", "flow_id", flowID)
 
@@ -200,7 +176,7 @@ func (c *Client) PublishFlow(ctx context.Context, account *Account, flowID strin
 
 // DeprecateFlow deprecates a published flow
 func (c *Client) DeprecateFlow(ctx context.Context, account *Account, flowID string) error {
-	url := fmt.Sprintf("%s/%s/%s/deprecate", BaseURL, account.APIVersion, flowID)
+	url := c.GraphURL(account, flowID+"/deprecate")
 
 	c.Log.Info("Deprecating flow", "flow_id", flowID)
 
@@ -225,7 +201,7 @@ func (c *Client) DeprecateFlow(ctx context.Context, account *Account, flowID str
 
 // DeleteFlow deletes a flow from Meta
 func (c *Client) DeleteFlow(ctx context.Context, account *Account, flowID string) error {
-	url := fmt.Sprintf("%s/%s/%s", BaseURL, account.APIVersion, flowID)
+	url := c.GraphURL(account, flowID)
 
 	c.Log.Info("Deleting flow from Meta", "flow_id", flowID)
 
@@ -241,7 +217,7 @@ func (c *Client) DeleteFlow(ctx context.Context, account *Account, flowID string
 
 // GetFlow fetches a single flow from Meta
 func (c *Client) GetFlow(ctx context.Context, account *Account, flowID string) (*FlowGetResponse, error) {
-	url := fmt.Sprintf("%s/%s/%s?fields=id,name,status,categories,preview.invalidate(false)", BaseURL, account.APIVersion, flowID)
+	url := c.GraphURL(account, flowID+"?fields=id,name,status,categories,preview.invalidate(false)")
 
 	respBody, err := c.doRequest(ctx, http.MethodGet, url, nil, account.AccessToken)
 	if err != nil {
@@ -269,7 +245,7 @@ type FlowAssetsResponse struct {
 // GetFlowAssets fetches the flow JSON assets from Meta
 func (c *Client) GetFlowAssets(ctx context.Context, account *Account, flowID string) (*FlowJSON, error) {
 	// First get the assets list to find the download URL
-	assetsURL := fmt.Sprintf("%s/%s/%s/assets", BaseURL, account.APIVersion, flowID)
+	assetsURL := c.GraphURL(account, flowID+"/assets")
 
 	c.Log.Info("Fetching flow assets", "flow_id", flowID, "url", assetsURL)
 
@@ -299,27 +275,10 @@ func (c *Client) GetFlowAssets(ctx context.Context, account *Account, flowID str
 	}
 
 	// Download the flow JSON
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create download request: %w", err)
-	}
-	req.Header.Set("Authorization", "Bearer "+account.AccessToken)
-
-	resp, err := c.HTTPClient.Do(req)
+	flowJSONBody, err := c.send(ctx, http.MethodGet, downloadURL, "", nil, account.AccessToken)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download flow JSON: %w", err)
 	}
-	defer resp.Body.Close()
-
-	flowJSONBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read flow JSON: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		c.Log.Error("Failed to download flow JSON", "status", resp.StatusCode, "body", string(flowJSONBody))
-		return nil, fmt.Errorf("failed to download flow JSON: status %d", resp.StatusCode)
-	}
 
 	var flowJSON FlowJSON
 	if err := json.Unmarshal(flowJSONBody, &flowJSON); err != nil {
@@ -351,5 +310,5 @@ func (c *Client) ListFlows(ctx context.Context, account *Account) ([]FlowGetResp
 
 // buildFlowsURL builds the flows endpoint URL
 func (c *Client) buildFlowsURL(account *Account) string {
-	return fmt.Sprintf("%s/%s/%s/flows", BaseURL, account.APIVersion, account.BusinessID)
+	return c.GraphURL(account, account.BusinessID+"/flows")
 }
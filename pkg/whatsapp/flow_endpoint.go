@@ -0,0 +1,230 @@
+package whatsapp
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Actions of WhatsApp Flows data-exchange requests
+const (
+	FlowActionInit         = "INIT"
+	FlowActionDataExchange = "data_exchange"
+	FlowActionBack         = "BACK"
+	FlowActionPing         = "ping"
+)
+
+// FlowEndpointKeyBits is the size of the RSA key pairs generated for flow endpoints
+const FlowEndpointKeyBits = 2048
+
+// ErrFlowDecryption is returned when a data-exchange request cannot be decrypted, e.g.
+// after the key pair was replaced. The endpoint answers it with HTTP 421 so WhatsApp
+// fetches the current public key and retries.
+var ErrFlowDecryption = errors.New("failed to decrypt flow request")
+
+// EncryptedFlowRequest is the body WhatsApp posts to a flow's data endpoint
+type EncryptedFlowRequest struct {
+	EncryptedFlowData string `json:"encrypted_flow_data"`
+	EncryptedAESKey   string `json:"encrypted_aes_key"`
+	InitialVector     string `json:"initial_vector"`
+}
+
+// FlowEndpointRequest is a decrypted data-exchange request
+type FlowEndpointRequest struct {
+	Version   string                 `json:"version"`
+	Action    string                 `json:"action"`
+	Screen    string                 `json:"screen,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	FlowToken string                 `json:"flow_token,omitempty"`
+}
+
+// IsErrorNotification reports whether the request notifies an error the client hit with
+// a previous response, which only needs to be acknowledged
+func (r *FlowEndpointRequest) IsErrorNotification() bool {
+	_, ok := r.Data["error"]
+	return ok && r.Action != FlowActionPing
+}
+
+// FlowEndpointResponse is the screen to show next and its data. Ping and error
+// notification responses only have data.
+type FlowEndpointResponse struct {
+	Screen string                 `json:"screen,omitempty"`
+	Data   map[string]interface{} `json:"data"`
+}
+
+// FlowCipher encrypts the response to a data-exchange request with the AES key the
+// request was encrypted with
+type FlowCipher struct {
+	key []byte
+	iv  []byte
+}
+
+// GenerateFlowKeyPair returns a new RSA key pair for a flow endpoint, PEM encoded
+func GenerateFlowKeyPair() (privateKeyPEM, publicKeyPEM string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, FlowEndpointKeyBits)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	privateKeyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	publicKeyPEM, err = encodePublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+	return privateKeyPEM, publicKeyPEM, nil
+}
+
+// FlowPublicKey returns the PEM encoded public key of a private key from GenerateFlowKeyPair
+func FlowPublicKey(privateKeyPEM string) (string, error) {
+	key, err := parsePrivateKey(privateKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	return encodePublicKey(&key.PublicKey)
+}
+
+// DecryptFlowRequest decrypts a data-exchange request: the AES key with the endpoint's RSA
+// private key (OAEP, SHA-256), then the flow data with AES-GCM. Returns the request and the
+// cipher for its response; errors wrap ErrFlowDecryption.
+func DecryptFlowRequest(body []byte, privateKeyPEM string) (*FlowEndpointRequest, *FlowCipher, error) {
+	var req EncryptedFlowRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid body: %v", ErrFlowDecryption, err)
+	}
+
+	key, err := parsePrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrFlowDecryption, err)
+	}
+	encryptedKey, err := base64.StdEncoding.DecodeString(req.EncryptedAESKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid encrypted_aes_key", ErrFlowDecryption)
+	}
+	data, err := base64.StdEncoding.DecodeString(req.EncryptedFlowData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid encrypted_flow_data", ErrFlowDecryption)
+	}
+	iv, err := base64.StdEncoding.DecodeString(req.InitialVector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid initial_vector", ErrFlowDecryption)
+	}
+
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, encryptedKey, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrFlowDecryption, err)
+	}
+	gcm, err := newFlowGCM(aesKey, len(iv))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrFlowDecryption, err)
+	}
+	plaintext, err := gcm.Open(nil, iv, data, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrFlowDecryption, err)
+	}
+
+	var decrypted FlowEndpointRequest
+	if err := json.Unmarshal(plaintext, &decrypted); err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid flow data: %v", ErrFlowDecryption, err)
+	}
+	return &decrypted, &FlowCipher{key: aesKey, iv: iv}, nil
+}
+
+// EncryptResponse encrypts a response with the request's AES key and the bit-flipped
+// initial vector, returning the base64 body to send back
+func (c *FlowCipher) EncryptResponse(v interface{}) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal response: %w", err)
+	}
+	iv := make([]byte, len(c.iv))
+	for i, b := range c.iv {
+		iv[i] = ^b
+	}
+	gcm, err := newFlowGCM(c.key, len(iv))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nil, iv, plaintext, nil)), nil
+}
+
+// SetBusinessPublicKey uploads the public key WhatsApp encrypts flow data-exchange
+// requests to the account's phone number with
+func (c *Client) SetBusinessPublicKey(ctx context.Context, account *Account, publicKeyPEM string) error {
+	endpoint := c.GraphURL(account, account.PhoneID+"/whatsapp_business_encryption")
+	form := url.Values{"business_public_key": {publicKeyPEM}}
+
+	c.Log.Info("Uploading flow endpoint public key", "phone_id", account.PhoneID)
+
+	if _, err := c.send(ctx, http.MethodPost, endpoint, "application/x-www-form-urlencoded", []byte(form.Encode()), account.AccessToken); err != nil {
+		c.Log.Error("Failed to upload flow endpoint public key", "error", err, "phone_id", account.PhoneID)
+		return err
+	}
+	return nil
+}
+
+// SetFlowEndpoint sets the URI of the endpoint that serves a flow's data-exchange requests
+func (c *Client) SetFlowEndpoint(ctx context.Context, account *Account, flowID, endpointURI string) error {
+	endpoint := c.GraphURL(account, flowID)
+
+	c.Log.Info("Setting flow endpoint", "flow_id", flowID, "endpoint_uri", endpointURI)
+
+	respBody, err := c.doRequest(ctx, http.MethodPost, endpoint, map[string]string{"endpoint_uri": endpointURI}, account.AccessToken)
+	if err != nil {
+		c.Log.Error("Failed to set flow endpoint", "error", err, "flow_id", flowID)
+		return err
+	}
+
+	var result FlowPublishResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("failed to set flow endpoint")
+	}
+	return nil
+}
+
+func newFlowGCM(key []byte, nonceSize int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, nonceSize)
+}
+
+func parsePrivateKey(privateKeyPEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return nil, errors.New("invalid private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+func encodePublicKey(key *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode public key: %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
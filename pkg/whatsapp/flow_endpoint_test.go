@@ -0,0 +1,199 @@
+package whatsapp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// encryptFlowRequest encrypts a request the way WhatsApp does: a random 128-bit AES key
+// and 16-byte IV for AES-GCM over the JSON, and the AES key with the endpoint's public key
+// (RSA-OAEP, SHA-256). It returns the body and the AES key and IV.
+func encryptFlowRequest(t *testing.T, publicKeyPEM string, req interface{}) ([]byte, []byte, []byte) {
+	t.Helper()
+
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		t.Fatal("invalid public key PEM")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aesKey := make([]byte, 16)
+	iv := make([]byte, 16)
+	if _, err := rand.Read(aesKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesBlock, err := aes.NewCipher(aesKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(aesBlock, len(iv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub.(*rsa.PublicKey), aesKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(EncryptedFlowRequest{
+		EncryptedFlowData: base64.StdEncoding.EncodeToString(gcm.Seal(nil, iv, plaintext, nil)),
+		EncryptedAESKey:   base64.StdEncoding.EncodeToString(encryptedKey),
+		InitialVector:     base64.StdEncoding.EncodeToString(iv),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body, aesKey, iv
+}
+
+func TestFlowEndpointRoundTrip(t *testing.T) {
+	privateKeyPEM, publicKeyPEM, err := GenerateFlowKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derived, err := FlowPublicKey(privateKeyPEM); err != nil || derived != publicKeyPEM {
+		t.Fatalf("FlowPublicKey = %q, %v; want the generated public key", derived, err)
+	}
+
+	want := FlowEndpointRequest{
+		Version:   "3.0",
+		Action:    FlowActionDataExchange,
+		Screen:    "APPOINTMENT",
+		Data:      map[string]interface{}{"department": "shopping", "date": "2024-01-01"},
+		FlowToken: "flow-token-1",
+	}
+	body, aesKey, iv := encryptFlowRequest(t, publicKeyPEM, want)
+
+	got, flowCipher, err := DecryptFlowRequest(body, privateKeyPEM)
+	if err != nil {
+		t.Fatalf("DecryptFlowRequest: %v", err)
+	}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("request = %+v, want %+v", *got, want)
+	}
+
+	resp := FlowEndpointResponse{Screen: "SUCCESS", Data: map[string]interface{}{"booked": true}}
+	encrypted, err := flowCipher.EncryptResponse(resp)
+	if err != nil {
+		t.Fatalf("EncryptResponse: %v", err)
+	}
+
+	// WhatsApp decrypts the response with its AES key and the bitwise inverse of its IV
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		t.Fatalf("response is not base64: %v", err)
+	}
+	flipped := make([]byte, len(iv))
+	for i, b := range iv {
+		flipped[i] = ^b
+	}
+	aesBlock, err := aes.NewCipher(aesKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(aesBlock, len(iv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gcm.Open(nil, iv, ciphertext, nil); err == nil {
+		t.Error("response decrypted with the request IV, want only the inverted IV to work")
+	}
+	plaintext, err := gcm.Open(nil, flipped, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("failed to decrypt response with the inverted IV: %v", err)
+	}
+	var gotResp FlowEndpointResponse
+	if err := json.Unmarshal(plaintext, &gotResp); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotResp, resp) {
+		t.Errorf("response = %+v, want %+v", gotResp, resp)
+	}
+}
+
+func TestFlowEndpointPKCS8Key(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, FlowEndpointKeyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	publicKeyPEM, err := FlowPublicKey(privateKeyPEM)
+	if err != nil {
+		t.Fatalf("FlowPublicKey: %v", err)
+	}
+
+	body, _, _ := encryptFlowRequest(t, publicKeyPEM, FlowEndpointRequest{Version: "3.0", Action: FlowActionPing})
+	req, _, err := DecryptFlowRequest(body, privateKeyPEM)
+	if err != nil {
+		t.Fatalf("DecryptFlowRequest: %v", err)
+	}
+	if req.Action != FlowActionPing {
+		t.Errorf("action = %q, want %q", req.Action, FlowActionPing)
+	}
+}
+
+func TestDecryptFlowRequestErrors(t *testing.T) {
+	privateKeyPEM, publicKeyPEM, err := GenerateFlowKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPrivateKeyPEM, _, err := GenerateFlowKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _, _ := encryptFlowRequest(t, publicKeyPEM, FlowEndpointRequest{Version: "3.0", Action: FlowActionInit})
+
+	var tampered EncryptedFlowRequest
+	if err := json.Unmarshal(body, &tampered); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(tampered.EncryptedFlowData)
+	data[0] ^= 1
+	tampered.EncryptedFlowData = base64.StdEncoding.EncodeToString(data)
+	tamperedBody, _ := json.Marshal(tampered)
+
+	tests := []struct {
+		name          string
+		body          []byte
+		privateKeyPEM string
+	}{
+		{"replaced key pair", body, otherPrivateKeyPEM},
+		{"tampered flow data", tamperedBody, privateKeyPEM},
+		{"invalid body", []byte("not json"), privateKeyPEM},
+		{"invalid base64", []byte(`{"encrypted_flow_data":"!","encrypted_aes_key":"!","initial_vector":"!"}`), privateKeyPEM},
+		{"invalid private key", body, "not a key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := DecryptFlowRequest(tt.body, tt.privateKeyPEM); !errors.Is(err, ErrFlowDecryption) {
+				t.Errorf("error = %v, want ErrFlowDecryption", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidFlowResponse is returned (wrapped) when a flow response does not match
+// the fields the flow completes with
+var ErrInvalidFlowResponse = errors.New("invalid flow response")
+
+// Types of flow response fields
+const (
+	FlowFieldString  = "string"
+	FlowFieldNumber  = "number"
+	FlowFieldBoolean = "boolean"
+	FlowFieldArray   = "array"
+)
+
+// formRefPattern matches a payload value taken from a form component, e.g. "${form.email}"
+var formRefPattern = regexp.MustCompile(`^\$\{form\.([A-Za-z0-9_]+)\}$`)
+
+// FlowResponseField is a field a flow sends back when it completes
+type FlowResponseField struct {
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"` // Empty when the value can be anything
+	Required bool   `json:"required"`
+}
+
+// FlowCompletion is a "complete" action of a flow and the fields its payload sends
+type FlowCompletion struct {
+	Screen string              `json:"screen"`
+	Fields []FlowResponseField `json:"fields"`
+}
+
+// ParseFlowResponse decodes the response_json of a flow reply
+func ParseFlowResponse(responseJSON string) (map[string]interface{}, error) {
+	var response map[string]interface{}
+	if err := json.Unmarshal([]byte(responseJSON), &response); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFlowResponse, err)
+	}
+	if response == nil {
+		response = map[string]interface{}{}
+	}
+	return response, nil
+}
+
+// FlowCompletions returns the "complete" actions found in a flow's screens. Payload values
+// taken from a form component ("${form.name}") get its type and required flag.
+func FlowCompletions(screens []interface{}) []FlowCompletion {
+	var completions []FlowCompletion
+	for _, s := range screens {
+		screen, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		screenID, _ := screen["id"].(string)
+
+		components := map[string]map[string]interface{}{}
+		var payloads []map[string]interface{}
+		walkFlowComponents(screen["layout"], func(c map[string]interface{}) {
+			if name, ok := c["name"].(string); ok && name != "" {
+				components[name] = c
+			}
+			if action, ok := c["on-click-action"].(map[string]interface{}); ok && action["name"] == "complete" {
+				payload, _ := action["payload"].(map[string]interface{})
+				payloads = append(payloads, payload)
+			}
+		})
+
+		for _, payload := range payloads {
+			completion := FlowCompletion{Screen: screenID}
+			for key, value := range payload {
+				field := FlowResponseField{Name: key}
+				if ref, ok := value.(string); ok {
+					if m := formRefPattern.FindStringSubmatch(ref); m != nil {
+						if c, ok := components[m[1]]; ok {
+							field.Type = flowComponentType(c)
+							field.Required, _ = c["required"].(bool)
+						}
+					}
+				}
+				completion.Fields = append(completion.Fields, field)
+			}
+			completions = append(completions, completion)
+		}
+	}
+	return completions
+}
+
+// ValidateFlowResponse checks a flow response against the flow's completions: it is valid
+// if it has the required fields of one of them, with values of the right type. Any
+// response is valid for a flow without completions (e.g. one whose screens are unknown).
+func ValidateFlowResponse(completions []FlowCompletion, response map[string]interface{}) error {
+	if len(completions) == 0 {
+		return nil
+	}
+	var firstErr error
+	for _, completion := range completions {
+		var problems []string
+		for _, field := range completion.Fields {
+			value, ok := response[field.Name]
+			if !ok || isEmptyFlowValue(value) {
+				if field.Required {
+					problems = append(problems, field.Name+" is required")
+				}
+				continue
+			}
+			if !flowValueHasType(value, field.Type) {
+				problems = append(problems, fmt.Sprintf("%s must be of type %s", field.Name, field.Type))
+			}
+		}
+		if len(problems) == 0 {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("%w: %s", ErrInvalidFlowResponse, strings.Join(problems, ", "))
+		}
+	}
+	return firstErr
+}
+
+// walkFlowComponents calls fn for every component in a screen layout, depth first
+func walkFlowComponents(v interface{}, fn func(map[string]interface{})) {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		fn(node)
+		walkFlowComponents(node["children"], fn)
+	case []interface{}:
+		for _, child := range node {
+			walkFlowComponents(child, fn)
+		}
+	}
+}
+
+// flowComponentType returns the type of the value a form component submits
+func flowComponentType(c map[string]interface{}) string {
+	componentType, _ := c["type"].(string)
+	switch componentType {
+	case "TextInput":
+		if inputType, _ := c["input-type"].(string); inputType == "number" {
+			return FlowFieldNumber
+		}
+		return FlowFieldString
+	case "TextArea", "DatePicker", "Dropdown", "RadioButtonsGroup":
+		return FlowFieldString
+	case "CheckboxGroup", "ChipsSelector", "PhotoPicker", "DocumentPicker":
+		return FlowFieldArray
+	case "OptIn":
+		return FlowFieldBoolean
+	}
+	return ""
+}
+
+func isEmptyFlowValue(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(val) == ""
+	case []interface{}:
+		return len(val) == 0
+	}
+	return false
+}
+
+func flowValueHasType(v interface{}, fieldType string) bool {
+	switch fieldType {
+	case FlowFieldString:
+		_, ok := v.(string)
+		return ok
+	case FlowFieldNumber:
+		switch val := v.(type) {
+		case float64:
+			return true
+		case string:
+			_, err := strconv.ParseFloat(val, 64)
+			return err == nil
+		}
+		return false
+	case FlowFieldBoolean:
+		_, ok := v.(bool)
+		return ok
+	case FlowFieldArray:
+		_, ok := v.([]interface{})
+		return ok
+	}
+	return true
+}
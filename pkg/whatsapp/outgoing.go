@@ -0,0 +1,526 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+)
+
+// ErrInvalidMessage is returned (wrapped) when a message breaks the Cloud API's rules,
+// before anything is sent
+var ErrInvalidMessage = errors.New("invalid message")
+
+// Cloud API limits for interactive messages
+const (
+	maxHeaderLength      = 60
+	maxFooterLength      = 60
+	maxInteractiveBody   = 1024
+	maxListBody          = 4096
+	maxActionButtonText  = 20
+	maxListSections      = 10
+	maxListRows          = 10
+	maxSectionTitle      = 24
+	maxRowTitle          = 24
+	maxRowDescription    = 72
+	maxRowID             = 200
+	maxReplyButtons      = 3
+	maxReplyButtonTitle  = 20
+	maxProductSections   = 10
+	maxProductListItems  = 30
+	maxTextMessageLength = 4096
+)
+
+// OutgoingMessage is a message that Client.Send can deliver
+type OutgoingMessage interface {
+	// Type is the Cloud API message type, e.g. "location" or "interactive"
+	Type() string
+	// Content checks the message and returns the object sent under the type's key
+	Content() (interface{}, error)
+}
+
+// Send sends msg to phoneNumber and returns the WhatsApp message ID. If replyTo is set,
+// the message quotes the message with that WhatsApp ID.
+func (c *Client) Send(ctx context.Context, account *Account, phoneNumber string, msg OutgoingMessage, replyTo string) (string, error) {
+	content, err := msg.Content()
+	if err != nil {
+		return "", err
+	}
+
+	payload := map[string]interface{}{
+		"messaging_product": "whatsapp",
+		"recipient_type":    "individual",
+		"to":                phoneNumber,
+		"type":              msg.Type(),
+		msg.Type():          content,
+	}
+	if replyTo != "" {
+		payload["context"] = map[string]interface{}{
+			"message_id": replyTo,
+		}
+	}
+
+	url := c.buildMessagesURL(account)
+	c.Log.Debug("Sending message", "phone", phoneNumber, "type", msg.Type())
+
+	respBody, err := c.doRequest(ctx, http.MethodPost, url, payload, account.AccessToken)
+	if err != nil {
+		c.Log.Error("Failed to send message", "error", err, "phone", phoneNumber, "type", msg.Type())
+		return "", fmt.Errorf("failed to send %s message: %w", msg.Type(), err)
+	}
+
+	var resp MetaAPIResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if len(resp.Messages) == 0 {
+		return "", fmt.Errorf("no message ID in response")
+	}
+
+	messageID := resp.Messages[0].ID
+	c.Log.Info("Message sent", "message_id", messageID, "phone", phoneNumber, "type", msg.Type())
+	return messageID, nil
+}
+
+// TextMessage is a plain text message
+type TextMessage struct {
+	Body       string `json:"body"`
+	PreviewURL bool   `json:"preview_url"`
+}
+
+func (m *TextMessage) Type() string { return "text" }
+
+func (m *TextMessage) Content() (interface{}, error) {
+	if err := checkText("body", m.Body, maxTextMessageLength, true); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"preview_url": m.PreviewURL,
+		"body":        m.Body,
+	}, nil
+}
+
+// LocationMessage shares a location pin
+type LocationMessage struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name,omitempty"`
+	Address   string  `json:"address,omitempty"`
+}
+
+func (m *LocationMessage) Type() string { return "location" }
+
+func (m *LocationMessage) Content() (interface{}, error) {
+	if m.Latitude < -90 || m.Latitude > 90 || m.Longitude < -180 || m.Longitude > 180 {
+		return nil, fmt.Errorf("%w: latitude must be within ±90 and longitude within ±180", ErrInvalidMessage)
+	}
+	if m.Latitude == 0 && m.Longitude == 0 {
+		return nil, fmt.Errorf("%w: latitude and longitude are required", ErrInvalidMessage)
+	}
+	return m, nil
+}
+
+// ContactCard is one contact of a contacts message
+type ContactCard struct {
+	Name     ContactName    `json:"name"`
+	Phones   []ContactPhone `json:"phones,omitempty"`
+	Emails   []ContactEmail `json:"emails,omitempty"`
+	Org      *ContactOrg    `json:"org,omitempty"`
+	URLs     []ContactURL   `json:"urls,omitempty"`
+	Birthday string         `json:"birthday,omitempty"` // YYYY-MM-DD
+}
+
+// ContactName is the name of a contact card; FormattedName is required
+type ContactName struct {
+	FormattedName string `json:"formatted_name"`
+	FirstName     string `json:"first_name,omitempty"`
+	LastName      string `json:"last_name,omitempty"`
+}
+
+// ContactPhone is a phone number of a contact card
+type ContactPhone struct {
+	Phone string `json:"phone"`
+	Type  string `json:"type,omitempty"` // CELL, MAIN, IPHONE, HOME, WORK
+	WaID  string `json:"wa_id,omitempty"`
+}
+
+// ContactEmail is an email address of a contact card
+type ContactEmail struct {
+	Email string `json:"email"`
+	Type  string `json:"type,omitempty"` // HOME, WORK
+}
+
+// ContactOrg is the organization of a contact card
+type ContactOrg struct {
+	Company    string `json:"company,omitempty"`
+	Department string `json:"department,omitempty"`
+	Title      string `json:"title,omitempty"`
+}
+
+// ContactURL is a website of a contact card
+type ContactURL struct {
+	URL  string `json:"url"`
+	Type string `json:"type,omitempty"` // HOME, WORK
+}
+
+// ContactsMessage shares one or more contact cards
+type ContactsMessage []ContactCard
+
+func (m ContactsMessage) Type() string { return "contacts" }
+
+func (m ContactsMessage) Content() (interface{}, error) {
+	if len(m) == 0 {
+		return nil, fmt.Errorf("%w: at least one contact is required", ErrInvalidMessage)
+	}
+	for i, card := range m {
+		if card.Name.FormattedName == "" {
+			return nil, fmt.Errorf("%w: contact %d needs name.formatted_name", ErrInvalidMessage, i+1)
+		}
+	}
+	return []ContactCard(m), nil
+}
+
+// StickerMessage sends a WebP sticker, either uploaded (MediaID) or hosted (Link)
+type StickerMessage struct {
+	MediaID string `json:"media_id,omitempty"`
+	Link    string `json:"link,omitempty"`
+}
+
+func (m *StickerMessage) Type() string { return "sticker" }
+
+func (m *StickerMessage) Content() (interface{}, error) {
+	switch {
+	case m.MediaID != "":
+		return map[string]interface{}{"id": m.MediaID}, nil
+	case m.Link != "":
+		return map[string]interface{}{"link": m.Link}, nil
+	}
+	return nil, fmt.Errorf("%w: sticker needs a media_id or link", ErrInvalidMessage)
+}
+
+// ButtonsMessage is an interactive message with up to three reply buttons
+type ButtonsMessage struct {
+	Header  string   `json:"header,omitempty"`
+	Body    string   `json:"body"`
+	Footer  string   `json:"footer,omitempty"`
+	Buttons []Button `json:"buttons"`
+}
+
+func (m *ButtonsMessage) Type() string { return "interactive" }
+
+func (m *ButtonsMessage) Content() (interface{}, error) {
+	if len(m.Buttons) == 0 || len(m.Buttons) > maxReplyButtons {
+		return nil, fmt.Errorf("%w: between 1 and %d buttons are required", ErrInvalidMessage, maxReplyButtons)
+	}
+	buttons := make([]map[string]interface{}, 0, len(m.Buttons))
+	for _, btn := range m.Buttons {
+		if err := checkText("button id", btn.ID, maxRowID, true); err != nil {
+			return nil, err
+		}
+		if err := checkText("button title", btn.Title, maxReplyButtonTitle, true); err != nil {
+			return nil, err
+		}
+		buttons = append(buttons, map[string]interface{}{
+			"type":  "reply",
+			"reply": map[string]interface{}{"id": btn.ID, "title": btn.Title},
+		})
+	}
+	return interactive("button", m.Header, m.Body, m.Footer, maxInteractiveBody, map[string]interface{}{
+		"buttons": buttons,
+	})
+}
+
+// ListRow is an option of a list message
+type ListRow struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// ListSection groups the rows of a list message
+type ListSection struct {
+	Title string    `json:"title,omitempty"`
+	Rows  []ListRow `json:"rows"`
+}
+
+// ListMessage is an interactive message whose options open in a list, grouped in sections
+type ListMessage struct {
+	Header     string        `json:"header,omitempty"`
+	Body       string        `json:"body"`
+	Footer     string        `json:"footer,omitempty"`
+	ButtonText string        `json:"button_text"` // Label of the button that opens the list
+	Sections   []ListSection `json:"sections"`
+}
+
+func (m *ListMessage) Type() string { return "interactive" }
+
+func (m *ListMessage) Content() (interface{}, error) {
+	if err := checkText("button_text", m.ButtonText, maxActionButtonText, true); err != nil {
+		return nil, err
+	}
+	if len(m.Sections) == 0 || len(m.Sections) > maxListSections {
+		return nil, fmt.Errorf("%w: between 1 and %d sections are required", ErrInvalidMessage, maxListSections)
+	}
+
+	rowCount := 0
+	ids := make(map[string]bool)
+	for i, section := range m.Sections {
+		if err := checkText("section title", section.Title, maxSectionTitle, len(m.Sections) > 1); err != nil {
+			return nil, err
+		}
+		if len(section.Rows) == 0 {
+			return nil, fmt.Errorf("%w: section %d has no rows", ErrInvalidMessage, i+1)
+		}
+		for _, row := range section.Rows {
+			if err := checkText("row id", row.ID, maxRowID, true); err != nil {
+				return nil, err
+			}
+			if ids[row.ID] {
+				return nil, fmt.Errorf("%w: duplicate row id %q", ErrInvalidMessage, row.ID)
+			}
+			ids[row.ID] = true
+			if err := checkText("row title", row.Title, maxRowTitle, true); err != nil {
+				return nil, err
+			}
+			if err := checkText("row description", row.Description, maxRowDescription, false); err != nil {
+				return nil, err
+			}
+		}
+		rowCount += len(section.Rows)
+	}
+	if rowCount > maxListRows {
+		return nil, fmt.Errorf("%w: a list can have at most %d rows in total", ErrInvalidMessage, maxListRows)
+	}
+
+	return interactive("list", m.Header, m.Body, m.Footer, maxListBody, map[string]interface{}{
+		"button":   m.ButtonText,
+		"sections": m.Sections,
+	})
+}
+
+// CTAURLMessage is an interactive message with a button that opens a URL
+type CTAURLMessage struct {
+	Header      string `json:"header,omitempty"`
+	Body        string `json:"body"`
+	Footer      string `json:"footer,omitempty"`
+	DisplayText string `json:"display_text"`
+	URL         string `json:"url"`
+}
+
+func (m *CTAURLMessage) Type() string { return "interactive" }
+
+func (m *CTAURLMessage) Content() (interface{}, error) {
+	if err := checkText("display_text", m.DisplayText, maxActionButtonText, true); err != nil {
+		return nil, err
+	}
+	if m.URL == "" {
+		return nil, fmt.Errorf("%w: url is required", ErrInvalidMessage)
+	}
+	return interactive("cta_url", m.Header, m.Body, m.Footer, maxInteractiveBody, map[string]interface{}{
+		"name": "cta_url",
+		"parameters": map[string]interface{}{
+			"display_text": m.DisplayText,
+			"url":          m.URL,
+		},
+	})
+}
+
+// LocationRequestMessage asks the customer to share their location
+type LocationRequestMessage struct {
+	Body string `json:"body"`
+}
+
+func (m *LocationRequestMessage) Type() string { return "interactive" }
+
+func (m *LocationRequestMessage) Content() (interface{}, error) {
+	return interactive("location_request_message", "", m.Body, "", maxInteractiveBody, map[string]interface{}{
+		"name": "send_location",
+	})
+}
+
+// FlowMessage opens a WhatsApp Flow. The flow starts on Screen with Data, or asks the
+// flow's data endpoint for its first screen when Screen is empty.
+type FlowMessage struct {
+	Header    string                 `json:"header,omitempty"`
+	Body      string                 `json:"body"`
+	Footer    string                 `json:"footer,omitempty"`
+	FlowID    string                 `json:"flow_id"`              // Meta flow ID
+	FlowToken string                 `json:"flow_token,omitempty"` // Returned with the flow's response
+	CTA       string                 `json:"cta"`                  // Label of the button that opens the flow
+	Screen    string                 `json:"screen,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Draft     bool                   `json:"draft,omitempty"` // Send a flow that is not published yet
+}
+
+func (m *FlowMessage) Type() string { return "interactive" }
+
+func (m *FlowMessage) Content() (interface{}, error) {
+	if m.FlowID == "" {
+		return nil, fmt.Errorf("%w: flow_id is required", ErrInvalidMessage)
+	}
+	if err := checkText("cta", m.CTA, maxActionButtonText, true); err != nil {
+		return nil, err
+	}
+
+	params := map[string]interface{}{
+		"flow_message_version": "3",
+		"flow_id":              m.FlowID,
+		"flow_cta":             m.CTA,
+		"flow_action":          "data_exchange",
+	}
+	if m.FlowToken != "" {
+		params["flow_token"] = m.FlowToken
+	}
+	if m.Screen != "" {
+		params["flow_action"] = "navigate"
+		payload := map[string]interface{}{"screen": m.Screen}
+		if len(m.Data) > 0 {
+			payload["data"] = m.Data
+		}
+		params["flow_action_payload"] = payload
+	}
+	if m.Draft {
+		params["mode"] = "draft"
+	}
+	return interactive("flow", m.Header, m.Body, m.Footer, maxInteractiveBody, map[string]interface{}{
+		"name":       "flow",
+		"parameters": params,
+	})
+}
+
+// ProductMessage shows a single product from the account's catalog
+type ProductMessage struct {
+	CatalogID         string `json:"catalog_id"`
+	ProductRetailerID string `json:"product_retailer_id"`
+	Body              string `json:"body,omitempty"`
+	Footer            string `json:"footer,omitempty"`
+}
+
+func (m *ProductMessage) Type() string { return "interactive" }
+
+func (m *ProductMessage) Content() (interface{}, error) {
+	if m.CatalogID == "" || m.ProductRetailerID == "" {
+		return nil, fmt.Errorf("%w: catalog_id and product_retailer_id are required", ErrInvalidMessage)
+	}
+	if err := checkText("body", m.Body, maxInteractiveBody, false); err != nil {
+		return nil, err
+	}
+	if err := checkText("footer", m.Footer, maxFooterLength, false); err != nil {
+		return nil, err
+	}
+
+	content := map[string]interface{}{
+		"type": "product",
+		"action": map[string]interface{}{
+			"catalog_id":          m.CatalogID,
+			"product_retailer_id": m.ProductRetailerID,
+		},
+	}
+	if m.Body != "" {
+		content["body"] = map[string]interface{}{"text": m.Body}
+	}
+	if m.Footer != "" {
+		content["footer"] = map[string]interface{}{"text": m.Footer}
+	}
+	return content, nil
+}
+
+// ProductSection groups the products of a product list message
+type ProductSection struct {
+	Title              string   `json:"title"`
+	ProductRetailerIDs []string `json:"product_retailer_ids"`
+}
+
+// ProductListMessage shows several products from the account's catalog, grouped in sections
+type ProductListMessage struct {
+	CatalogID string           `json:"catalog_id"`
+	Header    string           `json:"header"`
+	Body      string           `json:"body"`
+	Footer    string           `json:"footer,omitempty"`
+	Sections  []ProductSection `json:"sections"`
+}
+
+func (m *ProductListMessage) Type() string { return "interactive" }
+
+func (m *ProductListMessage) Content() (interface{}, error) {
+	if m.CatalogID == "" {
+		return nil, fmt.Errorf("%w: catalog_id is required", ErrInvalidMessage)
+	}
+	if err := checkText("header", m.Header, maxHeaderLength, true); err != nil {
+		return nil, err
+	}
+	if len(m.Sections) == 0 || len(m.Sections) > maxProductSections {
+		return nil, fmt.Errorf("%w: between 1 and %d sections are required", ErrInvalidMessage, maxProductSections)
+	}
+
+	itemCount := 0
+	sections := make([]map[string]interface{}, 0, len(m.Sections))
+	for i, section := range m.Sections {
+		if err := checkText("section title", section.Title, maxSectionTitle, true); err != nil {
+			return nil, err
+		}
+		if len(section.ProductRetailerIDs) == 0 {
+			return nil, fmt.Errorf("%w: section %d has no products", ErrInvalidMessage, i+1)
+		}
+		items := make([]map[string]interface{}, 0, len(section.ProductRetailerIDs))
+		for _, id := range section.ProductRetailerIDs {
+			items = append(items, map[string]interface{}{"product_retailer_id": id})
+		}
+		itemCount += len(items)
+		sections = append(sections, map[string]interface{}{
+			"title":         section.Title,
+			"product_items": items,
+		})
+	}
+	if itemCount > maxProductListItems {
+		return nil, fmt.Errorf("%w: a product list can have at most %d products", ErrInvalidMessage, maxProductListItems)
+	}
+
+	return interactive("product_list", m.Header, m.Body, m.Footer, maxInteractiveBody, map[string]interface{}{
+		"catalog_id": m.CatalogID,
+		"sections":   sections,
+	})
+}
+
+// interactive builds the interactive object shared by all interactive message types
+func interactive(kind, header, body, footer string, maxBody int, action map[string]interface{}) (interface{}, error) {
+	if err := checkText("header", header, maxHeaderLength, false); err != nil {
+		return nil, err
+	}
+	if err := checkText("body", body, maxBody, true); err != nil {
+		return nil, err
+	}
+	if err := checkText("footer", footer, maxFooterLength, false); err != nil {
+		return nil, err
+	}
+
+	content := map[string]interface{}{
+		"type":   kind,
+		"body":   map[string]interface{}{"text": body},
+		"action": action,
+	}
+	if header != "" {
+		content["header"] = map[string]interface{}{"type": "text", "text": header}
+	}
+	if footer != "" {
+		content["footer"] = map[string]interface{}{"text": footer}
+	}
+	return content, nil
+}
+
+// checkText checks a text field against its length limit in characters
+func checkText(field, value string, max int, required bool) error {
+	if value == "" {
+		if required {
+			return fmt.Errorf("%w: %s is required", ErrInvalidMessage, field)
+		}
+		return nil
+	}
+	if n := utf8.RuneCountInString(value); n > max {
+		return fmt.Errorf("%w: %s is %d characters, the limit is %d", ErrInvalidMessage, field, n, max)
+	}
+	return nil
+}
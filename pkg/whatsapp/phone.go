@@ -0,0 +1,40 @@
+package whatsapp
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalidPhoneNumber is returned when a phone number cannot be normalized
+var ErrInvalidPhoneNumber = errors.New("invalid phone number: expected international format with country code")
+
+// NormalizePhoneNumber converts a phone number to the canonical form used for contacts:
+// E.164 digits without the leading "+", which is also the wa_id format Meta uses in webhooks.
+// Accepts "+14155552671", "0014155552671", "1 (415) 555-2671" and similar inputs.
+func NormalizePhoneNumber(phone string) (string, error) {
+	phone = strings.TrimSpace(phone)
+	phone = strings.TrimPrefix(phone, "+")
+
+	var b strings.Builder
+	for _, c := range phone {
+		switch {
+		case c >= '0' && c <= '9':
+			b.WriteRune(c)
+		case c == ' ' || c == '-' || c == '.' || c == '(' || c == ')':
+			// Formatting characters are dropped
+		default:
+			return "", ErrInvalidPhoneNumber
+		}
+	}
+
+	digits := b.String()
+
+	// International dialing prefix
+	digits = strings.TrimPrefix(digits, "00")
+
+	// E.164 allows at most 15 digits; country code + subscriber number is at least 8
+	if len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return "", ErrInvalidPhoneNumber
+	}
+	return digits, nil
+}
@@ -0,0 +1,48 @@
+package whatsapp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	tests := []struct {
+		name  string
+		phone string
+		want  string // "" when the number is invalid
+	}{
+		{"digits", "14155552671", "14155552671"},
+		{"plus", "+14155552671", "14155552671"},
+		{"spaces", "+1 415 555 2671", "14155552671"},
+		{"dashes", "1-415-555-2671", "14155552671"},
+		{"parentheses and dots", "1 (415) 555.2671", "14155552671"},
+		{"surrounding whitespace", "  +447911123456\n", "447911123456"},
+		{"leading 00", "0014155552671", "14155552671"},
+		{"leading 00 with spaces", "00 44 7911 123456", "447911123456"},
+		{"shortest", "49301234", "49301234"},
+		{"longest", "123456789012345", "123456789012345"},
+		{"too short", "+4930123", ""},
+		{"too long", "+1234567890123456", ""},
+		{"too long after 00", "001234567890123456", ""},
+		{"national format", "04155552671", ""},
+		{"letters", "+1415555CALL", ""},
+		{"plus in the middle", "1415+5552671", ""},
+		{"empty", "", ""},
+		{"only formatting", "+ ( ) -", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizePhoneNumber(tt.phone)
+			if tt.want == "" {
+				if !errors.Is(err, ErrInvalidPhoneNumber) {
+					t.Errorf("NormalizePhoneNumber(%q) = %q, %v; want ErrInvalidPhoneNumber", tt.phone, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("NormalizePhoneNumber(%q) = %q, %v; want %q", tt.phone, got, err, tt.want)
+			}
+		})
+	}
+}
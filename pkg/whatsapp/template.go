@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -267,3 +268,88 @@ func extractExamplesForComponent(sampleValues []interface{}, componentType strin
 	}
 	return examples
 }
+
+// BuildTemplateComponents converts template send parameters into the components array
+// expected by the messages API
+func BuildTemplateComponents(params *TemplateSendParams) []map[string]interface{} {
+	if params == nil {
+		return nil
+	}
+
+	var components []map[string]interface{}
+
+	if h := params.Header; h != nil && h.Type != "" {
+		headerType := strings.ToLower(h.Type)
+		var param map[string]interface{}
+		switch headerType {
+		case "text":
+			if h.Text != "" {
+				param = map[string]interface{}{"type": "text", "text": h.Text}
+			}
+		case "image", "document", "video":
+			media := map[string]interface{}{}
+			if h.MediaID != "" {
+				media["id"] = h.MediaID
+			} else if h.Link != "" {
+				media["link"] = h.Link
+			}
+			if headerType == "document" && h.Filename != "" {
+				media["filename"] = h.Filename
+			}
+			if len(media) > 0 {
+				param = map[string]interface{}{"type": headerType, headerType: media}
+			}
+		}
+		if param != nil {
+			if h.Name != "" && headerType == "text" {
+				param["parameter_name"] = h.Name
+			}
+			components = append(components, map[string]interface{}{
+				"type":       "header",
+				"parameters": []map[string]interface{}{param},
+			})
+		}
+	}
+
+	if len(params.Body) > 0 {
+		bodyParams := make([]map[string]interface{}, 0, len(params.Body))
+		for i, v := range params.Body {
+			param := map[string]interface{}{
+				"type": "text",
+				"text": v,
+			}
+			if i < len(params.BodyNames) {
+				param["parameter_name"] = params.BodyNames[i]
+			}
+			bodyParams = append(bodyParams, param)
+		}
+		components = append(components, map[string]interface{}{
+			"type":       "body",
+			"parameters": bodyParams,
+		})
+	}
+
+	for _, btn := range params.Buttons {
+		subType := strings.ToLower(btn.SubType)
+		var param map[string]interface{}
+		switch subType {
+		case "quick_reply":
+			param = map[string]interface{}{"type": "payload", "payload": btn.Value}
+		case "copy_code":
+			param = map[string]interface{}{"type": "coupon_code", "coupon_code": btn.Value}
+		case "flow":
+			param = map[string]interface{}{"type": "action", "action": map[string]interface{}{"flow_token": btn.Value}}
+		default:
+			subType = "url"
+			param = map[string]interface{}{"type": "text", "text": btn.Value}
+		}
+		components = append(components, map[string]interface{}{
+			"type":       "button",
+			"sub_type":   subType,
+			"index":      strconv.Itoa(btn.Index),
+			"parameters": []map[string]interface{}{param},
+		})
+	}
+
+	return components
+}
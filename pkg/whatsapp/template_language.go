@@ -0,0 +1,59 @@
+package whatsapp
+
+import "strings"
+
+// NormalizeLanguageCode returns a locale in the form Meta uses for template languages:
+// lowercase language and uppercase region joined by an underscore ("pt-br" -> "pt_BR")
+func NormalizeLanguageCode(code string) string {
+	code = strings.ReplaceAll(strings.TrimSpace(code), "-", "_")
+	lang, region, _ := strings.Cut(code, "_")
+	lang = strings.ToLower(lang)
+	if region == "" {
+		return lang
+	}
+	return lang + "_" + strings.ToUpper(region)
+}
+
+// SelectTemplateLanguage returns the language, among those a template is available in,
+// that best suits a contact's locale. The locale is tried first, then each fallback in
+// order; each matches its exact language, then the bare language ("pt" for "pt_BR"),
+// then another region of the same language. Returns "" when nothing matches.
+func SelectTemplateLanguage(available []string, locale string, fallback []string) string {
+	for _, want := range append([]string{locale}, fallback...) {
+		want = NormalizeLanguageCode(want)
+		if want == "" {
+			continue
+		}
+		base := languageBase(want)
+
+		var bare, sameBase string
+		for _, lang := range available {
+			norm := NormalizeLanguageCode(lang)
+			switch {
+			case norm == want:
+				return lang
+			case norm == base:
+				if bare == "" {
+					bare = lang
+				}
+			case languageBase(norm) == base:
+				if sameBase == "" {
+					sameBase = lang
+				}
+			}
+		}
+		if bare != "" {
+			return bare
+		}
+		if sameBase != "" {
+			return sameBase
+		}
+	}
+	return ""
+}
+
+// languageBase returns the language of a normalized code without its region
+func languageBase(code string) string {
+	lang, _, _ := strings.Cut(code, "_")
+	return lang
+}
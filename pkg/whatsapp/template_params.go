@@ -0,0 +1,206 @@
+package whatsapp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TemplateParamHeader is the value key of a template's header parameter: the text
+// variable of a TEXT header, or the media link of an IMAGE, VIDEO or DOCUMENT header
+const TemplateParamHeader = "header"
+
+// ErrMissingTemplateParams is returned when values lack parameters the template needs
+var ErrMissingTemplateParams = errors.New("missing template parameters")
+
+// templateVarPattern matches positional ({{1}}) and named ({{first_name}}) template variables
+var templateVarPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// TemplateButtonParamKey returns the value key of the parameter for the button at index
+func TemplateButtonParamKey(index int) string {
+	return "button_" + strconv.Itoa(index)
+}
+
+// TemplateVariables returns the variables used in a template text. Positional variables
+// are returned as "1" up to the highest index used; named variables in order of appearance.
+func TemplateVariables(text string) []string {
+	var names []string
+	seen := map[string]bool{}
+	highest := 0
+	for _, m := range templateVarPattern.FindAllStringSubmatch(text, -1) {
+		if n, err := strconv.Atoi(m[1]); err == nil {
+			if n > highest {
+				highest = n
+			}
+			continue
+		}
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	if len(names) > 0 {
+		return names
+	}
+
+	vars := make([]string, highest)
+	for i := range vars {
+		vars[i] = strconv.Itoa(i + 1)
+	}
+	return vars
+}
+
+// RenderTemplateText substitutes the variables of a template text with values,
+// leaving variables without a value as they are
+func RenderTemplateText(text string, values map[string]interface{}) string {
+	return templateVarPattern.ReplaceAllStringFunc(text, func(m string) string {
+		if v := paramValue(values, templateVarPattern.FindStringSubmatch(m)[1]); v != "" {
+			return v
+		}
+		return m
+	})
+}
+
+// TemplateLayout is the part of a template definition that takes parameters when sending
+type TemplateLayout struct {
+	HeaderType    string        // TEXT, IMAGE, VIDEO, DOCUMENT or empty
+	HeaderContent string        // Header text, for TEXT headers
+	BodyContent   string        // Body text
+	Buttons       []interface{} // [{type, text, url, ...}] as submitted
+}
+
+// TemplateParamKey is a value read by ParamsFromValues
+type TemplateParamKey struct {
+	Key      string `json:"key"`
+	Required bool   `json:"required"`
+}
+
+// ParamKeys lists the values the template reads: body variables by number or name, the
+// header and buttons. Media headers are not required, as the sender may supply one for all.
+func (l *TemplateLayout) ParamKeys() []TemplateParamKey {
+	var keys []TemplateParamKey
+	switch strings.ToUpper(l.HeaderType) {
+	case "TEXT":
+		if len(TemplateVariables(l.HeaderContent)) > 0 {
+			keys = append(keys, TemplateParamKey{Key: TemplateParamHeader, Required: true})
+		}
+	case "IMAGE", "VIDEO", "DOCUMENT":
+		keys = append(keys, TemplateParamKey{Key: TemplateParamHeader})
+	}
+	for _, v := range TemplateVariables(l.BodyContent) {
+		keys = append(keys, TemplateParamKey{Key: v, Required: true})
+	}
+	for i, b := range l.Buttons {
+		if subType, required := templateButtonParam(b); subType != "" {
+			keys = append(keys, TemplateParamKey{Key: TemplateButtonParamKey(i), Required: required})
+		}
+	}
+	return keys
+}
+
+// ParamsFromValues builds the send parameters of the template from values keyed as
+// listed by ParamKeys. header is used for media headers when values has no header link.
+// Missing parameters are reported with an error wrapping ErrMissingTemplateParams.
+func (l *TemplateLayout) ParamsFromValues(values map[string]interface{}, header *TemplateHeaderParam) (*TemplateSendParams, error) {
+	params := &TemplateSendParams{}
+	var missing []string
+
+	switch headerType := strings.ToUpper(l.HeaderType); headerType {
+	case "TEXT":
+		if vars := TemplateVariables(l.HeaderContent); len(vars) > 0 {
+			if v := paramValue(values, TemplateParamHeader); v != "" {
+				params.Header = &TemplateHeaderParam{Type: "text", Text: v}
+				if !isPositionalVariable(vars[0]) {
+					params.Header.Name = vars[0]
+				}
+			} else {
+				missing = append(missing, TemplateParamHeader)
+			}
+		}
+	case "IMAGE", "VIDEO", "DOCUMENT":
+		mediaType := strings.ToLower(headerType)
+		if link := paramValue(values, TemplateParamHeader); link != "" {
+			params.Header = &TemplateHeaderParam{Type: mediaType, Link: link}
+		} else if header != nil && (header.MediaID != "" || header.Link != "") {
+			h := *header
+			h.Type = mediaType
+			params.Header = &h
+		} else {
+			missing = append(missing, TemplateParamHeader)
+		}
+	}
+
+	vars := TemplateVariables(l.BodyContent)
+	named := len(vars) > 0 && !isPositionalVariable(vars[0])
+	for _, name := range vars {
+		v := paramValue(values, name)
+		if v == "" {
+			missing = append(missing, "{{"+name+"}}")
+			continue
+		}
+		params.Body = append(params.Body, v)
+		if named {
+			params.BodyNames = append(params.BodyNames, name)
+		}
+	}
+
+	for i, b := range l.Buttons {
+		subType, required := templateButtonParam(b)
+		if subType == "" {
+			continue
+		}
+		key := TemplateButtonParamKey(i)
+		v := paramValue(values, key)
+		if v == "" {
+			if required {
+				missing = append(missing, key)
+			}
+			continue
+		}
+		params.Buttons = append(params.Buttons, TemplateButtonParam{Index: i, SubType: subType, Value: v})
+	}
+
+	if len(missing) > 0 {
+		return params, fmt.Errorf("%w: %s", ErrMissingTemplateParams, strings.Join(missing, ", "))
+	}
+	return params, nil
+}
+
+// templateButtonParam returns the sub_type of the parameter a template button takes
+// (empty if it takes none) and whether the parameter is required
+func templateButtonParam(b interface{}) (subType string, required bool) {
+	btn, ok := b.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	btnType, _ := btn["type"].(string)
+	switch strings.ToUpper(btnType) {
+	case "URL":
+		url, _ := btn["url"].(string)
+		if len(TemplateVariables(url)) > 0 {
+			return "url", true
+		}
+	case "COPY_CODE":
+		return "copy_code", true
+	case "FLOW":
+		return "flow", false
+	case "QUICK_REPLY":
+		return "quick_reply", false
+	}
+	return "", false
+}
+
+func isPositionalVariable(name string) bool {
+	_, err := strconv.Atoi(name)
+	return err == nil
+}
+
+func paramValue(values map[string]interface{}, key string) string {
+	v, ok := values[key]
+	if !ok || v == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(v))
+}
@@ -0,0 +1,356 @@
+package whatsapp
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits Meta applies to message templates
+const (
+	maxTemplateNameLength    = 512
+	maxTemplateHeaderLength  = 60
+	maxTemplateBodyLength    = 1024
+	maxTemplateFooterLength  = 60
+	maxTemplateButtons       = 10
+	maxTemplateButtonText    = 25
+	maxTemplateURLButtons    = 2
+	maxTemplatePhoneButtons  = 1
+	maxTemplateCopyButtons   = 1
+	maxTemplateURLLength     = 2000
+	maxTemplatePhoneLength   = 20
+	maxTemplateHeaderVars    = 1
+	maxTemplateURLButtonVars = 1
+)
+
+// Severities of template issues. Templates with errors are not submitted; warnings are
+// likely, but not certain, to get the template rejected.
+const (
+	TemplateIssueError   = "error"
+	TemplateIssueWarning = "warning"
+)
+
+var (
+	templateNamePattern     = regexp.MustCompile(`^[a-z0-9_]+$`)
+	templateLanguagePattern = regexp.MustCompile(`^[a-z]{2,3}(_[A-Z]{2})?$`)
+	templateNamedVarPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	templateAdjacentVars    = regexp.MustCompile(`\}\}\s*\{\{`)
+	templateURLPattern      = regexp.MustCompile(`https?://`)
+)
+
+// TemplateIssue is a problem found in a template before it is submitted to Meta
+type TemplateIssue struct {
+	Field    string `json:"field"` // name, language, category, header, body, footer, buttons or buttons[i]
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// TemplateIssues is the result of ValidateTemplate
+type TemplateIssues []TemplateIssue
+
+// HasErrors reports whether any issue is an error
+func (issues TemplateIssues) HasErrors() bool {
+	return issues.FirstError() != nil
+}
+
+// FirstError returns the first error, or nil if there are only warnings
+func (issues TemplateIssues) FirstError() *TemplateIssue {
+	for i := range issues {
+		if issues[i].Severity == TemplateIssueError {
+			return &issues[i]
+		}
+	}
+	return nil
+}
+
+type templateValidator struct {
+	issues TemplateIssues
+}
+
+func (v *templateValidator) errorf(field, format string, args ...interface{}) {
+	v.issues = append(v.issues, TemplateIssue{Field: field, Severity: TemplateIssueError, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *templateValidator) warnf(field, format string, args ...interface{}) {
+	v.issues = append(v.issues, TemplateIssue{Field: field, Severity: TemplateIssueWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// ValidateTemplate checks a template against Meta's rules before it is submitted: the
+// name format, length limits, variable numbering and samples, the buttons allowed and
+// the rules of AUTHENTICATION templates. It returns nil for a valid template.
+func ValidateTemplate(t *TemplateSubmission) TemplateIssues {
+	v := &templateValidator{}
+
+	switch {
+	case t.Name == "":
+		v.errorf("name", "name is required")
+	case len(t.Name) > maxTemplateNameLength:
+		v.errorf("name", "name is %d characters, the limit is %d", len(t.Name), maxTemplateNameLength)
+	case !templateNamePattern.MatchString(t.Name):
+		v.errorf("name", "name may only contain lowercase letters, numbers and underscores")
+	}
+
+	if !templateLanguagePattern.MatchString(t.Language) {
+		v.errorf("language", "language %q is not a language code such as en or en_US", t.Language)
+	}
+
+	category := strings.ToUpper(t.Category)
+	switch category {
+	case "MARKETING", "UTILITY", "AUTHENTICATION":
+	default:
+		v.errorf("category", "category must be MARKETING, UTILITY or AUTHENTICATION")
+	}
+
+	v.header(t)
+	v.body(t)
+	v.footer(t)
+	v.buttons(t)
+	if category == "AUTHENTICATION" {
+		v.authentication(t)
+	}
+	return v.issues
+}
+
+func (v *templateValidator) header(t *TemplateSubmission) {
+	switch strings.ToUpper(t.HeaderType) {
+	case "", "NONE":
+	case "TEXT":
+		if t.HeaderContent == "" {
+			v.errorf("header", "header text is required for a TEXT header")
+			return
+		}
+		if n := utf8.RuneCountInString(t.HeaderContent); n > maxTemplateHeaderLength {
+			v.errorf("header", "header is %d characters, the limit is %d", n, maxTemplateHeaderLength)
+		}
+		if strings.Contains(t.HeaderContent, "\n") {
+			v.errorf("header", "header cannot contain line breaks")
+		}
+		vars := v.variables("header", t.HeaderContent)
+		if len(vars) > maxTemplateHeaderVars {
+			v.errorf("header", "header can have at most %d variable", maxTemplateHeaderVars)
+		}
+		v.samples("header", vars, extractExamplesForComponent(t.SampleValues, "header"))
+	case "IMAGE", "VIDEO", "DOCUMENT":
+		if t.HeaderContent == "" {
+			v.errorf("header", "media headers need a sample media handle")
+		}
+	default:
+		v.errorf("header", "header type must be TEXT, IMAGE, VIDEO or DOCUMENT")
+	}
+}
+
+func (v *templateValidator) body(t *TemplateSubmission) {
+	body := strings.TrimSpace(t.BodyContent)
+	if body == "" {
+		v.errorf("body", "body is required")
+		return
+	}
+	if n := utf8.RuneCountInString(t.BodyContent); n > maxTemplateBodyLength {
+		v.errorf("body", "body is %d characters, the limit is %d", n, maxTemplateBodyLength)
+	}
+
+	vars := v.variables("body", body)
+	if len(vars) == 0 {
+		return
+	}
+	if strings.HasPrefix(body, "{{") {
+		v.errorf("body", "body cannot start with a variable")
+	}
+	if strings.HasSuffix(body, "}}") {
+		v.errorf("body", "body cannot end with a variable")
+	}
+	if templateAdjacentVars.MatchString(body) {
+		v.warnf("body", "variables next to each other are often rejected; put text between them")
+	}
+	// Meta rejects templates with too many variables for the length of the text
+	words := len(strings.Fields(templateVarPattern.ReplaceAllString(body, " ")))
+	if words < 2*len(vars)+1 {
+		v.warnf("body", "body has %d variable(s) for %d word(s) of text; Meta may reject it as having too many variables", len(vars), words)
+	}
+	v.samples("body", vars, extractExamplesForComponent(t.SampleValues, "body"))
+}
+
+func (v *templateValidator) footer(t *TemplateSubmission) {
+	if t.FooterContent == "" {
+		return
+	}
+	if n := utf8.RuneCountInString(t.FooterContent); n > maxTemplateFooterLength {
+		v.errorf("footer", "footer is %d characters, the limit is %d", n, maxTemplateFooterLength)
+	}
+	if templateVarPattern.MatchString(t.FooterContent) {
+		v.errorf("footer", "footer cannot contain variables")
+	}
+}
+
+func (v *templateValidator) buttons(t *TemplateSubmission) {
+	if len(t.Buttons) > maxTemplateButtons {
+		v.errorf("buttons", "template has %d buttons, the limit is %d", len(t.Buttons), maxTemplateButtons)
+	}
+
+	counts := map[string]int{}
+	// Quick replies must be grouped together, before or after the call-to-action buttons
+	groups := 0
+	prevQuickReply := false
+	for i, b := range t.Buttons {
+		field := fmt.Sprintf("buttons[%d]", i)
+		btn, ok := b.(map[string]interface{})
+		if !ok {
+			v.errorf(field, "button must be an object")
+			continue
+		}
+		btnType, _ := btn["type"].(string)
+		btnType = strings.ToUpper(btnType)
+		text, _ := btn["text"].(string)
+
+		if text == "" {
+			v.errorf(field, "button text is required")
+		} else if n := utf8.RuneCountInString(text); n > maxTemplateButtonText {
+			v.errorf(field, "button text is %d characters, the limit is %d", n, maxTemplateButtonText)
+		}
+
+		switch btnType {
+		case "QUICK_REPLY":
+		case "URL":
+			v.urlButton(field, btn)
+		case "PHONE_NUMBER":
+			phone, _ := btn["phone_number"].(string)
+			if phone == "" {
+				v.errorf(field, "phone_number is required for a PHONE_NUMBER button")
+			} else if len(phone) > maxTemplatePhoneLength {
+				v.errorf(field, "phone_number is %d characters, the limit is %d", len(phone), maxTemplatePhoneLength)
+			}
+		case "COPY_CODE":
+			if example, _ := btn["example"].(string); example == "" {
+				v.errorf(field, "an example code is required for a COPY_CODE button")
+			}
+		default:
+			v.errorf(field, "button type must be QUICK_REPLY, URL, PHONE_NUMBER or COPY_CODE")
+			continue
+		}
+		counts[btnType]++
+
+		isQuickReply := btnType == "QUICK_REPLY"
+		if groups == 0 || isQuickReply != prevQuickReply {
+			groups++
+		}
+		prevQuickReply = isQuickReply
+	}
+
+	if counts["URL"] > maxTemplateURLButtons {
+		v.errorf("buttons", "template has %d URL buttons, the limit is %d", counts["URL"], maxTemplateURLButtons)
+	}
+	if counts["PHONE_NUMBER"] > maxTemplatePhoneButtons {
+		v.errorf("buttons", "template has %d phone number buttons, the limit is %d", counts["PHONE_NUMBER"], maxTemplatePhoneButtons)
+	}
+	if counts["COPY_CODE"] > maxTemplateCopyButtons {
+		v.errorf("buttons", "template has %d copy code buttons, the limit is %d", counts["COPY_CODE"], maxTemplateCopyButtons)
+	}
+	if groups > 2 {
+		v.errorf("buttons", "quick reply buttons must be grouped together, not mixed with other buttons")
+	}
+}
+
+func (v *templateValidator) urlButton(field string, btn map[string]interface{}) {
+	url, _ := btn["url"].(string)
+	if url == "" {
+		v.errorf(field, "url is required for a URL button")
+		return
+	}
+	if len(url) > maxTemplateURLLength {
+		v.errorf(field, "url is %d characters, the limit is %d", len(url), maxTemplateURLLength)
+	}
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		v.errorf(field, "url must start with https:// or http://")
+	}
+
+	matches := templateVarPattern.FindAllStringIndex(url, -1)
+	if len(matches) == 0 {
+		return
+	}
+	if len(matches) > maxTemplateURLButtonVars {
+		v.errorf(field, "url can have at most %d variable", maxTemplateURLButtonVars)
+	}
+	if last := matches[len(matches)-1]; last[1] != len(url) {
+		v.errorf(field, "the url variable must be at the end of the url")
+	}
+	if name := templateVarPattern.FindStringSubmatch(url)[1]; name != "1" {
+		v.errorf(field, "the url variable must be {{1}}")
+	}
+	if example, _ := btn["example"].(string); example == "" {
+		v.errorf(field, "an example is required for a url with a variable")
+	}
+}
+
+// authentication checks the extra rules of AUTHENTICATION templates, which carry a one-time code
+func (v *templateValidator) authentication(t *TemplateSubmission) {
+	if h := strings.ToUpper(t.HeaderType); h != "" && h != "NONE" {
+		v.errorf("header", "authentication templates cannot have a header")
+	}
+	if vars := TemplateVariables(t.BodyContent); len(vars) != 1 {
+		v.errorf("body", "authentication templates must have exactly one variable, the code")
+	}
+	if templateURLPattern.MatchString(t.BodyContent) {
+		v.errorf("body", "authentication templates cannot contain URLs")
+	}
+
+	copyButtons := 0
+	for i, b := range t.Buttons {
+		btn, _ := b.(map[string]interface{})
+		btnType, _ := btn["type"].(string)
+		if strings.EqualFold(btnType, "COPY_CODE") {
+			copyButtons++
+			continue
+		}
+		v.errorf(fmt.Sprintf("buttons[%d]", i), "authentication templates only allow a copy code button")
+	}
+	if copyButtons != 1 {
+		v.errorf("buttons", "authentication templates need one copy code button")
+	}
+}
+
+// variables checks the numbering of a text's variables and returns them: positional
+// variables must run from {{1}} without gaps, and cannot be mixed with named ones
+func (v *templateValidator) variables(field, text string) []string {
+	if strings.Count(text, "{{") != strings.Count(text, "}}") || strings.Count(text, "{{") != len(templateVarPattern.FindAllString(text, -1)) {
+		v.errorf(field, "malformed variable; use {{1}}, {{2}}, ... or {{name}}")
+		return nil
+	}
+
+	var positional []int
+	named := 0
+	seen := map[int]bool{}
+	for _, m := range templateVarPattern.FindAllStringSubmatch(text, -1) {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			named++
+			if !templateNamedVarPattern.MatchString(m[1]) {
+				v.errorf(field, "variable {{%s}} must use lowercase letters, numbers and underscores", m[1])
+			}
+			continue
+		}
+		if !seen[n] {
+			seen[n] = true
+			positional = append(positional, n)
+		}
+	}
+	if named > 0 && len(positional) > 0 {
+		v.errorf(field, "numbered and named variables cannot be mixed")
+		return nil
+	}
+	for i := 1; i <= len(positional); i++ {
+		if !seen[i] {
+			v.errorf(field, "variables must be numbered from {{1}} without gaps; {{%d}} is missing", i)
+			return nil
+		}
+	}
+	return TemplateVariables(text)
+}
+
+// samples checks that every variable has a sample value, which Meta needs for review
+func (v *templateValidator) samples(field string, vars, samples []string) {
+	if len(vars) > len(samples) {
+		v.errorf(field, "%d variable(s) but %d sample value(s); add a sample for each variable", len(vars), len(samples))
+	}
+}
@@ -0,0 +1,243 @@
+package whatsapp
+
+import (
+	"strings"
+	"testing"
+)
+
+func sample(component string, index int, value string) map[string]interface{} {
+	return map[string]interface{}{"component": component, "index": index, "value": value}
+}
+
+func button(fields ...string) map[string]interface{} {
+	b := map[string]interface{}{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		b[fields[i]] = fields[i+1]
+	}
+	return b
+}
+
+func quickReply(text string) map[string]interface{} {
+	return button("type", "QUICK_REPLY", "text", text)
+}
+
+func TestValidateTemplate(t *testing.T) {
+	// validTemplate is a utility template that passes every check
+	validTemplate := func() *TemplateSubmission {
+		return &TemplateSubmission{
+			Name:          "order_update",
+			Language:      "en_US",
+			Category:      "UTILITY",
+			HeaderType:    "TEXT",
+			HeaderContent: "Order {{1}}",
+			BodyContent:   "Hello {{1}}, your order {{2}} has shipped and is on its way.",
+			FooterContent: "Reply STOP to opt out",
+			Buttons:       []interface{}{button("type", "URL", "text", "Track", "url", "https://example.com/track/{{1}}", "example", "https://example.com/track/42")},
+			SampleValues:  []interface{}{sample("header", 1, "42"), sample("body", 1, "Ana"), sample("body", 2, "42")},
+		}
+	}
+	// authTemplate is an authentication template that passes every check
+	authTemplate := func() *TemplateSubmission {
+		return &TemplateSubmission{
+			Name:         "login_code",
+			Language:     "en",
+			Category:     "AUTHENTICATION",
+			BodyContent:  "Your code is {{1}}. Do not share it with anyone.",
+			Buttons:      []interface{}{button("type", "COPY_CODE", "text", "Copy code", "example", "123456")},
+			SampleValues: []interface{}{sample("body", 1, "123456")},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		base     func() *TemplateSubmission
+		modify   func(*TemplateSubmission)
+		field    string // field of the expected issue; "" for a valid template
+		severity string
+		message  string // substring of the expected issue's message
+	}{
+		{"valid", validTemplate, func(*TemplateSubmission) {}, "", "", ""},
+		{"valid authentication", authTemplate, func(*TemplateSubmission) {}, "", "", ""},
+		{"lowercase category", validTemplate, func(s *TemplateSubmission) { s.Category = "marketing" }, "", "", ""},
+
+		// Name and language
+		{"no name", validTemplate, func(s *TemplateSubmission) { s.Name = "" }, "name", TemplateIssueError, "name is required"},
+		{"uppercase name", validTemplate, func(s *TemplateSubmission) { s.Name = "Order_Update" }, "name", TemplateIssueError, "lowercase letters"},
+		{"name with dash", validTemplate, func(s *TemplateSubmission) { s.Name = "order-update" }, "name", TemplateIssueError, "lowercase letters"},
+		{"name with space", validTemplate, func(s *TemplateSubmission) { s.Name = "order update" }, "name", TemplateIssueError, "lowercase letters"},
+		{"name at limit", validTemplate, func(s *TemplateSubmission) { s.Name = strings.Repeat("a", maxTemplateNameLength) }, "", "", ""},
+		{"name too long", validTemplate, func(s *TemplateSubmission) { s.Name = strings.Repeat("a", maxTemplateNameLength+1) }, "name", TemplateIssueError, "the limit is 512"},
+		{"language", validTemplate, func(s *TemplateSubmission) { s.Language = "english" }, "language", TemplateIssueError, "not a language code"},
+		{"language region case", validTemplate, func(s *TemplateSubmission) { s.Language = "en_us" }, "language", TemplateIssueError, "not a language code"},
+		{"category", validTemplate, func(s *TemplateSubmission) { s.Category = "NEWS" }, "category", TemplateIssueError, "category must be"},
+
+		// Length limits, counted in characters
+		{"header at limit", validTemplate, func(s *TemplateSubmission) {
+			s.HeaderContent = strings.Repeat("é", maxTemplateHeaderLength)
+			s.SampleValues = s.SampleValues[1:]
+		}, "", "", ""},
+		{"header too long", validTemplate, func(s *TemplateSubmission) { s.HeaderContent = strings.Repeat("a", maxTemplateHeaderLength+1) }, "header", TemplateIssueError, "the limit is 60"},
+		{"header line break", validTemplate, func(s *TemplateSubmission) { s.HeaderContent = "Order\n{{1}}" }, "header", TemplateIssueError, "line breaks"},
+		{"empty text header", validTemplate, func(s *TemplateSubmission) { s.HeaderContent = "" }, "header", TemplateIssueError, "header text is required"},
+		{"media header without sample", validTemplate, func(s *TemplateSubmission) { s.HeaderType = "IMAGE"; s.HeaderContent = "" }, "header", TemplateIssueError, "sample media handle"},
+		{"header type", validTemplate, func(s *TemplateSubmission) { s.HeaderType = "AUDIO" }, "header", TemplateIssueError, "header type must be"},
+		{"body too long", validTemplate, func(s *TemplateSubmission) { s.BodyContent = strings.Repeat("ü", maxTemplateBodyLength+1) }, "body", TemplateIssueError, "the limit is 1024"},
+		{"no body", validTemplate, func(s *TemplateSubmission) { s.BodyContent = "  \n" }, "body", TemplateIssueError, "body is required"},
+		{"footer too long", validTemplate, func(s *TemplateSubmission) { s.FooterContent = strings.Repeat("a", maxTemplateFooterLength+1) }, "footer", TemplateIssueError, "the limit is 60"},
+		{"footer variable", validTemplate, func(s *TemplateSubmission) { s.FooterContent = "Sent to {{1}}" }, "footer", TemplateIssueError, "cannot contain variables"},
+		{"button text too long", validTemplate, func(s *TemplateSubmission) {
+			s.Buttons = []interface{}{quickReply(strings.Repeat("a", maxTemplateButtonText+1))}
+		}, "buttons[0]", TemplateIssueError, "the limit is 25"},
+		{"url too long", validTemplate, func(s *TemplateSubmission) {
+			s.Buttons = []interface{}{button("type", "URL", "text", "Open", "url", "https://example.com/"+strings.Repeat("a", maxTemplateURLLength))}
+		}, "buttons[0]", TemplateIssueError, "the limit is 2000"},
+		{"phone too long", validTemplate, func(s *TemplateSubmission) {
+			s.Buttons = []interface{}{button("type", "PHONE_NUMBER", "text", "Call", "phone_number", "+1555000000000000000000")}
+		}, "buttons[0]", TemplateIssueError, "the limit is 20"},
+
+		// Placeholder numbering and samples
+		{"named variables", validTemplate, func(s *TemplateSubmission) {
+			s.BodyContent = "Hello {{first_name}}, your order {{order_id}} has shipped today."
+		}, "", "", ""},
+		{"gap in numbering", validTemplate, func(s *TemplateSubmission) {
+			s.BodyContent = "Hello {{1}}, your order {{3}} has shipped and is on its way."
+		}, "body", TemplateIssueError, "{{2}} is missing"},
+		{"numbering not from one", validTemplate, func(s *TemplateSubmission) {
+			s.BodyContent = "Hello {{2}}, your order has shipped and is on its way."
+		}, "body", TemplateIssueError, "{{1}} is missing"},
+		{"mixed variables", validTemplate, func(s *TemplateSubmission) {
+			s.BodyContent = "Hello {{1}}, your order {{order_id}} has shipped and is on its way."
+		}, "body", TemplateIssueError, "cannot be mixed"},
+		{"uppercase named variable", validTemplate, func(s *TemplateSubmission) {
+			s.BodyContent = "Hello {{Name}}, your order has shipped and is on its way."
+		}, "body", TemplateIssueError, "lowercase letters"},
+		{"malformed variable", validTemplate, func(s *TemplateSubmission) {
+			s.BodyContent = "Hello {{1}, your order {{2}} has shipped and is on its way."
+		}, "body", TemplateIssueError, "malformed variable"},
+		{"body starts with variable", validTemplate, func(s *TemplateSubmission) {
+			s.BodyContent = "{{1}}, your order {{2}} has shipped and is on its way."
+		}, "body", TemplateIssueError, "cannot start with a variable"},
+		{"body ends with variable", validTemplate, func(s *TemplateSubmission) {
+			s.BodyContent = "Hello, your order has shipped and is on its way {{1}} {{2}}"
+		}, "body", TemplateIssueError, "cannot end with a variable"},
+		{"adjacent variables", validTemplate, func(s *TemplateSubmission) {
+			s.BodyContent = "Hello {{1}}{{2}}, your order has shipped and is on its way."
+		}, "body", TemplateIssueWarning, "next to each other"},
+		{"too many variables for the text", validTemplate, func(s *TemplateSubmission) {
+			s.BodyContent = "Hi {{1}} and {{2}}."
+		}, "body", TemplateIssueWarning, "too many variables"},
+		{"missing body sample", validTemplate, func(s *TemplateSubmission) { s.SampleValues = s.SampleValues[:2] }, "body", TemplateIssueError, "2 variable(s) but 1 sample value(s)"},
+		{"missing header sample", validTemplate, func(s *TemplateSubmission) { s.SampleValues = s.SampleValues[1:] }, "header", TemplateIssueError, "1 variable(s) but 0 sample value(s)"},
+		{"legacy samples", validTemplate, func(s *TemplateSubmission) {
+			s.SampleValues = []interface{}{
+				map[string]interface{}{"component": "header", "values": []interface{}{"42"}},
+				map[string]interface{}{"component": "body", "values": []interface{}{"Ana", "42"}},
+			}
+		}, "", "", ""},
+		{"two header variables", validTemplate, func(s *TemplateSubmission) {
+			s.HeaderContent = "Order {{1}} for {{2}}"
+			s.SampleValues = append(s.SampleValues, sample("header", 2, "Ana"))
+		}, "header", TemplateIssueError, "at most 1 variable"},
+
+		// Buttons
+		{"quick replies", validTemplate, func(s *TemplateSubmission) {
+			s.Buttons = []interface{}{quickReply("Yes"), quickReply("No"), quickReply("Later")}
+		}, "", "", ""},
+		{"quick replies after call to action", validTemplate, func(s *TemplateSubmission) {
+			s.Buttons = []interface{}{button("type", "URL", "text", "Open", "url", "https://example.com"), quickReply("Yes"), quickReply("No")}
+		}, "", "", ""},
+		{"quick replies mixed with call to action", validTemplate, func(s *TemplateSubmission) {
+			s.Buttons = []interface{}{quickReply("Yes"), button("type", "URL", "text", "Open", "url", "https://example.com"), quickReply("No")}
+		}, "buttons", TemplateIssueError, "grouped together"},
+		{"too many buttons", validTemplate, func(s *TemplateSubmission) {
+			s.Buttons = nil
+			for i := 0; i <= maxTemplateButtons; i++ {
+				s.Buttons = append(s.Buttons, quickReply("Option"))
+			}
+		}, "buttons", TemplateIssueError, "the limit is 10"},
+		{"three url buttons", validTemplate, func(s *TemplateSubmission) {
+			url := button("type", "URL", "text", "Open", "url", "https://example.com")
+			s.Buttons = []interface{}{url, url, url}
+		}, "buttons", TemplateIssueError, "3 URL buttons, the limit is 2"},
+		{"two phone buttons", validTemplate, func(s *TemplateSubmission) {
+			phone := button("type", "PHONE_NUMBER", "text", "Call", "phone_number", "+15550000000")
+			s.Buttons = []interface{}{phone, phone}
+		}, "buttons", TemplateIssueError, "2 phone number buttons, the limit is 1"},
+		{"two copy code buttons", validTemplate, func(s *TemplateSubmission) {
+			code := button("type", "COPY_CODE", "text", "Copy", "example", "SAVE10")
+			s.Buttons = []interface{}{code, code}
+		}, "buttons", TemplateIssueError, "2 copy code buttons, the limit is 1"},
+		{"button type", validTemplate, func(s *TemplateSubmission) { s.Buttons = []interface{}{button("type", "OTP", "text", "Go")} }, "buttons[0]", TemplateIssueError, "button type must be"},
+		{"button not an object", validTemplate, func(s *TemplateSubmission) { s.Buttons = []interface{}{"Yes"} }, "buttons[0]", TemplateIssueError, "must be an object"},
+		{"button without text", validTemplate, func(s *TemplateSubmission) { s.Buttons = []interface{}{quickReply("")} }, "buttons[0]", TemplateIssueError, "button text is required"},
+		{"url without scheme", validTemplate, func(s *TemplateSubmission) {
+			s.Buttons = []interface{}{button("type", "URL", "text", "Open", "url", "example.com")}
+		}, "buttons[0]", TemplateIssueError, "must start with https://"},
+		{"url variable not at end", validTemplate, func(s *TemplateSubmission) {
+			s.Buttons = []interface{}{button("type", "URL", "text", "Open", "url", "https://example.com/{{1}}/track", "example", "https://example.com/42/track")}
+		}, "buttons[0]", TemplateIssueError, "at the end of the url"},
+		{"url variable not {{1}}", validTemplate, func(s *TemplateSubmission) {
+			s.Buttons = []interface{}{button("type", "URL", "text", "Open", "url", "https://example.com/{{2}}", "example", "https://example.com/42")}
+		}, "buttons[0]", TemplateIssueError, "must be {{1}}"},
+		{"url variable without example", validTemplate, func(s *TemplateSubmission) {
+			s.Buttons = []interface{}{button("type", "URL", "text", "Open", "url", "https://example.com/{{1}}")}
+		}, "buttons[0]", TemplateIssueError, "an example is required"},
+		{"phone without number", validTemplate, func(s *TemplateSubmission) {
+			s.Buttons = []interface{}{button("type", "PHONE_NUMBER", "text", "Call")}
+		}, "buttons[0]", TemplateIssueError, "phone_number is required"},
+		{"copy code without example", validTemplate, func(s *TemplateSubmission) {
+			s.Buttons = []interface{}{button("type", "COPY_CODE", "text", "Copy")}
+		}, "buttons[0]", TemplateIssueError, "an example code is required"},
+
+		// AUTHENTICATION templates
+		{"authentication header", authTemplate, func(s *TemplateSubmission) { s.HeaderType = "TEXT"; s.HeaderContent = "Login" }, "header", TemplateIssueError, "cannot have a header"},
+		{"authentication without code", authTemplate, func(s *TemplateSubmission) {
+			s.BodyContent = "Your login code is on its way."
+		}, "body", TemplateIssueError, "exactly one variable"},
+		{"authentication with two variables", authTemplate, func(s *TemplateSubmission) {
+			s.BodyContent = "Hi {{1}}, your code is {{2}}. Do not share it with anyone."
+			s.SampleValues = append(s.SampleValues, sample("body", 2, "123456"))
+		}, "body", TemplateIssueError, "exactly one variable"},
+		{"authentication url", authTemplate, func(s *TemplateSubmission) {
+			s.BodyContent = "Your code is {{1}}. Do not share it, see https://example.com."
+		}, "body", TemplateIssueError, "cannot contain URLs"},
+		{"authentication quick reply", authTemplate, func(s *TemplateSubmission) { s.Buttons = append(s.Buttons, quickReply("Resend")) }, "buttons[1]", TemplateIssueError, "only allow a copy code button"},
+		{"authentication without copy code", authTemplate, func(s *TemplateSubmission) { s.Buttons = nil }, "buttons", TemplateIssueError, "need one copy code button"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.base()
+			tt.modify(s)
+			issues := ValidateTemplate(s)
+
+			if tt.field == "" {
+				if len(issues) > 0 {
+					t.Errorf("issues = %+v, want none", issues)
+				}
+				return
+			}
+			for _, issue := range issues {
+				if issue.Field == tt.field && issue.Severity == tt.severity && strings.Contains(issue.Message, tt.message) {
+					return
+				}
+			}
+			t.Errorf("issues = %+v, want a %s on %s containing %q", issues, tt.severity, tt.field, tt.message)
+		})
+	}
+}
+
+func TestTemplateIssues(t *testing.T) {
+	warning := TemplateIssue{Field: "body", Severity: TemplateIssueWarning, Message: "w"}
+	err := TemplateIssue{Field: "name", Severity: TemplateIssueError, Message: "e"}
+
+	if issues := (TemplateIssues{warning}); issues.HasErrors() || issues.FirstError() != nil {
+		t.Error("warnings alone count as errors")
+	}
+	if issues := (TemplateIssues{warning, err}); !issues.HasErrors() || *issues.FirstError() != err {
+		t.Errorf("FirstError = %+v, want %+v", issues.FirstError(), err)
+	}
+	if TemplateIssues(nil).HasErrors() {
+		t.Error("no issues count as errors")
+	}
+}
@@ -34,7 +34,8 @@ type MetaAPIError struct {
 		ErrorData    struct {
 			Details string `json:"details"`
 		} `json:"error_data"`
-		FBTraceID string `json:"fbtrace_id"`
+		FBTraceID   string `json:"fbtrace_id"`
+		IsTransient bool   `json:"is_transient"`
 	} `json:"error"`
 }
 
@@ -77,6 +78,34 @@ type TemplateExample struct {
 	BodyText   [][]string `json:"body_text,omitempty"`
 }
 
+// TemplateHeaderParam is the header parameter for sending a template
+// Type is text, image, document or video; media headers use either MediaID or Link
+type TemplateHeaderParam struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Name     string `json:"name,omitempty"` // Variable name, for templates with named parameters
+	MediaID  string `json:"media_id,omitempty"`
+	Link     string `json:"link,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// TemplateButtonParam is a parameter for a dynamic template button
+// SubType is url, quick_reply, copy_code or flow; Index is the button's position in the template
+type TemplateButtonParam struct {
+	Index   int    `json:"index"`
+	SubType string `json:"sub_type"`
+	Value   string `json:"value"`
+}
+
+// TemplateSendParams holds all parameters for sending a template message
+// Templates with named parameters ({{first_name}}) set BodyNames alongside Body
+type TemplateSendParams struct {
+	Header    *TemplateHeaderParam  `json:"header,omitempty"`
+	Body      []string              `json:"body,omitempty"`
+	BodyNames []string              `json:"body_names,omitempty"`
+	Buttons   []TemplateButtonParam `json:"buttons,omitempty"`
+}
+
 // TemplateListResponse represents response from fetching templates
 type TemplateListResponse struct {
 	Data []MetaTemplate `json:"data"`
@@ -100,6 +129,15 @@ type WebhookChange struct {
 	Field string       `json:"field"`
 }
 
+// PhoneNumberInfo represents a business phone number's details
+type PhoneNumberInfo struct {
+	ID                 string `json:"id"`
+	DisplayPhoneNumber string `json:"display_phone_number"`
+	VerifiedName       string `json:"verified_name"`
+	QualityRating      string `json:"quality_rating"`
+	MessagingLimitTier string `json:"messaging_limit_tier"`
+}
+
 // WebhookValue represents the value of a webhook change
 type WebhookValue struct {
 	MessagingProduct string           `json:"messaging_product"`
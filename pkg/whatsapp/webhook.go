@@ -1,12 +1,19 @@
 package whatsapp
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// SignatureHeader is the header Meta uses to sign webhook payloads
+const SignatureHeader = "X-Hub-Signature-256"
+
 // VerifyWebhook verifies the webhook challenge from Meta
 func VerifyWebhook(mode, token, challenge, expectedToken string) (string, error) {
 	if mode != "subscribe" {
@@ -18,6 +25,21 @@ func VerifyWebhook(mode, token, challenge, expectedToken string) (string, error)
 	return challenge, nil
 }
 
+// VerifySignature checks an X-Hub-Signature-256 header value ("sha256=<hex>")
+// against the HMAC-SHA256 of the raw request body keyed with the app secret
+func VerifySignature(body []byte, signature, appSecret string) bool {
+	if appSecret == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
 // ParseWebhook parses the incoming webhook payload from Meta
 func ParseWebhook(body []byte) (*WebhookPayload, error) {
 	var payload WebhookPayload
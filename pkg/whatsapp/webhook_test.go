@@ -0,0 +1,46 @@
+package whatsapp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestVerifySignature(t *testing.T) {
+	const secret = "app-secret"
+	body := []byte(`{"object":"whatsapp_business_account","entry":[]}`)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	sum := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+		secret    string
+		want      bool
+	}{
+		{"valid", body, "sha256=" + sum, secret, true},
+		{"uppercase hex", body, "sha256=" + strings.ToUpper(sum), secret, true},
+		{"other secret", body, "sha256=" + sum, "other-secret", false},
+		{"modified body", []byte(`{"object":"whatsapp_business_account","entry":[{}]}`), "sha256=" + sum, secret, false},
+		{"bad signature", body, "sha256=" + strings.Repeat("0", len(sum)), secret, false},
+		{"truncated signature", body, "sha256=" + sum[:32], secret, false},
+		{"not hex", body, "sha256=" + strings.Repeat("z", len(sum)), secret, false},
+		{"missing prefix", body, sum, secret, false},
+		{"sha1 prefix", body, "sha1=" + sum, secret, false},
+		{"empty signature", body, "", secret, false},
+		{"empty secret", body, "sha256=" + sum, "", false},
+		{"empty secret and matching signature", body, "sha256=" + hex.EncodeToString(hmac.New(sha256.New, nil).Sum(nil)), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifySignature(tt.body, tt.signature, tt.secret); got != tt.want {
+				t.Errorf("VerifySignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}